@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// WordPress admin credentials. The admin account the control plane creates
+// when it runs the installer (see setupWordPress), or resets on request,
+// gets a random password. The site record keeps a SHA-256 reference to it
+// and the password itself only until POST
+// /api/sites/:site/admin-credentials/reveal hands it out once; it is never
+// logged nor stored in a job result. A clone or a restore brings an admin
+// account along in the database it copies, so by default the site keeps
+// that account's reference (AdminPasswordReuse); AdminPasswordReset sets a
+// new password on the account instead, to be revealed the same way.
+const (
+	AdminPasswordReuse = "reuse"
+	AdminPasswordReset = "reset"
+)
+
+// ValidateAdminPasswordMode accepts the admin password modes of a clone or
+// restore; "" is AdminPasswordReuse.
+func ValidateAdminPasswordMode(mode string) (string, error) {
+	switch mode {
+	case "", AdminPasswordReuse:
+		return AdminPasswordReuse, nil
+	case AdminPasswordReset:
+		return mode, nil
+	}
+	return "", fmt.Errorf("admin_password must be %q or %q", AdminPasswordReuse, AdminPasswordReset)
+}
+
+// adminPasswordRef is the reference to a password kept on the site record.
+func adminPasswordRef(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// siteAdminUser is the admin account to reset on s: the one recorded, or
+// the one the installer creates.
+func siteAdminUser(s *Site) string {
+	if s.AdminUser != "" {
+		return s.AdminUser
+	}
+	return wpSetupAdminUser
+}
+
+// resetAdminPassword sets a new random password on user in the WordPress of
+// resources and returns it.
+func resetAdminPassword(ctx context.Context, docker *client.Client, cfg Config, resources, user string) (string, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return "", err
+	}
+	args := []string{"update", user, "--user_pass=" + password, "--skip-email"}
+	r, err := runWPCLI(ctx, docker, cfg, resources, "user", args)
+	if err != nil {
+		return "", err
+	}
+	if r.ExitCode != 0 {
+		return "", fmt.Errorf("wp user %s exited %d: %s", strings.Join(redactWPCLIArgs(args), " "), r.ExitCode, strings.TrimSpace(r.Stderr))
+	}
+	return password, nil
+}
+
+// AdminCredentialsStatus is the site's admin account in GET
+// /api/sites/:site. The password is never shown there.
+type AdminCredentialsStatus struct {
+	User       string `json:"user"`
+	Revealable bool   `json:"revealable"` // the password has not been revealed yet
+}
+
+// adminCredentialsStatus returns s's admin account, or nil when the control
+// plane has none on record.
+func adminCredentialsStatus(s *Site) *AdminCredentialsStatus {
+	if s.AdminUser == "" {
+		return nil
+	}
+	return &AdminCredentialsStatus{User: s.AdminUser, Revealable: s.AdminPassRevealable}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateAdminPasswordMode(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"", AdminPasswordReuse, false},
+		{"reuse", AdminPasswordReuse, false},
+		{"reset", AdminPasswordReset, false},
+		{"keep", "", true},
+		{"RESET", "", true},
+	} {
+		got, err := ValidateAdminPasswordMode(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ValidateAdminPasswordMode(%q) = %q, %v; want %q, error %t", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestInstallResultOmitsAdminPassword(t *testing.T) {
+	b, err := json.Marshal(ProvisionResult{Install: &InstallResult{Installed: true, AdminUser: "admin", AdminPassword: "s3cret-value"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cret-value") {
+		t.Errorf("job result carries the admin password: %s", b)
+	}
+}
+
+func TestAdminPasswordRef(t *testing.T) {
+	ref := adminPasswordRef("s3cret-value")
+	if len(ref) != 64 || strings.Contains(ref, "s3cret") {
+		t.Errorf("adminPasswordRef = %q, want a 64-character hex digest", ref)
+	}
+	if ref != adminPasswordRef("s3cret-value") || ref == adminPasswordRef("other") {
+		t.Error("adminPasswordRef is not a deterministic digest of the password")
+	}
+}
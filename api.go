@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/mail"
+	"os"
+	"path"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,16 +29,49 @@ import (
 
 var validSite = regexp.MustCompile(`^[a-z0-9]+$`)
 
+// validVolumeSize matches the size hints accepted by common quota drivers, e.g. "512M", "10G".
+var validVolumeSize = regexp.MustCompile(`^[1-9][0-9]*[KMGT]?$`)
+
+// validLogMaxSize matches Docker's max-size log option, e.g. "10m", "1g".
+var validLogMaxSize = regexp.MustCompile(`^[1-9][0-9]*[kmg]?$`)
+
+// validLogMaxFile matches Docker's max-file log option.
+var validLogMaxFile = regexp.MustCompile(`^[1-9][0-9]?$`)
+
 type API struct {
 	db        *DB
 	cfg       Config
 	docker    *client.Client
 	tunnel    *TunnelManager
 	backupper *Backupper
+	events    EventPublisher
+	maint     *Maintenance
+	heartbeat *WorkerHeartbeat
+
+	// statsCache holds the last GET /api/stats response so dashboards polling
+	// it don't hit MySQL and Docker on every request.
+	statsMu    sync.Mutex
+	statsCache gin.H
+	statsAt    time.Time
+
+	// diskCache holds the last GET /api/sites/:site/disk measurement per
+	// site, reused for diskUsageCacheTTL.
+	diskMu    sync.Mutex
+	diskCache map[string]*DiskUsage
+
+	// domainMu serializes the handlers that rewrite sites' domain config
+	// in the request — setting, removing and moving custom domains and
+	// redeploying config — with each other and with queuing a destroy, so
+	// none of them overwrites a snippet another is writing.
+	domainMu sync.Mutex
 }
 
-func NewAPI(db *DB, cfg Config, docker *client.Client, tunnel *TunnelManager, backupper *Backupper) *API {
-	return &API{db: db, cfg: cfg, docker: docker, tunnel: tunnel, backupper: backupper}
+// statsCacheTTL bounds how stale GET /api/stats may be.
+const statsCacheTTL = 5 * time.Second
+
+func NewAPI(db *DB, cfg Config, docker *client.Client, tunnel *TunnelManager, backupper *Backupper, events EventPublisher, maint *Maintenance, heartbeat *WorkerHeartbeat) *API {
+	return &API{db: db, cfg: cfg, docker: docker, tunnel: tunnel, backupper: backupper, events: events, maint: maint, heartbeat: heartbeat,
+		diskCache: make(map[string]*DiskUsage)}
 }
 
 // POST /api/sites/:site/domain
@@ -32,104 +79,145 @@ func NewAPI(db *DB, cfg Config, docker *client.Client, tunnel *TunnelManager, ba
 // Flow: Validate → DNS check → Apply Infra → Commit DB
 // DB is only written AFTER all infrastructure changes succeed.
 // On partial failure, completed infra steps are rolled back.
+//
+// Optional "www": "redirect" (www.<domain> → <domain>) or "serve" (both
+// hostnames serve the site) also routes and certifies the www variant. Its
+// DNS must point at the ingress too; if www does not resolve at all it is
+// skipped with a warning and only the bare domain is set.
+//
+// With "wait_for_dns": true a domain whose only failed check is DNS is
+// accepted (202) instead of rejected: the site moves to DOMAIN_PENDING and
+// the worker attaches the domain once its DNS points at the ingress (see
+// domain_wait.go).
 func (a *API) handleSetCustomDomain(c *gin.Context) {
 	site := c.Param("site")
 
 	var req struct {
-		Domain string `json:"domain" binding:"required"`
+		Domain     string `json:"domain" binding:"required"`
+		WWW        string `json:"www"`
+		WaitForDNS bool   `json:"wait_for_dns"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "domain is required"})
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "domain is required")
 		return
 	}
 
-	domain := strings.ToLower(strings.TrimSpace(req.Domain))
-
-	// ── Validate format ───────────────────────────────────────────────
-	if err := ValidateCustomDomain(domain, a.cfg.BaseDomain); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	domain, err := NormalizeDomain(req.Domain)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
 		return
 	}
-
-	// ── Validate DNS points to our ingress IP ───────────────────────
-	if err := ValidateDomainPointsToIngress(domain, a.cfg.PublicIP); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	wwwMode := strings.ToLower(strings.TrimSpace(req.WWW))
+	if err := ValidateWWWMode(domain, wwwMode); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 
-	if err := a.db.EnsureDomainAvailable(domain, site); err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-		return
+	// Held until the domain is committed; the certificate wait below runs
+	// without it.
+	a.domainMu.Lock()
+	locked := true
+	unlock := func() {
+		if locked {
+			locked = false
+			a.domainMu.Unlock()
+		}
+	}
+	defer unlock()
+
+	// ── Validate format, DNS and availability ─────────────────────────
+	// Shared with GET /api/domains/check so the two cannot drift apart.
+	// A DNS failure alone can be waited out with wait_for_dns.
+	checks := a.checkCustomDomain(domain, site)
+	dnsErr := ""
+	if failed := firstFailedCheck(checks); failed != nil {
+		if !req.WaitForDNS || failed.Name != "dns" || !checks[len(checks)-1].OK {
+			respondError(c, failed.status, failed.Code, failed.Error)
+			return
+		}
+		dnsErr = failed.Error
+	}
+
+	var warnings []string
+	if wwwMode != "" {
+		www := wwwDomain(domain)
+		var skip bool
+		if dnsErr == "" {
+			skip, err = checkWWWDNS(a.cfg.DomainResolver(), domain, a.cfg.IngressIPs)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, CodeDNSMismatch, err.Error())
+				return
+			}
+		}
+		if err := a.db.EnsureDomainAvailable(www, site); err != nil {
+			respondError(c, http.StatusConflict, CodeDomainTaken, err.Error())
+			return
+		}
+		if skip {
+			warnings = append(warnings, www+" does not resolve; www handling skipped — add its DNS record and set the domain again")
+			wwwMode = ""
+		}
 	}
 
 	existing, err := a.db.GetSite(site)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
 		return
 	}
 	if existing.Status != "ACTIVE" && existing.Status != "DOMAIN_ACTIVE" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site must be ACTIVE to set custom domain"})
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to set custom domain")
+		return
+	}
+	if existing.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites cannot have a custom domain")
+		return
+	}
+	if existing.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
 		return
 	}
 
 	// Idempotent: if domain is already set to this value, no-op
-	if existing.CustomDomain == domain {
+	if existing.CustomDomain == domain && existing.WWWMode == wwwMode {
 		c.JSON(http.StatusOK, gin.H{
 			"site":          site,
 			"custom_domain": domain,
 			"status":        existing.Status,
+			"www":           wwwMode,
 			"message":       "domain already set",
+			"warnings":      warnings,
 		})
 		return
 	}
-
-	isWP := a.isWordPressSite(existing)
-
-	// ── Apply Infra FIRST ─────────────────────────────────────────────
-	// Step 1 [WordPress only]: update nginx sidecar — add custom domain to
-	// server_name and switch HTTP_HOST to $host
-	if isWP {
-		p := NewProvisioner(a.docker, a.cfg)
-		if err := p.writeNginxConfigWithDomains(
-			NginxContainerName(site), PHPContainerName(site),
-			existing.Domain, domain,
-		); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "nginx config failed: " + err.Error()})
-			return
-		}
-	}
-
-	// Step 2: Regenerate Caddy snippet with both hostnames (gets TLS cert automatically)
-	if err := a.regenerateCaddy(site, existing.Domain, domain); err != nil {
-		// Rollback Step 1: revert nginx to single domain
-		if isWP {
-			p := NewProvisioner(a.docker, a.cfg)
-			p.writeNginxConfigWithDomains(NginxContainerName(site), PHPContainerName(site), existing.Domain, existing.CustomDomain)
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "caddy update failed: " + err.Error()})
+	if dnsErr != "" {
+		a.waitForDomainDNS(c, existing, domain, wwwMode, dnsErr)
 		return
 	}
 
-	// Step 3 [WordPress only]: update siteurl + home in wp_options.
-	// Best-effort — WordPress tables may not exist yet if WP hasn't been installed.
-	// nginx $host passthrough means requests still work even if this fails.
-	if isWP {
-		p := NewProvisioner(a.docker, a.cfg)
-		if err := p.updateWordPressURLs(site, "https://"+domain); err != nil {
-			log.Printf("[WARN] site=%s wp_options update failed (non-fatal): %v", site, err)
-		}
+	// ── Apply Infra FIRST ─────────────────────────────────────────────
+	// Infra changes run detached from the request context so a client
+	// disconnect cannot abandon a half-applied change or its rollback.
+	if err := attachCustomDomain(context.Background(), a.docker, a.cfg, existing, a.isWordPressSite(existing), domain, wwwMode); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
 	}
 
 	// ── Commit DB state LAST ──────────────────────────────────────────
-	if err := a.db.SetCustomDomain(site, domain); err != nil {
+	if err := a.db.SetCustomDomain(site, domain, wwwMode); err != nil {
 		log.Printf("[CRITICAL] site=%s domain=%s infra applied but DB commit failed: %v", site, domain, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "domain applied but failed to persist — retry the request"})
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "domain applied but failed to persist — retry the request")
 		return
 	}
+	unlock()
 
 	// Poll Caddy for cert readiness (up to 30s). Non-blocking on failure —
 	// Caddy will keep retrying ACME in the background regardless.
-	certStatus := PollCaddyCert(a.docker, a.cfg, domain, 30*time.Second)
+	certStatus := PollCaddyCert(c.Request.Context(), a.docker, a.cfg, domain, 30*time.Second)
+	a.recordHistory(site, EventDomainSet, domain)
+	a.events.Publish(Event{
+		Type: EventDomainSet, Site: site, RequestID: requestID(c),
+		Data: map[string]any{"domain": domain, "www": wwwMode, "cert_status": string(certStatus)},
+	})
 	if certStatus == CertIssued {
 		log.Printf("[api] site=%s custom domain set to %s (cert: issued)", site, domain)
 	} else {
@@ -140,8 +228,161 @@ func (a *API) handleSetCustomDomain(c *gin.Context) {
 		"site":           site,
 		"default_domain": existing.Domain,
 		"custom_domain":  domain,
+		"www":            wwwMode,
 		"cert_status":    string(certStatus),
 		"status":         "active",
+		"warnings":       warnings,
+	})
+}
+
+// POST /api/domains/move
+//
+// Moves a custom domain, with its www handling, from one site to another:
+//
+//	{"domain": "example.com", "from_site": "a", "to_site": "b"}
+//
+// Flow: Validate → Apply Infra → Commit DB, as for setting a domain. The
+// Caddy route switches with a single reload (see moveCustomDomain), so the
+// domain is never unrouted nor routed twice, and both sites' records are
+// updated in one transaction. Both sites must be ACTIVE and without a
+// pending or processing job, and to_site subdomain-routed and without a
+// custom domain of its own. DNS is not re-checked: the domain already
+// points at the ingress for from_site.
+func (a *API) handleMoveDomain(c *gin.Context) {
+	var req struct {
+		Domain   string `json:"domain" binding:"required"`
+		FromSite string `json:"from_site" binding:"required"`
+		ToSite   string `json:"to_site" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "domain, from_site and to_site are required")
+		return
+	}
+	domain, err := NormalizeDomain(req.Domain)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
+		return
+	}
+	fromSite, toSite := strings.ToLower(req.FromSite), strings.ToLower(req.ToSite)
+	if fromSite == toSite {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "from_site and to_site must differ")
+		return
+	}
+
+	a.domainMu.Lock()
+	defer a.domainMu.Unlock()
+
+	from, err := a.db.GetSite(fromSite)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch from_site")
+		return
+	}
+	if err == sql.ErrNoRows || !ownsSite(c, from) {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "from_site not found")
+		return
+	}
+	to, err := a.db.GetSite(toSite)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch to_site")
+		return
+	}
+	if err == sql.ErrNoRows || !ownsSite(c, to) {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "to_site not found")
+		return
+	}
+	if from.CustomDomain != domain {
+		respondError(c, http.StatusConflict, CodeNoCustomDomain, fmt.Sprintf("%s is not the custom domain of %s", domain, fromSite))
+		return
+	}
+	if from.Status != "ACTIVE" && from.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "from_site must be ACTIVE to give up its custom domain")
+		return
+	}
+	if to.Status != "ACTIVE" && to.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "to_site must be ACTIVE to receive a custom domain")
+		return
+	}
+	// A job on either site may rewrite its config under the move.
+	for _, site := range []string{fromSite, toSite} {
+		active, err := a.db.HasActiveJob(site)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+			return
+		}
+		if active {
+			respondError(c, http.StatusConflict, CodeJobActive, site+" has a pending or processing job")
+			return
+		}
+	}
+	if to.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites cannot have a custom domain")
+		return
+	}
+	if to.CustomDomain != "" {
+		respondError(c, http.StatusConflict, CodeDomainTaken, fmt.Sprintf("to_site already has custom domain %s — remove it first", to.CustomDomain))
+		return
+	}
+	if from.CaddyManual || to.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return
+	}
+
+	// ── Apply Infra FIRST ─────────────────────────────────────────────
+	// Detached from the request context — see handleSetCustomDomain.
+	if err := moveCustomDomain(context.Background(), a.docker, a.cfg, from, to, a.isWordPressSite(from), a.isWordPressSite(to)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+
+	// ── Commit DB state LAST ──────────────────────────────────────────
+	if err := a.db.MoveCustomDomain(domain, from.WWWMode, fromSite, toSite); err != nil {
+		log.Printf("[CRITICAL] domain=%s moved from %s to %s but DB commit failed: %v", domain, fromSite, toSite, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "domain moved but failed to persist — retry the request")
+		return
+	}
+
+	log.Printf("[api] domain %s moved from site=%s to site=%s req=%s", domain, fromSite, toSite, requestID(c))
+	a.recordHistory(fromSite, EventDomainMoved, domain+" → "+toSite)
+	a.recordHistory(toSite, EventDomainMoved, domain+" ← "+fromSite)
+	a.events.Publish(Event{
+		Type: EventDomainMoved, Site: toSite, RequestID: requestID(c),
+		Data: map[string]any{"domain": domain, "from_site": fromSite, "to_site": toSite},
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"domain":    domain,
+		"from_site": fromSite,
+		"to_site":   toSite,
+		"www":       from.WWWMode,
+		"status":    "moved",
+	})
+}
+
+// GET /api/domains/check?domain=...[&site=...]
+//
+// Reports whether a custom domain could be set right now, running the same
+// checks as POST /api/sites/:site/domain without changing anything. Pass
+// site to ignore that site's own claim on the domain.
+func (a *API) handleDomainCheck(c *gin.Context) {
+	raw := c.Query("domain")
+	if raw == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "domain is required")
+		return
+	}
+	domain, err := NormalizeDomain(raw)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"domain":    raw,
+			"available": false,
+			"checks":    []DomainCheck{{Name: "format", Error: err.Error(), Code: CodeDomainInvalid}},
+		})
+		return
+	}
+
+	checks := a.checkCustomDomain(domain, strings.ToLower(c.Query("site")))
+	c.JSON(http.StatusOK, gin.H{
+		"domain":    domain,
+		"available": firstFailedCheck(checks) == nil,
+		"checks":    checks,
 	})
 }
 
@@ -150,50 +391,65 @@ func (a *API) handleSetCustomDomain(c *gin.Context) {
 // Flow: Remove Infra → Commit DB
 // Infra is reverted BEFORE the DB record is updated.
 // If infra reverts but DB fails, a retry will converge.
+// On a site waiting for a domain's DNS it cancels the wait instead.
 func (a *API) handleRemoveCustomDomain(c *gin.Context) {
 	site := c.Param("site")
 
+	a.domainMu.Lock()
+	defer a.domainMu.Unlock()
+
 	existing, err := a.db.GetSite(site)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if existing.PendingDomain != "" {
+		a.cancelPendingDomain(c, existing)
 		return
 	}
 	if existing.CustomDomain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no custom domain set"})
+		respondError(c, http.StatusBadRequest, CodeNoCustomDomain, "no custom domain set")
+		return
+	}
+	if existing.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
 		return
 	}
 
 	customDomain := existing.CustomDomain
 	isWP := a.isWordPressSite(existing)
 
+	// Detached from the request context — see handleSetCustomDomain.
+	ctx := context.Background()
+
 	// ── Remove Infra FIRST ────────────────────────────────────────────
 	// Step 1 [WordPress only]: revert nginx to single domain, hardcoded HTTP_HOST
 	if isWP {
 		p := NewProvisioner(a.docker, a.cfg)
-		if err := p.writeNginxConfigWithDomains(
-			NginxContainerName(site), PHPContainerName(site),
-			existing.Domain, "",
+		if err := p.writeNginxConfigWithDomains(ctx,
+			NginxContainerName(SiteResources(existing)), PHPContainerName(SiteResources(existing)),
+			existing.Domain, "", "", existing.PathPrefix, existing.NginxSnippet, existing.FastCGI,
 		); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "nginx revert failed: " + err.Error()})
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "nginx revert failed: "+err.Error())
 			return
 		}
 	}
 
 	// Step 2: Regenerate Caddy snippet with only the default subdomain
-	if err := a.regenerateCaddy(site, existing.Domain, ""); err != nil {
+	if err := a.regenerateCaddy(ctx, site, existing.Domain, "", ""); err != nil {
 		// Rollback Step 1: put nginx back with custom domain
 		if isWP {
 			p := NewProvisioner(a.docker, a.cfg)
-			p.writeNginxConfigWithDomains(NginxContainerName(site), PHPContainerName(site), existing.Domain, customDomain)
+			p.writeNginxConfigWithDomains(ctx, NginxContainerName(SiteResources(existing)), PHPContainerName(SiteResources(existing)), existing.Domain, customDomain, existing.WWWMode, existing.PathPrefix, existing.NginxSnippet, existing.FastCGI)
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "caddy revert failed: " + err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy revert failed: "+err.Error())
 		return
 	}
 
 	// Step 3 [WordPress only]: revert siteurl + home back to default subdomain
 	if isWP {
 		p := NewProvisioner(a.docker, a.cfg)
-		if err := p.updateWordPressURLs(site, "https://"+existing.Domain); err != nil {
+		if err := p.updateWordPressURLs(ctx, SiteResources(existing), "https://"+existing.Domain); err != nil {
 			log.Printf("[WARN] site=%s wp_options revert failed (non-fatal): %v", site, err)
 		}
 	}
@@ -201,11 +457,19 @@ func (a *API) handleRemoveCustomDomain(c *gin.Context) {
 	// ── Commit DB state LAST ──────────────────────────────────────────
 	if err := a.db.RemoveCustomDomain(site); err != nil {
 		log.Printf("[CRITICAL] site=%s domain=%s infra removed but DB commit failed: %v", site, customDomain, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "domain unrouted but failed to persist — retry the request"})
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "domain unrouted but failed to persist — retry the request")
 		return
 	}
+	// A domain attached after waiting for its DNS left the site DOMAIN_ACTIVE.
+	if SiteStatus(existing.Status) == SiteDomainActive {
+		if err := a.db.UpdateSiteStatus(site, string(SiteActive)); err != nil {
+			log.Printf("[api] site=%s warning: domain removed but status not returned to ACTIVE: %v", site, err)
+		}
+	}
 
 	log.Printf("[api] site=%s custom domain %s removed", site, customDomain)
+	a.recordHistory(site, EventDomainRemoved, customDomain)
+	a.events.Publish(Event{Type: EventDomainRemoved, Site: site, RequestID: requestID(c), Data: map[string]any{"domain": customDomain}})
 	c.JSON(http.StatusOK, gin.H{
 		"site":   site,
 		"domain": existing.Domain,
@@ -213,9 +477,131 @@ func (a *API) handleRemoveCustomDomain(c *gin.Context) {
 	})
 }
 
+// resolveBaseDomain returns the base domain a provision request asked for,
+// normalized, or the default BaseDomain for "". On failure it writes a 400
+// and returns false.
+func (a *API) resolveBaseDomain(c *gin.Context, requested string) (string, bool) {
+	if strings.TrimSpace(requested) == "" {
+		return a.cfg.BaseDomain, true
+	}
+	requested, err := NormalizeDomain(requested)
+	if err != nil || !a.cfg.IsBaseDomain(requested) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "base_domain must be one of "+strings.Join(a.cfg.BaseDomains, ", "))
+		return "", false
+	}
+	return requested, true
+}
+
+// ensureVolumeAttachable checks that volume exists on app-01 and may back
+// site's files: it is not a template, nginx config or static-sites volume,
+// nor another live site's. It returns the volume's driver, and false if a
+// response has been written.
+func (a *API) ensureVolumeAttachable(c *gin.Context, site, volume string) (string, bool) {
+	if strings.HasPrefix(volume, NginxConfVolumeName("")) || volume == a.cfg.CaddyStaticVolume {
+		respondError(c, http.StatusBadRequest, CodeVolumeUnavailable, "volume "+volume+" is reserved by the platform")
+		return "", false
+	}
+	templates, err := a.db.ListTemplates()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load templates")
+		return "", false
+	}
+	for _, t := range templates {
+		if t.Volume == volume {
+			respondError(c, http.StatusBadRequest, CodeVolumeUnavailable, "volume "+volume+" backs template "+t.Name+"; use template instead")
+			return "", false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	vol, err := a.docker.VolumeInspect(ctx, volume)
+	if client.IsErrNotFound(err) {
+		respondError(c, http.StatusBadRequest, CodeVolumeUnavailable, "volume "+volume+" does not exist on app-01")
+		return "", false
+	} else if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to inspect volume: "+err.Error())
+		return "", false
+	}
+
+	if err := a.db.EnsureVolumeAvailable(volume, site); err != nil {
+		respondError(c, http.StatusConflict, CodeVolumeUnavailable, err.Error())
+		return "", false
+	}
+	return vol.Driver, true
+}
+
+// ensureSubdomainAvailable refuses (409) a slug whose <site>.<baseDomain>
+// is a reserved subdomain or is already served by another live site, so two
+// sites are never assigned the same domain. Path-routed sites share the base
+// domain and skip this. Returns false if a response has been written.
+func (a *API) ensureSubdomainAvailable(c *gin.Context, site, baseDomain string) bool {
+	if err := ValidateSubdomainNotReserved(site, a.cfg.ReservedSubdomains); err != nil {
+		respondError(c, http.StatusConflict, CodeSubdomainReserved, err.Error())
+		return false
+	}
+	if err := a.db.EnsureSiteDomainAvailable(SiteDomain(site, baseDomain), site); err != nil {
+		respondError(c, http.StatusConflict, CodeDomainTaken, err.Error())
+		return false
+	}
+	return true
+}
+
+// ensureNoCollisions is the shared pre-provision check for every site type.
+// It refuses (409, listing the conflicting resources) when containers or
+// volumes for the slug already exist on app-01, unless force is set, in which
+// case they are removed first. force needs the admin API key, and callers
+// must already have refused slugs whose site is not DESTROYED or FAILED.
+// keepVolume, when set, is an existing volume the site is being provisioned
+// onto and never counts as a collision. Returns false if a response has
+// been written.
+func (a *API) ensureNoCollisions(c *gin.Context, site string, force bool, keepVolume string) bool {
+	if force && !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "force requires the admin API key")
+		return false
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	all, err := FindResourceCollisions(ctx, a.docker, site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check for existing resources: "+err.Error())
+		return false
+	}
+	// A leftover wp_<site> the request attaches as its existing volume is
+	// not in the way — it is the point.
+	var found []string
+	for _, r := range all {
+		if keepVolume == "" || r != "volume:"+keepVolume {
+			found = append(found, r)
+		}
+	}
+	if len(found) == 0 {
+		return true
+	}
+	if !force {
+		respondErrorWith(c, http.StatusConflict, CodeResourceConflict,
+			"leftover resources exist for this site name — destroy them or retry with force=true",
+			gin.H{"conflicts": found})
+		return false
+	}
+
+	log.Printf("[api] site=%s force cleanup of leftover resources: %v", site, found)
+	if err := CleanupResourceCollisions(ctx, a.docker, found); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "force cleanup failed: "+err.Error())
+		return false
+	}
+	return true
+}
+
 // isWordPressSite returns true if the site was provisioned as a WordPress site
 // (as opposed to a static site). Used to gate nginx and wp_options updates.
+// Reads the persisted type; legacy rows without one fall back to the
+// provisioning job, and to WordPress when that job has been purged.
 func (a *API) isWordPressSite(s *Site) bool {
+	if s.Type != "" {
+		return s.Type == SiteTypeWordPress
+	}
 	if s.JobID == "" {
 		return true // assume WP when no job record available
 	}
@@ -223,106 +609,166 @@ func (a *API) isWordPressSite(s *Site) bool {
 	if err != nil {
 		return true // safe default
 	}
-	return job.Type == JobProvision
+	// Blue/green, scheduled backup and resource update jobs are only queued
+	// for WordPress sites.
+	return job.Type == JobProvision || job.Type == JobClone || job.Type == JobGreenCreate ||
+		job.Type == JobGreenCutover || job.Type == JobGreenRollback || job.Type == JobGreenDiscard || job.Type == JobBlueRetire ||
+		job.Type == JobBackup || job.Type == JobResourcesUpdate
 }
 
-func (a *API) regenerateCaddy(site, defaultDomain, customDomain string) error {
-	// Check job type to determine Caddy config format
-	existing, err := a.db.GetSite(site)
+// POST /api/sites/:site/dns/verify
+//
+// Queues a VERIFY_DNS job that checks the tunnel CNAME of each of the
+// site's hostnames and re-adds the missing ones (see dns_routes.go). The
+// per-hostname status is the job's result.
+func (a *API) handleVerifyDNS(c *gin.Context) {
+	site := c.Param("site")
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
 	if err != nil {
-		return err
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to verify its DNS routes")
+		return
 	}
 
-	job, err := a.db.GetJob(existing.JobID)
+	active, err := a.db.HasActiveJob(site)
 	if err != nil {
-		return err
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
 	}
 
-	if job.Type == JobStaticProvision {
-		sp := NewStaticProvisioner(a.docker, a.cfg)
-		if err := sp.writeCaddyConfig(site, defaultDomain, customDomain); err != nil {
-			return err
-		}
-	} else {
-		p := NewProvisioner(a.docker, a.cfg)
-		if err := p.writeCaddyConfig(site, NginxContainerName(site), defaultDomain, customDomain); err != nil {
-			return err
-		}
+	// Not linked to the site: a check leaves it as it is, and legacy rows
+	// without a type are told apart by the job they point at.
+	jobID := uuid.New().String()
+	if err := a.db.InsertJob(jobID, JobVerifyDNS, site, requestID(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+
+	domains := []string{}
+	for _, h := range siteHostnames(s) {
+		domains = append(domains, h.Domain)
+	}
+	log.Printf("[api] site=%s dns route check queued job=%s req=%s", site, jobID, requestID(c))
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id":  jobID,
+		"site":    site,
+		"domains": domains,
+		"status":  "PENDING",
+	})
+}
+
+// regenerateCaddy rewrites the site's generated Caddy config and reloads.
+// It refuses with errCaddyManuallyEdited rather than clobber a snippet an
+// operator replaced by hand.
+func (a *API) regenerateCaddy(ctx context.Context, site, defaultDomain, customDomain, wwwMode string) error {
+	existing, err := a.db.GetSite(site)
+	if err != nil {
+		return err
+	}
+	if existing.CaddyManual {
+		return errCaddyManuallyEdited
+	}
+	if err := writeSiteCaddyConfig(ctx, a.docker, a.cfg, existing, a.isWordPressSite(existing), defaultDomain, customDomain, wwwMode); err != nil {
+		return err
 	}
 
 	// Always reload Caddy after writing the snippet so the running config
 	// matches disk immediately. Without this, domain changes are invisible
 	// to Caddy until the next unrelated reload.
-	return reloadCaddy(a.cfg)
+	return reloadCaddy(ctx, a.cfg)
 }
 
 // POST /api/static/provision
 func (a *API) handleStaticProvision(c *gin.Context) {
 	site := strings.ToLower(c.PostForm("site"))
 	if site == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site is required"})
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "site is required")
 		return
 	}
 	if !validSite.MatchString(site) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site name must be lowercase letters and numbers only"})
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name must be lowercase letters and numbers only")
 		return
 	}
 
 	// Reject if site already has an active job
 	active, err := a.db.HasActiveJob(site)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check job status"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
 		return
 	}
 	if active {
-		c.JSON(http.StatusConflict, gin.H{"error": "site already has a pending or processing job"})
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
 		return
 	}
 
-	// Reject if site is already active
+	// Only a new slug, or one whose site was destroyed or failed, may be
+	// provisioned; force must never reach a live site's resources.
 	existingSite, err := a.db.GetSite(site)
 	if err != nil && err != sql.ErrNoRows {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check site status"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check site status")
 		return
 	}
-	if existingSite != nil && existingSite.Status == "ACTIVE" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site already exists and is active"})
+	if existingSite != nil && existingSite.Status != "DESTROYED" && existingSite.Status != "FAILED" {
+		respondError(c, http.StatusConflict, CodeSiteExists, "site already exists ("+existingSite.Status+") — destroy it first")
 		return
 	}
-
-	file, err := c.FormFile("zip")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "zip file is required"})
+	if existingSite != nil && existingSite.Status == "FAILED" && !ownsSite(c, existingSite) {
+		respondError(c, http.StatusConflict, CodeSiteExists, "site already exists")
 		return
 	}
 
-	// Save zip temporarily
-	tmpPath := "/tmp/" + site + ".zip"
-	if err := c.SaveUploadedFile(file, tmpPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save zip"})
+	baseDomain, ok := a.resolveBaseDomain(c, c.PostForm("base_domain"))
+	if !ok {
+		return
+	}
+	if !a.ensureSubdomainAvailable(c, site, baseDomain) {
+		return
+	}
+	if !a.enforceQuota(c, site) {
+		return
+	}
+	if !a.enforceHostCapacity(c, site) {
+		return
+	}
+	if !a.ensureNoCollisions(c, site, c.PostForm("force") == "true", "") {
 		return
 	}
 
-	jobID := uuid.New().String()
-	domain := SiteDomain(site, a.cfg.BaseDomain)
-
-	if err := a.db.InsertJob(jobID, JobStaticProvision, site); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue job"})
+	file, ok := formZip(c)
+	if !ok || !ensureZipIndex(c, file) {
 		return
 	}
 
-	if err := a.db.UpsertSite(site, domain, "PROVISIONING", jobID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record site"})
+	// Save zip temporarily
+	tmpPath := "/tmp/" + site + ".zip"
+	if err := c.SaveUploadedFile(file, tmpPath); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save zip")
 		return
 	}
 
-	// Store zip path in job payload so worker can find it
-	if err := a.db.SetJobPayload(jobID, tmpPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store payload"})
+	jobID := uuid.New().String()
+	domain := SiteDomain(site, baseDomain)
+
+	// The zip path is the job's payload, so the worker can find it.
+	rec := SiteProvision{Site: site, Domain: domain, BaseDomain: baseDomain, Type: SiteTypeStatic, Tenant: tenant(c)}
+	if err := a.db.QueueProvision(rec, jobID, JobStaticProvision, requestID(c), tmpPath); err != nil {
+		log.Printf("[api] site=%s static provision not queued: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
+	respondJobAccepted(c, jobID, gin.H{
 		"job_id": jobID,
 		"site":   site,
 		"domain": domain,
@@ -330,59 +776,273 @@ func (a *API) handleStaticProvision(c *gin.Context) {
 	})
 }
 
-func (a *API) RegisterRoutes(r *gin.Engine) {
-	r.Use(a.authMiddleware())
-
-	v1 := r.Group("/api")
-	{
-		v1.POST("/provision", a.handleProvision)
-		v1.POST("/destroy", a.handleDestroy)
-		v1.GET("/jobs/:id", a.handleJobStatus)
-		v1.GET("/sites/:site", a.handleSiteStatus)
-		v1.GET("/health", a.handleHealth)
-		v1.GET("/sites", a.handleListSites)
-		v1.DELETE("/sites/:site", a.handleDeleteSite)
-		v1.DELETE("/jobs/:id", a.handleDeleteJob)
-		v1.POST("/static/provision", a.handleStaticProvision)
-		v1.POST("/sites/:site/domain", a.handleSetCustomDomain)
-		v1.DELETE("/sites/:site/domain", a.handleRemoveCustomDomain)
-		v1.GET("/sites/:site/domain/status", a.handleDomainStatus)
-		v1.POST("/sites/:site/cert-retry", a.handleCertRetry)
-		v1.POST("/sites/:site/backup", a.handleBackupSite)
-		v1.GET("/sites/:site/backups", a.handleListBackups)
-		v1.POST("/sites/:site/restore/:date", a.handleRestoreSite)
-	}
-}
-
-// GET /api/sites/:site/domain/status
+// POST /api/sites/:site/deploy
 //
-// Returns a live snapshot of the custom domain's DNS and TLS state. Safe to
-// poll frequently from the UI — no infra changes are made. Designed for the
-// "add domain" setup flow where the user needs to know:
-//   - what A record value to configure
-//   - whether their DNS change has propagated
-//   - whether the TLS cert has been issued
-func (a *API) handleDomainStatus(c *gin.Context) {
+// Publishes a new zip for an existing static site. The upload is extracted
+// into a new release alongside the live one and Caddy switches over only
+// once it is in place, so the site never serves a half-extracted tree. The
+// release being replaced is kept for POST /api/sites/:site/rollback.
+func (a *API) handleStaticDeploy(c *gin.Context) {
 	site := c.Param("site")
 
-	s, err := a.db.GetSite(site)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+	s, ok := a.liveStaticSite(c, site)
+	if !ok {
 		return
 	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch site"})
+
+	file, ok := formZip(c)
+	if !ok || !ensureZipIndex(c, file) {
 		return
 	}
-	if s.CustomDomain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no custom domain set on this site"})
+
+	jobID := uuid.New().String()
+
+	// Per-job path: the live release's original upload may still be on disk.
+	tmpPath := "/tmp/" + site + "-" + jobID + ".zip"
+	if err := c.SaveUploadedFile(file, tmpPath); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save zip")
 		return
 	}
 
-	domain := s.CustomDomain
+	if err := a.db.InsertJobWithPayload(jobID, JobStaticDeploy, site, requestID(c), tmpPath); err != nil {
+		os.Remove(tmpPath)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+	if err := a.db.SetSiteJob(site, jobID); err != nil {
+		log.Printf("[api] site=%s warning: deploy job %s queued but not linked to site: %v", site, jobID, err)
+	}
 
-	// Live DNS check — always fresh, never cached
-	dns := CheckDomainDNS(domain, a.cfg.PublicIP)
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id":          jobID,
+		"site":            site,
+		"domain":          s.Domain,
+		"current_release": s.StaticRelease,
+		"status":          "PENDING",
+	})
+}
+
+// POST /api/sites/:site/rollback
+//
+// Switches a static site back to the release it served before the last
+// deploy. The two releases swap places, so calling it again rolls forward.
+func (a *API) handleStaticRollback(c *gin.Context) {
+	site := c.Param("site")
+
+	s, ok := a.liveStaticSite(c, site)
+	if !ok {
+		return
+	}
+	if s.StaticPrevRelease == "" {
+		respondError(c, http.StatusConflict, CodeNoPreviousRelease, "no previous release to roll back to")
+		return
+	}
+
+	// Detached from the request: once Caddy is reloaded the DB must be
+	// updated to match, even if the client disconnects.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sp := NewStaticProvisioner(a.docker, a.cfg)
+	if err := sp.activateRelease(ctx, s, s.StaticPrevRelease); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "rollback failed, current release still serving: "+err.Error())
+		return
+	}
+	if err := a.db.SetStaticReleases(site, s.StaticPrevRelease, s.StaticRelease); err != nil {
+		log.Printf("[CRITICAL] site=%s rolled back to release %s but DB not updated: %v", site, s.StaticPrevRelease, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "rolled back but failed to record release")
+		return
+	}
+
+	log.Printf("[api] site=%s rolled back static release %s -> %s req=%s", site, s.StaticRelease, s.StaticPrevRelease, requestID(c))
+	a.recordHistory(site, EventReleaseRolledBack, s.StaticRelease+" -> "+s.StaticPrevRelease)
+	a.events.Publish(Event{
+		Type: EventReleaseRolledBack, Site: site, RequestID: requestID(c),
+		Data: map[string]any{"from": s.StaticRelease, "to": s.StaticPrevRelease},
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"site":             site,
+		"current_release":  s.StaticPrevRelease,
+		"previous_release": s.StaticRelease,
+	})
+}
+
+// liveStaticSite loads a static site that is serving and has no job in
+// flight — the precondition for deploying or rolling back a release.
+// Returns false if a response has been written.
+func (a *API) liveStaticSite(c *gin.Context, site string) (*Site, bool) {
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return nil, false
+	}
+	if a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "only static sites have releases")
+		return nil, false
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site must be ACTIVE (is %s)", s.Status))
+		return nil, false
+	}
+
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return nil, false
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return nil, false
+	}
+	return s, true
+}
+
+// RegisterRoutes mounts the API. Routes registered with long are
+// long-lived and exempt from the server's 10s read/write timeouts (bounded
+// by API_LONG_REQUEST_TIMEOUT_SEC instead):
+//   - GET  /api/jobs/:id/events           Server-Sent Events stream
+//   - POST /api/sites/:site/backup        synchronous backup
+//   - POST /api/sites/:site/restore/:date synchronous restore
+//   - POST /api/sites/:site/reconcile     synchronous checks and repairs
+//   - POST /api/sites/:site/resume        synchronous start and repairs
+//   - GET  /api/sites/:site/disk          du over the site's files
+//   - POST /api/sites/:site/domain        waits up to 30s for the certificate
+//   - POST /api/sites/:site/cert-retry    waits up to 30s for the certificate
+//   - POST /api/domains/move              synchronous config rewrites and reload
+//   - POST /api/sites/:site/wp-cli        WP-CLI command, up to 2 minutes
+//   - POST /api/admin/prune               orphan scan and removal, up to 5 minutes
+//
+// Zip uploads are registered with uploadLong (API_UPLOAD_TIMEOUT_SEC) and
+// upload (MAX_UPLOAD_MB, 413 beyond it):
+//   - POST /api/static/provision
+//   - POST /api/sites/:site/deploy
+//
+// New streaming or download routes must be registered with long too, and
+// new upload routes with uploadLong and upload.
+//
+// In read-only mode every non-GET route except /api/maintenance answers 503
+// (see readOnlyMiddleware); new mutating routes are covered automatically.
+//
+// A tenant key only reaches its own tenant's sites and their jobs: routes
+// with :site or /jobs/:id are checked by tenantScopeMiddleware, and handlers
+// that take a site in the body must call ensureSiteOwner.
+func (a *API) RegisterRoutes(r *gin.Engine) {
+	r.Use(a.authMiddleware())
+	r.Use(readOnlyMiddleware(a.maint))
+	r.Use(a.tenantScopeMiddleware())
+	long := longLived(a.cfg.APILongRequestTimeout)
+	uploadLong := longLived(a.cfg.APIUploadTimeout)
+	upload := maxUploadBody(int64(a.cfg.MaxUploadMB) << 20)
+
+	v1 := r.Group("/api")
+	{
+		v1.POST("/provision", a.handleProvision)
+		v1.POST("/destroy", a.handleDestroy)
+		v1.GET("/jobs/:id", a.handleJobStatus)
+		v1.GET("/jobs/:id/events", long, a.handleJobEvents)
+		v1.GET("/sites/:site", a.handleSiteStatus)
+		v1.GET("/sites/:site/history", a.handleSiteHistory)
+		v1.GET("/sites/:site/disk", long, a.handleSiteDisk)
+		v1.GET("/health", a.handleHealth)
+		v1.GET("/version", a.handleVersion)
+		v1.GET("/config", a.handleEffectiveConfig)
+		v1.GET("/stats", a.handleStats)
+		v1.GET("/domains", a.handleListDomains)
+		v1.GET("/domains/check", a.handleDomainCheck)
+		v1.POST("/domains/move", long, a.handleMoveDomain)
+		v1.POST("/admin/prune", long, a.handlePrune)
+		v1.POST("/admin/jobs/purge", a.handlePurgeJobs)
+		v1.GET("/admin/worker", a.handleAdminWorker)
+		v1.GET("/quota", a.handleQuota)
+		v1.GET("/sites", a.handleListSites)
+		v1.DELETE("/sites/:site", a.handleDeleteSite)
+		v1.DELETE("/jobs/:id", a.handleDeleteJob)
+		v1.POST("/jobs/:id/retry", a.handleRetryJob)
+		v1.GET("/jobs/stuck", a.handleListStuckJobs)
+		v1.GET("/jobs/failures", a.handleJobFailures)
+		v1.POST("/jobs/:id/recover", a.handleRecoverJob)
+		v1.POST("/jobs/:id/cancel", a.handleCancelJob)
+		v1.POST("/static/provision", requireFeature(a.cfg, FeatureStatic), uploadLong, upload, a.handleStaticProvision)
+		v1.POST("/sites/:site/deploy", requireFeature(a.cfg, FeatureStatic), uploadLong, upload, a.handleStaticDeploy)
+		v1.POST("/sites/:site/rollback", requireFeature(a.cfg, FeatureStatic), a.handleStaticRollback)
+		v1.POST("/sites/:site/domain", long, a.handleSetCustomDomain)
+		v1.DELETE("/sites/:site/domain", a.handleRemoveCustomDomain)
+		v1.GET("/sites/:site/domain/status", a.handleDomainStatus)
+		v1.GET("/sites/:site/domain/preview", a.handleDomainPreview)
+		v1.POST("/sites/:site/cert-retry", long, a.handleCertRetry)
+		v1.GET("/sites/:site/cert", a.handleSiteCert)
+		v1.POST("/sites/:site/redeploy-config", a.handleRedeployConfig)
+		v1.POST("/sites/:site/subdomain", a.handleChangeSubdomain)
+		blueGreen := requireFeature(a.cfg, FeatureBlueGreen)
+		v1.POST("/sites/:site/green", blueGreen, a.handleCreateGreen)
+		v1.DELETE("/sites/:site/green", blueGreen, a.handleDiscardGreen)
+		v1.POST("/sites/:site/green/cutover", blueGreen, a.handleGreenCutover)
+		v1.POST("/sites/:site/green/rollback", blueGreen, a.handleGreenRollback)
+		v1.DELETE("/sites/:site/green/retired", blueGreen, a.handleRetireBlue)
+		v1.POST("/sites/:site/reconcile", long, a.handleReconcileSite)
+		v1.POST("/sites/:site/pause", a.handlePauseSite)
+		v1.POST("/sites/:site/resume", long, a.handleResumeSite)
+		backups := requireFeature(a.cfg, FeatureBackups)
+		v1.POST("/sites/:site/backup", backups, long, a.handleBackupSite)
+		v1.GET("/sites/:site/backups", backups, a.handleListBackups)
+		v1.POST("/sites/:site/backup-schedule", backups, a.handleSetBackupSchedule)
+		v1.POST("/sites/:site/restore/:date", backups, long, a.handleRestoreSite)
+		v1.POST("/sites/:site/clone", requireFeature(a.cfg, FeatureClone), a.handleCloneSite)
+		v1.POST("/sites/:site/wp-cli", requireFeature(a.cfg, FeatureWPCLI), long, a.handleWPCLI)
+		v1.GET("/sites/:site/nginx-snippet", a.handleGetNginxSnippet)
+		v1.PUT("/sites/:site/nginx-snippet", a.handleSetNginxSnippet)
+		v1.PUT("/sites/:site/fastcgi", a.handleSetFastCGI)
+		v1.PUT("/sites/:site/nginx-resources", a.handleSetNginxResources)
+		v1.POST("/sites/:site/dns/verify", a.handleVerifyDNS)
+		v1.GET("/sites/:site/wp-urls", a.handleWordPressURLs)
+		v1.POST("/sites/:site/admin-credentials/reveal", a.handleRevealAdminCredentials)
+		v1.GET("/sites/:site/caddy", a.handleGetCaddySnippet)
+		v1.PUT("/sites/:site/caddy", a.handleSetCaddySnippet)
+		v1.DELETE("/sites/:site/caddy", a.handleResetCaddySnippet)
+		v1.GET("/sites/:site/caddy-log", a.handleTailCaddyLog)
+		v1.PUT("/sites/:site/caddy-log", a.handleSetCaddyLog)
+		v1.PUT("/sites/:site/error-page", a.handleSetErrorPage)
+		v1.GET("/templates", a.handleListTemplates)
+		v1.POST("/templates", a.handleRegisterTemplate)
+		v1.GET("/worker", a.handleWorkerStatus)
+		v1.GET("/maintenance", a.handleGetMaintenance)
+		v1.PUT("/maintenance", a.handleSetMaintenance)
+	}
+}
+
+// GET /api/sites/:site/domain/status
+//
+// Returns a live snapshot of the custom domain's DNS and TLS state. Safe to
+// poll frequently from the UI — no infra changes are made. Designed for the
+// "add domain" setup flow where the user needs to know:
+//   - what A record value to configure
+//   - whether their DNS change has propagated
+//   - whether the TLS cert has been issued
+func (a *API) handleDomainStatus(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.CustomDomain == "" {
+		respondError(c, http.StatusBadRequest, CodeNoCustomDomain, "no custom domain set on this site")
+		return
+	}
+
+	domain := s.CustomDomain
+
+	// Live DNS check — always fresh, never cached
+	dns := CheckDomainDNS(a.cfg.DomainResolver(), domain, a.cfg.IngressIPs)
 
 	// Live cert check
 	certIssued := caddyHasCert(a.docker, a.cfg, domain)
@@ -404,8 +1064,9 @@ func (a *API) handleDomainStatus(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"domain":      domain,
-		"expected_ip": a.cfg.PublicIP,
+		"domain":       domain,
+		"expected_ip":  a.cfg.PublicIP,
+		"expected_ips": a.cfg.IngressIPs,
 		"dns": gin.H{
 			"ok":       dns.PointsToIP,
 			"resolved": dns.Resolved,
@@ -416,6 +1077,94 @@ func (a *API) handleDomainStatus(c *gin.Context) {
 	})
 }
 
+// GET /api/sites/:site/domain/preview?domain=<custom>[&www=redirect|serve]
+//
+// Dry run of a custom-domain change: renders the Caddy snippet (and, for
+// WordPress sites, the nginx server block) that set-domain would write for
+// the given domain and www mode — or that remove-domain would write when
+// domain is omitted — and diffs each against the config currently in the
+// container.
+// Nothing is written or reloaded.
+func (a *API) handleDomainPreview(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+
+	customDomain, wwwMode := "", ""
+	if raw := c.Query("domain"); raw != "" {
+		if s.PathPrefix != "" {
+			respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites cannot have a custom domain")
+			return
+		}
+		customDomain, err = NormalizeDomain(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
+			return
+		}
+		if err := ValidateCustomDomain(customDomain, a.cfg.BaseDomains); err != nil {
+			respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
+			return
+		}
+		wwwMode = strings.ToLower(c.Query("www"))
+		if err := ValidateWWWMode(customDomain, wwwMode); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	isWP := a.isWordPressSite(s)
+
+	caddyPath := caddySnippetPath(a.cfg, s)
+	var caddyConf string
+	switch {
+	case isWP && s.PathPrefix != "":
+		caddyConf = renderCaddyPathRoute(s.PathPrefix, NginxContainerName(SiteResources(s)))
+	case isWP:
+		caddyConf = renderCaddyConfig(NginxContainerName(SiteResources(s)), s.Domain, customDomain, wwwMode,
+			renderCaddyLog(a.cfg, site, s.CaddyLog), renderCaddyErrorPage(a.cfg, site, s.ErrorPage))
+	default:
+		caddyConf = renderStaticCaddyConfig(site, s.StaticRelease, s.Domain, customDomain, wwwMode,
+			renderCaddyLog(a.cfg, site, s.CaddyLog), renderCaddyErrorPage(a.cfg, site, s.ErrorPage))
+	}
+	caddyPreview, err := previewContainerFile(ctx, a.docker, a.cfg.CaddyContainer, caddyPath, caddyConf)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to read current caddy config: "+err.Error())
+		return
+	}
+
+	resp := gin.H{
+		"site":                   site,
+		"current_custom_domain":  s.CustomDomain,
+		"proposed_custom_domain": customDomain,
+		"caddy":                  caddyPreview,
+	}
+
+	if isWP {
+		nginxConf := renderNginxConfig(PHPContainerName(SiteResources(s)), s.Domain, customDomain, wwwMode, s.PathPrefix, s.NginxSnippet,
+			resolveFastCGI(a.cfg, s.FastCGI))
+		nginxPreview, err := previewContainerFile(ctx, a.docker, NginxContainerName(SiteResources(s)),
+			nginxSiteConfPath, nginxConf)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to read current nginx config: "+err.Error())
+			return
+		}
+		resp["nginx"] = nginxPreview
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 // POST /api/sites/:site/cert-retry
 //
 // Forces a Caddy reload to re-queue ACME issuance for the site's domain, then
@@ -426,15 +1175,15 @@ func (a *API) handleCertRetry(c *gin.Context) {
 
 	s, err := a.db.GetSite(site)
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
 		return
 	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch site"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
 		return
 	}
 	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site is not active"})
+		respondError(c, http.StatusConflict, CodeSiteState, "site is not active")
 		return
 	}
 
@@ -443,287 +1192,3089 @@ func (a *API) handleCertRetry(c *gin.Context) {
 		domainToCheck = s.CustomDomain
 	}
 
-	if err := reloadCaddy(a.cfg); err != nil {
-		log.Printf("[cert-retry] site=%s caddy reload failed: %v", site, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "caddy reload failed: " + err.Error()})
+	if err := reloadCaddy(c.Request.Context(), a.cfg); err != nil {
+		log.Printf("[cert-retry] site=%s caddy reload failed: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy reload failed: "+err.Error())
+		return
+	}
+
+	log.Printf("[cert-retry] site=%s domain=%s caddy reloaded, polling cert...", site, domainToCheck)
+	certStatus := PollCaddyCert(c.Request.Context(), a.docker, a.cfg, domainToCheck, 30*time.Second)
+	log.Printf("[cert-retry] site=%s domain=%s cert_status=%s", site, domainToCheck, certStatus)
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":        site,
+		"domain":      domainToCheck,
+		"cert_status": string(certStatus),
+	})
+}
+
+// GET /api/sites/:site/cert
+//
+// Returns the details of the certificates Caddy holds for the site's default
+// and custom domains — subject, SANs, issuer, validity and days until
+// expiry. Certificates within CERT_EXPIRY_WARN_DAYS of expiry are flagged,
+// since Caddy renews well before that and one this close means renewal is
+// failing.
+func (a *API) handleSiteCert(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+
+	domains := []string{s.Domain}
+	if s.CustomDomain != "" {
+		domains = append(domains, s.CustomDomain)
+	}
+	certs := make([]CertDetails, 0, len(domains))
+	expiringSoon := false
+	for _, domain := range domains {
+		cert := readCaddyCert(c.Request.Context(), a.docker, a.cfg, domain, a.cfg.CertExpiryWarnDays)
+		expiringSoon = expiringSoon || cert.ExpiringSoon
+		certs = append(certs, cert)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":          site,
+		"warn_days":     a.cfg.CertExpiryWarnDays,
+		"expiring_soon": expiringSoon,
+		"certs":         certs,
+	})
+}
+
+// POST /api/provision
+func (a *API) handleProvision(c *gin.Context) {
+	var req struct {
+		Site         string `json:"site" binding:"required"`
+		VolumeSize   string `json:"volume_size"`
+		Template     string `json:"template"`
+		Routing      string `json:"routing"`        // "subdomain" (default) or "path"
+		NginxSnippet string `json:"nginx_snippet"`  // extra rules for the nginx server block
+		LogMaxSize   string `json:"log_max_size"`   // container log rotation size, e.g. "10m"
+		LogMaxFile   string `json:"log_max_file"`   // rotated container log files to keep
+		Restart      string `json:"restart_policy"` // no, on-failure, always or unless-stopped (default)
+		CustomDomain string `json:"custom_domain"`  // attached once the site is up, if DNS is ready
+		BaseDomain   string `json:"base_domain"`    // one of the configured base domains (default BASE_DOMAIN)
+		Force        bool   `json:"force"`          // remove leftover containers/volumes for this slug
+
+		NginxResources NginxResources `json:"nginx_resources"` // sidecar limits; omitted fields use the defaults
+
+		FastCGI FastCGISettings `json:"fastcgi"` // nginx → PHP-FPM timeouts and buffers; omitted fields use the defaults
+
+		FPM FPMPool `json:"fpm"` // PHP-FPM pool plan and overrides; omitted fields use the plan's
+
+		ObjectCache bool `json:"object_cache"` // Redis object cache, per-site or shared per REDIS_MODE
+
+		Hook *PostProvisionHook `json:"hook"` // overrides POST_PROVISION_HOOK; admin key only
+
+		ExistingVolume string `json:"existing_volume"` // populated volume to mount instead of a new one; admin key only
+
+		Network string `json:"network"` // Docker network to pin the site to instead of NETWORK_ISOLATION's; admin key only
+		Egress  string `json:"egress"`  // outbound policy, "open" or "internal" (default EGRESS_POLICY)
+
+		Locale     string   `json:"locale"`      // install WordPress in this locale, e.g. de_DE
+		Plugins    []string `json:"plugins"`     // wordpress.org slugs to install and activate, best effort
+		AdminEmail string   `json:"admin_email"` // admin address if the installer is run (default admin@<domain>)
+
+		WPConfigExtra string `json:"wp_config_extra"` // replaces WP_CONFIG_EXTRA for this site ("none" = nothing); admin key only
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "site is required")
+		return
+	}
+
+	site := strings.ToLower(req.Site)
+
+	if !validSite.MatchString(site) {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name must be lowercase letters and numbers only")
+		return
+	}
+	if err := ValidateSiteDBIdentifiers(site); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name is too long: "+err.Error())
+		return
+	}
+
+	opts := ProvisionOptions{VolumeSize: strings.ToUpper(strings.TrimSpace(req.VolumeSize))}
+	if opts.VolumeSize != "" && !validVolumeSize.MatchString(opts.VolumeSize) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "volume_size must be a number with an optional K/M/G/T suffix")
+		return
+	}
+
+	opts.NginxSnippet = strings.TrimSpace(req.NginxSnippet)
+	if err := ValidateNginxSnippet(opts.NginxSnippet); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	opts.LogMaxSize = strings.ToLower(strings.TrimSpace(req.LogMaxSize))
+	opts.LogMaxFile = strings.TrimSpace(req.LogMaxFile)
+	if (opts.LogMaxSize != "" || opts.LogMaxFile != "") && !logDriverRotates(a.cfg.LogDriver) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "log_max_size and log_max_file need the json-file or local log driver, not "+a.cfg.LogDriver)
+		return
+	}
+	if opts.LogMaxSize != "" && !validLogMaxSize.MatchString(opts.LogMaxSize) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "log_max_size must be a number with an optional k/m/g suffix")
+		return
+	}
+	if opts.LogMaxFile != "" && !validLogMaxFile.MatchString(opts.LogMaxFile) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "log_max_file must be between 1 and 99")
+		return
+	}
+
+	opts.RestartPolicy = strings.ToLower(strings.TrimSpace(req.Restart))
+	if opts.RestartPolicy != "" && !restartPolicies[opts.RestartPolicy] {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "restart_policy must be one of no, on-failure, always, unless-stopped")
+		return
+	}
+
+	if err := req.NginxResources.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	opts.NginxResources = req.NginxResources
+
+	if err := req.FastCGI.validate("fastcgi."); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	opts.FastCGI = req.FastCGI
+
+	if err := resolveFPMPool(a.cfg, req.FPM).validate("fpm."); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	opts.FPM = req.FPM
+	if req.ObjectCache {
+		if !a.cfg.FeatureEnabled(FeatureRedis) {
+			respondFeatureDisabled(c, FeatureRedis)
+			return
+		}
+		opts.ObjectCache = a.cfg.RedisMode
+	}
+
+	opts.Locale = strings.TrimSpace(req.Locale)
+	for _, slug := range req.Plugins {
+		if slug = strings.ToLower(strings.TrimSpace(slug)); slug != "" {
+			opts.Plugins = append(opts.Plugins, slug)
+		}
+	}
+	if err := validateWordPressSetup(opts.Locale, opts.Plugins); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if opts.AdminEmail = strings.TrimSpace(req.AdminEmail); opts.AdminEmail != "" {
+		if _, err := mail.ParseAddress(opts.AdminEmail); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "admin_email is not a valid email address")
+			return
+		}
+	}
+
+	// A hook runs arbitrary commands against the site's files and database,
+	// so only the admin key may supply one.
+	if req.Hook != nil {
+		if !a.cfg.FeatureEnabled(FeatureHooks) {
+			respondFeatureDisabled(c, FeatureHooks)
+			return
+		}
+		if !c.GetBool(fullAccessKey) {
+			respondError(c, http.StatusForbidden, CodeAdminRequired, "hook requires the admin API key")
+			return
+		}
+		req.Hook.Command = strings.TrimSpace(req.Hook.Command)
+		req.Hook.Image = strings.TrimSpace(req.Hook.Image)
+		if req.Hook.Command == "" || len(req.Hook.Command) > hookCommandMaxLen {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("hook.command must be 1-%d bytes", hookCommandMaxLen))
+			return
+		}
+		opts.Hook = req.Hook
+	}
+
+	// An attached volume exposes whatever it holds to the new site, so only
+	// the admin key may name one.
+	if v := strings.TrimSpace(req.ExistingVolume); v != "" {
+		if !c.GetBool(fullAccessKey) {
+			respondError(c, http.StatusForbidden, CodeAdminRequired, "existing_volume requires the admin API key")
+			return
+		}
+		if req.Template != "" || opts.VolumeSize != "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "existing_volume cannot be combined with template or volume_size")
+			return
+		}
+		opts.ExistingVolume = v
+	}
+
+	// A pinned network decides which other sites can reach this one, so
+	// only the admin key may name one.
+	opts.Network = a.cfg.NetworkFor(tenant(c), site)
+	if v := strings.TrimSpace(req.Network); v != "" {
+		if !c.GetBool(fullAccessKey) {
+			respondError(c, http.StatusForbidden, CodeAdminRequired, "network requires the admin API key")
+			return
+		}
+		if err := ValidateNetworkName(v); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		opts.Network = v
+		if v == a.cfg.DockerNetwork {
+			opts.Network = ""
+		}
+	}
+
+	// The egress policy is set on the site's network, so a restricted
+	// site gets one of its own rather than sharing its tenant's.
+	opts.Egress = a.cfg.EgressPolicy
+	if req.Egress != "" {
+		var err error
+		if opts.Egress, err = ValidateEgressPolicy(strings.TrimSpace(req.Egress)); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if opts.Egress != EgressOpen {
+		if strings.TrimSpace(req.Network) != "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "network cannot be combined with an egress policy: the site gets a network of its own")
+			return
+		}
+		opts.Network = SiteNetworkName(site)
+	}
+
+	// wp_config_extra is PHP run on every request of the site, so only the
+	// admin key may set it.
+	if v := strings.TrimSpace(req.WPConfigExtra); v != "" {
+		if !c.GetBool(fullAccessKey) {
+			respondError(c, http.StatusForbidden, CodeAdminRequired, "wp_config_extra requires the admin API key")
+			return
+		}
+		if len(v) > maxWPConfigExtra {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("wp_config_extra must be at most %d bytes", maxWPConfigExtra))
+			return
+		}
+		opts.WPConfigExtra = v
+	}
+
+	switch req.Routing {
+	case "", "subdomain":
+	case "path":
+		opts.PathMode = true
+	default:
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, `routing must be "subdomain" or "path"`)
+		return
+	}
+
+	baseDomain, ok := a.resolveBaseDomain(c, req.BaseDomain)
+	if !ok {
+		return
+	}
+	// The path-routing block in Caddy is generated for the default base
+	// domain only.
+	if opts.PathMode && baseDomain != a.cfg.BaseDomain {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path routing is only available on "+a.cfg.BaseDomain)
+		return
+	}
+	if baseDomain != a.cfg.BaseDomain {
+		opts.BaseDomain = baseDomain
+	}
+
+	// A custom domain must be valid and unclaimed now; DNS may still be
+	// propagating, so that check is repeated when the job finishes.
+	dnsPending := false
+	if req.CustomDomain != "" {
+		if opts.PathMode {
+			respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites cannot have a custom domain")
+			return
+		}
+		domain, err := NormalizeDomain(req.CustomDomain)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
+			return
+		}
+		for _, check := range a.checkCustomDomain(domain, site) {
+			switch {
+			case check.OK || check.Skipped:
+			case check.Name == "dns":
+				dnsPending = true
+			default:
+				respondError(c, check.status, check.Code, check.Error)
+				return
+			}
+		}
+		opts.CustomDomain = domain
+	}
+
+	if req.Template != "" {
+		tmpl, err := a.db.GetTemplate(req.Template)
+		if err == sql.ErrNoRows {
+			respondError(c, http.StatusBadRequest, CodeTemplateNotFound, "unknown template: "+req.Template)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load template")
+			return
+		}
+		opts.Template = tmpl
+	}
+
+	// Reject if site already has an active job
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
+	}
+
+	// Only a new slug, or one whose site was destroyed or failed, may be
+	// provisioned; force must never reach a live site's resources.
+	existing, err := a.db.GetSite(site)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check site status")
+		return
+	}
+	if existing != nil && existing.Status != "DESTROYED" && existing.Status != "FAILED" {
+		respondError(c, http.StatusConflict, CodeSiteExists, "site already exists ("+existing.Status+") — destroy it first")
+		return
+	}
+	if existing != nil && existing.Status == "FAILED" && !ownsSite(c, existing) {
+		respondError(c, http.StatusConflict, CodeSiteExists, "site already exists")
+		return
+	}
+
+	if !opts.PathMode && !a.ensureSubdomainAvailable(c, site, baseDomain) {
+		return
+	}
+	if !a.enforceQuota(c, site) {
+		return
+	}
+	if !a.enforceHostCapacity(c, site) {
+		return
+	}
+	volumeDriver := a.cfg.VolumeDriver
+	if opts.ExistingVolume != "" {
+		if volumeDriver, ok = a.ensureVolumeAttachable(c, site, opts.ExistingVolume); !ok {
+			return
+		}
+	}
+	if !a.ensureNoCollisions(c, site, req.Force, opts.ExistingVolume) {
+		return
+	}
+
+	jobID := uuid.New().String()
+	domain := SiteDomain(site, baseDomain)
+	pathPrefix := ""
+	if opts.PathMode {
+		domain, pathPrefix = baseDomain, SitePathPrefix(site)
+	}
+
+	payload, err := json.Marshal(opts)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to encode provision options")
+		return
+	}
+
+	rec := SiteProvision{
+		Site: site, Domain: domain, PathPrefix: pathPrefix, BaseDomain: baseDomain,
+		Type: SiteTypeWordPress, Tenant: tenant(c),
+		VolumeDriver: volumeDriver, VolumeSize: opts.VolumeSize, ExternalVolume: opts.ExistingVolume,
+		NginxSnippet:   opts.NginxSnippet,
+		RestartPolicy:  opts.RestartPolicy,
+		NginxResources: opts.NginxResources,
+		WPConfigExtra:  opts.WPConfigExtra,
+		FastCGI:        opts.FastCGI,
+		FPM:            opts.FPM,
+		ObjectCache:    opts.ObjectCache,
+		Network:        opts.Network,
+		Egress:         opts.Egress,
+	}
+	if err := a.db.QueueProvision(rec, jobID, JobProvision, requestID(c), string(payload)); err != nil {
+		log.Printf("[api] site=%s provision not queued: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+
+	resp := gin.H{
+		"job_id": jobID,
+		"site":   site,
+		"domain": domain,
+		"url":    "https://" + domain + pathPrefix + "/",
+		"status": "PENDING",
+	}
+	if opts.CustomDomain != "" {
+		resp["custom_domain"] = opts.CustomDomain
+		resp["custom_domain_dns_ready"] = !dnsPending
+	}
+	respondJobAccepted(c, jobID, resp)
+}
+
+// GET /api/sites
+func (a *API) handleListSites(c *gin.Context) {
+	all, err := a.db.ListSites()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch sites")
+		return
+	}
+	sites := []Site{}
+	for i := range all {
+		if ownsSite(c, &all[i]) {
+			sites = append(sites, all[i])
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"sites": sites})
+}
+
+// GET /api/domains[?include_destroyed=true]
+//
+// Lists every hostname the platform routes — each site's default domain,
+// custom domain, www variant and green preview domain — with the owning
+// site and its status, as the source of truth to diff against the tunnel
+// ingress and DNS records.
+func (a *API) handleListDomains(c *gin.Context) {
+	domains, err := a.db.ListDomains(a.cfg.BaseDomain, c.Query("include_destroyed") == "true")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch domains")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"domains": domains, "count": len(domains)})
+}
+
+// DELETE /api/sites/:site
+func (a *API) handleDeleteSite(c *gin.Context) {
+	site := c.Param("site")
+
+	existing, err := a.db.GetSite(site)
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if existing.Status != "DESTROYED" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be DESTROYED before hard delete")
+		return
+	}
+
+	if err := a.db.HardDeleteSite(site); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete site")
+		return
+	}
+
+	a.events.Publish(Event{Type: EventSiteDeleted, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"deleted": site})
+}
+
+// DELETE /api/jobs/:id
+func (a *API) handleDeleteJob(c *gin.Context) {
+	id := c.Param("id")
+
+	job, err := a.db.GetJob(id)
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if job.Status == StatusProcessing || job.Status == StatusPending {
+		respondError(c, http.StatusConflict, CodeJobActive, "cannot delete active job")
+		return
+	}
+
+	if err := a.db.HardDeleteJob(id); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to delete job")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": id})
+}
+
+// POST /api/jobs/:id/retry
+//
+// Re-queues a FAILED job with its attempt counter reset, as a cleaner
+// alternative to deleting it and re-issuing the original request. Only the
+// site's current job can be retried, and only when the site has no other
+// PENDING or PROCESSING job.
+func (a *API) handleRetryJob(c *gin.Context) {
+	id := c.Param("id")
+
+	job, err := a.db.GetJob(id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch job")
+		return
+	}
+	if job.Status != StatusFailed {
+		respondError(c, http.StatusConflict, CodeJobNotRetryable, fmt.Sprintf("only FAILED jobs can be retried (job is %s)", job.Status))
+		return
+	}
+
+	s, err := a.db.GetSite(job.Site)
+	if err != nil {
+		respondError(c, http.StatusConflict, CodeJobNotRetryable, "site record no longer exists — re-issue the original request")
+		return
+	}
+	if s.JobID != job.ID {
+		respondErrorWith(c, http.StatusConflict, CodeJobNotRetryable, "a newer job has run for this site since — retry that one instead", gin.H{"current_job_id": s.JobID})
+		return
+	}
+
+	active, err := a.db.HasActiveJob(job.Site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check active jobs")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has an active job")
+		return
+	}
+
+	if job.Type == JobStaticProvision || job.Type == JobStaticDeploy {
+		payload, err := a.db.GetJobPayload(job.ID)
+		if err != nil || payload == "" {
+			respondError(c, http.StatusConflict, CodeJobNotRetryable, "job has no upload payload — re-upload the site")
+			return
+		}
+		if _, err := os.Stat(payload); err != nil {
+			respondError(c, http.StatusConflict, CodeJobNotRetryable, "uploaded zip is no longer available — re-upload the site")
+			return
+		}
+	}
+
+	reset, err := a.db.ResetFailedJob(job.ID)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to reset job")
+		return
+	}
+	if !reset {
+		respondError(c, http.StatusConflict, CodeJobNotRetryable, "job is no longer FAILED")
+		return
+	}
+
+	// A redeploy, subdomain change or blue/green step runs against a live
+	// site, so its status is left as is.
+	if !job.Type.KeepsSiteStatus() {
+		siteStatus := SiteProvisioning
+		if job.Type == JobDestroy {
+			siteStatus = SiteDestroying
+		}
+		if err := a.db.UpdateSiteStatus(job.Site, string(siteStatus)); err != nil {
+			log.Printf("[api] site=%s warning: job %s re-queued but site status not updated: %v", job.Site, job.ID, err)
+		}
+	}
+
+	prevErr := ""
+	if job.Error != nil {
+		prevErr = *job.Error
+	}
+	log.Printf("[api] manual retry: job=%s type=%s site=%s by=%s req=%s previous_error=%q",
+		job.ID, job.Type, job.Site, c.ClientIP(), requestID(c), prevErr)
+	a.recordHistory(job.Site, EventJobRetried, fmt.Sprintf("%s %s", job.Type, job.ID))
+	a.events.Publish(Event{
+		Type: EventJobRetried, Site: job.Site, JobID: job.ID, RequestID: requestID(c),
+		Data: map[string]any{"job_type": job.Type, "previous_error": prevErr},
+	})
+
+	respondJobAccepted(c, job.ID, gin.H{
+		"job_id": job.ID,
+		"site":   job.Site,
+		"type":   job.Type,
+		"status": StatusPending,
+	})
+}
+
+// GET /api/jobs/stuck
+//
+// Lists PROCESSING jobs running longer than their type's timeout — the jobs
+// startup recovery would reset — oldest first, each with running=true when
+// this process's worker is still on it. Admin key only.
+func (a *API) handleListStuckJobs(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "listing stuck jobs requires the admin API key")
+		return
+	}
+	jobs, err := a.db.ListStuckJobs(a.cfg.JobTimeouts, a.cfg.StuckJobTimeout)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to list stuck jobs")
+		return
+	}
+	out := make([]gin.H, 0, len(jobs))
+	for i := range jobs {
+		body := jobStatusBody(&jobs[i])
+		body["timeout_ms"] = a.cfg.JobTimeout(jobs[i].Type).Milliseconds()
+		body["running"] = a.heartbeat.Running(jobs[i].ID)
+		out = append(out, body)
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": out})
+}
+
+// Window of GET /api/jobs/failures, in hours.
+const (
+	jobFailuresDefaultHours = 24
+	jobFailuresMaxHours     = 30 * 24
+)
+
+// GET /api/jobs/failures[?hours=N][&type=T]
+//
+// Groups the jobs that FAILED in the last N hours (default 24, at most 720)
+// by error code and normalized message (see normalizeJobError), most
+// frequent first, so a wave of failures shows its common cause. type
+// narrows it to one job type. Admin key only.
+func (a *API) handleJobFailures(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "listing job failures requires the admin API key")
+		return
+	}
+	hours := jobFailuresDefaultHours
+	if raw := c.Query("hours"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > jobFailuresMaxHours {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("hours must be between 1 and %d", jobFailuresMaxHours))
+			return
+		}
+		hours = n
+	}
+	jobType := JobType(strings.ToUpper(c.Query("type")))
+
+	failures, err := a.db.ListFailedJobErrors(time.Duration(hours)*time.Hour, jobType)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to list failed jobs")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"hours":   hours,
+		"total":   len(failures),
+		"classes": summarizeJobFailures(failures),
+	})
+}
+
+// POST /api/jobs/:id/recover
+//
+// Puts one stuck job back to PENDING without a restart — the single-job
+// version of startup recovery. Only a job PROCESSING past its timeout is
+// reset, and never while the worker heartbeat shows it is still running it:
+// the attempt would then finish against a row already handed back to the
+// queue. Attempts are not reset. Admin key only.
+func (a *API) handleRecoverJob(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "recovering a job requires the admin API key")
+		return
+	}
+	job, err := a.db.GetJob(c.Param("id"))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch job")
+		return
+	}
+	if job.Status != StatusProcessing {
+		respondError(c, http.StatusConflict, CodeJobNotStuck, fmt.Sprintf("only PROCESSING jobs can be recovered (job is %s)", job.Status))
+		return
+	}
+	if a.heartbeat.Running(job.ID) {
+		respondError(c, http.StatusConflict, CodeJobNotStuck, "the worker is still running this job — see GET /api/worker")
+		return
+	}
+
+	recovered, err := a.db.RecoverJob(job.ID, a.cfg.JobTimeouts, a.cfg.StuckJobTimeout)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to recover job")
+		return
+	}
+	if !recovered {
+		respondError(c, http.StatusConflict, CodeJobNotStuck,
+			fmt.Sprintf("job has not been PROCESSING longer than its %s timeout", a.cfg.JobTimeout(job.Type)))
+		return
+	}
+
+	log.Printf("[api] manual recover: job=%s type=%s site=%s started_at=%v by=%s req=%s",
+		job.ID, job.Type, job.Site, job.StartedAt, c.ClientIP(), requestID(c))
+	a.recordHistory(job.Site, EventJobRecovered, fmt.Sprintf("%s %s", job.Type, job.ID))
+	a.events.Publish(Event{
+		Type: EventJobRecovered, Site: job.Site, JobID: job.ID, RequestID: requestID(c),
+		Data: map[string]any{"job_type": job.Type},
+	})
+
+	respondJobAccepted(c, job.ID, gin.H{
+		"job_id": job.ID,
+		"site":   job.Site,
+		"type":   job.Type,
+		"status": StatusPending,
+	})
+}
+
+// POST /api/jobs/:id/cancel
+//
+// Cancels a job. A PENDING job is failed with JOB_CANCELLED before it runs.
+// A provision or clone this process's worker is running has its context
+// cancelled: the step in flight is aborted, everything the earlier steps
+// created is rolled back as when a step fails, and the job ends FAILED
+// with JOB_CANCELLED instead of being retried. Other running jobs cannot be
+// cancelled.
+func (a *API) handleCancelJob(c *gin.Context) {
+	job, err := a.db.GetJob(c.Param("id"))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch job")
+		return
+	}
+
+	record := func(phase string) {
+		log.Printf("[api] cancel: job=%s type=%s site=%s %s by=%s req=%s",
+			job.ID, job.Type, job.Site, phase, c.ClientIP(), requestID(c))
+		a.recordHistory(job.Site, EventJobCancelled, fmt.Sprintf("%s %s", job.Type, job.ID))
+		a.events.Publish(Event{
+			Type: EventJobCancelled, Site: job.Site, JobID: job.ID, RequestID: requestID(c),
+			Data: map[string]any{"job_type": job.Type, "phase": phase},
+		})
+	}
+
+	switch job.Status {
+	case StatusPending:
+		cancelled, err := a.db.CancelPendingJob(job.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to cancel job")
+			return
+		}
+		if cancelled {
+			record("pending")
+			c.JSON(http.StatusOK, gin.H{"job_id": job.ID, "site": job.Site, "type": job.Type, "status": StatusFailed})
+			return
+		}
+		// Claimed by the worker meanwhile: cancel it running, if it can be.
+		if job, err = a.db.GetJob(job.ID); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch job")
+			return
+		}
+		if job.Status != StatusProcessing {
+			break
+		}
+		fallthrough
+	case StatusProcessing:
+		if !job.Type.CancelsMidRun() {
+			respondError(c, http.StatusConflict, CodeJobNotCancellable,
+				fmt.Sprintf("a running %s job cannot be cancelled", job.Type))
+			return
+		}
+		if !a.heartbeat.Cancel(job.ID) {
+			respondError(c, http.StatusConflict, CodeJobNotCancellable,
+				"the worker is not running this job — see GET /api/jobs/stuck")
+			return
+		}
+		record("processing")
+		respondJobAccepted(c, job.ID, gin.H{
+			"job_id":  job.ID,
+			"site":    job.Site,
+			"type":    job.Type,
+			"status":  StatusProcessing,
+			"message": "cancelling: the job rolls back and ends FAILED with JOB_CANCELLED",
+		})
+		return
+	}
+	respondError(c, http.StatusConflict, CodeJobNotCancellable, fmt.Sprintf("job is already %s", job.Status))
+}
+
+// POST /api/destroy
+func (a *API) handleDestroy(c *gin.Context) {
+	var req struct {
+		Site    string `json:"site" binding:"required"`
+		Confirm string `json:"confirm"` // must repeat the site name when REQUIRE_DESTROY_CONFIRM is on
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "site is required")
+		return
+	}
+
+	site := strings.ToLower(req.Site)
+
+	if !validSite.MatchString(site) {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name must be lowercase letters and numbers only")
+		return
+	}
+	// Compared exactly: the point is to make the caller type the name again.
+	if a.cfg.RequireDestroyConfirm && req.Confirm != site {
+		respondError(c, http.StatusBadRequest, CodeConfirmRequired, fmt.Sprintf("destroying a site requires \"confirm\": %q", site))
+		return
+	}
+
+	// A domain move checks for jobs under the same lock.
+	a.domainMu.Lock()
+	defer a.domainMu.Unlock()
+
+	// Must exist and not already be destroying
+	existing, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows || existing == nil {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check site")
+		return
+	}
+	if !ensureSiteOwner(c, existing) {
+		return
+	}
+	if existing.Status == "DESTROYING" || existing.Status == "DESTROYED" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site is already being destroyed or is destroyed")
+		return
+	}
+
+	// Reject if already has active job
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
+	}
+
+	jobID := uuid.New().String()
+
+	if err := a.db.InsertJob(jobID, JobDestroy, site, requestID(c)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+
+	if err := a.db.UpsertSite(site, existing.Domain, "DESTROYING", jobID, ""); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to update site status")
+		return
+	}
+
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id": jobID,
+		"site":   site,
+		"status": "PENDING",
+	})
+}
+
+// GET /api/sites/:site/history
+//
+// Returns the site's current state followed by a chronological timeline of
+// its jobs, status transitions and API-driven changes (domains, snippets,
+// rollbacks). History is kept until the site is hard-deleted.
+func (a *API) handleSiteHistory(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+
+	jobs, err := a.db.ListSiteJobs(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch jobs")
+		return
+	}
+	history, err := a.db.ListSiteHistory(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":          s.Site,
+		"status":        s.Status,
+		"domain":        s.Domain,
+		"custom_domain": s.CustomDomain,
+		"job_id":        s.JobID,
+		"updated_at":    s.UpdatedAt,
+		"timeline":      buildSiteTimeline(jobs, history),
+	})
+}
+
+// recordHistory appends an entry to the site's history timeline. Failures
+// are logged only — history must never fail the operation it describes.
+func (a *API) recordHistory(site, kind, detail string) {
+	if err := a.db.RecordSiteHistory(site, kind, detail); err != nil {
+		log.Printf("[api] site=%s warning: could not record %s in history: %v", site, kind, err)
+	}
+}
+
+// GET /api/jobs/:id
+//
+// Supports conditional polling: the response carries an ETag, and a request
+// whose If-None-Match matches it gets 304 Not Modified.
+func (a *API) handleJobStatus(c *gin.Context) {
+	id := c.Param("id")
+
+	job, err := a.db.GetJob(id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	body, err := json.Marshal(jobStatusBody(job))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	// Pollers send the ETag back in If-None-Match and get an empty 304
+	// until something about the job changes.
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// jobStatusBody is the job representation served by GET /api/jobs/:id and
+// streamed by GET /api/jobs/:id/events.
+func jobStatusBody(job *Job) gin.H {
+	return gin.H{
+		"job_id":        job.ID,
+		"request_id":    job.RequestID,
+		"type":          job.Type,
+		"site":          job.Site,
+		"status":        job.Status,
+		"attempts":      job.Attempts,
+		"max_attempts":  job.MaxAttempts,
+		"error":         job.Error,
+		"error_code":    job.ErrorCode,
+		"result":        jobResult(job),
+		"created_at":    job.CreatedAt,
+		"started_at":    job.StartedAt,
+		"completed_at":  job.CompletedAt,
+		"queue_wait_ms": durationMs(job.QueueWait()),
+		"duration_ms":   durationMs(job.Duration()),
+	}
+}
+
+// Job event streams re-read the job row every jobEventsPollInterval and,
+// while nothing changes, write a comment every jobEventsHeartbeat so idle
+// proxies keep the connection open.
+const (
+	jobEventsPollInterval = time.Second
+	jobEventsHeartbeat    = 15 * time.Second
+)
+
+// GET /api/jobs/:id/events
+//
+// Streams the job as Server-Sent Events: a "status" event carrying the same
+// body as GET /api/jobs/:id each time it changes, starting with the current
+// state. The stream ends after the event for a terminal state (COMPLETED or
+// FAILED), or with a "deleted" event if the job is removed meanwhile.
+// Registered as a long-lived route, so the write timeout does not apply.
+func (a *API) handleJobEvents(c *gin.Context) {
+	id := c.Param("id")
+
+	job, err := a.db.GetJob(id)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeJobNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // stop nginx in front of the API buffering events
+	c.Status(http.StatusOK)
+
+	var last []byte
+	// emit writes job if it changed since the last event and reports
+	// whether the stream is done.
+	emit := func(job *Job) bool {
+		body, err := json.Marshal(jobStatusBody(job))
+		if err != nil {
+			log.Printf("[api] job=%s event stream: %v", id, err)
+			return true
+		}
+		if string(body) != string(last) {
+			fmt.Fprintf(c.Writer, "event: status\ndata: %s\n\n", body)
+			c.Writer.Flush()
+			last = body
+		}
+		return job.Status == StatusCompleted || job.Status == StatusFailed
+	}
+
+	if emit(job) {
+		return
+	}
+	poll := time.NewTicker(jobEventsPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(jobEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		case <-poll.C:
+			job, err := a.db.GetJob(id)
+			if err == sql.ErrNoRows {
+				fmt.Fprintf(c.Writer, "event: deleted\ndata: {\"job_id\":%q}\n\n", id)
+				c.Writer.Flush()
+				return
+			}
+			if err != nil {
+				// Transient DB trouble: keep the stream and try again next tick.
+				log.Printf("[api] job=%s event stream: %v", id, err)
+				continue
+			}
+			if emit(job) {
+				return
+			}
+		}
+	}
+}
+
+// jobResult returns the JSON a job stored about its latest attempt, or nil.
+func jobResult(job *Job) any {
+	if job.Result == nil {
+		return nil
+	}
+	return json.RawMessage(*job.Result)
+}
+
+// respondJobAccepted answers 202 with body and a Location header pointing
+// at the queued job, so generic HTTP clients can poll it without parsing
+// the body.
+func respondJobAccepted(c *gin.Context, jobID string, body gin.H) {
+	c.Header("Location", "/api/jobs/"+jobID)
+	c.JSON(http.StatusAccepted, body)
+}
+
+// durationMs converts an optional duration to milliseconds for JSON, keeping
+// nil as null.
+func durationMs(d *time.Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	ms := d.Milliseconds()
+	return &ms
+}
+
+// GET /api/sites/:site
+func (a *API) handleSiteStatus(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+
+	// Live infra checks — only meaningful for ACTIVE/DOMAIN_ACTIVE sites
+	certStatus := ""
+	var warnings []string
+	if s.Status == "ACTIVE" || s.Status == "DOMAIN_ACTIVE" {
+		domainToCheck := s.Domain
+		if s.CustomDomain != "" {
+			domainToCheck = s.CustomDomain
+		}
+
+		// 1. Cert check
+		if caddyHasCert(a.docker, a.cfg, domainToCheck) {
+			certStatus = string(CertIssued)
+		} else {
+			certStatus = string(CertPending)
+			warnings = append(warnings, "TLS cert not yet issued — Caddy is retrying ACME in background; call /cert-retry to force")
+		}
+
+		// 2. Caddy snippet exists on disk. Path-routed sites have a route
+		// file instead, which names their prefix rather than a host.
+		snippetPath := caddySnippetPath(a.cfg, s)
+		routes := domainToCheck
+		if s.PathPrefix != "" {
+			routes = s.PathPrefix
+		}
+		snippetOK := caddySnippetExists(a.docker, a.cfg, snippetPath)
+		if !snippetOK {
+			warnings = append(warnings, "Caddy config snippet missing — site will not be routed; re-provision or call /cert-retry")
+		} else {
+			// 3. Snippet contains the active domain (catches stale snippet after domain move)
+			if !caddySnippetContainsDomain(a.docker, a.cfg, snippetPath, routes) {
+				warnings = append(warnings, "Caddy snippet exists but does not route "+routes+" — reload may be needed; call /cert-retry")
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":            s.Site,
+		"domain":          s.Domain,
+		"type":            s.Type,
+		"custom_domain":   s.CustomDomain,
+		"www":             s.WWWMode,
+		"base_domain":     SiteBaseDomain(s, a.cfg.BaseDomain),
+		"path_prefix":     s.PathPrefix,
+		"status":          s.Status,
+		"cert_status":     certStatus,
+		"warnings":        warnings,
+		"job_id":          s.JobID,
+		"last_backup_at":  s.LastBackupAt,
+		"backup_schedule": s.BackupSchedule,
+		"volume_driver":   s.VolumeDriver,
+		"volume_size":     s.VolumeSize,
+		"release":         s.StaticRelease,
+		"prev_release":    s.StaticPrevRelease,
+		"restart_policy":  s.RestartPolicy,
+		"nginx_resources": s.NginxResources,
+		"fastcgi":         resolveFastCGI(a.cfg, s.FastCGI),
+		"fpm":             resolveFPMPool(a.cfg, s.FPM),
+		"caddy_log":       s.CaddyLog,
+		"error_page":      s.ErrorPage,
+		"object_cache":    s.ObjectCache,
+		"network":         SiteNetwork(s, a.cfg),
+		"egress":          s.Egress,
+		"admin":           adminCredentialsStatus(s),
+		"external_volume": s.ExternalVolume,
+		"blue_green":      blueGreenStatus(s, a.cfg.BaseDomain),
+		"pending_domain":  pendingDomainStatus(s),
+		"created_at":      s.CreatedAt,
+		"updated_at":      s.UpdatedAt,
+	})
+}
+
+// GET /api/sites/:site/disk[?refresh=true]
+//
+// Reports the storage a site consumes: its files (WordPress volume or
+// static releases) and, for WordPress, its database. Measurements are
+// cached for diskUsageCacheTTL; refresh=true measures again.
+func (a *API) handleSiteDisk(c *gin.Context) {
+	site := c.Param("site")
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status == string(SiteDestroyed) {
+		respondError(c, http.StatusConflict, CodeSiteState, "site is destroyed")
+		return
+	}
+
+	a.diskMu.Lock()
+	cached := a.diskCache[site]
+	a.diskMu.Unlock()
+	if cached != nil && c.Query("refresh") != "true" && time.Since(cached.MeasuredAt) < diskUsageCacheTTL {
+		c.JSON(http.StatusOK, cached)
+		return
+	}
+
+	usage, err := measureDiskUsage(c.Request.Context(), a.docker, a.cfg, s, a.isWordPressSite(s))
+	if err != nil {
+		log.Printf("[api] site=%s disk usage: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to measure disk usage: "+err.Error())
+		return
+	}
+
+	a.diskMu.Lock()
+	a.diskCache[site] = usage
+	a.diskMu.Unlock()
+	c.JSON(http.StatusOK, usage)
+}
+
+// GET /api/version
+//
+// Reports the build and the optional features enabled in this deployment
+// (FEATURES), so clients can tell which routes will answer 501.
+func (a *API) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"version":  version,
+		"go":       runtime.Version(),
+		"features": a.cfg.enabledFeatures(),
+	})
+}
+
+// GET /api/config
+//
+// Lists every environment variable the control plane read at startup with
+// the value it resolved to and whether that was set or defaulted; secrets
+// are redacted. Admin key only.
+func (a *API) handleEffectiveConfig(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "reading the configuration requires the admin API key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"settings": a.cfg.effective})
+}
+
+// GET /api/health
+//
+// With ?deep=true, also checks the tunnel service target and, when
+// TUNNEL_SELFTEST_HOST is set, that the known host routes through the tunnel
+// to Caddy. A failed deep check returns 503.
+func (a *API) handleHealth(c *gin.Context) {
+	if c.Query("deep") != "true" {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "read_only": a.maint.Enabled()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
+	defer cancel()
+
+	resp := gin.H{"status": "ok", "read_only": a.maint.Enabled()}
+	healthy := true
+
+	if err := ProbeServiceTarget(ctx, a.cfg.ServiceTarget); err != nil {
+		resp["service_target"] = gin.H{"url": a.cfg.ServiceTarget, "ok": false, "error": err.Error()}
+		healthy = false
+	} else {
+		resp["service_target"] = gin.H{"url": a.cfg.ServiceTarget, "ok": true}
+	}
+
+	if a.cfg.TunnelSelfTestHost != "" {
+		check := a.tunnel.SelfTest(ctx, a.cfg.TunnelSelfTestHost)
+		resp["tunnel"] = check
+		healthy = healthy && check.OK
+	}
+
+	// A worker paused by read-only mode still polls, so it stays healthy.
+	worker := a.heartbeat.Status()
+	resp["worker"] = worker
+	healthy = healthy && worker.Healthy
+
+	if !healthy {
+		resp["status"] = "degraded"
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/sites/:site/clone
+//
+// Queues a CLONE job that provisions `target` as a copy of a live WordPress
+// site: fresh DB/volume/containers, the source volume copied in, the source
+// database dumped and imported, siteurl/home rewritten to the target domain,
+// and new Caddy/nginx config. Typical use: staging from production.
+func (a *API) handleCloneSite(c *gin.Context) {
+	source := c.Param("site")
+
+	var req struct {
+		Target        string `json:"target" binding:"required"`
+		Force         bool   `json:"force"`          // remove leftover containers/volumes for the target slug
+		AdminPassword string `json:"admin_password"` // "reuse" (default) or "reset"
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "target is required")
+		return
+	}
+	adminPassword, err := ValidateAdminPasswordMode(req.AdminPassword)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	target := strings.ToLower(req.Target)
+	if !validSite.MatchString(target) {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name must be lowercase letters and numbers only")
+		return
+	}
+	if err := ValidateSiteDBIdentifiers(target); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "site name is too long: "+err.Error())
+		return
+	}
+	if target == source {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "target must differ from source")
+		return
+	}
+
+	src, err := a.db.GetSite(source)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if src.Status != "ACTIVE" && src.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "source site must be ACTIVE to clone")
+		return
+	}
+	if !a.isWordPressSite(src) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "only WordPress sites can be cloned")
+		return
+	}
+
+	existing, err := a.db.GetSite(target)
+	if err != nil && err != sql.ErrNoRows {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check target status")
+		return
+	}
+	if existing != nil && existing.Status != "DESTROYED" {
+		respondError(c, http.StatusConflict, CodeSiteExists, "target site already exists")
+		return
+	}
+	active, err := a.db.HasActiveJob(target)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "target already has a pending or processing job")
+		return
+	}
+	baseDomain := SiteBaseDomain(src, a.cfg.BaseDomain)
+	if !a.ensureSubdomainAvailable(c, target, baseDomain) {
+		return
+	}
+	if !a.enforceQuota(c, target) {
+		return
+	}
+	if !a.enforceHostCapacity(c, target) {
+		return
+	}
+	if !a.ensureNoCollisions(c, target, req.Force, "") {
+		return
+	}
+
+	srcURLDomain := src.Domain
+	if src.CustomDomain != "" {
+		srcURLDomain = src.CustomDomain
+	}
+	opts := ProvisionOptions{
+		VolumeSize: src.VolumeSize,
+		CloneFrom:  source,
+		Template: &SiteTemplate{
+			Name:     "clone:" + source,
+			Volume:   SiteVolume(src),
+			Database: WPDatabaseName(SiteResources(src)),
+			Domain:   srcURLDomain,
+		},
+		NginxSnippet:   src.NginxSnippet,
+		RestartPolicy:  src.RestartPolicy,
+		BaseDomain:     src.BaseDomain,
+		NginxResources: src.NginxResources,
+		FastCGI:        src.FastCGI,
+		FPM:            src.FPM,
+		WPConfigExtra:  src.WPConfigExtra,
+		ObjectCache:    src.ObjectCache,
+		Network:        a.cfg.NetworkFor(tenant(c), target),
+		Egress:         src.Egress,
+	}
+	if opts.Egress != EgressOpen {
+		opts.Network = SiteNetworkName(target)
+	}
+	if adminPassword == AdminPasswordReset {
+		opts.AdminPassword = adminPassword
+	}
+	payload, err := json.Marshal(opts)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to encode clone options")
+		return
+	}
+
+	jobID := uuid.New().String()
+	domain := SiteDomain(target, baseDomain)
+
+	rec := SiteProvision{
+		Site: target, Domain: domain, BaseDomain: baseDomain,
+		Type: SiteTypeWordPress, Tenant: tenant(c),
+		VolumeDriver: a.cfg.VolumeDriver, VolumeSize: src.VolumeSize,
+		NginxSnippet:   src.NginxSnippet,
+		RestartPolicy:  src.RestartPolicy,
+		NginxResources: src.NginxResources,
+		WPConfigExtra:  src.WPConfigExtra,
+		FastCGI:        src.FastCGI,
+		FPM:            src.FPM,
+		ObjectCache:    src.ObjectCache,
+		Network:        opts.Network,
+		Egress:         opts.Egress,
+	}
+	if err := a.db.QueueProvision(rec, jobID, JobClone, requestID(c), string(payload)); err != nil {
+		log.Printf("[api] site=%s clone not queued: %v", target, err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+
+	log.Printf("[api] clone queued %s → %s (job %s)", source, target, jobID)
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id":         jobID,
+		"source":         source,
+		"site":           target,
+		"domain":         domain,
+		"admin_password": adminPassword,
+		"status":         "PENDING",
+	})
+}
+
+// GET /api/sites/:site/nginx-snippet
+func (a *API) handleGetNginxSnippet(c *gin.Context) {
+	s, err := a.db.GetSite(c.Param("site"))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"site": s.Site, "nginx_snippet": s.NginxSnippet})
+}
+
+// PUT /api/sites/:site/nginx-snippet
+//
+// Replaces the site's custom nginx rules. The snippet is validated against the
+// directive allowlist, the server block is rewritten with the current domains
+// and checked with `nginx -t` (the old block is restored on failure), and only
+// then persisted. An empty snippet removes the custom rules. WordPress only.
+func (a *API) handleSetNginxSnippet(c *gin.Context) {
+	site := c.Param("site")
+
+	var req struct {
+		Snippet string `json:"nginx_snippet"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	snippet := strings.TrimSpace(req.Snippet)
+	if err := ValidateNginxSnippet(snippet); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "nginx snippets are only supported for WordPress sites")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its nginx snippet")
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	p := NewProvisioner(a.docker, a.cfg)
+	if err := p.writeNginxConfigWithDomains(context.Background(),
+		NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s)),
+		s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, snippet, s.FastCGI,
+	); err != nil {
+		if errors.Is(err, errNginxConfigRejected) {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "nginx config update failed: "+err.Error())
+		return
+	}
+
+	if err := a.db.SetNginxSnippet(site, snippet); err != nil {
+		log.Printf("[CRITICAL] site=%s nginx snippet applied but DB commit failed: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "snippet applied but failed to persist — retry the request")
+		return
+	}
+
+	log.Printf("[api] site=%s nginx snippet updated (%d bytes) req=%s", site, len(snippet), requestID(c))
+	a.recordHistory(site, EventNginxSnippetSet, fmt.Sprintf("%d bytes", len(snippet)))
+	a.events.Publish(Event{Type: EventNginxSnippetSet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "nginx_snippet": snippet})
+}
+
+// PUT /api/sites/:site/nginx-resources
+//
+// Changes the nginx sidecar's limits of a live site, e.g. on a plan change:
+//
+//	{"memory_mb": 256, "cpu_millis": 1000, "pids": 200}
+//
+// Omitted or zero fields use the NGINX_* defaults. Runs as a
+// RESOURCES_UPDATE job that updates the container in place when the daemon
+// supports it and otherwise replaces it, keeping the old container until
+// the new one runs (see container_update.go). The new limits are recorded
+// only once they are in effect.
+func (a *API) handleSetNginxResources(c *gin.Context) {
+	site := c.Param("site")
+
+	var req NginxResources
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "nginx resources are only supported for WordPress sites")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its nginx resources")
+		return
+	}
+	if req == s.NginxResources {
+		c.JSON(http.StatusOK, gin.H{"site": site, "nginx_resources": req, "message": "nginx resources unchanged"})
+		return
+	}
+
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
+	}
+
+	payload, _ := json.Marshal(req)
+	jobID := uuid.New().String()
+	if err := a.db.InsertJobWithPayload(jobID, JobResourcesUpdate, site, requestID(c), string(payload)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+	if err := a.db.SetSiteJob(site, jobID); err != nil {
+		log.Printf("[api] site=%s warning: resources job %s queued but not linked to site: %v", site, jobID, err)
+	}
+
+	log.Printf("[api] site=%s nginx resources change queued job=%s req=%s", site, jobID, requestID(c))
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id":          jobID,
+		"site":            site,
+		"nginx_resources": req,
+		"status":          "PENDING",
+	})
+}
+
+// PUT /api/sites/:site/fastcgi
+//
+// Sets the site's nginx → PHP-FPM timeouts and buffer size, e.g. for an
+// import that runs past the default read timeout:
+//
+//	{"read_timeout_sec": 300, "send_timeout_sec": 300, "buffer_size_kb": 32}
+//
+// Omitted or zero fields use the FASTCGI_* defaults, so {} resets the site.
+// The server block is rewritten and checked like a snippet change, and the
+// settings are kept across later rewrites (custom domains, redeploys).
+func (a *API) handleSetFastCGI(c *gin.Context) {
+	site := c.Param("site")
+
+	var req FastCGISettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if err := req.validate(""); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "fastcgi settings are only supported for WordPress sites")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its fastcgi settings")
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	p := NewProvisioner(a.docker, a.cfg)
+	if err := p.writeNginxConfigWithDomains(context.Background(),
+		NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s)),
+		s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, req,
+	); err != nil {
+		if errors.Is(err, errNginxConfigRejected) {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+			return
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "nginx config update failed: "+err.Error())
+		return
+	}
+
+	if err := a.db.SetSiteFastCGI(site, req); err != nil {
+		log.Printf("[CRITICAL] site=%s fastcgi settings applied but DB commit failed: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "settings applied but failed to persist — retry the request")
+		return
+	}
+
+	effective := resolveFastCGI(a.cfg, req)
+	log.Printf("[api] site=%s fastcgi set read=%ds send=%ds buffer=%dk req=%s",
+		site, effective.ReadTimeoutSec, effective.SendTimeoutSec, effective.BufferSizeKB, requestID(c))
+	a.recordHistory(site, EventFastCGISet, fmt.Sprintf("read=%ds send=%ds buffer=%dk", effective.ReadTimeoutSec, effective.SendTimeoutSec, effective.BufferSizeKB))
+	a.events.Publish(Event{Type: EventFastCGISet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "fastcgi": effective})
+}
+
+// POST /api/sites/:site/redeploy-config
+//
+// Regenerates the site's nginx server block (WordPress only) and Caddy
+// config from its persisted attributes and the current templates, then
+// reloads both. Fixes config-only drift — e.g. after a base-domain change or
+// a template upgrade — without touching data or containers. The nginx block
+// is checked with `nginx -t` before reload and restored if rejected. Sites
+// with a manually edited Caddy config are refused.
+func (a *API) handleRedeployConfig(c *gin.Context) {
+	site := c.Param("site")
+
+	a.domainMu.Lock()
+	defer a.domainMu.Unlock()
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to redeploy its config")
+		return
+	}
+	if s.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	ctx := context.Background()
+	isWP := a.isWordPressSite(s)
+	var applied []string
+
+	if isWP {
+		p := NewProvisioner(a.docker, a.cfg)
+		if err := p.writeNginxConfigWithDomains(ctx,
+			NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s)),
+			s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI,
+		); err != nil {
+			if errors.Is(err, errNginxConfigRejected) {
+				respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+				return
+			}
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "nginx config failed: "+err.Error())
+			return
+		}
+		applied = append(applied, "nginx")
+	}
+
+	if err := a.regenerateCaddy(ctx, site, s.Domain, s.CustomDomain, s.WWWMode); err != nil {
+		respondErrorWith(c, http.StatusInternalServerError, CodeInfraFailed, "caddy config failed: "+err.Error(), gin.H{"applied": applied})
+		return
+	}
+	applied = append(applied, "caddy")
+
+	log.Printf("[api] site=%s config redeployed (%s) req=%s", site, strings.Join(applied, ", "), requestID(c))
+	a.recordHistory(site, EventConfigRedeployed, strings.Join(applied, ", "))
+	a.events.Publish(Event{Type: EventConfigRedeployed, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "applied": applied})
+}
+
+// POST /api/sites/:site/reconcile
+//
+// Runs every drift check against the site on demand — containers present and
+// running, volume, egress rules, nginx server block, Caddy snippet, TLS cert —
+// and returns a per-check report. With ?repair=true failed checks are also
+// repaired where possible (see reconcileSite). Refused while a job is in flight.
+func (a *API) handleReconcileSite(c *gin.Context) {
+	site := c.Param("site")
+	repair := c.Query("repair") == "true"
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site must be ACTIVE to reconcile (is %s)", s.Status))
+		return
+	}
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	checks := a.reconcileSite(context.Background(), s, repair)
+
+	inSync := true
+	var repaired []string
+	for _, check := range checks {
+		if check.Repaired {
+			repaired = append(repaired, check.Name)
+		} else if !check.OK {
+			inSync = false
+		}
+	}
+	if len(repaired) > 0 {
+		log.Printf("[api] site=%s reconcile repaired %s req=%s", site, strings.Join(repaired, ", "), requestID(c))
+		a.recordHistory(site, EventSiteReconciled, strings.Join(repaired, ", "))
+		a.events.Publish(Event{
+			Type: EventSiteReconciled, Site: site, RequestID: requestID(c),
+			Data: map[string]any{"repaired": repaired},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":    site,
+		"repair":  repair,
+		"in_sync": inSync,
+		"checks":  checks,
+	})
+}
+
+// pausableSite loads a WordPress site with no job in flight for pause or
+// resume. Returns false if a response has been written.
+func (a *API) pausableSite(c *gin.Context, site string) (*Site, bool) {
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return nil, false
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "static sites have no containers to pause")
+		return nil, false
+	}
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return nil, false
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return nil, false
+	}
+	return s, true
+}
+
+// POST /api/sites/:site/pause
+//
+// Stops the site's PHP and nginx containers to free app-01 resources while
+// keeping the volume and database; the site is PAUSED until resumed and its
+// domains answer 502 meanwhile. Restart policies are cleared so a daemon
+// restart does not bring the containers back. WordPress only.
+func (a *API) handlePauseSite(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.pausableSite(c, site)
+	if !ok {
+		return
+	}
+	if !SiteStatus(s.Status).CanTransitionTo(SitePaused) {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site cannot be paused while %s", s.Status))
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*a.cfg.DockerRemoveTimeout)
+	defer cancel()
+	names := []string{NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s))}
+	if s.ObjectCache == ObjectCachePerSite {
+		names = append(names, RedisContainerName(SiteResources(s)))
+	}
+	for _, name := range names {
+		if _, err := a.docker.ContainerUpdate(ctx, name, container.UpdateConfig{RestartPolicy: container.RestartPolicy{Name: "no"}}); err != nil && !client.IsErrNotFound(err) {
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to clear restart policy of "+name+": "+err.Error())
+			return
+		}
+		if err := a.docker.ContainerStop(ctx, name, container.StopOptions{}); err != nil && !client.IsErrNotFound(err) {
+			// Half-paused: the site may still be partly up. Resume restores it.
+			log.Printf("[api] site=%s pause: stopping %s failed: %v", site, name, err)
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to stop "+name+" — call /resume to restore the site: "+err.Error())
+			return
+		}
+	}
+	if err := a.db.TransitionSite(site, SitePaused); err != nil {
+		log.Printf("[CRITICAL] site=%s containers stopped but status not updated: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "containers stopped but failed to record PAUSED status")
+		return
+	}
+
+	log.Printf("[api] site=%s paused req=%s", site, requestID(c))
+	a.recordHistory(site, EventSitePaused, "")
+	a.events.Publish(Event{Type: EventSitePaused, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "status": SitePaused})
+}
+
+// POST /api/sites/:site/resume
+//
+// Starts a paused site's containers again with their restart policy, then
+// reapplies the nginx and Caddy config if either drifted while paused (the
+// same repairs as POST /api/sites/:site/reconcile). The site returns to
+// ACTIVE, or DOMAIN_ACTIVE with a custom domain, once its containers run;
+// the per-check report is included.
+func (a *API) handleResumeSite(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.pausableSite(c, site)
+	if !ok {
+		return
+	}
+	if SiteStatus(s.Status) != SitePaused {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site is not paused (is %s)", s.Status))
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	checks := a.reconcileSite(context.Background(), s, true)
+	for _, check := range checks {
+		if (check.Name == "php_container" || check.Name == "nginx_container") && !check.OK && !check.Repaired {
+			respondErrorWith(c, http.StatusInternalServerError, CodeInfraFailed, "failed to start "+check.Name+": "+check.RepairError, gin.H{"checks": checks})
+			return
+		}
+	}
+	to := SiteActive
+	if s.CustomDomain != "" {
+		to = SiteDomainActive
+	}
+	if err := a.db.TransitionSite(site, to); err != nil {
+		log.Printf("[CRITICAL] site=%s containers started but status not updated: %v", site, err)
+		respondErrorWith(c, http.StatusInternalServerError, CodePersistFailed, "containers started but failed to record "+string(to)+" status", gin.H{"checks": checks})
+		return
+	}
+
+	log.Printf("[api] site=%s resumed req=%s", site, requestID(c))
+	a.recordHistory(site, EventSiteResumed, "")
+	a.events.Publish(Event{Type: EventSiteResumed, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "status": to, "checks": checks})
+}
+
+// POST /api/sites/:site/subdomain
+//
+// Moves a site's default domain to <subdomain>.<its base domain> without
+// renaming the site: the slug, containers, volume and database stay. Runs as
+// a CHANGE_SUBDOMAIN job that rewrites the nginx and Caddy config and, when
+// no custom domain is canonical, the WordPress URLs. The new name is covered
+// by the base domain's wildcard DNS. The old domain stops serving once the
+// job completes.
+func (a *API) handleChangeSubdomain(c *gin.Context) {
+	site := c.Param("site")
+
+	var req struct {
+		Subdomain string `json:"subdomain" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	sub := strings.ToLower(req.Subdomain)
+	if !validSite.MatchString(sub) {
+		respondError(c, http.StatusBadRequest, CodeInvalidSiteName, "subdomain must be lowercase letters and numbers only")
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status != string(SiteActive) && s.Status != string(SiteDomainActive) {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site must be ACTIVE to change its subdomain (is %s)", s.Status))
+		return
+	}
+	if s.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites have no subdomain of their own")
+		return
+	}
+	if s.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return
+	}
+
+	baseDomain := SiteBaseDomain(s, a.cfg.BaseDomain)
+	domain := SiteDomain(sub, baseDomain)
+	if domain == s.Domain {
+		c.JSON(http.StatusOK, gin.H{"site": site, "domain": domain, "message": "subdomain unchanged"})
+		return
+	}
+	if err := ValidateSubdomainNotReserved(sub, a.cfg.ReservedSubdomains); err != nil {
+		respondError(c, http.StatusConflict, CodeSubdomainReserved, err.Error())
+		return
+	}
+	if err := a.db.EnsureSiteDomainAvailable(domain, site); err != nil {
+		respondError(c, http.StatusConflict, CodeDomainTaken, err.Error())
+		return
+	}
+
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return
+	}
+
+	payload, _ := json.Marshal(changeSubdomainPayload{Subdomain: sub, WordPress: a.isWordPressSite(s)})
+	jobID := uuid.New().String()
+	if err := a.db.InsertJobWithPayload(jobID, JobChangeSubdomain, site, requestID(c), string(payload)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+	if err := a.db.SetSiteJob(site, jobID); err != nil {
+		log.Printf("[api] site=%s warning: subdomain job %s queued but not linked to site: %v", site, jobID, err)
+	}
+
+	log.Printf("[api] site=%s subdomain change %s → %s queued job=%s req=%s", site, s.Domain, domain, jobID, requestID(c))
+	respondJobAccepted(c, jobID, gin.H{
+		"job_id":     jobID,
+		"site":       site,
+		"old_domain": s.Domain,
+		"domain":     domain,
+		"status":     "PENDING",
+	})
+}
+
+// blueGreenSite loads an ACTIVE, subdomain-routed WordPress site with no
+// job in flight for a blue/green step. Returns false if a response has been
+// written.
+func (a *API) blueGreenSite(c *gin.Context, site string) (*Site, bool) {
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return nil, false
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "blue/green deploys are only supported for WordPress sites")
+		return nil, false
+	}
+	if s.Status != string(SiteActive) && s.Status != string(SiteDomainActive) {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site must be ACTIVE for a blue/green deploy (is %s)", s.Status))
+		return nil, false
+	}
+	if s.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "path-routed sites cannot be deployed blue/green")
+		return nil, false
+	}
+	if s.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return nil, false
+	}
+	active, err := a.db.HasActiveJob(site)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check job status")
+		return nil, false
+	}
+	if active {
+		respondError(c, http.StatusConflict, CodeJobActive, "site already has a pending or processing job")
+		return nil, false
+	}
+	return s, true
+}
+
+// queueBlueGreenJob queues a blue/green job for site and answers 202 with
+// body plus the job fields.
+func (a *API) queueBlueGreenJob(c *gin.Context, site string, jobType JobType, payload string, body gin.H) {
+	jobID := uuid.New().String()
+	if err := a.db.InsertJobWithPayload(jobID, jobType, site, requestID(c), payload); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to queue job")
+		return
+	}
+	if err := a.db.SetSiteJob(site, jobID); err != nil {
+		log.Printf("[api] site=%s warning: %s job %s queued but not linked to site: %v", site, jobType, jobID, err)
+	}
+
+	log.Printf("[api] site=%s %s queued job=%s req=%s", site, jobType, jobID, requestID(c))
+	body["job_id"], body["site"], body["type"], body["status"] = jobID, site, jobType, "PENDING"
+	respondJobAccepted(c, jobID, body)
+}
+
+// POST /api/sites/:site/green
+//
+// Clones a WordPress site's live containers, volume and database into a
+// green set served at https://<site>-green.<base domain>/, to validate an
+// upgrade before cutting over. Runs as a GREEN_CREATE job; the site keeps
+// serving from its live set. Only one spare set exists at a time, so a set
+// still kept for rollback must be retired first. Leftover resources under
+// the green set's name are refused unless ?force=true.
+func (a *API) handleCreateGreen(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.blueGreenSite(c, site)
+	if !ok {
+		return
+	}
+	if s.GreenResources != "" {
+		respondError(c, http.StatusConflict, CodeGreenExists, "site already has a green set "+s.GreenResources+" — cut over to it or discard it first")
+		return
+	}
+	if s.RetiredResources != "" {
+		respondError(c, http.StatusConflict, CodeSiteState,
+			"the set "+s.RetiredResources+" is kept for rollback until the last cutover is retired — retire it first with DELETE /api/sites/"+site+"/green/retired")
+		return
+	}
+	if s.ExternalVolume != "" {
+		respondError(c, http.StatusConflict, CodeSiteState, "sites on an external volume cannot be deployed blue/green")
+		return
+	}
+	if err := ValidateSiteDBIdentifiers(GreenResources(s)); err != nil {
+		respondError(c, http.StatusConflict, CodeSiteState, "the site name is too long for a blue/green set: "+err.Error())
+		return
+	}
+
+	baseDomain := SiteBaseDomain(s, a.cfg.BaseDomain)
+	previewDomain := SiteDomain(GreenPreviewSite(site), baseDomain)
+	if err := a.db.EnsureSiteDomainAvailable(previewDomain, site); err != nil {
+		respondError(c, http.StatusConflict, CodeDomainTaken, err.Error())
+		return
+	}
+	green := GreenResources(s)
+	if !a.ensureNoCollisions(c, green, c.Query("force") == "true", "") {
+		return
+	}
+
+	opts := ProvisionOptions{
+		VolumeSize: s.VolumeSize,
+		CloneFrom:  site,
+		Template: &SiteTemplate{
+			Name:     "green:" + site,
+			Volume:   SiteVolume(s),
+			Database: WPDatabaseName(SiteResources(s)),
+			Domain:   canonicalDomain(s),
+		},
+		NginxSnippet:   s.NginxSnippet,
+		BaseDomain:     baseDomain,
+		RestartPolicy:  s.RestartPolicy,
+		NginxResources: s.NginxResources,
+		FastCGI:        s.FastCGI,
+		FPM:            s.FPM,
+		WPConfigExtra:  s.WPConfigExtra,
+		ObjectCache:    s.ObjectCache,
+		Resources:      green,
+		Network:        s.Network,
+		Egress:         s.Egress,
+	}
+	payload, err := json.Marshal(opts)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to encode green options")
+		return
+	}
+	if err := a.db.SetGreenResources(site, green); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to record green set")
+		return
+	}
+	a.queueBlueGreenJob(c, site, JobGreenCreate, string(payload), gin.H{
+		"green":          green,
+		"preview_domain": previewDomain,
+		"url":            "https://" + previewDomain + "/",
+	})
+}
+
+// DELETE /api/sites/:site/green
+//
+// Discards the site's green set and its preview route (GREEN_DISCARD job).
+// Also cleans up after a failed GREEN_CREATE.
+func (a *API) handleDiscardGreen(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.blueGreenSite(c, site)
+	if !ok {
+		return
+	}
+	if s.GreenResources == "" {
+		respondError(c, http.StatusNotFound, CodeNoGreen, "site has no green set")
+		return
+	}
+	a.queueBlueGreenJob(c, site, JobGreenDiscard, "", gin.H{"green": s.GreenResources})
+}
+
+// POST /api/sites/:site/green/cutover
+//
+// Serves the site from its green set (GREEN_CUTOVER job): the green nginx
+// server block and WordPress URLs are moved to the site's domains, then the
+// site's Caddy snippet is pointed at the green sidecar in one reload. The
+// previous live set keeps running as the retired set for
+// BLUE_GREEN_KEEP_MIN minutes (see POST .../green/rollback) and is then
+// removed by a BLUE_RETIRE job.
+func (a *API) handleGreenCutover(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.blueGreenSite(c, site)
+	if !ok {
+		return
+	}
+	if s.GreenResources == "" {
+		respondError(c, http.StatusConflict, CodeNoGreen, "site has no green set to cut over to")
+		return
+	}
+	if j, err := a.db.GetJob(s.JobID); err == nil && j.Type == JobGreenCreate && j.Status != StatusCompleted {
+		respondError(c, http.StatusConflict, CodeNoGreen, "the green set was not created successfully — retry job "+j.ID+" or discard the green set")
+		return
+	}
+	a.queueBlueGreenJob(c, site, JobGreenCutover, "", gin.H{
+		"live":     SiteResources(s),
+		"green":    s.GreenResources,
+		"keep_for": a.cfg.BlueGreenKeep.String(),
+	})
+}
+
+// POST /api/sites/:site/green/rollback
+//
+// Serves the site from its retired set again (GREEN_ROLLBACK job), while
+// that set is still kept. The set rolled back from becomes the retired set
+// in turn. Content changed since the cutover stays with the set it was
+// written to.
+func (a *API) handleGreenRollback(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.blueGreenSite(c, site)
+	if !ok {
+		return
+	}
+	if s.RetiredResources == "" {
+		respondError(c, http.StatusConflict, CodeNoRetired, "site has no retired set to roll back to")
+		return
+	}
+	a.queueBlueGreenJob(c, site, JobGreenRollback, "", gin.H{
+		"live":    SiteResources(s),
+		"restore": s.RetiredResources,
+	})
+}
+
+// DELETE /api/sites/:site/green/retired
+//
+// Removes the set kept for rollback now instead of at its retire time
+// (BLUE_RETIRE job); a rollback is no longer possible afterwards.
+func (a *API) handleRetireBlue(c *gin.Context) {
+	site := c.Param("site")
+	s, ok := a.blueGreenSite(c, site)
+	if !ok {
+		return
+	}
+	if s.RetiredResources == "" {
+		respondError(c, http.StatusNotFound, CodeNoRetired, "site has no retired set")
+		return
+	}
+	a.queueBlueGreenJob(c, site, JobBlueRetire, "", gin.H{"retired": s.RetiredResources})
+}
+
+// GET /api/sites/:site/wp-urls
+//
+// Reports the siteurl and home options WordPress has stored alongside the
+// URL the control plane expects, for debugging redirect loops and mixed
+// content. WordPress only. Path-routed sites pin both URLs in wp-config.php,
+// which takes precedence over the stored options.
+func (a *API) handleWordPressURLs(c *gin.Context) {
+	s, err := a.db.GetSite(c.Param("site"))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "wp-urls is only available for WordPress sites")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	p := NewProvisioner(a.docker, a.cfg)
+	siteURL, home, err := p.readWordPressURLs(ctx, SiteResources(s))
+	if errors.Is(err, errWordPressNotInstalled) {
+		respondError(c, http.StatusConflict, CodeWPNotInstalled, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+
+	host := s.Domain
+	if s.CustomDomain != "" {
+		host = s.CustomDomain
+	}
+	expected := "https://" + host + s.PathPrefix
+	var mismatches []string
+	if siteURL != expected {
+		mismatches = append(mismatches, "siteurl")
+	}
+	if home != expected {
+		mismatches = append(mismatches, "home")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"site":             s.Site,
+		"siteurl":          siteURL,
+		"home":             home,
+		"expected":         expected,
+		"match":            len(mismatches) == 0,
+		"mismatches":       mismatches,
+		"pinned_in_config": s.PathPrefix != "",
+	})
+}
+
+// GET /api/sites/:site/caddy
+//
+// Returns the Caddy config currently deployed for the site, read from the
+// Caddy container, and whether it was edited by hand.
+func (a *API) handleGetCaddySnippet(c *gin.Context) {
+	s, err := a.db.GetSite(c.Param("site"))
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	snippetPath := caddySnippetPath(a.cfg, s)
+	content, err := readContainerFile(ctx, a.docker, a.cfg.CaddyContainer, snippetPath)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"site":            s.Site,
+		"path":            snippetPath,
+		"caddy":           content,
+		"manually_edited": s.CaddyManual,
+	})
+}
+
+// PUT /api/sites/:site/caddy
+//
+// Replaces the site's Caddy config with operator-supplied content. Admin key
+// only. The file is written, the whole Caddyfile is checked with
+// `caddy validate` and Caddy is reloaded; on any failure the previous file
+// is put back. The site is then flagged as manually edited so domain changes
+// cannot silently regenerate over it.
+func (a *API) handleSetCaddySnippet(c *gin.Context) {
+	site := c.Param("site")
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "editing the Caddy config requires the admin API key")
+		return
+	}
+
+	var req struct {
+		Caddy string `json:"caddy"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	content := strings.TrimSpace(req.Caddy)
+	if content == "" {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "caddy is required — use DELETE to revert to the generated config")
+		return
+	}
+	if len(content) > caddySnippetMaxLen {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("caddy config exceeds %d bytes", caddySnippetMaxLen))
+		return
+	}
+	content += "\n"
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its Caddy config")
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	ctx := context.Background()
+	snippetPath := caddySnippetPath(a.cfg, s)
+	dir, name := path.Dir(snippetPath), path.Base(snippetPath)
+
+	previous, err := readContainerFile(ctx, a.docker, a.cfg.CaddyContainer, snippetPath)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+	if err := copyCaddyFile(ctx, a.docker, a.cfg, dir, name, content); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy config write failed: "+err.Error())
+		return
+	}
+	restore := func() {
+		if err := copyCaddyFile(ctx, a.docker, a.cfg, dir, name, previous); err != nil {
+			log.Printf("[CRITICAL] site=%s could not restore previous caddy config: %v", site, err)
+		}
+	}
+
+	if err := validateCaddy(ctx, a.cfg); err != nil {
+		restore()
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := reloadCaddy(ctx, a.cfg); err != nil {
+		restore()
+		if rerr := reloadCaddy(ctx, a.cfg); rerr != nil {
+			log.Printf("[CRITICAL] site=%s caddy reload after restore failed: %v", site, rerr)
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy reload failed: "+err.Error())
+		return
+	}
+
+	if err := a.db.SetCaddyManual(site, true); err != nil {
+		log.Printf("[CRITICAL] site=%s caddy config applied but manual flag not persisted: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "config applied but failed to persist — retry the request")
+		return
+	}
+
+	log.Printf("[api] site=%s caddy config replaced manually (%d bytes) req=%s", site, len(content), requestID(c))
+	a.recordHistory(site, EventCaddySnippetSet, fmt.Sprintf("%d bytes", len(content)))
+	a.events.Publish(Event{Type: EventCaddySnippetSet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "path": snippetPath, "caddy": content, "manually_edited": true})
+}
+
+// DELETE /api/sites/:site/caddy
+//
+// Discards a manually edited Caddy config: clears the flag and regenerates
+// the site's config from its current domains. Admin key only.
+func (a *API) handleResetCaddySnippet(c *gin.Context) {
+	site := c.Param("site")
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "editing the Caddy config requires the admin API key")
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !s.CaddyManual {
+		c.JSON(http.StatusOK, gin.H{"site": site, "manually_edited": false, "message": "caddy config is already generated"})
+		return
+	}
+
+	if err := a.db.SetCaddyManual(site, false); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to clear manual flag")
+		return
+	}
+	if err := a.regenerateCaddy(context.Background(), site, s.Domain, s.CustomDomain, s.WWWMode); err != nil {
+		if ferr := a.db.SetCaddyManual(site, true); ferr != nil {
+			log.Printf("[api] site=%s warning: could not restore manual caddy flag: %v", site, ferr)
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy regenerate failed: "+err.Error())
+		return
+	}
+
+	log.Printf("[api] site=%s caddy config reverted to generated req=%s", site, requestID(c))
+	a.recordHistory(site, EventCaddySnippetReset, "")
+	a.events.Publish(Event{Type: EventCaddySnippetReset, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "manually_edited": false})
+}
+
+// PUT /api/sites/:site/caddy-log
+//
+// Turns Caddy request logging for the site on or off:
+//
+//	{"output": "file"}   // JSON lines in CADDY_LOG_DIR/<site>.log, see GET
+//	{"output": "stdout"} // JSON lines on Caddy's own stdout
+//	{"output": "off"}
+//
+// The setting is stored with the site and the generated snippet rewritten,
+// so later domain changes keep it. Not available to path-routed sites, which
+// share one site block, or to a manually edited Caddy config.
+func (a *API) handleSetCaddyLog(c *gin.Context) {
+	site := c.Param("site")
+
+	var req struct {
+		Output string `json:"output"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	mode, err := ValidateCaddyLogMode(strings.ToLower(strings.TrimSpace(req.Output)))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "request logging is not available to path-routed sites")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its request logging")
+		return
+	}
+	if s.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return
+	}
+	if mode == s.CaddyLog {
+		c.JSON(http.StatusOK, gin.H{"site": site, "caddy_log": mode})
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain.
+	ctx := context.Background()
+	if mode == CaddyLogFile {
+		if err := ensureCaddyConfDir(ctx, a.docker, a.cfg.CaddyContainer, a.cfg.CaddyLogDir); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+			return
+		}
+	}
+
+	// regenerateCaddy renders from the stored site, so the mode is stored
+	// first and put back if the new snippet cannot be applied.
+	if err := a.db.SetSiteCaddyLog(site, mode); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to record request logging")
+		return
+	}
+	if err := a.regenerateCaddy(ctx, site, s.Domain, s.CustomDomain, s.WWWMode); err != nil {
+		if rbErr := a.db.SetSiteCaddyLog(site, s.CaddyLog); rbErr != nil {
+			log.Printf("[CRITICAL] site=%s could not restore caddy_log=%q: %v", site, s.CaddyLog, rbErr)
+		} else if rbErr := a.regenerateCaddy(ctx, site, s.Domain, s.CustomDomain, s.WWWMode); rbErr != nil {
+			log.Printf("[CRITICAL] site=%s could not restore previous caddy config: %v", site, rbErr)
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy update failed: "+err.Error())
+		return
+	}
+
+	shown := mode
+	if shown == CaddyLogOff {
+		shown = "off"
+	}
+	log.Printf("[api] site=%s caddy request logging %s req=%s", site, shown, requestID(c))
+	a.recordHistory(site, EventCaddyLogSet, shown)
+	a.events.Publish(Event{Type: EventCaddyLogSet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "caddy_log": mode})
+}
+
+// PUT /api/sites/:site/error-page
+//
+// Sets the page Caddy serves for the site when it fails with a 5xx, such as
+// a 502 while the site's containers are down:
+//
+//	{"page": "default"}                   // the platform page, ERROR_PAGE_HTML
+//	{"page": "custom", "html": "<html>…"} // the site's own
+//	{"page": "off"}                       // Caddy's bare error
+//
+// The setting is stored with the site and the generated snippet rewritten,
+// so later domain changes keep it. Not available to path-routed sites, which
+// share one site block, or to a manually edited Caddy config.
+func (a *API) handleSetErrorPage(c *gin.Context) {
+	site := c.Param("site")
+
+	var req struct {
+		Page string `json:"page"`
+		HTML string `json:"html"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	mode, err := ValidateErrorPageMode(strings.ToLower(strings.TrimSpace(req.Page)))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	html := ""
+	if mode == ErrorPageCustom {
+		html = req.HTML
+		if strings.TrimSpace(html) == "" {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "html is required for a custom error page")
+			return
+		}
+		if len(html) > errorPageMaxLen {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("html must be at most %d bytes", errorPageMaxLen))
+			return
+		}
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if s.PathPrefix != "" {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedRouting, "error pages are not available to path-routed sites")
+		return
+	}
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site must be ACTIVE to change its error page")
+		return
+	}
+	if s.CaddyManual {
+		respondError(c, http.StatusConflict, CodeCaddyManual, errCaddyManuallyEdited.Error())
+		return
+	}
+
+	// Detached from the request context — see handleSetCustomDomain. The
+	// page is written first so the snippet never points at a missing file;
+	// the default page is rewritten too, picking up ERROR_PAGE_HTML changes.
+	ctx := context.Background()
+	p := NewProvisioner(a.docker, a.cfg)
+	if mode != ErrorPageOff {
+		if err := p.writeErrorPage(ctx, site, siteErrorPageHTML(a.cfg, mode, html)); err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "failed to write error page: "+err.Error())
+			return
+		}
+	}
+
+	// regenerateCaddy renders from the stored site, so the page is stored
+	// first and put back if the new snippet cannot be applied.
+	if err := a.db.SetSiteErrorPage(site, mode, html); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to record error page")
+		return
+	}
+	if err := a.regenerateCaddy(ctx, site, s.Domain, s.CustomDomain, s.WWWMode); err != nil {
+		if s.ErrorPage != ErrorPageOff {
+			if rbErr := p.writeErrorPage(ctx, site, siteErrorPageHTML(a.cfg, s.ErrorPage, s.ErrorPageHTML)); rbErr != nil {
+				log.Printf("[CRITICAL] site=%s could not restore previous error page: %v", site, rbErr)
+			}
+		}
+		if rbErr := a.db.SetSiteErrorPage(site, s.ErrorPage, s.ErrorPageHTML); rbErr != nil {
+			log.Printf("[CRITICAL] site=%s could not restore error_page=%q: %v", site, s.ErrorPage, rbErr)
+		} else if rbErr := a.regenerateCaddy(ctx, site, s.Domain, s.CustomDomain, s.WWWMode); rbErr != nil {
+			log.Printf("[CRITICAL] site=%s could not restore previous caddy config: %v", site, rbErr)
+		}
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "caddy update failed: "+err.Error())
+		return
+	}
+
+	shown := mode
+	if shown == ErrorPageOff {
+		shown = "off"
+	}
+	log.Printf("[api] site=%s error page %s req=%s", site, shown, requestID(c))
+	a.recordHistory(site, EventErrorPageSet, shown)
+	a.events.Publish(Event{Type: EventErrorPageSet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "error_page": mode})
+}
+
+// GET /api/sites/:site/caddy-log[?lines=N]
+//
+// Returns the newest N (default 100, at most 1000) entries of the site's
+// request log, oldest first. Only sites logging to a file have one to read.
+func (a *API) handleTailCaddyLog(c *gin.Context) {
+	site := c.Param("site")
+
+	lines := caddyLogTailDefault
+	if raw := c.Query("lines"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > caddyLogTailMax {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("lines must be between 1 and %d", caddyLogTailMax))
+			return
+		}
+		lines = n
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	switch s.CaddyLog {
+	case CaddyLogFile:
+	case CaddyLogStdout:
+		respondError(c, http.StatusConflict, CodeCaddyLogOff, "site logs requests to Caddy's stdout — read them with docker logs "+a.cfg.CaddyContainer)
+		return
+	default:
+		respondError(c, http.StatusConflict, CodeCaddyLogOff, "request logging is off for this site — enable it with PUT /api/sites/:site/caddy-log")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	// Cut to the output cap here so the newest lines are the ones kept.
+	logPath := caddyLogPath(a.cfg, site)
+	res, err := runExec(ctx, a.docker, a.cfg.CaddyContainer, []string{
+		"sh", "-c", `[ -f "$2" ] || exit 0; tail -n "$1" "$2" | tail -c "$3"`,
+		"sh", strconv.Itoa(lines), logPath, strconv.Itoa(execMaxOutput),
+	})
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+	if res.ExitCode != 0 {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "tail failed: "+strings.TrimSpace(res.Stderr))
+		return
+	}
+
+	out := res.Stdout
+	if len(out) >= execMaxOutput {
+		// The first line was cut mid-entry.
+		if i := strings.IndexByte(out, '\n'); i >= 0 {
+			out = out[i+1:]
+		}
+	}
+	entries := []json.RawMessage{}
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" && json.Valid([]byte(line)) {
+			entries = append(entries, json.RawMessage(line))
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"site": site, "path": logPath, "entries": entries})
+}
+
+// POST /api/admin/prune
+//
+// Removes orphaned per-site volumes and dangling images from app-01 (see
+// PruneOrphans). Admin key only. Without "confirm": true this is a dry run
+// that only reports what would be removed.
+func (a *API) handlePrune(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "pruning requires the admin API key")
 		return
 	}
+	var req struct {
+		Confirm bool `json:"confirm"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+			return
+		}
+	}
 
-	log.Printf("[cert-retry] site=%s domain=%s caddy reloaded, polling cert...", site, domainToCheck)
-	certStatus := PollCaddyCert(a.docker, a.cfg, domainToCheck, 30*time.Second)
-	log.Printf("[cert-retry] site=%s domain=%s cert_status=%s", site, domainToCheck, certStatus)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
 
-	c.JSON(http.StatusOK, gin.H{
-		"site":        site,
-		"domain":      domainToCheck,
-		"cert_status": string(certStatus),
-	})
+	report, err := PruneOrphans(ctx, a.docker, a.db, !req.Confirm)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "prune failed: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, report)
 }
 
-// POST /api/provision
-func (a *API) handleProvision(c *gin.Context) {
+// POST /api/admin/jobs/purge
+//
+// Deletes COMPLETED jobs older than completed_days and FAILED jobs older
+// than failed_days (JOB_PURGE_COMPLETED_DAYS and JOB_PURGE_FAILED_DAYS by
+// default), in batches (see DB.PurgeJobs). Jobs from the last day and a
+// site's current job are always kept. Admin key only. Without
+// "confirm": true this is a dry run that only counts them.
+func (a *API) handlePurgeJobs(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "purging jobs requires the admin API key")
+		return
+	}
 	var req struct {
-		Site string `json:"site" binding:"required"`
+		Confirm       bool `json:"confirm"`
+		CompletedDays int  `json:"completed_days"`
+		FailedDays    int  `json:"failed_days"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site is required"})
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+			return
+		}
+	}
+	floorDays := int(jobPurgeFloor.Hours() / 24)
+	if req.CompletedDays < 0 || req.FailedDays < 0 ||
+		(req.CompletedDays > 0 && req.CompletedDays < floorDays) || (req.FailedDays > 0 && req.FailedDays < floorDays) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("completed_days and failed_days must be at least %d", floorDays))
 		return
 	}
+	ages := map[JobStatus]time.Duration{
+		StatusCompleted: a.cfg.JobPurgeCompletedAge,
+		StatusFailed:    a.cfg.JobPurgeFailedAge,
+	}
+	if req.CompletedDays > 0 {
+		ages[StatusCompleted] = time.Duration(req.CompletedDays) * 24 * time.Hour
+	}
+	if req.FailedDays > 0 {
+		ages[StatusFailed] = time.Duration(req.FailedDays) * 24 * time.Hour
+	}
 
-	site := strings.ToLower(req.Site)
-
-	if !validSite.MatchString(site) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site name must be lowercase letters and numbers only"})
-		return
+	counts := map[JobStatus]int64{}
+	for _, status := range []JobStatus{StatusCompleted, StatusFailed} {
+		var n int64
+		var err error
+		if req.Confirm {
+			n, err = a.db.PurgeJobs(status, ages[status])
+		} else {
+			n, err = a.db.CountPurgeableJobs(status, ages[status])
+		}
+		counts[status] = n
+		if err != nil {
+			log.Printf("[api] purge of %s jobs stopped after %d: %v", status, n, err)
+			respondError(c, http.StatusInternalServerError, CodeInternal, "failed to purge jobs")
+			return
+		}
 	}
+	if req.Confirm {
+		log.Printf("[api] purged %d completed and %d failed jobs", counts[StatusCompleted], counts[StatusFailed])
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":        !req.Confirm,
+		"completed":      counts[StatusCompleted],
+		"failed":         counts[StatusFailed],
+		"removed":        counts[StatusCompleted] + counts[StatusFailed],
+		"completed_days": int(ages[StatusCompleted].Hours() / 24),
+		"failed_days":    int(ages[StatusFailed].Hours() / 24),
+	})
+}
 
-	// Reject if site already has an active job
-	active, err := a.db.HasActiveJob(site)
+// GET /api/worker
+//
+// Reports the job worker's heartbeat: when it last polled for jobs, when
+// it last completed one, and whether it looks alive. Tells an idle queue
+// apart from a wedged worker.
+func (a *API) handleWorkerStatus(c *gin.Context) {
+	pending, err := a.db.CountPendingJobs()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check job status"})
-		return
-	}
-	if active {
-		c.JSON(http.StatusConflict, gin.H{"error": "site already has a pending or processing job"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to count pending jobs")
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"worker": a.heartbeat.Status(), "pending_jobs": pending, "read_only": a.maint.Enabled()})
+}
 
-	// Reject if site is already active
-	existing, err := a.db.GetSite(site)
-	if err != nil && err != sql.ErrNoRows {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check site status"})
+// GET /api/admin/worker
+//
+// Reports what the job worker is doing: the jobs it is running, with how
+// long each has run and when its timeout cancels it, alongside the
+// heartbeat of GET /api/worker and the poll interval. Admin key only.
+func (a *API) handleAdminWorker(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "worker details require the admin API key")
 		return
 	}
-	if existing != nil && existing.Status == "ACTIVE" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site already exists and is active"})
+	pending, err := a.db.CountPendingJobs()
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to count pending jobs")
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":              a.heartbeat.Jobs(),
+		"worker":            a.heartbeat.Status(),
+		"started_at":        a.heartbeat.StartedAt(),
+		"poll_interval_sec": int(a.heartbeat.PollInterval().Seconds()),
+		"pending_jobs":      pending,
+	})
+}
 
-	jobID := uuid.New().String()
-	domain := SiteDomain(site, a.cfg.BaseDomain)
+// GET /api/maintenance
+//
+// Reports whether the control plane is in read-only mode.
+func (a *API) handleGetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, a.maint.State())
+}
 
-	if err := a.db.InsertJob(jobID, JobProvision, site); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue job"})
+// PUT /api/maintenance
+//
+// Enters or leaves read-only mode at runtime: {"enabled": true, "reason":
+// "app-01 kernel upgrade"}. While enabled, mutating routes answer 503 and
+// the worker claims no new jobs. Admin key only. Not persisted — a restart
+// comes back in the READ_ONLY_MODE state.
+func (a *API) handleSetMaintenance(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "maintenance mode requires the admin API key")
 		return
 	}
-
-	if err := a.db.UpsertSite(site, domain, "PROVISIONING", jobID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record site"})
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "enabled is required")
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"job_id": jobID,
-		"site":   site,
-		"domain": domain,
-		"status": "PENDING",
-	})
+	a.maint.Set(*req.Enabled, strings.TrimSpace(req.Reason))
+	log.Printf("[api] maintenance enabled=%t by tenant=%s req=%s", *req.Enabled, tenant(c), requestID(c))
+	c.JSON(http.StatusOK, a.maint.State())
 }
 
-// GET /api/sites
-func (a *API) handleListSites(c *gin.Context) {
-	sites, err := a.db.ListSites()
+// GET /api/quota
+//
+// Returns the calling tenant's usage and limits.
+func (a *API) handleQuota(c *gin.Context) {
+	usage, err := a.tenantUsage(tenant(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sites"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to load usage")
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"sites": sites})
+	c.JSON(http.StatusOK, usage)
 }
 
-// DELETE /api/sites/:site
-func (a *API) handleDeleteSite(c *gin.Context) {
+// POST /api/sites/:site/wp-cli
+//
+// Runs an allowlisted WP-CLI command inside the site's PHP container and
+// returns stdout, stderr and the exit code. Destructive subcommands (installs,
+// deletes, updates) require "force": true AND the admin API key.
+// WordPress sites only. A non-zero exit code is returned as 200 — the call
+// itself succeeded; the caller inspects exit_code.
+func (a *API) handleWPCLI(c *gin.Context) {
 	site := c.Param("site")
 
-	existing, err := a.db.GetSite(site)
+	var req struct {
+		Command string   `json:"command" binding:"required"`
+		Args    []string `json:"args"`
+		Force   bool     `json:"force"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "command is required")
+		return
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
 		return
 	}
-	if existing.Status != "DESTROYED" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site must be DESTROYED before hard delete"})
+	if s.Status != "ACTIVE" && s.Status != "DOMAIN_ACTIVE" {
+		respondError(c, http.StatusConflict, CodeSiteState, "site is not active")
 		return
 	}
-
-	if err := a.db.HardDeleteSite(site); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete site"})
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "wp-cli is only available for WordPress sites")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"deleted": site})
-}
-
-// DELETE /api/jobs/:id
-func (a *API) handleDeleteJob(c *gin.Context) {
-	id := c.Param("id")
-
-	job, err := a.db.GetJob(id)
+	destructive, err := ValidateWPCLICommand(req.Command, req.Args)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
-	if job.Status == StatusProcessing || job.Status == StatusPending {
-		c.JSON(http.StatusConflict, gin.H{"error": "cannot delete active job"})
+	if destructive && (!req.Force || !c.GetBool(fullAccessKey)) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "destructive command requires force=true and the admin API key")
 		return
 	}
 
-	if err := a.db.HardDeleteJob(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete job"})
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Minute)
+	defer cancel()
+
+	log.Printf("[wp-cli] site=%s req=%s running: wp %s %s", site, requestID(c), req.Command, strings.Join(redactWPCLIArgs(req.Args), " "))
+	result, err := runWPCLI(ctx, a.docker, a.cfg, SiteResources(s), req.Command, req.Args)
+	if err != nil {
+		log.Printf("[wp-cli] site=%s failed: %v", site, err)
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
 		return
 	}
+	log.Printf("[wp-cli] site=%s exit_code=%d", site, result.ExitCode)
 
-	c.JSON(http.StatusOK, gin.H{"deleted": id})
+	c.JSON(http.StatusOK, gin.H{
+		"site":      site,
+		"command":   req.Command,
+		"args":      req.Args,
+		"stdout":    result.Stdout,
+		"stderr":    result.Stderr,
+		"exit_code": result.ExitCode,
+	})
 }
 
-// POST /api/destroy
-func (a *API) handleDestroy(c *gin.Context) {
+// POST /api/templates
+//
+// Registers a WordPress template: an existing Docker volume on app-01 and an
+// existing database on state-01 that new sites can be seeded from via
+// POST /api/provision {"template": "<name>"}. Re-registering a name re-points it.
+// Admin key only: a template's volume and database are copied into every
+// site seeded from it, whoever they belong to.
+func (a *API) handleRegisterTemplate(c *gin.Context) {
+	if !c.GetBool(fullAccessKey) {
+		respondError(c, http.StatusForbidden, CodeAdminRequired, "registering a template requires the admin API key")
+		return
+	}
 	var req struct {
-		Site string `json:"site" binding:"required"`
+		Name     string `json:"name" binding:"required"`
+		Volume   string `json:"volume" binding:"required"`
+		Database string `json:"database" binding:"required"`
+		Domain   string `json:"domain" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site is required"})
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "name, volume, database and domain are required")
 		return
 	}
 
-	site := strings.ToLower(req.Site)
-
-	if !validSite.MatchString(site) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "site name must be lowercase letters and numbers only"})
-		return
-	}
-
-	// Must exist and not already be destroying
-	existing, err := a.db.GetSite(site)
-	if err == sql.ErrNoRows || existing == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+	name := strings.ToLower(req.Name)
+	if !validSite.MatchString(name) {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "template name must be lowercase letters and numbers only")
 		return
 	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check site"})
+	if ValidateDBIdentifier(req.Database, mysqlDatabaseNameMax) != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "database must be a plain MySQL identifier")
 		return
 	}
-	if existing.Status == "DESTROYING" || existing.Status == "DESTROYED" {
-		c.JSON(http.StatusConflict, gin.H{"error": "site is already being destroyed or is destroyed"})
-		return
+	domain, err := NormalizeDomain(req.Domain)
+	if err == nil {
+		err = ValidateDomainFormat(domain)
 	}
-
-	// Reject if already has active job
-	active, err := a.db.HasActiveJob(site)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check job status"})
-		return
-	}
-	if active {
-		c.JSON(http.StatusConflict, gin.H{"error": "site already has a pending or processing job"})
+		respondError(c, http.StatusBadRequest, CodeDomainInvalid, err.Error())
 		return
 	}
 
-	jobID := uuid.New().String()
-
-	if err := a.db.InsertJob(jobID, JobDestroy, site); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to queue job"})
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	if _, err := a.docker.VolumeInspect(ctx, req.Volume); err != nil {
+		respondError(c, http.StatusBadRequest, CodeVolumeUnavailable, "template volume not found on app-01: "+req.Volume)
 		return
 	}
 
-	if err := a.db.UpsertSite(site, existing.Domain, "DESTROYING", jobID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update site status"})
+	tmpl := SiteTemplate{Name: name, Volume: req.Volume, Database: req.Database, Domain: domain}
+	if err := a.db.UpsertTemplate(tmpl); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save template")
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"job_id": jobID,
-		"site":   site,
-		"status": "PENDING",
-	})
+	log.Printf("[api] template %s registered (volume=%s db=%s)", name, req.Volume, req.Database)
+	c.JSON(http.StatusOK, gin.H{"template": tmpl})
 }
 
-// GET /api/jobs/:id
-func (a *API) handleJobStatus(c *gin.Context) {
-	id := c.Param("id")
-
-	job, err := a.db.GetJob(id)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
-		return
-	}
+// GET /api/templates
+func (a *API) handleListTemplates(c *gin.Context) {
+	templates, err := a.db.ListTemplates()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch templates")
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"job_id":       job.ID,
-		"type":         job.Type,
-		"site":         job.Site,
-		"status":       job.Status,
-		"attempts":     job.Attempts,
-		"max_attempts": job.MaxAttempts,
-		"error":        job.Error,
-		"created_at":   job.CreatedAt,
-		"started_at":   job.StartedAt,
-		"completed_at": job.CompletedAt,
-	})
+	if templates == nil {
+		templates = []SiteTemplate{} // return [] not null
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
 }
 
-// GET /api/sites/:site
-func (a *API) handleSiteStatus(c *gin.Context) {
-	site := c.Param("site")
+// GET /api/stats
+//
+// Platform-wide totals for the ops dashboard: sites and jobs by status, the
+// last 24 h job success rate, and container/volume counts on app-01.
+// Cached for statsCacheTTL.
+func (a *API) handleStats(c *gin.Context) {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 
-	s, err := a.db.GetSite(site)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+	if a.statsCache != nil && time.Since(a.statsAt) < statsCacheTTL {
+		c.JSON(http.StatusOK, a.statsCache)
 		return
 	}
+
+	summary, err := a.db.Summary()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch site"})
+		log.Printf("[api] stats: %v", err)
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to compute stats")
 		return
 	}
 
-	// Live infra checks — only meaningful for ACTIVE/DOMAIN_ACTIVE sites
-	certStatus := ""
-	var warnings []string
-	if s.Status == "ACTIVE" || s.Status == "DOMAIN_ACTIVE" {
-		domainToCheck := s.Domain
-		if s.CustomDomain != "" {
-			domainToCheck = s.CustomDomain
-		}
-
-		// 1. Cert check
-		if caddyHasCert(a.docker, a.cfg, domainToCheck) {
-			certStatus = string(CertIssued)
-		} else {
-			certStatus = string(CertPending)
-			warnings = append(warnings, "TLS cert not yet issued — Caddy is retrying ACME in background; call /cert-retry to force")
-		}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
 
-		// 2. Caddy snippet exists on disk
-		snippetOK := caddySnippetExists(a.docker, a.cfg, s.Site)
-		if !snippetOK {
-			warnings = append(warnings, "Caddy config snippet missing — site will not be routed; re-provision or call /cert-retry")
-		} else {
-			// 3. Snippet contains the active domain (catches stale snippet after domain move)
-			if !caddySnippetContainsDomain(a.docker, a.cfg, s.Site, domainToCheck) {
-				warnings = append(warnings, "Caddy snippet exists but does not route "+domainToCheck+" — reload may be needed; call /cert-retry")
-			}
-		}
+	dockerStats := gin.H{}
+	if info, err := a.docker.Info(ctx); err != nil {
+		dockerStats["error"] = err.Error()
+	} else {
+		dockerStats["containers"] = info.Containers
+		dockerStats["containers_running"] = info.ContainersRunning
+	}
+	if vols, err := a.docker.VolumeList(ctx, volume.ListOptions{}); err != nil {
+		dockerStats["volumes_error"] = err.Error()
+	} else {
+		dockerStats["volumes"] = len(vols.Volumes)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"site":           s.Site,
-		"domain":         s.Domain,
-		"custom_domain":  s.CustomDomain,
-		"status":         s.Status,
-		"cert_status":    certStatus,
-		"warnings":       warnings,
-		"job_id":         s.JobID,
-		"last_backup_at": s.LastBackupAt,
-		"created_at":     s.CreatedAt,
-		"updated_at":     s.UpdatedAt,
-	})
-}
-
-// GET /api/health
-func (a *API) handleHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	a.statsCache = gin.H{
+		"sites_by_status":      summary.SitesByStatus,
+		"jobs_by_status":       summary.JobsByStatus,
+		"recent_jobs_finished": summary.RecentJobsFinished,
+		"recent_success_rate":  summary.RecentSuccessRate,
+		"job_queue_wait_ms":    summary.QueueWaitMs,
+		"job_duration_ms":      summary.DurationMs,
+		"docker":               dockerStats,
+		"generated_at":         time.Now().UTC(),
+	}
+	a.statsAt = time.Now()
+	c.JSON(http.StatusOK, a.statsCache)
 }
 
 // POST /api/sites/:site/backup
@@ -733,21 +4284,81 @@ func (a *API) handleHealth(c *gin.Context) {
 func (a *API) handleBackupSite(c *gin.Context) {
 	site := c.Param("site")
 	if a.backupper.r2 == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backup not configured (R2 credentials missing)"})
+		respondError(c, http.StatusServiceUnavailable, CodeBackupNotConfigured, "backup not configured (R2 credentials missing)")
 		return
 	}
 	if _, err := a.db.GetSite(site); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
 		return
 	}
-	if err := a.backupper.BackupSite(site); err != nil {
+	if err := a.backupper.BackupSite(c.Request.Context(), site); err != nil {
 		log.Printf("[api] backup failed site=%s: %v", site, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"site": site, "status": "backed_up", "date": dateStamp()})
 }
 
+// POST /api/sites/:site/backup-schedule
+//
+// Sets the site's backup schedule and retention:
+//
+//	{"interval_hours": 24, "keep": 14, "max_age_days": 30}
+//
+// The worker then queues a BACKUP job whenever interval_hours have passed
+// since the last backup and prunes older backups to keep copies and
+// max_age_days (0 = no limit). interval_hours 0 removes the schedule and
+// returns the site to the daily backup run. WordPress sites only.
+func (a *API) handleSetBackupSchedule(c *gin.Context) {
+	site := c.Param("site")
+
+	var req BackupSchedule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid request body")
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Enabled() && a.backupper.r2 == nil {
+		respondError(c, http.StatusServiceUnavailable, CodeBackupNotConfigured, "backup not configured (R2 credentials missing)")
+		return
+	}
+	if !req.Enabled() {
+		req = BackupSchedule{} // retention only applies to scheduled backups
+	}
+
+	s, err := a.db.GetSite(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
+		return
+	}
+	if !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "backup schedules are only supported for WordPress sites")
+		return
+	}
+	if s.Status == "DESTROYING" || s.Status == "DESTROYED" {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site is %s", s.Status))
+		return
+	}
+
+	if err := a.db.SetBackupSchedule(site, req); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to save backup schedule")
+		return
+	}
+
+	log.Printf("[api] site=%s backup schedule set interval=%dh keep=%d max_age=%dd req=%s",
+		site, req.IntervalHours, req.Keep, req.MaxAgeDays, requestID(c))
+	a.recordHistory(site, EventBackupScheduleSet, fmt.Sprintf("interval=%dh keep=%d max_age_days=%d", req.IntervalHours, req.Keep, req.MaxAgeDays))
+	a.events.Publish(Event{Type: EventBackupScheduleSet, Site: site, RequestID: requestID(c)})
+	c.JSON(http.StatusOK, gin.H{"site": site, "backup_schedule": req})
+}
+
 // GET /api/sites/:site/backups
 //
 // Lists all available backup entries for the site in R2.
@@ -755,23 +4366,23 @@ func (a *API) handleBackupSite(c *gin.Context) {
 func (a *API) handleListBackups(c *gin.Context) {
 	site := c.Param("site")
 	if a.backupper.r2 == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backup not configured (R2 credentials missing)"})
+		respondError(c, http.StatusServiceUnavailable, CodeBackupNotConfigured, "backup not configured (R2 credentials missing)")
 		return
 	}
 	if _, err := a.db.GetSite(site); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
 		return
 	}
 	ctx := c.Request.Context()
 
 	dbEntries, err := a.backupper.r2.List(ctx, prefixForSiteDB(site))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot list DB backups: " + err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "cannot list DB backups: "+err.Error())
 		return
 	}
 	volEntries, err := a.backupper.r2.List(ctx, prefixForSiteVolume(site))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot list volume backups: " + err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, "cannot list volume backups: "+err.Error())
 		return
 	}
 
@@ -795,35 +4406,125 @@ func (a *API) handleListBackups(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"site": site, "backups": items})
 }
 
-// POST /api/sites/:site/restore/:date
+// POST /api/sites/:site/restore/:date[?admin_password=reuse|reset]
 //
 // Restores both the database and volume from the backup taken on :date (YYYY-MM-DD).
 // Runs synchronously — the response is returned once both restores complete.
 // The site's running containers are NOT stopped automatically; quiesce writes
-// first for a fully consistent restore.
+// first for a fully consistent restore. The restored database brings back
+// the admin account as it was backed up; admin_password=reset then sets a
+// new password on it, to be revealed once (see admin_credentials.go).
 func (a *API) handleRestoreSite(c *gin.Context) {
 	site := c.Param("site")
 	date := c.Param("date")
+	adminPassword, err := ValidateAdminPasswordMode(c.Query("admin_password"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
 
 	if a.backupper.r2 == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "backup not configured (R2 credentials missing)"})
+		respondError(c, http.StatusServiceUnavailable, CodeBackupNotConfigured, "backup not configured (R2 credentials missing)")
 		return
 	}
 	if !dateRe.MatchString(date) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date format — expected YYYY-MM-DD"})
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "invalid date format — expected YYYY-MM-DD")
 		return
 	}
-	if _, err := a.db.GetSite(site); err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "site not found"})
+	s, err := a.db.GetSite(site)
+	if err != nil {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	}
+	if adminPassword == AdminPasswordReset && !a.isWordPressSite(s) {
+		respondError(c, http.StatusBadRequest, CodeUnsupportedSiteType, "admin_password=reset is only available for WordPress sites")
 		return
 	}
 
 	if err := a.backupper.RestoreSite(site, date); err != nil {
 		log.Printf("[api] restore failed site=%s date=%s: %v", site, date, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, CodeInfraFailed, err.Error())
+		return
+	}
+	if adminPassword == AdminPasswordReset {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+		user := siteAdminUser(s)
+		password, err := resetAdminPassword(ctx, a.docker, a.cfg, SiteResources(s), user)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInfraFailed, "restored, but the admin password was not reset: "+err.Error())
+			return
+		}
+		if err := a.db.SetSiteAdminCredentials(site, user, password); err != nil {
+			respondError(c, http.StatusInternalServerError, CodePersistFailed, "restored and admin password reset, but it was not stored: "+err.Error())
+			return
+		}
+		log.Printf("[api] site=%s admin password of %s reset after restore req=%s", site, user, requestID(c))
+		a.recordHistory(site, EventAdminPasswordSet, user+": reset on restore from "+date)
+		a.events.Publish(Event{
+			Type: EventAdminPasswordSet, Site: site, RequestID: requestID(c),
+			Data: map[string]any{"user": user},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"site": site, "date": date, "admin_password": adminPassword, "status": "restored"})
+}
+
+// POST /api/sites/:site/admin-credentials/reveal
+//
+// Returns the WordPress admin login and password the control plane
+// generated for the site, once: the password is forgotten as it is
+// returned. Only its reference stays on the site record.
+func (a *API) handleRevealAdminCredentials(c *gin.Context) {
+	site := c.Param("site")
+	if _, err := a.db.GetSite(site); err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+		return
+	} else if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to fetch site")
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"site": site, "date": date, "status": "restored"})
+
+	user, password, err := a.db.RevealSiteAdminPassword(site)
+	if err == sql.ErrNoRows {
+		respondError(c, http.StatusNotFound, CodeNoCredentials, "no admin password to reveal: none was generated, or it was revealed already")
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to reveal admin credentials")
+		return
+	}
+
+	log.Printf("[api] site=%s admin password of %s revealed req=%s", site, user, requestID(c))
+	a.recordHistory(site, EventAdminPasswordRevealed, user)
+	a.events.Publish(Event{
+		Type: EventAdminPasswordRevealed, Site: site, RequestID: requestID(c),
+		Data: map[string]any{"user": user},
+	})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"site": site, "admin_user": user, "admin_password": password})
+}
+
+// fullAccessKey is the gin context key set by authMiddleware when the request
+// authenticated with ADMIN_API_KEY.
+const fullAccessKey = "full_access"
+
+// tenantForKey maps an API key to the tenant it authenticates, or "" when the
+// key is unknown.
+func (a *API) tenantForKey(key string) string {
+	switch {
+	case key == "":
+		return ""
+	case a.cfg.AdminAPIKey != "" && key == a.cfg.AdminAPIKey:
+		return tenantAdmin
+	case key == a.cfg.APIKey:
+		return tenantDefault
+	}
+	for name, k := range a.cfg.TenantAPIKeys {
+		if k != "" && key == k {
+			return name
+		}
+	}
+	return ""
 }
 
 // authMiddleware validates the X-API-Key header on every request
@@ -836,10 +4537,13 @@ func (a *API) authMiddleware() gin.HandlerFunc {
 		}
 
 		key := c.GetHeader("X-API-Key")
-		if key == "" || key != a.cfg.APIKey {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		name := a.tenantForKey(key)
+		if name == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorBody(http.StatusUnauthorized, CodeUnauthorized, "unauthorized"))
 			return
 		}
+		c.Set(fullAccessKey, name == tenantAdmin)
+		c.Set(tenantKey, name)
 
 		c.Next()
 	}
@@ -12,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-sql-driver/mysql"
 )
 
 // R2Client wraps an S3-compatible client pointed at Cloudflare R2.
@@ -201,20 +202,14 @@ func dateFromKey(key string) string {
 	return name
 }
 
-// parseDSNCredentials extracts user and password from a DSN of the form:
-// user:pass@tcp(host:port)/
-// Safe: looks for @tcp( to isolate the credentials prefix.
+// parseDSNCredentials extracts user and password from a go-sql-driver DSN,
+// e.g. user:pass@tcp(host:port)/. Returns empty strings if it cannot parse.
 func parseDSNCredentials(dsn string) (user, pass string) {
-	atTCP := strings.Index(dsn, "@tcp(")
-	if atTCP < 0 {
+	dc, err := mysql.ParseDSN(dsn)
+	if err != nil {
 		return "", ""
 	}
-	creds := dsn[:atTCP]
-	colon := strings.Index(creds, ":")
-	if colon < 0 {
-		return creds, ""
-	}
-	return creds[:colon], creds[colon+1:]
+	return dc.User, dc.Passwd
 }
 
 // stripDockerMux strips the 8-byte Docker stream multiplexing header from each
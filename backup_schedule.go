@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Per-site backup schedules. A site with a schedule is backed up by a BACKUP
+// job the worker queues once its interval has passed since last_backup_at,
+// and its backups are then pruned to the schedule's retention; sites without
+// one keep the daily BackupAll run. Backups are keyed by UTC date, so an
+// interval shorter than a day would only overwrite the same objects.
+const (
+	minBackupIntervalHours = 24
+	maxBackupIntervalHours = 24 * 365
+	maxBackupKeep          = 365
+	maxBackupAgeDays       = 3650
+)
+
+// backupSweepInterval is how often the worker looks for due backups.
+const backupSweepInterval = 5 * time.Minute
+
+// BackupSchedule is a site's backup interval and retention. Keep caps the
+// number of backups kept and MaxAgeDays their age; zero means no limit.
+type BackupSchedule struct {
+	IntervalHours int `json:"interval_hours"`
+	Keep          int `json:"keep,omitempty"`
+	MaxAgeDays    int `json:"max_age_days,omitempty"`
+}
+
+// Enabled reports whether the site is backed up on its own schedule.
+func (s BackupSchedule) Enabled() bool { return s.IntervalHours > 0 }
+
+func (s BackupSchedule) validate() error {
+	if s.IntervalHours != 0 && (s.IntervalHours < minBackupIntervalHours || s.IntervalHours > maxBackupIntervalHours) {
+		return fmt.Errorf("interval_hours must be 0 (disabled) or between %d and %d", minBackupIntervalHours, maxBackupIntervalHours)
+	}
+	if s.Keep < 0 || s.Keep > maxBackupKeep {
+		return fmt.Errorf("keep must be between 0 (unlimited) and %d", maxBackupKeep)
+	}
+	if s.MaxAgeDays < 0 || s.MaxAgeDays > maxBackupAgeDays {
+		return fmt.Errorf("max_age_days must be between 0 (unlimited) and %d", maxBackupAgeDays)
+	}
+	return nil
+}
+
+// PruneBackups deletes site's database and volume backups beyond the keep
+// most recent, and any older than maxAgeDays (zero = no limit). Like
+// DeleteOlderThan it logs and continues past individual delete failures.
+func (b *Backupper) PruneBackups(ctx context.Context, site string, keep, maxAgeDays int) error {
+	if b.r2 == nil {
+		return fmt.Errorf("R2 not configured")
+	}
+	cutoff := time.Now().AddDate(0, 0, -maxAgeDays)
+	var lastErr error
+	for _, prefix := range []string{prefixForSiteDB(site), prefixForSiteVolume(site)} {
+		entries, err := b.r2.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", prefix, err)
+		}
+		// Keys end in the backup date, so newest first is descending key order.
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Key > entries[j].Key })
+		for i, e := range entries {
+			if (keep == 0 || i < keep) && (maxAgeDays == 0 || !e.LastModified.Before(cutoff)) {
+				continue
+			}
+			if err := b.r2.deleteObject(ctx, e.Key); err != nil {
+				log.Printf("[backupper] site=%s prune: failed to delete %s: %v", site, e.Key, err)
+				lastErr = err
+				continue
+			}
+			log.Printf("[backupper] site=%s prune: deleted %s", site, e.Key)
+		}
+	}
+	return lastErr
+}
+
+// runBackup backs up the site of a BACKUP job, then applies its schedule's
+// retention. A failed prune is logged only: the backup itself succeeded.
+func (w *Worker) runBackup(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	backupper := w.destroyer.backupper
+	if err := backupper.BackupSite(ctx, job.Site); err != nil {
+		return err
+	}
+	if sched := s.BackupSchedule; sched.Keep > 0 || sched.MaxAgeDays > 0 {
+		if err := backupper.PruneBackups(ctx, job.Site, sched.Keep, sched.MaxAgeDays); err != nil {
+			log.Printf("[worker] site=%s warning: backup retention not fully applied: %v", job.Site, err)
+		}
+	}
+	return nil
+}
+
+// queueDueBackups queues a BACKUP job for every site whose schedule is due,
+// at most once per backupSweepInterval and only when R2 is configured and
+// backups are enabled. Sites with a job in flight are picked up by a later
+// sweep.
+func (w *Worker) queueDueBackups() {
+	if w.destroyer.backupper.r2 == nil || !w.cfg.FeatureEnabled(FeatureBackups) || time.Since(w.lastBackupSweep) < backupSweepInterval {
+		return
+	}
+	w.lastBackupSweep = time.Now()
+
+	sites, err := w.db.ListDueBackups()
+	if err != nil {
+		log.Printf("[worker] error listing due backups: %v", err)
+		return
+	}
+	for _, site := range sites {
+		active, err := w.db.HasActiveJob(site)
+		if err != nil || active {
+			continue
+		}
+		jobID := uuid.New().String()
+		if err := w.db.InsertJobWithPayload(jobID, JobBackup, site, "", ""); err != nil {
+			log.Printf("[worker] site=%s error queueing scheduled backup: %v", site, err)
+			continue
+		}
+		if err := w.db.SetSiteJob(site, jobID); err != nil {
+			log.Printf("[worker] site=%s warning: backup job %s queued but not linked to site: %v", site, jobID, err)
+		}
+		log.Printf("[worker] site=%s scheduled backup due, queued job %s", site, jobID)
+	}
+}
@@ -36,14 +36,14 @@ func NewBackupper(docker *client.Client, cfg Config, r2 *R2Client, db *DB) *Back
 // BackupSite backs up the database and volume for a single site.
 // Both must succeed — the first failure aborts and returns an error.
 // Called by Destroyer.Run() before any destructive step.
-func (b *Backupper) BackupSite(site string) error {
+func (b *Backupper) BackupSite(ctx context.Context, site string) error {
 	if b.r2 == nil {
 		return fmt.Errorf("R2 not configured — cannot back up site %s", site)
 	}
-	if err := b.BackupDatabase(site); err != nil {
+	if err := b.BackupDatabase(ctx, site); err != nil {
 		return fmt.Errorf("database backup failed: %w", err)
 	}
-	if err := b.BackupVolume(site); err != nil {
+	if err := b.BackupVolume(ctx, site); err != nil {
 		return fmt.Errorf("volume backup failed: %w", err)
 	}
 
@@ -72,7 +72,11 @@ func (b *Backupper) BackupAll() {
 		if s.Status == "DESTROYED" || s.Status == "FAILED" || s.Status == "CREATED" {
 			continue
 		}
-		if err := b.BackupSite(s.Site); err != nil {
+		// Scheduled sites are backed up by their own BACKUP jobs.
+		if s.BackupSchedule.Enabled() {
+			continue
+		}
+		if err := b.BackupSite(context.Background(), s.Site); err != nil {
 			log.Printf("[backupper] BackupAll: site=%s FAILED: %v", s.Site, err)
 			failed++
 			continue // never stops the loop
@@ -92,15 +96,18 @@ func (b *Backupper) BackupAll() {
 // After the upload finishes, ContainerWait is used to verify the exit code.
 // If mysqldump exited non-zero (e.g., wrong credentials, DB not found), any
 // partial/empty object already uploaded to R2 is deleted and an error is returned.
-func (b *Backupper) BackupDatabase(site string) error {
+func (b *Backupper) BackupDatabase(ctx context.Context, site string) error {
 	if b.r2 == nil {
 		return fmt.Errorf("R2 not configured")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	dbName := WPDatabaseName(site)
+	dbName, err := b.siteDatabase(site)
+	if err != nil {
+		return err
+	}
 	dbUser, dbPass := parseDSNCredentials(b.cfg.WordPressDSN)
 	dbHostPort := b.cfg.DBHost() // e.g. "10.10.0.20:3306"
 	dbHost, dbPort, err := net.SplitHostPort(dbHostPort)
@@ -219,15 +226,18 @@ func (b *Backupper) BackupDatabase(site string) error {
 //
 // ContainerWait is used after upload to verify tar exited cleanly.
 // If non-zero, the uploaded object is deleted from R2 before returning error.
-func (b *Backupper) BackupVolume(site string) error {
+func (b *Backupper) BackupVolume(ctx context.Context, site string) error {
 	if b.r2 == nil {
 		return fmt.Errorf("R2 not configured")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
 	defer cancel()
 
-	volumeName := VolumeName(site)
+	volumeName, err := b.siteVolume(site)
+	if err != nil {
+		return err
+	}
 	containerName := fmt.Sprintf("backup_vol_%s_%d", site, time.Now().UnixNano())
 
 	createResp, err := b.docker.ContainerCreate(ctx,
@@ -372,7 +382,10 @@ func (b *Backupper) RestoreDatabase(ctx context.Context, site, date string) erro
 	}
 	defer gzReader.Close()
 
-	dbName := WPDatabaseName(site)
+	dbName, err := b.siteDatabase(site)
+	if err != nil {
+		return err
+	}
 	dbUser, dbPass := parseDSNCredentials(b.cfg.WordPressDSN)
 	dbHostPort := b.cfg.DBHost()
 	dbHost, dbPort, splitErr := net.SplitHostPort(dbHostPort)
@@ -465,7 +478,10 @@ func (b *Backupper) RestoreVolume(ctx context.Context, site, date string) error
 	}
 	defer rc.Close()
 
-	volumeName := VolumeName(site)
+	volumeName, err := b.siteVolume(site)
+	if err != nil {
+		return err
+	}
 	containerName := fmt.Sprintf("restore_vol_%s_%d", site, time.Now().UnixNano())
 
 	createResp, err := b.docker.ContainerCreate(ctx,
@@ -537,6 +553,29 @@ func (b *Backupper) RestoreVolume(ctx context.Context, site, date string) error
 	return nil
 }
 
+// siteVolume returns the volume holding site's files (see SiteVolume).
+func (b *Backupper) siteVolume(site string) (string, error) {
+	s, err := b.db.GetSite(site)
+	if err != nil {
+		return "", fmt.Errorf("load site %s: %w", site, err)
+	}
+	return SiteVolume(s), nil
+}
+
+// siteDatabase returns the database of site's live resource set (see
+// SiteResources).
+func (b *Backupper) siteDatabase(site string) (string, error) {
+	s, err := b.db.GetSite(site)
+	if err != nil {
+		return "", fmt.Errorf("load site %s: %w", site, err)
+	}
+	dbName := WPDatabaseName(SiteResources(s))
+	if err := ValidateDBIdentifier(dbName, mysqlDatabaseNameMax); err != nil {
+		return "", fmt.Errorf("site %s: %w", site, err)
+	}
+	return dbName, nil
+}
+
 // waitContainer waits for a container to stop and returns its exit code.
 // Safe to call after the container has already exited — returns immediately.
 func (b *Backupper) waitContainer(ctx context.Context, containerID string) (int64, error) {
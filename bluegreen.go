@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blue/green WordPress deploys. A site's containers, volumes and database
+// form a resource set named by SiteResources. A green set is a clone of the
+// live one, reachable at https://<site>-green.<base domain>/ to try a core
+// or plugin upgrade; the cutover re-points the site's Caddy snippet at the
+// green nginx sidecar in a single reload. The set it replaced keeps running
+// for Config.BlueGreenKeep, so a rollback is the same switch in reverse,
+// and is then retired. Every step is a job that leaves the site ACTIVE (or
+// DOMAIN_ACTIVE) throughout (see JobType.KeepsSiteStatus):
+//
+//	GREEN_CREATE    clone the live set into the green set, route the preview
+//	GREEN_CUTOVER   serve the green set; the live set becomes the retired one
+//	GREEN_ROLLBACK  serve the retired set; the live set becomes the retired one
+//	GREEN_DISCARD   remove the green set and its preview route
+//	BLUE_RETIRE     remove the retired set, queued by the worker once due
+//
+// Changes written to the green set before the cutover, or to the live set
+// after it, are not carried across: each switch serves a set as it is.
+
+// BlueGreenStatus is the blue/green state of a site in GET /api/sites/:site.
+type BlueGreenStatus struct {
+	Live          string     `json:"live"`                     // the set serving the site
+	Green         string     `json:"green,omitempty"`          // set being prepared, see the site's job for progress
+	PreviewDomain string     `json:"preview_domain,omitempty"` // where the green set is served
+	Retired       string     `json:"retired,omitempty"`        // set kept for POST .../green/rollback
+	RetireAfter   *time.Time `json:"retire_after,omitempty"`
+}
+
+// blueGreenStatus returns s's blue/green state, or nil for a site that has
+// never had a green set.
+func blueGreenStatus(s *Site, fallbackBaseDomain string) *BlueGreenStatus {
+	if s.Resources == "" && s.GreenResources == "" && s.RetiredResources == "" {
+		return nil
+	}
+	st := &BlueGreenStatus{Live: SiteResources(s), Green: s.GreenResources, Retired: s.RetiredResources, RetireAfter: s.RetireAfter}
+	if st.Green != "" {
+		st.PreviewDomain = SiteDomain(GreenPreviewSite(s.Site), SiteBaseDomain(s, fallbackBaseDomain))
+	}
+	return st
+}
+
+// retireSweepInterval is how often the worker looks for retired sets that
+// are due for removal.
+const retireSweepInterval = time.Minute
+
+// createGreen provisions the green set recorded on the site when the job was
+// queued, seeded from the live set, under the preview name. A failed attempt
+// is rolled back by Provisioner.Run; the green set stays recorded so it can
+// be retried or discarded.
+func (w *Worker) createGreen(ctx context.Context, job *Job) error {
+	opts, err := w.provisionOptions(job.ID)
+	if err != nil {
+		return err
+	}
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	if opts.Resources == "" || opts.Resources != s.GreenResources {
+		return fmt.Errorf("green set %q is no longer recorded for the site", opts.Resources)
+	}
+	if opts.Resources == SiteResources(s) {
+		return fmt.Errorf("green set %s is the live set", opts.Resources)
+	}
+
+	preview := GreenPreviewSite(job.Site)
+	if err := w.runProvision(ctx, job, preview, opts); err != nil {
+		return err
+	}
+
+	domain := SiteDomain(preview, SiteBaseDomain(s, w.cfg.BaseDomain))
+	log.Printf("[worker] site=%s green set %s ready at %s", job.Site, opts.Resources, domain)
+	if err := w.db.RecordSiteHistory(job.Site, EventGreenCreated, opts.Resources+" at "+domain); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	return nil
+}
+
+// cutoverGreen serves the site from its green set and keeps the previous
+// live set as the retired one. If the switch fails the green set is put
+// back on its preview hostname and the site keeps serving as before.
+func (w *Worker) cutoverGreen(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	green, live := s.GreenResources, SiteResources(s)
+	if green == "" {
+		return fmt.Errorf("site has no green set to cut over to")
+	}
+	if s.CaddyManual {
+		return errCaddyManuallyEdited
+	}
+
+	if err := w.switchLive(ctx, s, green); err != nil {
+		w.restorePreview(ctx, s)
+		return err
+	}
+	if err := w.db.SwitchLiveResources(job.Site, green, live, w.cfg.BlueGreenKeep); err != nil {
+		log.Printf("[CRITICAL] site=%s now served by %s but DB commit failed: %v", job.Site, green, err)
+		return fmt.Errorf("record cutover: %w", err)
+	}
+	if err := w.removePreviewRoute(ctx, job.Site); err != nil {
+		log.Printf("[WARN] site=%s could not remove the green preview route (non-fatal): %v", job.Site, err)
+	}
+
+	log.Printf("[worker] site=%s cut over %s → %s (retiring %s in %s)", job.Site, live, green, live, w.cfg.BlueGreenKeep)
+	if err := w.db.RecordSiteHistory(job.Site, EventGreenCutover, live+" → "+green); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	return nil
+}
+
+// rollbackGreen serves the site from its retired set again, which then
+// swaps places with the live set: rolling back twice returns to where the
+// cutover left the site.
+func (w *Worker) rollbackGreen(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	retired, live := s.RetiredResources, SiteResources(s)
+	if retired == "" {
+		return fmt.Errorf("site has no retired set to roll back to")
+	}
+	if s.CaddyManual {
+		return errCaddyManuallyEdited
+	}
+
+	if err := w.switchLive(ctx, s, retired); err != nil {
+		return err
+	}
+	if err := w.db.SwitchLiveResources(job.Site, retired, live, w.cfg.BlueGreenKeep); err != nil {
+		log.Printf("[CRITICAL] site=%s now served by %s but DB commit failed: %v", job.Site, retired, err)
+		return fmt.Errorf("record rollback: %w", err)
+	}
+
+	log.Printf("[worker] site=%s rolled back %s → %s", job.Site, live, retired)
+	if err := w.db.RecordSiteHistory(job.Site, EventGreenRolledBack, live+" → "+retired); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	return nil
+}
+
+// discardGreen removes the site's green set and its preview route.
+func (w *Worker) discardGreen(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	green := s.GreenResources
+	if green == "" {
+		return nil
+	}
+	if err := w.removePreviewRoute(ctx, job.Site); err != nil {
+		return fmt.Errorf("removePreviewRoute: %w", err)
+	}
+	if err := w.removeSet(ctx, s, green); err != nil {
+		return err
+	}
+	if err := w.db.SetGreenResources(job.Site, ""); err != nil {
+		return fmt.Errorf("record discard: %w", err)
+	}
+
+	log.Printf("[worker] site=%s green set %s discarded", job.Site, green)
+	if err := w.db.RecordSiteHistory(job.Site, EventGreenDiscarded, green); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	return nil
+}
+
+// retireBlue removes the site's retired set; a rollback is no longer
+// possible afterwards.
+func (w *Worker) retireBlue(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	retired := s.RetiredResources
+	if retired == "" {
+		return nil
+	}
+	if err := w.removeSet(ctx, s, retired); err != nil {
+		return err
+	}
+	if err := w.db.ClearRetiredResources(job.Site); err != nil {
+		return fmt.Errorf("record retirement: %w", err)
+	}
+
+	log.Printf("[worker] site=%s retired set %s removed", job.Site, retired)
+	if err := w.db.RecordSiteHistory(job.Site, EventBlueRetired, retired); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	return nil
+}
+
+// switchLive routes s to the resource set to: its nginx server block gets
+// the site's domains and its WordPress URLs the site's canonical URL, then
+// the site's Caddy snippet is pointed at its nginx sidecar and Caddy
+// reloaded once. If Caddy fails the snippet is put back. Persisting the
+// switch is left to the caller.
+func (w *Worker) switchLive(ctx context.Context, s *Site, to string) error {
+	p := w.provisioner
+	if err := p.writeNginxConfigWithDomains(ctx, NginxContainerName(to), PHPContainerName(to),
+		s.Domain, s.CustomDomain, s.WWWMode, "", s.NginxSnippet, s.FastCGI,
+	); err != nil {
+		return fmt.Errorf("nginx config failed: %w", err)
+	}
+	if err := p.updateWordPressURLs(ctx, to, "https://"+canonicalDomain(s)); err != nil {
+		return fmt.Errorf("wp_options update failed: %w", err)
+	}
+
+	target := *s
+	target.Resources = to
+	err := writeSiteCaddyConfig(ctx, p.docker, w.cfg, &target, true, s.Domain, s.CustomDomain, s.WWWMode)
+	if err == nil {
+		err = reloadCaddy(ctx, w.cfg)
+	}
+	if err != nil {
+		if rbErr := writeSiteCaddyConfig(ctx, p.docker, w.cfg, s, true, s.Domain, s.CustomDomain, s.WWWMode); rbErr != nil {
+			log.Printf("[rollback] site=%s could not restore caddy snippet: %v", s.Site, rbErr)
+		}
+		return fmt.Errorf("caddy update failed: %w", err)
+	}
+	return nil
+}
+
+// restorePreview puts a green set whose cutover failed back on its preview
+// hostname. Best effort: the live site is unaffected either way.
+func (w *Worker) restorePreview(ctx context.Context, s *Site) {
+	p, green := w.provisioner, s.GreenResources
+	domain := SiteDomain(GreenPreviewSite(s.Site), SiteBaseDomain(s, w.cfg.BaseDomain))
+	if err := p.writeNginxConfig(ctx, NginxContainerName(green), PHPContainerName(green), domain, "", s.NginxSnippet, s.FastCGI); err != nil {
+		log.Printf("[rollback] site=%s could not restore green nginx config: %v", s.Site, err)
+	}
+	if err := p.updateWordPressURLs(ctx, green, "https://"+domain); err != nil {
+		log.Printf("[rollback] site=%s could not restore green URLs: %v", s.Site, err)
+	}
+}
+
+// removeSet removes a green or retired resource set of s, refusing the
+// live one.
+func (w *Worker) removeSet(ctx context.Context, s *Site, name string) error {
+	if name == SiteResources(s) {
+		return fmt.Errorf("refusing to remove %s: it is the live set", name)
+	}
+	if err := w.destroyer.removeResourceSet(ctx, name, "", ""); err != nil {
+		return err
+	}
+	if err := w.destroyer.dropDatabase(ctx, WPDatabaseName(name), WPDatabaseUser(name)); err != nil {
+		return fmt.Errorf("dropDatabase: %w", err)
+	}
+	return nil
+}
+
+// removePreviewRoute removes the green preview snippet of site and reloads
+// Caddy.
+func (w *Worker) removePreviewRoute(ctx context.Context, site string) error {
+	path := w.cfg.CaddyConfDir + "/" + CaddyConfFile(GreenPreviewSite(site))
+	res, err := runExec(ctx, w.provisioner.docker, w.cfg.CaddyContainer, []string{"rm", "-f", path})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("rm %s exited %d: %s", path, res.ExitCode, res.Stderr)
+	}
+	return reloadCaddy(ctx, w.cfg)
+}
+
+// queueDueRetirements queues a BLUE_RETIRE job for every site whose retired
+// set is past its keep time, at most once per retireSweepInterval. Sites
+// with a job in flight are picked up by a later sweep.
+func (w *Worker) queueDueRetirements() {
+	if time.Since(w.lastRetireSweep) < retireSweepInterval {
+		return
+	}
+	w.lastRetireSweep = time.Now()
+
+	sites, err := w.db.ListDueRetirements()
+	if err != nil {
+		log.Printf("[worker] error listing due retirements: %v", err)
+		return
+	}
+	for _, site := range sites {
+		active, err := w.db.HasActiveJob(site)
+		if err != nil || active {
+			continue
+		}
+		jobID := uuid.New().String()
+		if err := w.db.InsertJobWithPayload(jobID, JobBlueRetire, site, "", ""); err != nil {
+			log.Printf("[worker] site=%s error queueing retirement: %v", site, err)
+			continue
+		}
+		if err := w.db.SetSiteJob(site, jobID); err != nil {
+			log.Printf("[worker] site=%s warning: retirement job %s queued but not linked to site: %v", site, jobID, err)
+		}
+		log.Printf("[worker] site=%s retired set due, queued job %s", site, jobID)
+	}
+}
+
+// canonicalDomain is the host a WordPress site's URLs use: its custom
+// domain when it has one, else its default domain.
+func canonicalDomain(s *Site) string {
+	if s.CustomDomain != "" {
+		return s.CustomDomain
+	}
+	return s.Domain
+}
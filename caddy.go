@@ -1,10 +1,15 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -27,25 +32,148 @@ func ensureCaddyConfDir(ctx context.Context, docker *client.Client, containerNam
 	return nil
 }
 
-// reloadCaddy signals the Caddy container to reload its configuration.
-// It uses `caddy reload` which is a graceful, zero-downtime reload.
-func reloadCaddy(cfg Config) error {
-	env := append(os.Environ(),
+// copyCaddyFile writes a single file into dir inside the Caddy container.
+func copyCaddyFile(ctx context.Context, docker *client.Client, cfg Config, dir, name, content string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	data := []byte(content)
+	tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	tw.Write(data)
+	tw.Close()
+
+	if err := docker.CopyToContainer(ctx, cfg.CaddyContainer, dir, &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("copy %s: %w", name, err)
+	}
+	return nil
+}
+
+// errCaddyConfigRejected marks a reload that failed because `caddy validate`
+// rejects the on-disk config — retrying cannot help.
+var errCaddyConfigRejected = errors.New("caddy config rejected by caddy validate")
+
+// errCaddyManuallyEdited is returned by regenerateCaddy for a site whose
+// snippet an operator replaced via PUT /api/sites/:site/caddy.
+var errCaddyManuallyEdited = errors.New("caddy snippet was manually edited — revert it with DELETE /api/sites/:site/caddy first")
+
+// caddySnippetMaxLen bounds a hand-written per-site Caddy snippet.
+const caddySnippetMaxLen = 16 * 1024
+
+// caddySnippetPath returns where the site's Caddy config lives inside the
+// Caddy container: its route file for path-routed sites, otherwise its
+// per-site snippet.
+func caddySnippetPath(cfg Config, s *Site) string {
+	if s.PathPrefix != "" {
+		return cfg.CaddyConfDir + "/" + caddyPathRoutesDir + "/" + CaddyPathRouteFile(s.Site)
+	}
+	return cfg.CaddyConfDir + "/" + CaddyConfFile(s.Site)
+}
+
+// caddyCommand runs the caddy CLI inside the Caddy container through the
+// docker CLI and returns its combined output. The process is killed if ctx
+// is cancelled.
+func caddyCommand(ctx context.Context, cfg Config, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", append([]string{"exec", cfg.CaddyContainer, "caddy"}, args...)...)
+	cmd.Env = append(os.Environ(),
 		"DOCKER_HOST="+cfg.DockerHost,
 		"DOCKER_TLS_VERIFY=1",
 		"DOCKER_CERT_PATH="+cfg.DockerCertDir,
 	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
 
-	reload := exec.Command("docker", "exec", cfg.CaddyContainer,
-		"caddy", "reload", "--config", "/etc/caddy/Caddyfile")
-	reload.Env = env
-	if out, err := reload.CombinedOutput(); err != nil {
-		return fmt.Errorf("caddy reload failed: %s", string(out))
+// validateCaddy runs `caddy validate` against the on-disk Caddyfile, which
+// imports every per-site snippet. A rejection wraps errCaddyConfigRejected.
+func validateCaddy(ctx context.Context, cfg Config) error {
+	if out, err := caddyCommand(ctx, cfg, "validate", "--config", "/etc/caddy/Caddyfile"); err != nil {
+		return fmt.Errorf("%w: %s", errCaddyConfigRejected, strings.TrimSpace(out))
 	}
+	return nil
+}
+
+// caddyCheckDir is where checkCaddySnippet validates a snippet inside the
+// Caddy container, away from the imported sites directory.
+const caddyCheckDir = "/tmp/hostplane-caddy-check"
 
+// checkCaddySnippet runs `caddy validate` on a single site snippet in
+// isolation, with a Caddyfile that imports nothing else, so a broken snippet
+// is rejected before it joins the directory every reload validates. A
+// rejection wraps errCaddyConfigRejected. The check files are removed again.
+func checkCaddySnippet(ctx context.Context, docker *client.Client, cfg Config, name, content string) error {
+	dir := caddyCheckDir + "/" + strings.TrimSuffix(name, ".caddy")
+	if err := ensureCaddyConfDir(ctx, docker, cfg.CaddyContainer, dir); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := runExec(context.Background(), docker, cfg.CaddyContainer, []string{"rm", "-rf", dir}); err != nil {
+			log.Printf("[caddy] warning: could not remove %s: %v", dir, err)
+		}
+	}()
+
+	if err := copyCaddyFile(ctx, docker, cfg, dir, name, content); err != nil {
+		return err
+	}
+	if err := copyCaddyFile(ctx, docker, cfg, dir, "Caddyfile", "import "+name+"\n"); err != nil {
+		return err
+	}
+	if out, err := caddyCommand(ctx, cfg, "validate", "--config", dir+"/Caddyfile"); err != nil {
+		return fmt.Errorf("%w: %s: %s", errCaddyConfigRejected, name, strings.TrimSpace(out))
+	}
 	return nil
 }
 
+// reloadCaddy signals the Caddy container to reload its configuration.
+// It uses `caddy reload` which is a graceful, zero-downtime reload.
+// A failed reload is retried (see retryReload) unless the config itself is
+// invalid. The docker CLI process is killed if ctx is cancelled.
+func reloadCaddy(ctx context.Context, cfg Config) error {
+	return retryReload(ctx, cfg, "caddy",
+		func() (string, error) {
+			return caddyCommand(ctx, cfg, "reload", "--config", "/etc/caddy/Caddyfile")
+		},
+		func() error { return validateCaddy(ctx, cfg) })
+}
+
+// retryReload runs reload up to cfg.ReloadAttempts times, pausing
+// cfg.ReloadRetryDelay between tries. Reloads occasionally fail transiently
+// (config not yet flushed, brief file lock), so after each failure validate
+// is consulted: a config error is returned at once, anything else is
+// retried. The last reload output is included once attempts run out.
+func retryReload(ctx context.Context, cfg Config, name string, reload func() (string, error), validate func() error) error {
+	attempts := max(cfg.ReloadAttempts, 1)
+	var out string
+	var err error
+	for attempt := 1; ; attempt++ {
+		if out, err = reload(); err == nil {
+			if attempt > 1 {
+				log.Printf("[%s] reload succeeded on attempt %d/%d", name, attempt, attempts)
+			}
+			return nil
+		}
+		if attempt == attempts || ctx.Err() != nil {
+			break
+		}
+		if verr := validate(); verr != nil {
+			return verr
+		}
+		log.Printf("[%s] reload attempt %d/%d failed, retrying: %s", name, attempt, attempts, strings.TrimSpace(out))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s reload failed: %s", name, strings.TrimSpace(out))
+		case <-time.After(cfg.ReloadRetryDelay):
+		}
+	}
+	if out == "" {
+		out = err.Error()
+	}
+	return fmt.Errorf("%s reload failed after %d attempt(s): %s", name, attempts, strings.TrimSpace(out))
+}
+
 // CaddyCertStatus reports whether Caddy has already obtained a TLS certificate
 // for a given domain. It execs `caddy list-certificates` inside the Caddy
 // container and checks whether the domain appears in the output.
@@ -61,24 +189,29 @@ const (
 // the cert is already present (e.g. renewed from a previous provisioning).
 // Returns CertPending if the cert is not yet issued after the timeout — this
 // is not an error; Caddy will keep retrying in the background.
-func PollCaddyCert(docker *client.Client, cfg Config, domain string, timeout time.Duration) CaddyCertStatus {
+// Polling stops early (returning CertPending) if ctx is cancelled.
+func PollCaddyCert(ctx context.Context, docker *client.Client, cfg Config, domain string, timeout time.Duration) CaddyCertStatus {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		if caddyHasCert(docker, cfg, domain) {
 			return CertIssued
 		}
-		time.Sleep(3 * time.Second)
+		select {
+		case <-ctx.Done():
+			return CertPending
+		case <-time.After(3 * time.Second):
+		}
 	}
 	return CertPending
 }
 
-// caddySnippetExists returns true if the per-site Caddy snippet file is present
-// inside the Caddy container. A missing snippet means the site is not routed.
-func caddySnippetExists(docker *client.Client, cfg Config, site string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// caddySnippetExists returns true if the per-site Caddy snippet file at
+// snippetPath is present inside the Caddy container. A missing snippet means
+// the site is not routed.
+func caddySnippetExists(docker *client.Client, cfg Config, snippetPath string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DockerExecTimeout)
 	defer cancel()
 
-	snippetPath := cfg.CaddyConfDir + "/" + CaddyConfFile(site)
 	execResp, err := docker.ContainerExecCreate(ctx, cfg.CaddyContainer, types.ExecConfig{
 		Cmd: []string{"test", "-f", snippetPath},
 	})
@@ -101,14 +234,13 @@ func caddySnippetExists(docker *client.Client, cfg Config, site string) bool {
 	return false
 }
 
-// caddySnippetContainsDomain returns true if the snippet for the given site
+// caddySnippetContainsDomain returns true if the snippet at snippetPath
 // contains the expected domain string. Catches stale snippets left over after
 // a domain was moved from one site to another without a reload.
-func caddySnippetContainsDomain(docker *client.Client, cfg Config, site, domain string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func caddySnippetContainsDomain(docker *client.Client, cfg Config, snippetPath, domain string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DockerExecTimeout)
 	defer cancel()
 
-	snippetPath := cfg.CaddyConfDir + "/" + CaddyConfFile(site)
 	execResp, err := docker.ContainerExecCreate(ctx, cfg.CaddyContainer, types.ExecConfig{
 		Cmd:          []string{"grep", "-q", domain, snippetPath},
 		AttachStdout: false,
@@ -133,16 +265,22 @@ func caddySnippetContainsDomain(docker *client.Client, cfg Config, site, domain
 	return false
 }
 
+// caddyCertPath is where Caddy stores the Let's Encrypt certificate for
+// domain inside its container.
+func caddyCertPath(domain string) string {
+	return "/data/caddy/certificates/acme-v02.api.letsencrypt.org-directory/" + domain + "/" + domain + ".crt"
+}
+
 // testing for the cert file in Caddy's on-disk ACME storage. This is more
 // reliable than `caddy list-certificates` which was removed in newer Caddy
 // versions. Caddy stores ACME certs at:
 //
 //	/data/caddy/certificates/acme-v02.api.letsencrypt.org-directory/<domain>/<domain>.crt
 func caddyHasCert(docker *client.Client, cfg Config, domain string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DockerExecTimeout)
 	defer cancel()
 
-	certPath := "/data/caddy/certificates/acme-v02.api.letsencrypt.org-directory/" + domain + "/" + domain + ".crt"
+	certPath := caddyCertPath(domain)
 
 	execResp, err := docker.ContainerExecCreate(ctx, cfg.CaddyContainer, types.ExecConfig{
 		Cmd: []string{"test", "-f", certPath},
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Per-site Caddy request logging. A site's generated snippet carries a `log`
+// directive when logging is on, writing one JSON object per request either
+// to its own file in Config.CaddyLogDir (which can be tailed through the
+// API) or to Caddy's stdout, next to everything else Caddy logs.
+const (
+	CaddyLogOff    = ""
+	CaddyLogFile   = "file"
+	CaddyLogStdout = "stdout"
+)
+
+// Log files are rolled by Caddy so a noisy site cannot fill the disk.
+const (
+	caddyLogRollSizeMB = 10
+	caddyLogRollKeep   = 3
+)
+
+// Tailing a site's log returns its newest lines: caddyLogTailDefault unless
+// asked otherwise, never more than caddyLogTailMax, and at most
+// execMaxOutput bytes of them.
+const (
+	caddyLogTailDefault = 100
+	caddyLogTailMax     = 1000
+)
+
+// ValidateCaddyLogMode accepts the request logging modes; "off" is accepted
+// as an alias of CaddyLogOff.
+func ValidateCaddyLogMode(mode string) (string, error) {
+	switch mode {
+	case CaddyLogOff, "off":
+		return CaddyLogOff, nil
+	case CaddyLogFile, CaddyLogStdout:
+		return mode, nil
+	}
+	return "", fmt.Errorf("output must be %q, %q or %q", CaddyLogFile, CaddyLogStdout, "off")
+}
+
+// caddyLogPath is where a site's request log lives inside the Caddy
+// container when it logs to a file.
+func caddyLogPath(cfg Config, site string) string {
+	return cfg.CaddyLogDir + "/" + site + ".log"
+}
+
+// renderCaddyLog returns the `log` directive for a site's block, indented
+// to sit inside it, or "" when the site does not log requests.
+func renderCaddyLog(cfg Config, site, mode string) string {
+	var b strings.Builder
+	switch mode {
+	case CaddyLogFile:
+		fmt.Fprintf(&b, "    log {\n        output file %s {\n            roll_size %dMiB\n            roll_keep %d\n        }\n        format json\n    }\n",
+			caddyLogPath(cfg, site), caddyLogRollSizeMB, caddyLogRollKeep)
+	case CaddyLogStdout:
+		b.WriteString("    log {\n        output stdout\n        format json\n    }\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// CertDetails describes the certificate Caddy holds for one domain. Issued
+// is false, with the other fields empty, when Caddy has none yet.
+type CertDetails struct {
+	Domain          string     `json:"domain"`
+	Issued          bool       `json:"issued"`
+	Subject         string     `json:"subject,omitempty"`
+	SANs            []string   `json:"sans,omitempty"`
+	Issuer          string     `json:"issuer,omitempty"`
+	NotBefore       *time.Time `json:"not_before,omitempty"`
+	NotAfter        *time.Time `json:"not_after,omitempty"`
+	DaysUntilExpiry int        `json:"days_until_expiry,omitempty"`
+	ExpiringSoon    bool       `json:"expiring_soon"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// readCaddyCert reads domain's certificate from the Caddy container (the
+// file caddyHasCert tests for) and parses the leaf. A certificate within
+// warnDays of expiry, or already expired, is flagged ExpiringSoon.
+func readCaddyCert(ctx context.Context, docker *client.Client, cfg Config, domain string, warnDays int) CertDetails {
+	details := CertDetails{Domain: domain}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.DockerExecTimeout)
+	defer cancel()
+	result, err := runExec(ctx, docker, cfg.CaddyContainer, []string{"cat", caddyCertPath(domain)})
+	if err != nil {
+		details.Error = err.Error()
+		return details
+	}
+	if result.ExitCode != 0 {
+		return details // no certificate on disk yet
+	}
+
+	block, _ := pem.Decode([]byte(result.Stdout))
+	if block == nil || block.Type != "CERTIFICATE" {
+		details.Error = "certificate file holds no PEM certificate"
+		return details
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		details.Error = fmt.Sprintf("parse certificate: %v", err)
+		return details
+	}
+
+	notBefore, notAfter := cert.NotBefore.UTC(), cert.NotAfter.UTC()
+	details.Issued = true
+	details.Subject = cert.Subject.String()
+	details.SANs = cert.DNSNames
+	details.Issuer = cert.Issuer.String()
+	details.NotBefore = &notBefore
+	details.NotAfter = &notAfter
+	details.DaysUntilExpiry = int(time.Until(notAfter).Hours() / 24)
+	details.ExpiringSoon = time.Until(notAfter) < time.Duration(warnDays)*24*time.Hour
+	if cert.VerifyHostname(domain) != nil {
+		details.Error = "certificate does not cover " + domain
+	}
+	return details
+}
@@ -0,0 +1,26 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// BackupSchedule is a site's own backup interval and retention. Keep and
+// MaxAgeDays are 0 for no limit; IntervalHours 0 means no schedule.
+type BackupSchedule struct {
+	IntervalHours int `json:"interval_hours"`
+	Keep          int `json:"keep,omitempty"`
+	MaxAgeDays    int `json:"max_age_days,omitempty"`
+}
+
+// SetBackupSchedule sets site's backup schedule; a zero IntervalHours
+// removes it.
+func (c *Client) SetBackupSchedule(ctx context.Context, site string, sched BackupSchedule) (*BackupSchedule, error) {
+	var out struct {
+		Schedule BackupSchedule `json:"backup_schedule"`
+	}
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/backup-schedule"), sched, &out); err != nil {
+		return nil, err
+	}
+	return &out.Schedule, nil
+}
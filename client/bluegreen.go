@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// BlueGreen is a WordPress site's blue/green state: the resource set serving
+// it, a green set being prepared and the set kept for rollback.
+type BlueGreen struct {
+	Live          string     `json:"live"`
+	Green         string     `json:"green"`
+	PreviewDomain string     `json:"preview_domain"`
+	Retired       string     `json:"retired"`
+	RetireAfter   *time.Time `json:"retire_after"`
+}
+
+// CreateGreen queues a clone of site into a green set served at
+// PreviewDomain. force removes leftover resources under the green set's name.
+func (c *Client) CreateGreen(ctx context.Context, site string, force bool) (*JobAccepted, error) {
+	path := sitePath(site, "/green")
+	if force {
+		path += "?force=true"
+	}
+	return c.queue(ctx, http.MethodPost, path)
+}
+
+// DiscardGreen queues the removal of site's green set.
+func (c *Client) DiscardGreen(ctx context.Context, site string) (*JobAccepted, error) {
+	return c.queue(ctx, http.MethodDelete, sitePath(site, "/green"))
+}
+
+// CutoverGreen queues the switch of site to its green set.
+func (c *Client) CutoverGreen(ctx context.Context, site string) (*JobAccepted, error) {
+	return c.queue(ctx, http.MethodPost, sitePath(site, "/green/cutover"))
+}
+
+// RollbackGreen queues the switch of site back to the set its last cutover
+// replaced, while that set is still kept.
+func (c *Client) RollbackGreen(ctx context.Context, site string) (*JobAccepted, error) {
+	return c.queue(ctx, http.MethodPost, sitePath(site, "/green/rollback"))
+}
+
+// RetireBlue queues the removal of the set kept for rollback now rather
+// than at its retire time.
+func (c *Client) RetireBlue(ctx context.Context, site string) (*JobAccepted, error) {
+	return c.queue(ctx, http.MethodDelete, sitePath(site, "/green/retired"))
+}
+
+// queue sends a body-less request that answers with a queued job.
+func (c *Client) queue(ctx context.Context, method, path string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, method, path, nil, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
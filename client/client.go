@@ -0,0 +1,108 @@
+// Package client is a Go client for the control plane API.
+//
+//	c := client.New("https://api.cowsaidmoo.tech", os.Getenv("HOSTPLANE_API_KEY"))
+//	acc, err := c.Provision(ctx, client.ProvisionRequest{Site: "blog"})
+//	if err != nil {
+//		return err
+//	}
+//	job, err := c.WaitForJob(ctx, acc.JobID, 0)
+//
+// API errors are returned as *APIError and can be matched on their code with
+// errors.Is, e.g. errors.Is(err, client.CodeJobActive).
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the control plane API. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default HTTP client (30s timeout). Uploads and
+// synchronous backups can outlast that; give them a client without one and
+// bound calls with their context instead.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// New returns a client for the API at baseURL (scheme and host, without
+// the /api prefix) authenticating with apiKey.
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do sends a JSON request to /api+path and decodes a 2xx response into out
+// (which may be nil). in, when non-nil, is marshalled as the body.
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// send adds the API key, sends req and decodes the response.
+func (c *Client) send(req *http.Request, out any) error {
+	req.Header.Set("X-API-Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return parseError(resp, data)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// sitePath returns /sites/<site> followed by suffix, escaping the site.
+func sitePath(site, suffix string) string {
+	return "/sites/" + url.PathEscape(site) + suffix
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorCode is the API's machine-readable error code. The values mirror
+// the server's errors.go; codes are stable and never renamed.
+//
+// An ErrorCode is itself an error so that errors.Is(err, CodeSiteNotFound)
+// matches an *APIError carrying that code.
+type ErrorCode string
+
+func (c ErrorCode) Error() string { return string(c) }
+
+const (
+	CodeInvalidRequest  ErrorCode = "INVALID_REQUEST"
+	CodeInvalidSiteName ErrorCode = "INVALID_SITE_NAME"
+	CodeUnauthorized    ErrorCode = "UNAUTHORIZED"
+	CodeForbidden       ErrorCode = "FORBIDDEN"
+	CodeAdminRequired   ErrorCode = "ADMIN_REQUIRED"
+	CodeReadOnly        ErrorCode = "READ_ONLY"
+	CodeConfirmRequired ErrorCode = "CONFIRM_REQUIRED"
+	CodeUploadTooLarge  ErrorCode = "UPLOAD_TOO_LARGE"
+	CodeInvalidUpload   ErrorCode = "INVALID_UPLOAD"
+
+	CodeSiteNotFound        ErrorCode = "SITE_NOT_FOUND"
+	CodeSiteExists          ErrorCode = "SITE_EXISTS"
+	CodeSiteState           ErrorCode = "SITE_STATE"
+	CodeUnsupportedSiteType ErrorCode = "UNSUPPORTED_SITE_TYPE"
+	CodeUnsupportedRouting  ErrorCode = "UNSUPPORTED_ROUTING"
+	CodeCaddyManual         ErrorCode = "CADDY_MANUAL"
+	CodeCaddyLogOff         ErrorCode = "CADDY_LOG_OFF"
+	CodeResourceConflict    ErrorCode = "RESOURCE_CONFLICT"
+	CodeVolumeUnavailable   ErrorCode = "VOLUME_UNAVAILABLE"
+	CodeTemplateNotFound    ErrorCode = "TEMPLATE_NOT_FOUND"
+	CodeNoPreviousRelease   ErrorCode = "NO_PREVIOUS_RELEASE"
+	CodeWPNotInstalled      ErrorCode = "WP_NOT_INSTALLED"
+	CodeQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeGreenExists         ErrorCode = "GREEN_EXISTS"
+	CodeNoGreen             ErrorCode = "NO_GREEN"
+	CodeNoRetired           ErrorCode = "NO_RETIRED"
+	CodeNoCredentials       ErrorCode = "NO_CREDENTIALS"
+
+	CodeDomainInvalid     ErrorCode = "DOMAIN_INVALID"
+	CodeDomainTaken       ErrorCode = "DOMAIN_TAKEN"
+	CodeSubdomainReserved ErrorCode = "SUBDOMAIN_RESERVED"
+	CodeDNSMismatch       ErrorCode = "DNS_MISMATCH"
+	CodeNoCustomDomain    ErrorCode = "NO_CUSTOM_DOMAIN"
+
+	CodeJobNotFound       ErrorCode = "JOB_NOT_FOUND"
+	CodeJobActive         ErrorCode = "JOB_ACTIVE"
+	CodeJobNotRetryable   ErrorCode = "JOB_NOT_RETRYABLE"
+	CodeJobNotStuck       ErrorCode = "JOB_NOT_STUCK"
+	CodeJobNotCancellable ErrorCode = "JOB_NOT_CANCELLABLE"
+	CodeJobCancelled      ErrorCode = "JOB_CANCELLED"
+
+	CodeBackupNotConfigured ErrorCode = "BACKUP_NOT_CONFIGURED"
+	CodeFeatureDisabled     ErrorCode = "FEATURE_DISABLED"
+	CodeInfraFailed         ErrorCode = "INFRA_FAILED"
+	CodeHostDiskFull        ErrorCode = "HOST_DISK_FULL"
+	CodeAtCapacity          ErrorCode = "AT_CAPACITY"
+	CodePersistFailed       ErrorCode = "PERSIST_FAILED"
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+// APIError is a non-2xx response. Code is empty for responses that did not
+// carry the error envelope (e.g. a proxy's 502 page).
+type APIError struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+
+	// Fields holds the whole decoded body, including extra fields such as
+	// "conflicts" or "checks". nil if the body was not JSON.
+	Fields map[string]json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("api: %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+	}
+	return fmt.Sprintf("api: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Is reports whether target is the error's code.
+func (e *APIError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && e.Code != "" && code == e.Code
+}
+
+// parseError builds the *APIError for a non-2xx response.
+func parseError(resp *http.Response, data []byte) error {
+	e := &APIError{Status: resp.StatusCode}
+	var env struct {
+		Error string    `json:"error"`
+		Code  ErrorCode `json:"code"`
+	}
+	if json.Unmarshal(data, &env) == nil && json.Unmarshal(data, &e.Fields) == nil {
+		e.Code, e.Message = env.Code, env.Error
+		return e
+	}
+	e.Message = strings.TrimSpace(string(data))
+	if len(e.Message) > 200 {
+		e.Message = e.Message[:200] + "…"
+	}
+	return e
+}
+
+// JobFailedError is returned by WaitForJob when the job ends FAILED.
+type JobFailedError struct {
+	Job *Job
+}
+
+func (e *JobFailedError) Error() string {
+	msg := "no error recorded"
+	if e.Job.Error != nil {
+		msg = *e.Job.Error
+	}
+	return fmt.Sprintf("job %s (%s %s) failed: %s", e.Job.ID, e.Job.Type, e.Job.Site, msg)
+}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Job states.
+const (
+	JobPending    = "PENDING"
+	JobProcessing = "PROCESSING"
+	JobCompleted  = "COMPLETED"
+	JobFailed     = "FAILED"
+)
+
+// DefaultPollInterval is WaitForJob's interval when none is given.
+const DefaultPollInterval = 2 * time.Second
+
+// Job is GET /api/jobs/:id.
+type Job struct {
+	ID          string          `json:"job_id"`
+	RequestID   string          `json:"request_id"`
+	Type        string          `json:"type"`
+	Site        string          `json:"site"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Error       *string         `json:"error"`
+	ErrorCode   string          `json:"error_code"` // e.g. HOST_DISK_FULL; set once FAILED
+	Result      json.RawMessage `json:"result"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at"`
+	QueueWaitMs *int64          `json:"queue_wait_ms"`
+	DurationMs  *int64          `json:"duration_ms"`
+}
+
+// Done reports whether the job has finished, successfully or not. A FAILED
+// attempt that will be retried goes back to PENDING, so FAILED is final.
+func (j *Job) Done() bool {
+	return j.Status == JobCompleted || j.Status == JobFailed
+}
+
+// JobAccepted is the 202 body of every request that queues a job. Only
+// JobID, Site and Status are always set.
+type JobAccepted struct {
+	JobID  string `json:"job_id"`
+	Site   string `json:"site"`
+	Status string `json:"status"`
+	Type   string `json:"type,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	URL    string `json:"url,omitempty"`
+
+	CustomDomain         string `json:"custom_domain,omitempty"`
+	CustomDomainDNSReady *bool  `json:"custom_domain_dns_ready,omitempty"`
+	CurrentRelease       string `json:"current_release,omitempty"` // static deploys
+	OldDomain            string `json:"old_domain,omitempty"`      // subdomain changes
+	Green                string `json:"green,omitempty"`           // blue/green steps
+	PreviewDomain        string `json:"preview_domain,omitempty"`  // CreateGreen
+}
+
+// GetJob returns a job's current state.
+func (c *Client) GetJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RetryJob re-queues a FAILED job.
+func (c *Client) RetryJob(ctx context.Context, id string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/retry", nil, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// StuckJob is an entry of ListStuckJobs.
+type StuckJob struct {
+	Job
+	TimeoutMs int64 `json:"timeout_ms"`
+	Running   bool  `json:"running"` // the worker is still on it; RecoverJob refuses
+}
+
+// ListStuckJobs returns the jobs PROCESSING past their timeout. Admin key
+// only.
+func (c *Client) ListStuckJobs(ctx context.Context) ([]StuckJob, error) {
+	var out struct {
+		Jobs []StuckJob `json:"jobs"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/jobs/stuck", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Jobs, nil
+}
+
+// RecoverJob puts a stuck job back to PENDING. Admin key only.
+func (c *Client) RecoverJob(ctx context.Context, id string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/recover", nil, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// JobFailureClass is one cause in a JobFailures summary: the FAILED jobs
+// whose errors are the same once site names, domains, ids and addresses
+// are taken out.
+type JobFailureClass struct {
+	Error        string         `json:"error"` // normalized, e.g. "create container wp_<site>: ..."
+	ErrorCode    string         `json:"error_code,omitempty"`
+	Count        int            `json:"count"`
+	Types        map[string]int `json:"types"`
+	Sites        []string       `json:"sites"` // a few of the most recent
+	ExampleJobID string         `json:"example_job_id"`
+	ExampleError string         `json:"example_error"`
+	LastSeen     time.Time      `json:"last_seen"`
+}
+
+// JobFailures is the result of GET /api/jobs/failures.
+type JobFailures struct {
+	Hours   int               `json:"hours"`
+	Total   int               `json:"total"`
+	Classes []JobFailureClass `json:"classes"` // most frequent first
+}
+
+// JobFailures groups the jobs that failed in the last hours (the server's
+// default of 24 if zero) by cause; jobType, when non-empty, narrows it to
+// one type. Admin key only.
+func (c *Client) JobFailures(ctx context.Context, hours int, jobType string) (*JobFailures, error) {
+	q := url.Values{}
+	if hours > 0 {
+		q.Set("hours", strconv.Itoa(hours))
+	}
+	if jobType != "" {
+		q.Set("type", jobType)
+	}
+	p := "/jobs/failures"
+	if len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+	var res JobFailures
+	if err := c.do(ctx, http.MethodGet, p, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PurgeJobsRequest is the body of POST /api/admin/jobs/purge. Zero days
+// use the server's JOB_PURGE_COMPLETED_DAYS and JOB_PURGE_FAILED_DAYS.
+type PurgeJobsRequest struct {
+	Confirm       bool `json:"confirm"` // false = dry run
+	CompletedDays int  `json:"completed_days,omitempty"`
+	FailedDays    int  `json:"failed_days,omitempty"`
+}
+
+// PurgeJobsResult is the jobs PurgeJobs removed, or would on a dry run.
+type PurgeJobsResult struct {
+	DryRun        bool  `json:"dry_run"`
+	Completed     int64 `json:"completed"`
+	Failed        int64 `json:"failed"`
+	Removed       int64 `json:"removed"`
+	CompletedDays int   `json:"completed_days"`
+	FailedDays    int   `json:"failed_days"`
+}
+
+// PurgeJobs deletes old COMPLETED and FAILED job records. Admin key only.
+func (c *Client) PurgeJobs(ctx context.Context, req PurgeJobsRequest) (*PurgeJobsResult, error) {
+	var res PurgeJobsResult
+	if err := c.do(ctx, http.MethodPost, "/admin/jobs/purge", req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CancelJob cancels a job. A PENDING job comes back FAILED at once; a
+// running provision or clone comes back PROCESSING and is rolled back, so
+// WaitForJob on it ends in a *JobFailedError with code JOB_CANCELLED.
+func (c *Client) CancelJob(ctx context.Context, id string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/cancel", nil, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// DeleteJob removes a finished job record.
+func (c *Client) DeleteJob(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, nil)
+}
+
+// WaitForJob polls a job every interval (DefaultPollInterval if zero) until
+// it is done or ctx ends. A COMPLETED job is returned with a nil error; a
+// FAILED one with a *JobFailedError. Errors from individual polls end the
+// wait, except 5xx responses, which are retried on the next tick.
+func (c *Client) WaitForJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := c.GetJob(ctx, id)
+		switch {
+		case err == nil && job.Status == JobCompleted:
+			return job, nil
+		case err == nil && job.Status == JobFailed:
+			return job, &JobFailedError{Job: job}
+		case err != nil && !retryable(err):
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryable reports whether a poll error is worth trying again.
+func retryable(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Status >= 500
+}
+
+// WorkerJob is a job the worker is running.
+type WorkerJob struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Site       string    `json:"site"`
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	Deadline   time.Time `json:"deadline"` // when the job's timeout cancels it
+	ElapsedSec int64     `json:"elapsed_sec"`
+}
+
+// WorkerHeartbeat is the worker's liveness as of GET /api/worker.
+type WorkerHeartbeat struct {
+	Healthy         bool       `json:"healthy"`
+	Detail          string     `json:"detail,omitempty"`
+	LastPollAt      *time.Time `json:"last_poll_at"`
+	LastCompletedAt *time.Time `json:"last_completed_at"`
+	LastFailedAt    *time.Time `json:"last_failed_at,omitempty"`
+	BusySince       *time.Time `json:"busy_since,omitempty"`
+}
+
+// WorkerActivity is GET /api/admin/worker.
+type WorkerActivity struct {
+	Jobs            []WorkerJob     `json:"jobs"` // empty when idle
+	Worker          WorkerHeartbeat `json:"worker"`
+	StartedAt       time.Time       `json:"started_at"`
+	PollIntervalSec int             `json:"poll_interval_sec"`
+	PendingJobs     int             `json:"pending_jobs"`
+}
+
+// WorkerActivity reports the jobs the worker is running and its heartbeat.
+// Admin key only.
+func (c *Client) WorkerActivity(ctx context.Context) (*WorkerActivity, error) {
+	var out WorkerActivity
+	if err := c.do(ctx, http.MethodGet, "/admin/worker", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
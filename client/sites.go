@@ -0,0 +1,540 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ProvisionRequest is the body of POST /api/provision. Only Site is
+// required; see the API reference for each field.
+type ProvisionRequest struct {
+	Site           string          `json:"site"`
+	VolumeSize     string          `json:"volume_size,omitempty"`
+	Template       string          `json:"template,omitempty"`
+	Routing        string          `json:"routing,omitempty"` // "subdomain" (default) or "path"
+	NginxSnippet   string          `json:"nginx_snippet,omitempty"`
+	LogMaxSize     string          `json:"log_max_size,omitempty"`
+	LogMaxFile     string          `json:"log_max_file,omitempty"`
+	RestartPolicy  string          `json:"restart_policy,omitempty"`
+	CustomDomain   string          `json:"custom_domain,omitempty"`
+	BaseDomain     string          `json:"base_domain,omitempty"`
+	Force          bool            `json:"force,omitempty"`
+	NginxResources *NginxResources `json:"nginx_resources,omitempty"`
+	FastCGI        *FastCGI        `json:"fastcgi,omitempty"`
+	FPM            *FPMPool        `json:"fpm,omitempty"`
+	Hook           *Hook           `json:"hook,omitempty"`            // admin key only
+	ExistingVolume string          `json:"existing_volume,omitempty"` // admin key only
+	Network        string          `json:"network,omitempty"`         // admin key only; Docker network to pin the site to
+	Egress         string          `json:"egress,omitempty"`          // outbound policy, "open" or "internal"; "" = the server's EGRESS_POLICY
+	Locale         string          `json:"locale,omitempty"`
+	Plugins        []string        `json:"plugins,omitempty"` // installed best effort; see the job result
+	AdminEmail     string          `json:"admin_email,omitempty"`
+	WPConfigExtra  string          `json:"wp_config_extra,omitempty"` // admin key only; "none" = no extra config
+	ObjectCache    bool            `json:"object_cache,omitempty"`    // Redis object cache, per-site or shared per the server's REDIS_MODE
+}
+
+// NginxResources are per-site nginx sidecar limits; zero fields use the
+// server's defaults.
+type NginxResources struct {
+	MemoryMB  int `json:"memory_mb,omitempty"`
+	CPUMillis int `json:"cpu_millis,omitempty"`
+	Pids      int `json:"pids,omitempty"`
+}
+
+// FastCGI are a site's nginx → PHP-FPM timeouts and buffer size; zero
+// fields use the server's defaults.
+type FastCGI struct {
+	ReadTimeoutSec int `json:"read_timeout_sec,omitempty"`
+	SendTimeoutSec int `json:"send_timeout_sec,omitempty"`
+	BufferSizeKB   int `json:"buffer_size_kb,omitempty"`
+}
+
+// FPMPool is a site's PHP-FPM pool: Plan is "small", "medium" or "large",
+// and non-zero fields override it. In GET /api/sites/:site every field is
+// the effective value.
+type FPMPool struct {
+	Plan            string `json:"plan,omitempty"`
+	PM              string `json:"pm,omitempty"` // "static", "dynamic" or "ondemand"
+	MaxChildren     int    `json:"max_children,omitempty"`
+	StartServers    int    `json:"start_servers,omitempty"`
+	MinSpareServers int    `json:"min_spare_servers,omitempty"`
+	MaxSpareServers int    `json:"max_spare_servers,omitempty"`
+	MaxRequests     int    `json:"max_requests,omitempty"`
+}
+
+// Hook is a post-provision command run against the new site.
+type Hook struct {
+	Command  string `json:"command"`
+	Image    string `json:"image,omitempty"`
+	Blocking bool   `json:"blocking,omitempty"`
+}
+
+// StaticProvisionRequest is the form of POST /api/static/provision.
+type StaticProvisionRequest struct {
+	Site       string
+	BaseDomain string
+	Force      bool
+	Zip        io.Reader // the site's files, zipped
+
+	// AllowNoIndex accepts a zip without a root index.html; a placeholder
+	// page is served at / instead.
+	AllowNoIndex bool
+}
+
+// Site is GET /api/sites/:site.
+type Site struct {
+	Site           string          `json:"site"`
+	Domain         string          `json:"domain"`
+	Type           string          `json:"type"`
+	CustomDomain   string          `json:"custom_domain"`
+	WWW            string          `json:"www"`
+	BaseDomain     string          `json:"base_domain"`
+	PathPrefix     string          `json:"path_prefix"`
+	Status         string          `json:"status"`
+	CertStatus     string          `json:"cert_status"`
+	Warnings       []string        `json:"warnings"`
+	JobID          string          `json:"job_id"`
+	LastBackupAt   *time.Time      `json:"last_backup_at"`
+	BackupSchedule BackupSchedule  `json:"backup_schedule"`
+	VolumeDriver   string          `json:"volume_driver"`
+	VolumeSize     string          `json:"volume_size"`
+	Release        string          `json:"release"`
+	PrevRelease    string          `json:"prev_release"`
+	RestartPolicy  string          `json:"restart_policy"`
+	NginxResources json.RawMessage `json:"nginx_resources"`
+	FastCGI        FastCGI         `json:"fastcgi"`      // effective values, defaults filled in
+	FPM            FPMPool         `json:"fpm"`          // effective pool
+	CaddyLog       string          `json:"caddy_log"`    // "file", "stdout" or "" (off)
+	ErrorPage      string          `json:"error_page"`   // "default", "custom" or "" (off)
+	ObjectCache    string          `json:"object_cache"` // "per-site", "shared" or "" (none)
+	Network        string          `json:"network"`      // Docker network of the site's containers
+	Egress         string          `json:"egress"`       // "internal" or "" (open)
+	Admin          *AdminAccount   `json:"admin"`        // nil unless the control plane created or reset the admin account
+	ExternalVolume string          `json:"external_volume"`
+	BlueGreen      *BlueGreen      `json:"blue_green"`     // nil until the site's first green set
+	PendingDomain  *PendingDomain  `json:"pending_domain"` // nil unless waiting for a domain's DNS
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// SiteSummary is one entry of GET /api/sites. The list carries the stored
+// site record as is, without the live checks of GetSite.
+type SiteSummary struct {
+	Site         string
+	Domain       string
+	Type         string
+	Status       string
+	JobID        string
+	CustomDomain string
+	PathPrefix   string
+	BaseDomain   string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// PendingDomain is a custom domain waiting for its DNS to point at the
+// ingress (site status DOMAIN_PENDING through DOMAIN_ROUTING).
+type PendingDomain struct {
+	Domain    string     `json:"domain"`
+	WWW       string     `json:"www"`
+	WaitUntil *time.Time `json:"wait_until"`
+	LastError string     `json:"last_error"`
+}
+
+// DomainResult is the response of setting a custom domain.
+type DomainResult struct {
+	Site          string         `json:"site"`
+	DefaultDomain string         `json:"default_domain"`
+	CustomDomain  string         `json:"custom_domain"`
+	WWW           string         `json:"www"`
+	CertStatus    string         `json:"cert_status"`
+	Status        string         `json:"status"`
+	Message       string         `json:"message"`
+	Warnings      []string       `json:"warnings"`
+	PendingDomain *PendingDomain `json:"pending_domain"` // set when waiting for DNS
+}
+
+// DomainCheck is GET /api/domains/check.
+type DomainCheck struct {
+	Domain    string `json:"domain"`
+	Available bool   `json:"available"`
+	Checks    []struct {
+		Name    string    `json:"name"`
+		OK      bool      `json:"ok"`
+		Skipped bool      `json:"skipped"`
+		Error   string    `json:"error"`
+		Code    ErrorCode `json:"code"`
+	} `json:"checks"`
+}
+
+// Provision queues a WordPress provision.
+func (c *Client) Provision(ctx context.Context, req ProvisionRequest) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, "/provision", req, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// ProvisionStatic uploads a zip and queues a static site provision.
+func (c *Client) ProvisionStatic(ctx context.Context, req StaticProvisionRequest) (*JobAccepted, error) {
+	fields := map[string]string{"site": req.Site}
+	if req.BaseDomain != "" {
+		fields["base_domain"] = req.BaseDomain
+	}
+	if req.Force {
+		fields["force"] = "true"
+	}
+	if req.AllowNoIndex {
+		fields["allow_no_index"] = "true"
+	}
+	return c.upload(ctx, "/static/provision", fields, req.Zip)
+}
+
+// DeployStatic uploads a new release for a static site.
+func (c *Client) DeployStatic(ctx context.Context, site string, zip io.Reader) (*JobAccepted, error) {
+	return c.upload(ctx, sitePath(site, "/deploy"), nil, zip)
+}
+
+// DeployStaticNoIndex is DeployStatic for a release without a root
+// index.html; a placeholder page is served at / instead.
+func (c *Client) DeployStaticNoIndex(ctx context.Context, site string, zip io.Reader) (*JobAccepted, error) {
+	return c.upload(ctx, sitePath(site, "/deploy"), map[string]string{"allow_no_index": "true"}, zip)
+}
+
+// Destroy queues the removal of a site's containers, volume and database.
+func (c *Client) Destroy(ctx context.Context, site string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, "/destroy", map[string]string{"site": site}, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// DestroyConfirmed is Destroy for servers running with
+// REQUIRE_DESTROY_CONFIRM: confirm must repeat the site name.
+func (c *Client) DestroyConfirmed(ctx context.Context, site, confirm string) (*JobAccepted, error) {
+	var acc JobAccepted
+	in := map[string]string{"site": site, "confirm": confirm}
+	if err := c.do(ctx, http.MethodPost, "/destroy", in, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// ListSites returns every site record, destroyed ones included.
+func (c *Client) ListSites(ctx context.Context) ([]SiteSummary, error) {
+	var resp struct {
+		Sites []SiteSummary `json:"sites"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/sites", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Sites, nil
+}
+
+// GetSite returns a site with its live certificate and routing checks.
+func (c *Client) GetSite(ctx context.Context, site string) (*Site, error) {
+	var s Site
+	if err := c.do(ctx, http.MethodGet, sitePath(site, ""), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// AdminAccount is the WordPress admin account the control plane created or
+// reset for a site. Its password is only returned by RevealAdminCredentials.
+type AdminAccount struct {
+	User       string `json:"user"`
+	Revealable bool   `json:"revealable"` // the password has not been revealed yet
+}
+
+// AdminCredentials is the response of RevealAdminCredentials.
+type AdminCredentials struct {
+	Site          string `json:"site"`
+	AdminUser     string `json:"admin_user"`
+	AdminPassword string `json:"admin_password"`
+}
+
+// RevealAdminCredentials returns the site's generated WordPress admin
+// password. It is returned once; a second call fails with
+// CodeNoCredentials.
+func (c *Client) RevealAdminCredentials(ctx context.Context, site string) (*AdminCredentials, error) {
+	var creds AdminCredentials
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/admin-credentials/reveal"), nil, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// DeleteSite hard-deletes the record of a DESTROYED site.
+func (c *Client) DeleteSite(ctx context.Context, site string) error {
+	return c.do(ctx, http.MethodDelete, sitePath(site, ""), nil, nil)
+}
+
+// SetCustomDomain points domain at site. www is "", "redirect" or "serve".
+func (c *Client) SetCustomDomain(ctx context.Context, site, domain, www string) (*DomainResult, error) {
+	body := map[string]string{"domain": domain}
+	if www != "" {
+		body["www"] = www
+	}
+	var res DomainResult
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/domain"), body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetCustomDomainWhenReady is SetCustomDomain, except that a domain whose
+// DNS does not point at the ingress yet is accepted and attached by the
+// control plane once it does; the result's Status is then DOMAIN_PENDING.
+func (c *Client) SetCustomDomainWhenReady(ctx context.Context, site, domain, www string) (*DomainResult, error) {
+	body := map[string]any{"domain": domain, "wait_for_dns": true}
+	if www != "" {
+		body["www"] = www
+	}
+	var res DomainResult
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/domain"), body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// RemoveCustomDomain returns site to its default domain only, or cancels
+// waiting for a pending domain's DNS.
+func (c *Client) RemoveCustomDomain(ctx context.Context, site string) error {
+	return c.do(ctx, http.MethodDelete, sitePath(site, "/domain"), nil, nil)
+}
+
+// CheckDomain reports whether domain could be set as a custom domain now.
+// site, when non-empty, ignores that site's own claim on the domain.
+func (c *Client) CheckDomain(ctx context.Context, domain, site string) (*DomainCheck, error) {
+	q := url.Values{"domain": {domain}}
+	if site != "" {
+		q.Set("site", site)
+	}
+	var res DomainCheck
+	if err := c.do(ctx, http.MethodGet, "/domains/check?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// DomainEntry is one hostname in ListDomains. Kind is "default", "custom",
+// "www" or "green_preview".
+type DomainEntry struct {
+	Domain     string `json:"domain"`
+	Kind       string `json:"kind"`
+	Site       string `json:"site"`
+	Status     string `json:"status"`
+	PathPrefix string `json:"path_prefix"`
+}
+
+// ListDomains returns every hostname the platform routes, with the owning
+// site, sorted by hostname. Destroyed sites' hostnames are only included
+// with includeDestroyed.
+func (c *Client) ListDomains(ctx context.Context, includeDestroyed bool) ([]DomainEntry, error) {
+	path := "/domains"
+	if includeDestroyed {
+		path += "?include_destroyed=true"
+	}
+	var res struct {
+		Domains []DomainEntry `json:"domains"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Domains, nil
+}
+
+// DomainMove is the answer to MoveDomain.
+type DomainMove struct {
+	Domain   string `json:"domain"`
+	FromSite string `json:"from_site"`
+	ToSite   string `json:"to_site"`
+	WWW      string `json:"www"`
+	Status   string `json:"status"`
+}
+
+// MoveDomain moves fromSite's custom domain to toSite without a gap in
+// routing.
+func (c *Client) MoveDomain(ctx context.Context, domain, fromSite, toSite string) (*DomainMove, error) {
+	in := map[string]string{"domain": domain, "from_site": fromSite, "to_site": toSite}
+	var res DomainMove
+	if err := c.do(ctx, http.MethodPost, "/domains/move", in, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetNginxResources queues a change of site's nginx sidecar limits. The
+// container is updated in place or replaced; zero fields use the server's
+// defaults.
+func (c *Client) SetNginxResources(ctx context.Context, site string, r NginxResources) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPut, sitePath(site, "/nginx-resources"), r, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// VerifyDNS queues a check of the tunnel DNS route of each of site's
+// hostnames; missing routes are re-added. The job's Result has the status
+// of each hostname.
+func (c *Client) VerifyDNS(ctx context.Context, site string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/dns/verify"), nil, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// SetFastCGI sets site's FastCGI timeouts and buffer size and returns the
+// effective values. A zero FastCGI resets the site to the defaults.
+func (c *Client) SetFastCGI(ctx context.Context, site string, f FastCGI) (*FastCGI, error) {
+	var out struct {
+		FastCGI FastCGI `json:"fastcgi"`
+	}
+	if err := c.do(ctx, http.MethodPut, sitePath(site, "/fastcgi"), f, &out); err != nil {
+		return nil, err
+	}
+	return &out.FastCGI, nil
+}
+
+// SetCaddyLog turns Caddy request logging for site on ("file" or "stdout")
+// or off ("off").
+func (c *Client) SetCaddyLog(ctx context.Context, site, output string) error {
+	return c.do(ctx, http.MethodPut, sitePath(site, "/caddy-log"), map[string]string{"output": output}, nil)
+}
+
+// SetErrorPage sets the page Caddy serves when site fails with a 5xx:
+// "default" (the platform's), "custom" with html, or "off".
+func (c *Client) SetErrorPage(ctx context.Context, site, page, html string) error {
+	body := map[string]string{"page": page}
+	if html != "" {
+		body["html"] = html
+	}
+	return c.do(ctx, http.MethodPut, sitePath(site, "/error-page"), body, nil)
+}
+
+// CaddyLog is the tail of a site's request log.
+type CaddyLog struct {
+	Site    string            `json:"site"`
+	Path    string            `json:"path"`
+	Entries []json.RawMessage `json:"entries"` // one Caddy JSON access log entry each, oldest first
+}
+
+// TailCaddyLog returns the newest lines entries of site's request log (0 =
+// the server's default). The site must log to a file.
+func (c *Client) TailCaddyLog(ctx context.Context, site string, lines int) (*CaddyLog, error) {
+	p := sitePath(site, "/caddy-log")
+	if lines > 0 {
+		p += "?lines=" + strconv.Itoa(lines)
+	}
+	var res CaddyLog
+	if err := c.do(ctx, http.MethodGet, p, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ChangeSubdomain queues a move of site's default domain to
+// <subdomain>.<its base domain>.
+func (c *Client) ChangeSubdomain(ctx context.Context, site, subdomain string) (*JobAccepted, error) {
+	var acc JobAccepted
+	if err := c.do(ctx, http.MethodPost, sitePath(site, "/subdomain"), map[string]string{"subdomain": subdomain}, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+// Health reports whether the API is up. deep also checks the tunnel and the
+// worker; a degraded control plane answers with an *APIError (503).
+func (c *Client) Health(ctx context.Context, deep bool) (map[string]any, error) {
+	path := "/health"
+	if deep {
+		path += "?deep=true"
+	}
+	var res map[string]any
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// VersionInfo is the control plane's build and enabled features.
+type VersionInfo struct {
+	Version  string   `json:"version"`
+	Go       string   `json:"go"`
+	Features []string `json:"features"`
+}
+
+// Version returns the control plane's build and the optional features
+// (FEATURES) it has enabled; routes for the others answer FEATURE_DISABLED.
+func (c *Client) Version(ctx context.Context) (*VersionInfo, error) {
+	var v VersionInfo
+	if err := c.do(ctx, http.MethodGet, "/version", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ConfigSetting is one environment variable the control plane resolved at
+// startup. Source is "env", "default" or "default (env value invalid)".
+type ConfigSetting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// EffectiveConfig returns the control plane's resolved configuration,
+// secrets redacted. It needs the admin key.
+func (c *Client) EffectiveConfig(ctx context.Context) ([]ConfigSetting, error) {
+	var resp struct {
+		Settings []ConfigSetting `json:"settings"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/config", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Settings, nil
+}
+
+// upload posts a multipart form with fields and the "zip" file. The zip is
+// buffered in memory so the request can carry a Content-Length.
+func (c *Client) upload(ctx context.Context, path string, fields map[string]string, zip io.Reader) (*JobAccepted, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return nil, err
+		}
+	}
+	part, err := w.CreateFormFile("zip", "site.zip")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, zip); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api"+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var acc JobAccepted
+	if err := c.send(req, &acc); err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
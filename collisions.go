@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// siteContainerNames lists every container name any site type may create for
+// a slug: the WordPress PHP/nginx pair and the static provisioner's temporary
+// upload/cleanup containers.
+func siteContainerNames(site string) []string {
+	return []string{
+		PHPContainerName(site),
+		NginxContainerName(site),
+		TmpUploadContainer(site),
+		"tmp_rmstatic_" + site,
+	}
+}
+
+// siteVolumeNames lists every per-site volume name for a slug.
+func siteVolumeNames(site string) []string {
+	return []string{VolumeName(site), NginxConfVolumeName(site)}
+}
+
+// FindResourceCollisions returns the containers and volumes on app-01 that
+// already use a name belonging to site, across both WordPress and static
+// naming schemes. Leftovers from an incompletely destroyed site of either
+// type show up here. An empty result means the slug is clean.
+func FindResourceCollisions(ctx context.Context, docker *client.Client, site string) ([]string, error) {
+	var found []string
+	for _, name := range siteContainerNames(site) {
+		_, err := docker.ContainerInspect(ctx, name)
+		if err == nil {
+			found = append(found, "container:"+name)
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("inspect container %s: %w", name, err)
+		}
+	}
+	for _, name := range siteVolumeNames(site) {
+		_, err := docker.VolumeInspect(ctx, name)
+		if err == nil {
+			found = append(found, "volume:"+name)
+			continue
+		}
+		if !client.IsErrNotFound(err) {
+			return nil, fmt.Errorf("inspect volume %s: %w", name, err)
+		}
+	}
+	return found, nil
+}
+
+// CleanupResourceCollisions force-removes resources reported by
+// FindResourceCollisions. Used when the caller explicitly requests force.
+func CleanupResourceCollisions(ctx context.Context, docker *client.Client, resources []string) error {
+	for _, r := range resources {
+		kind, name, _ := strings.Cut(r, ":")
+		var err error
+		switch kind {
+		case "container":
+			err = docker.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true})
+		case "volume":
+			err = docker.VolumeRemove(ctx, name, true)
+		}
+		if err != nil && !client.IsErrNotFound(err) {
+			return fmt.Errorf("remove %s: %w", r, err)
+		}
+		log.Printf("[collisions] removed leftover %s", r)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForceProvisionRefusesLiveSite(t *testing.T) {
+	cfg := integrationConfig(t)
+	db := integrationDB(t, cfg)
+	// Quotas and host limits must not answer before the checks under test.
+	cfg.QuotaMaxSites, cfg.QuotaMaxProvisionsPerDay = 0, 0
+	cfg.TenantMaxSites, cfg.TenantMaxProvisionsPerDay = nil, nil
+	cfg.MaxSitesPerHost, cfg.MaxContainersPerHost = 0, 0
+
+	tests := []struct {
+		name       string
+		status     SiteStatus
+		fullAccess bool
+		want       int
+	}{
+		{"active", SiteActive, true, http.StatusConflict},
+		{"domain active", SiteDomainActive, true, http.StatusConflict},
+		{"domain pending", SiteDomainPending, true, http.StatusConflict},
+		{"paused", SitePaused, true, http.StatusConflict},
+		{"failed, tenant key", SiteFailed, false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			site := testSiteName()
+			insertTestSite(t, db, site, SiteDomain(site, cfg.BaseDomain))
+			if _, err := db.conn.Exec(`UPDATE sites SET status=? WHERE site=?`, string(tt.status), site); err != nil {
+				t.Fatal(err)
+			}
+			fake, docker := newFakeDocker(t)
+			fake.containers["php_"+site] = "running"
+			fake.containers["nginx_"+site] = "running"
+			fake.volumes["wp_"+site] = "local"
+			a := &API{db: db, docker: docker, cfg: cfg}
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/api/provision", func(c *gin.Context) {
+				c.Set(fullAccessKey, tt.fullAccess)
+				c.Next()
+			}, a.handleProvision)
+			body, _ := json.Marshal(map[string]any{"site": site, "force": true})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/provision", bytes.NewReader(body)))
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if fake.state("php_"+site) != "running" || fake.state("nginx_"+site) != "running" || fake.Removes != 0 {
+				t.Errorf("containers removed: php=%q nginx=%q removes=%d", fake.state("php_"+site), fake.state("nginx_"+site), fake.Removes)
+			}
+			if _, ok := fake.volumes["wp_"+site]; !ok {
+				t.Error("volume wp_" + site + " removed")
+			}
+		})
+	}
+}
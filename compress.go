@@ -0,0 +1,176 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Response compression. A response is held back until it reaches the
+// minimum size; past it, it is compressed with the best encoding the client
+// accepts. Responses that stay smaller, that stream (the first Flush sends
+// what is buffered as is), that are already compressed, or whose handler
+// set its own Content-Encoding go out unchanged.
+
+// incompressibleTypes are media types that are compressed already, or
+// streamed, and are never compressed again.
+var incompressibleTypes = map[string]bool{
+	"text/event-stream":        true,
+	"application/zip":          true,
+	"application/gzip":         true,
+	"application/x-gzip":       true,
+	"application/x-tar":        true,
+	"application/octet-stream": true,
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip; "" means neither is acceptable.
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok && strings.Trim(q, "0.") == "" {
+			continue // q=0: explicitly refused
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	switch {
+	case accepted["gzip"] || accepted["*"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressMiddleware compresses responses of at least minBytes for clients
+// that accept gzip or deflate. Register it after the logger, so the access
+// log still sees every request, and ahead of the routes.
+func compressMiddleware(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		enc := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		if enc == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: enc, minBytes: minBytes}
+		c.Writer = w
+		defer w.finish()
+		c.Next()
+	}
+}
+
+// compressWriter buffers a response until it can tell whether to compress
+// it, then either compresses or passes it through.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minBytes int
+
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when passing through
+}
+
+// Unwrap lets http.ResponseController (longLived) reach the connection.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if !w.compressible() {
+			if err := w.passThrough(); err != nil {
+				return 0, err
+			}
+		} else {
+			w.buf = append(w.buf, p...)
+			if len(w.buf) < w.minBytes {
+				return len(p), nil
+			}
+			if err := w.startCompressing(); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been buffered uncompressed if nothing was decided
+// yet: a handler that flushes is streaming, and must not wait on minBytes.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.passThrough()
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// compressible reports whether the response, as its headers stand, may be
+// compressed.
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if status := w.Status(); status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return !incompressibleTypes[mediaType] && !strings.HasPrefix(mediaType, "image/")
+}
+
+// passThrough sends the buffered bytes as they are; later writes follow.
+func (w *compressWriter) passThrough() error {
+	w.decided = true
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// startCompressing sets the encoding headers and writes the buffered bytes
+// through the encoder.
+func (w *compressWriter) startCompressing() error {
+	w.decided = true
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.enc = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.enc = zlib.NewWriter(w.ResponseWriter)
+	}
+	_, err := w.enc.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// finish ends the response: a response still under minBytes goes out
+// uncompressed, and a compressed one gets its trailer.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.passThrough()
+	}
+	if w.enc != nil {
+		w.enc.Close()
+	}
+}
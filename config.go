@@ -1,43 +1,251 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"net"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type Config struct {
 	// API
 	APIPort string
 	APIKey  string
+	// AdminAPIKey is an optional full-access key. It authenticates like APIKey
+	// and additionally unlocks destructive operations (e.g. forced WP-CLI).
+	AdminAPIKey string
+	// TenantAPIKeys maps tenant name → API key for multi-tenant setups
+	// (TENANT_API_KEYS="acme=k1,beta=k2"). Sites provisioned with a tenant key
+	// are attributed to that tenant for quota purposes; APIKey is "default".
+	// "admin" and "default" are reserved and refused at startup.
+	TenantAPIKeys map[string]string
+
+	// API network access — defense in depth in front of the API keys. When
+	// APIAllowCIDRs is empty (the default) every source may reach the API.
+	// APIClientIPHeader (e.g. "X-Forwarded-For" or "CF-Connecting-IP") is
+	// only believed for connections from APITrustedProxies.
+	APIAllowCIDRs     []*net.IPNet
+	APITrustedProxies []*net.IPNet
+	APIClientIPHeader string
+
+	// Quotas — per tenant, 0 = unlimited. The TENANT_* maps override the
+	// defaults for individual tenants, e.g. TENANT_MAX_SITES="acme=50".
+	QuotaMaxSites             int
+	QuotaMaxProvisionsPerDay  int
+	TenantMaxSites            map[string]string
+	TenantMaxProvisionsPerDay map[string]string
+
+	// Host capacity — for the platform as a whole, 0 = unlimited. Checked
+	// before a provision is queued (see enforceHostCapacity).
+	MaxSitesPerHost      int // sites holding resources on the app server
+	MaxContainersPerHost int // containers of any kind on the Docker host
 
 	// Databases
 	ControlDSN   string // controlplane DB (jobs, sites)
 	WordPressDSN string // root-level DSN to create wp_ databases
+	wpDBAddr     string // host:port parsed from WordPressDSN — see DBHost
 
 	// Docker
 	DockerHost    string
 	DockerCertDir string // path to TLS certs for app-01
 
+	// Volumes — per-site wp_<site> volumes. VolumeDriver defaults to "local";
+	// set it to a quota-enforcing plugin for stronger tenant isolation.
+	VolumeDriver      string
+	VolumeDriverOpts  map[string]string // e.g. VOLUME_DRIVER_OPTS="type=xfs,o=prjquota"
+	VolumeSizeOpt     string            // driver opt key that receives the per-site size hint (e.g. "size")
+	VolumeDefaultSize string            // size hint used when the provision request omits one
+
+	// Container logs — Docker log driver and options applied to every site
+	// container. With json-file or local, max-size/max-file default to
+	// 10m × 3 so a chatty site cannot fill app-01's disk; provision requests
+	// may override both per site.
+	LogDriver string
+	LogOpts   map[string]string
+
+	// nginx sidecar limits, unless a site overrides them at provision time.
+	NginxMemoryMB  int
+	NginxCPUMillis int // 1000 = one CPU
+	NginxPidsLimit int
+
 	// Caddy
 	CaddyConfDir      string // path to per-site snippet dir inside Caddy container
 	CaddyContainer    string // Docker container name for Caddy
+	CaddyLogDir       string // per-site request logs inside the Caddy container
 	CaddyStaticVolume string // shared Docker volume name mounted at /srv/sites in Caddy
 
+	// Edge nginx that routed static sites before Caddy did (see
+	// legacy_nginx.go); "" when there is none to clean up.
+	EdgeNginxContainer string
+	EdgeNginxConfDir   string
+
+	// CertExpiryWarnDays flags certificates expiring within this many days
+	// in GET /api/sites/:site/cert. Caddy renews 30 days out, so a cert
+	// this close to expiry means renewal is failing.
+	CertExpiryWarnDays int
+
+	// ProvisionPlaceholder serves a "being set up" page on a WordPress
+	// site's domain while it provisions (see provisioning_page.go).
+	ProvisionPlaceholder bool
+
+	// ErrorPageHTML is the platform error page sites with the default error
+	// page serve (see error_page.go).
+	ErrorPageHTML string
+
 	// Domain
-	BaseDomain string
+	BaseDomain  string   // default base domain for <site>.<BaseDomain>
+	BaseDomains []string // every base domain a site may be provisioned under; always includes BaseDomain
+
+	// ReservedSubdomains are labels under BaseDomain that no site slug may
+	// take, since <site>.<BaseDomain> would shadow them (mail, api, ...).
+	ReservedSubdomains []string
+
+	// WordPress
+	WPCLIBinary string // WP-CLI executable inside the PHP container image
+
+	// Post-provision hook run after every WordPress provision that does not
+	// bring its own (see hook.go). Empty PostProvisionHook disables it.
+	PostProvisionHook         string        // shell command, run with sh -c
+	PostProvisionHookImage    string        // run in a one-shot container of this image ("" = exec in the PHP container)
+	PostProvisionHookBlocking bool          // a failing hook fails and rolls back the provision
+	PostProvisionHookTimeout  time.Duration // limit for a single hook run
 
 	// Infrastructure
-	AppServerIP           string // IP of the app server (containers + caddy)
-	PublicIP              string // Public VPS IP — custom domain A records must point here
-	DockerNetwork         string // Docker network for site containers
-	CloudflaredConfigPath string // path to cloudflared config.yml
-	TunnelName            string // Cloudflare tunnel name
-	ServiceTarget         string // upstream service URL for tunnel ingress
+	AppServerIP           string   // IP of the app server (containers + caddy)
+	PublicIP              string   // Public VPS IP — custom domain A records must point here
+	IngressIPs            []string // all public ingress addresses (v4 and v6); custom domains must resolve to one of them
+	DockerNetwork         string   // Docker network for site containers
+	NetworkIsolation      string   // NetworkShared, NetworkTenant or NetworkSite (see network.go)
+	CloudflaredConfigPath string   // path to cloudflared config.yml
+	TunnelName            string   // Cloudflare tunnel name
+	ServiceTarget         string   // upstream service URL for tunnel ingress
+	TunnelSelfTestHost    string   // known hostname used to verify tunnel → Caddy routing ("" = skip)
+	TunnelRouteWorkers    int      // max concurrent `cloudflared tunnel route dns` calls in AddRoutes
+
+	// Outbound network policy (see egress.go). EgressPolicy is the policy
+	// of sites that do not pick one; EgressAllowCIDRs are reachable under
+	// EgressInternal besides the database server.
+	EgressPolicy     string
+	EgressAllowCIDRs []*net.IPNet
+	EgressImage      string // runs iptables on the Docker host
+
+	// ReadOnlyMode starts the control plane in maintenance (read-only) mode;
+	// it can be toggled at runtime with PUT /api/maintenance.
+	ReadOnlyMode   bool
+	ReadOnlyReason string
+
+	// DNSResolvers are the servers (host:port) custom-domain DNS checks
+	// query, in order; empty uses the system resolver. DNSLookupTimeout
+	// bounds each lookup so validation cannot hang.
+	DNSResolvers     []string
+	DNSLookupTimeout time.Duration
+
+	// APILongRequestTimeout bounds the long-lived API routes (event streams,
+	// zip uploads, synchronous backup/restore) in place of the 10s server
+	// timeouts. 0 means no limit.
+	APILongRequestTimeout time.Duration
+
+	// APIUploadTimeout bounds the zip upload routes instead, from the first
+	// byte of the request to the end of the response, so a slow client can
+	// send a large zip without holding a connection for the full
+	// APILongRequestTimeout. 0 means no limit.
+	APIUploadTimeout time.Duration
+
+	// APICompression compresses API responses of at least
+	// APICompressMinBytes for clients that accept gzip or deflate (see
+	// compress.go).
+	APICompression      bool
+	APICompressMinBytes int
+
+	// StaticUploadConcurrency caps how many static upload temp containers
+	// run at once across all jobs and requests, so parallel uploads cannot
+	// swamp app-01.
+	StaticUploadConcurrency int
+
+	// MaxUploadMB caps a static site zip upload (the whole multipart body).
+	MaxUploadMB int
+
+	// Events — lifecycle notifications to an external bus (see events.go)
+	EventsBackend    string // "", "none", "webhook", "nats" or "redis"
+	EventsWebhookURL string // POST target for the webhook backend
+	EventsURL        string // nats://[user:pass@]host:4222 or redis://[:pass@]host:6379
+	EventsTopic      string // NATS subject or Redis stream name
+
+	// Docker operation timeouts. Raise these on slow or heavily loaded hosts
+	// where legitimate operations would otherwise time out and be retried.
+	DockerCreateTimeout time.Duration // creating and starting containers, creating volumes
+	DockerRemoveTimeout time.Duration // stopping and removing containers and volumes
+	DockerExecTimeout   time.Duration // execs and file copies into the Caddy container
+	DockerReloadTimeout time.Duration // writing an nginx server block, testing it and reloading
+
+	// Reloads — Caddy and nginx reloads that fail for reasons other than an
+	// invalid config are retried up to ReloadAttempts times in total.
+	ReloadAttempts   int
+	ReloadRetryDelay time.Duration
 
 	// Worker
 	WorkerPollInterval int // seconds
-	StuckJobTimeout    int // minutes
+	StuckJobTimeout    int // minutes — fallback for job types without an entry in JobTimeouts
+
+	// WPConfigExtra is the PHP injected into every WordPress site's
+	// wp-config.php as WORDPRESS_CONFIG_EXTRA, unless the site was
+	// provisioned with its own (see siteConfigExtra). "none" injects nothing.
+	WPConfigExtra string
+
+	// BlueGreenKeep is how long the resource set a blue/green cutover or
+	// rollback replaced keeps running for a rollback before the worker
+	// retires it (see bluegreen.go).
+	BlueGreenKeep time.Duration
+
+	// A custom domain set with wait_for_dns before its DNS points at the
+	// ingress is re-checked every DomainDNSPollInterval until it does, or
+	// until DomainDNSWaitTimeout has passed (see domain_wait.go).
+	DomainDNSPollInterval time.Duration
+	DomainDNSWaitTimeout  time.Duration
+
+	// POST /api/admin/jobs/purge deletes COMPLETED jobs older than
+	// JobPurgeCompletedAge and FAILED jobs older than JobPurgeFailedAge,
+	// unless the request asks for other ages; neither may go below
+	// jobPurgeFloor.
+	JobPurgeCompletedAge time.Duration
+	JobPurgeFailedAge    time.Duration
+
+	// FastCGI is the default nginx → PHP-FPM tuning of every WordPress
+	// server block; sites override it per field (see FastCGISettings).
+	FastCGI FastCGISettings
+
+	// FPMPlan is the PHP-FPM pool plan (one of fpmPlans) of sites that do
+	// not pick one; "" keeps the image's defaults (see php_fpm.go).
+	FPMPlan string
+
+	// Redis object cache for sites that opt in (see redis.go). RedisMode is
+	// ObjectCachePerSite (a redis_<site> container each) or ObjectCacheShared
+	// (one RedisSharedContainer, created on first use). Memory caps are
+	// Redis' maxmemory.
+	RedisMode            string
+	RedisImage           string
+	RedisSharedContainer string
+	RedisMemoryMB        int
+	RedisSharedMemoryMB  int
+
+	// StartupSelfTest provisions and destroys a throwaway static site at boot
+	// (see startup_check.go) and refuses to start if any step fails. Off by
+	// default: it writes to the real Caddy and static volume.
+	StartupSelfTest        bool
+	StartupSelfTestTimeout time.Duration
+
+	// JobClaimPolicy is the order the worker claims pending jobs in:
+	// ClaimFIFO (oldest first) or ClaimFair (see DB.ClaimNextJob).
+	JobClaimPolicy string
+
+	// JobTimeouts is the per-type limit (minutes) for a single job attempt.
+	// A PROCESSING job older than its limit is considered stuck, and the
+	// worker cancels an attempt that runs past it.
+	JobTimeouts map[JobType]int
 
 	// Backup (R2 / Cloudflare)
 	R2AccountID       string
@@ -49,66 +257,383 @@ type Config struct {
 	// backup. Defaults to true. Set REQUIRE_BACKUP_BEFORE_DESTROY=false to
 	// skip during development / debugging when R2 is not yet configured.
 	RequireBackupBeforeDestroy bool
+
+	// RequireDestroyConfirm makes POST /api/destroy refuse a request whose
+	// "confirm" field does not repeat the site name, guarding production
+	// sites against a mistyped slug. Off by default so existing automation
+	// keeps working; REQUIRE_DESTROY_CONFIRM=true turns it on.
+	RequireDestroyConfirm bool
+
+	// Features are the optional capabilities enabled in this deployment,
+	// from FEATURES (see features.go); unset enables all of them.
+	Features map[Feature]bool
+
+	// effective is every variable read above, as resolved, secrets
+	// redacted (see config_effective.go).
+	effective []ConfigSetting
 }
 
 func LoadConfig() Config {
-	return Config{
+	var err error
+	if secretSource, err = newSecretSource(); err != nil {
+		log.Fatalf("SECRETS_BACKEND is unusable: %v", err)
+	}
+
+	cfg := Config{
 		APIPort:                    getEnv("API_PORT", "8080"),
 		APIKey:                     mustEnv("API_KEY"),
+		AdminAPIKey:                getEnv("ADMIN_API_KEY", ""),
+		TenantAPIKeys:              getEnvMap("TENANT_API_KEYS"),
+		APIClientIPHeader:          getEnv("API_CLIENT_IP_HEADER", ""),
+		QuotaMaxSites:              getEnvInt("QUOTA_MAX_SITES", 0),
+		MaxSitesPerHost:            getEnvInt("MAX_SITES_PER_HOST", 0),
+		MaxContainersPerHost:       getEnvInt("MAX_CONTAINERS_PER_HOST", 0),
+		QuotaMaxProvisionsPerDay:   getEnvInt("QUOTA_MAX_PROVISIONS_PER_DAY", 0),
+		TenantMaxSites:             getEnvMap("TENANT_MAX_SITES"),
+		TenantMaxProvisionsPerDay:  getEnvMap("TENANT_MAX_PROVISIONS_PER_DAY"),
 		ControlDSN:                 getEnv("CONTROL_DSN", "control:control@123@tcp(10.10.0.20:3306)/controlplane"),
 		WordPressDSN:               getEnv("WP_DSN", "control:control@123@tcp(10.10.0.20:3306)/"),
 		DockerHost:                 getEnv("DOCKER_HOST", "tcp://10.10.0.10:2376"),
 		DockerCertDir:              getEnv("DOCKER_CERT_DIR", "/opt/control/certs"),
+		VolumeDriver:               getEnv("VOLUME_DRIVER", "local"),
+		VolumeDriverOpts:           getEnvMap("VOLUME_DRIVER_OPTS"),
+		VolumeSizeOpt:              getEnv("VOLUME_SIZE_OPT", ""),
+		VolumeDefaultSize:          getEnv("VOLUME_DEFAULT_SIZE", ""),
+		LogDriver:                  getEnv("LOG_DRIVER", "json-file"),
+		LogOpts:                    getEnvMap("LOG_OPTS"),
+		NginxMemoryMB:              getEnvInt("NGINX_MEMORY_MB", 128),
+		NginxCPUMillis:             getEnvInt("NGINX_CPU_MILLIS", 500),
+		NginxPidsLimit:             getEnvInt("NGINX_PIDS_LIMIT", 50),
 		CaddyConfDir:               getEnv("CADDY_CONF_DIR", "/etc/caddy/sites"),
 		CaddyContainer:             getEnv("CADDY_CONTAINER", "caddy"),
+		CaddyLogDir:                getEnv("CADDY_LOG_DIR", "/var/log/caddy/sites"),
 		CaddyStaticVolume:          getEnv("CADDY_STATIC_VOLUME", "caddy_static_sites"),
+		EdgeNginxContainer:         getEnv("EDGE_NGINX_CONTAINER", ""),
+		EdgeNginxConfDir:           getEnv("EDGE_NGINX_CONF_DIR", "/etc/nginx/conf.d"),
+		CertExpiryWarnDays:         getEnvInt("CERT_EXPIRY_WARN_DAYS", 14),
+		ProvisionPlaceholder:       getEnvBool("PROVISION_PLACEHOLDER", false),
+		ErrorPageHTML:              getEnv("ERROR_PAGE_HTML", defaultErrorPageHTML),
 		BaseDomain:                 getEnv("BASE_DOMAIN", "hosto.com"),
+		ReservedSubdomains:         getEnvList("RESERVED_SUBDOMAINS", defaultReservedSubdomains...),
+		WPCLIBinary:                getEnv("WP_CLI_BINARY", "wp"),
+		PostProvisionHook:          getEnv("POST_PROVISION_HOOK", ""),
+		PostProvisionHookImage:     getEnv("POST_PROVISION_HOOK_IMAGE", ""),
+		PostProvisionHookBlocking:  getEnvBool("POST_PROVISION_HOOK_BLOCKING", false),
+		PostProvisionHookTimeout:   time.Duration(getEnvInt("POST_PROVISION_HOOK_TIMEOUT_SEC", 300)) * time.Second,
 		AppServerIP:                getEnv("APP_SERVER_IP", "10.10.0.10"),
 		PublicIP:                   getEnv("PUBLIC_IP", "129.212.247.213"),
+		IngressIPs:                 getEnvList("INGRESS_IPS", getEnv("PUBLIC_IP", "129.212.247.213")),
+		ReadOnlyMode:               getEnvBool("READ_ONLY_MODE", false),
+		ReadOnlyReason:             getEnv("READ_ONLY_REASON", ""),
+		DNSLookupTimeout:           time.Duration(getEnvInt("DNS_LOOKUP_TIMEOUT_SEC", 5)) * time.Second,
 		DockerNetwork:              getEnv("DOCKER_NETWORK", "wp_backend"),
+		NetworkIsolation:           getEnv("NETWORK_ISOLATION", NetworkShared),
+		EgressPolicy:               getEnv("EGRESS_POLICY", EgressOpen),
+		EgressImage:                getEnv("EGRESS_IMAGE", "alpine:3.20"),
 		CloudflaredConfigPath:      getEnv("CLOUDFLARED_CONFIG", "/etc/cloudflared/config.yml"),
 		TunnelName:                 getEnv("TUNNEL_NAME", "hosto"),
 		ServiceTarget:              getEnv("TUNNEL_SERVICE_TARGET", "http://10.10.0.10:8080"),
+		TunnelSelfTestHost:         getEnv("TUNNEL_SELFTEST_HOST", ""),
+		TunnelRouteWorkers:         getEnvInt("TUNNEL_ROUTE_WORKERS", 4),
+		APILongRequestTimeout:      time.Duration(getEnvInt("API_LONG_REQUEST_TIMEOUT_SEC", 3600)) * time.Second,
+		APIUploadTimeout:           time.Duration(getEnvInt("API_UPLOAD_TIMEOUT_SEC", 900)) * time.Second,
+		StaticUploadConcurrency:    getEnvInt("STATIC_UPLOAD_CONCURRENCY", 2),
+		MaxUploadMB:                getEnvInt("MAX_UPLOAD_MB", 200),
+		APICompression:             getEnvBool("API_COMPRESSION", true),
+		APICompressMinBytes:        getEnvInt("API_COMPRESS_MIN_BYTES", 1024),
+		EventsBackend:              strings.ToLower(getEnv("EVENTS_BACKEND", "")),
+		EventsWebhookURL:           getEnv("EVENTS_WEBHOOK_URL", ""),
+		EventsURL:                  getEnv("EVENTS_URL", ""),
+		EventsTopic:                getEnv("EVENTS_TOPIC", "hostplane.events"),
+		DockerCreateTimeout:        time.Duration(getEnvInt("DOCKER_CREATE_TIMEOUT_SEC", 60)) * time.Second,
+		DockerRemoveTimeout:        time.Duration(getEnvInt("DOCKER_REMOVE_TIMEOUT_SEC", 30)) * time.Second,
+		DockerExecTimeout:          time.Duration(getEnvInt("DOCKER_EXEC_TIMEOUT_SEC", 10)) * time.Second,
+		DockerReloadTimeout:        time.Duration(getEnvInt("DOCKER_RELOAD_TIMEOUT_SEC", 15)) * time.Second,
+		ReloadAttempts:             getEnvInt("RELOAD_ATTEMPTS", 3),
+		ReloadRetryDelay:           time.Duration(getEnvInt("RELOAD_RETRY_DELAY_MS", 500)) * time.Millisecond,
 		WorkerPollInterval:         3,
 		StuckJobTimeout:            10,
+		JobClaimPolicy:             strings.ToLower(getEnv("JOB_CLAIM_POLICY", ClaimFIFO)),
+		WPConfigExtra:              getEnv("WP_CONFIG_EXTRA", defaultWPConfigExtra),
+		BlueGreenKeep:              time.Duration(getEnvInt("BLUE_GREEN_KEEP_MIN", 60)) * time.Minute,
+		DomainDNSPollInterval:      time.Duration(getEnvInt("DOMAIN_DNS_POLL_INTERVAL_SEC", 60)) * time.Second,
+		DomainDNSWaitTimeout:       time.Duration(getEnvInt("DOMAIN_DNS_WAIT_HOURS", 48)) * time.Hour,
+		JobPurgeCompletedAge:       time.Duration(getEnvInt("JOB_PURGE_COMPLETED_DAYS", 30)) * 24 * time.Hour,
+		JobPurgeFailedAge:          time.Duration(getEnvInt("JOB_PURGE_FAILED_DAYS", 90)) * 24 * time.Hour,
+		StartupSelfTest:            getEnvBool("STARTUP_SELFTEST", false),
+		StartupSelfTestTimeout:     time.Duration(getEnvInt("STARTUP_SELFTEST_TIMEOUT_SEC", 120)) * time.Second,
 		R2AccountID:                getEnv("R2_ACCOUNT_ID", ""),
 		R2AccessKeyID:              getEnv("R2_ACCESS_KEY_ID", ""),
 		R2SecretAccessKey:          getEnv("R2_SECRET_ACCESS_KEY", ""),
 		R2Bucket:                   getEnv("R2_BUCKET", "hostplane-backups"),
 		RequireBackupBeforeDestroy: getEnvBool("REQUIRE_BACKUP_BEFORE_DESTROY", true),
+		RequireDestroyConfirm:      getEnvBool("REQUIRE_DESTROY_CONFIRM", false),
+		JobTimeouts: map[JobType]int{
+			JobProvision:       getEnvInt("JOB_TIMEOUT_PROVISION", 10),
+			JobStaticProvision: getEnvInt("JOB_TIMEOUT_STATIC_PROVISION", 30),
+			JobDestroy:         getEnvInt("JOB_TIMEOUT_DESTROY", 30), // includes the pre-destroy backup
+			JobClone:           getEnvInt("JOB_TIMEOUT_CLONE", 30),
+			JobStaticDeploy:    getEnvInt("JOB_TIMEOUT_STATIC_DEPLOY", 30),
+			JobChangeSubdomain: getEnvInt("JOB_TIMEOUT_CHANGE_SUBDOMAIN", 5),
+			JobGreenCreate:     getEnvInt("JOB_TIMEOUT_GREEN_CREATE", 30),
+			JobGreenCutover:    getEnvInt("JOB_TIMEOUT_GREEN_CUTOVER", 10),
+			JobGreenRollback:   getEnvInt("JOB_TIMEOUT_GREEN_ROLLBACK", 10),
+			JobGreenDiscard:    getEnvInt("JOB_TIMEOUT_GREEN_DISCARD", 10),
+			JobBlueRetire:      getEnvInt("JOB_TIMEOUT_BLUE_RETIRE", 10),
+			JobBackup:          getEnvInt("JOB_TIMEOUT_BACKUP", 60),
+			JobResourcesUpdate: getEnvInt("JOB_TIMEOUT_RESOURCES_UPDATE", 5),
+			JobVerifyDNS:       getEnvInt("JOB_TIMEOUT_VERIFY_DNS", 5),
+		},
+	}
+
+	cfg.FastCGI = FastCGISettings{
+		ReadTimeoutSec: getEnvInt("FASTCGI_READ_TIMEOUT_SEC", 60),
+		SendTimeoutSec: getEnvInt("FASTCGI_SEND_TIMEOUT_SEC", 60),
+		BufferSizeKB:   getEnvInt("FASTCGI_BUFFER_SIZE_KB", 16),
+	}
+	cfg.FPMPlan = getEnv("PHP_FPM_PLAN", "")
+	cfg.RedisMode = getEnv("REDIS_MODE", ObjectCachePerSite)
+	cfg.RedisImage = getEnv("REDIS_IMAGE", "redis:7-alpine")
+	cfg.RedisSharedContainer = getEnv("REDIS_SHARED_CONTAINER", "redis")
+	cfg.RedisMemoryMB = getEnvInt("REDIS_MEMORY_MB", 64)
+	cfg.RedisSharedMemoryMB = getEnvInt("REDIS_SHARED_MEMORY_MB", 512)
+
+	if cfg.Features, err = parseFeatures(getEnvList("FEATURES")); err != nil {
+		log.Fatalf("FEATURES is invalid: %v", err)
+	}
+	if cfg.APIAllowCIDRs, err = parseCIDRList(getEnvList("API_ALLOW_CIDRS")); err != nil {
+		log.Fatalf("API_ALLOW_CIDRS is invalid: %v", err)
+	}
+	if cfg.APITrustedProxies, err = parseCIDRList(getEnvList("API_TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("API_TRUSTED_PROXIES is invalid: %v", err)
+	}
+	if cfg.EgressAllowCIDRs, err = parseCIDRList(getEnvList("EGRESS_ALLOW_CIDRS")); err != nil {
+		log.Fatalf("EGRESS_ALLOW_CIDRS is invalid: %v", err)
+	}
+	for _, n := range cfg.EgressAllowCIDRs {
+		if n.IP.To4() == nil {
+			log.Fatalf("EGRESS_ALLOW_CIDRS must be IPv4: site networks have no IPv6, not %s", n)
+		}
+	}
+	if cfg.EgressPolicy, err = ValidateEgressPolicy(cfg.EgressPolicy); err != nil {
+		log.Fatalf("EGRESS_POLICY is invalid: %v", err)
 	}
+	if cfg.DNSResolvers, err = parseDNSServers(getEnvList("DNS_RESOLVERS")); err != nil {
+		log.Fatalf("DNS_RESOLVERS is invalid: %v", err)
+	}
+	if cfg.MaxUploadMB <= 0 {
+		log.Fatalf("MAX_UPLOAD_MB must be positive, not %d", cfg.MaxUploadMB)
+	}
+	if cfg.APIUploadTimeout < 0 || cfg.APILongRequestTimeout < 0 {
+		log.Fatalf("API_UPLOAD_TIMEOUT_SEC and API_LONG_REQUEST_TIMEOUT_SEC must not be negative")
+	}
+	if f := cfg.FastCGI; f.ReadTimeoutSec <= 0 || f.SendTimeoutSec <= 0 || f.BufferSizeKB <= 0 {
+		log.Fatalf("FASTCGI_READ_TIMEOUT_SEC, FASTCGI_SEND_TIMEOUT_SEC and FASTCGI_BUFFER_SIZE_KB must be positive")
+	} else if err := f.validate(""); err != nil {
+		log.Fatalf("FASTCGI_* settings are invalid: %v", err)
+	}
+	if err := resolveFPMPool(cfg, FPMPool{}).validate(""); err != nil {
+		log.Fatalf("PHP_FPM_PLAN is invalid: %v", err)
+	}
+	if cfg.RedisMode != ObjectCachePerSite && cfg.RedisMode != ObjectCacheShared {
+		log.Fatalf("REDIS_MODE must be %q or %q, not %q", ObjectCachePerSite, ObjectCacheShared, cfg.RedisMode)
+	}
+	switch cfg.NetworkIsolation {
+	case NetworkShared, NetworkTenant, NetworkSite:
+	default:
+		log.Fatalf("NETWORK_ISOLATION must be %q, %q or %q, not %q", NetworkShared, NetworkTenant, NetworkSite, cfg.NetworkIsolation)
+	}
+	if cfg.RedisMemoryMB < 16 || cfg.RedisSharedMemoryMB < 16 {
+		log.Fatalf("REDIS_MEMORY_MB and REDIS_SHARED_MEMORY_MB must be at least 16")
+	}
+	if cfg.JobPurgeCompletedAge < jobPurgeFloor || cfg.JobPurgeFailedAge < jobPurgeFloor {
+		log.Fatalf("JOB_PURGE_COMPLETED_DAYS and JOB_PURGE_FAILED_DAYS must be at least %d", int(jobPurgeFloor.Hours()/24))
+	}
+	if cfg.JobClaimPolicy != ClaimFIFO && cfg.JobClaimPolicy != ClaimFair {
+		log.Fatalf("JOB_CLAIM_POLICY must be %q or %q, not %q", ClaimFIFO, ClaimFair, cfg.JobClaimPolicy)
+	}
+	if err := validateTenantAPIKeys(cfg.TenantAPIKeys); err != nil {
+		log.Fatalf("TENANT_API_KEYS is invalid: %v", err)
+	}
+
+	addr, err := parseDBAddr(cfg.WordPressDSN)
+	if err != nil {
+		log.Fatalf("WP_DSN is unusable: %v", err)
+	}
+	cfg.wpDBAddr = addr
+
+	if cfg.BaseDomain, err = NormalizeDomain(cfg.BaseDomain); err != nil {
+		log.Fatalf("BASE_DOMAIN is invalid: %v", err)
+	}
+	cfg.BaseDomains = []string{cfg.BaseDomain}
+	for _, d := range getEnvList("BASE_DOMAINS") {
+		if d, err = NormalizeDomain(d); err != nil {
+			log.Fatalf("BASE_DOMAINS is invalid: %v", err)
+		}
+		if !cfg.IsBaseDomain(d) {
+			cfg.BaseDomains = append(cfg.BaseDomains, d)
+		}
+	}
+	cfg.effective = effectiveConfig()
+	return cfg
 }
 
+// The getEnv helpers read each variable through lookupEnv, so KEY_FILE and
+// the secrets backend work for all of them (see secrets.go), and record what
+// it resolved to, and where from, for the effective configuration (see
+// config_effective.go).
+
 func getEnv(key, fallback string) string {
-	if v := os.Getenv(key); v != "" {
+	if v, source := lookupEnv(key); v != "" {
+		recordEnv(key, v, source)
 		return v
 	}
+	recordEnv(key, fallback, ConfigFromDefault)
 	return fallback
 }
 
 func mustEnv(key string) string {
-	v := os.Getenv(key)
+	v, source := lookupEnv(key)
 	if v == "" {
-		log.Fatalf("Required env var %s is not set", key)
+		log.Fatalf("Required env var %s (or %s_FILE) is not set", key, key)
 	}
+	recordEnv(key, v, source)
 	return v
 }
 
 func getEnvBool(key string, fallback bool) bool {
-	v := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	v, source := lookupEnv(key)
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		recordEnv(key, strconv.FormatBool(fallback), ConfigFromDefault)
+		return fallback
+	}
+	b := v == "true" || v == "1" || v == "yes"
+	recordEnv(key, strconv.FormatBool(b), source)
+	return b
+}
+
+func getEnvInt(key string, fallback int) int {
+	v, source := lookupEnv(key)
+	v = strings.TrimSpace(v)
 	if v == "" {
+		recordEnv(key, strconv.Itoa(fallback), ConfigFromDefault)
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("[config] %s=%q is not a positive integer, using %d", key, v, fallback)
+		recordEnv(key, strconv.Itoa(fallback), ConfigInvalid)
 		return fallback
 	}
-	return v == "true" || v == "1" || v == "yes"
+	recordEnv(key, strconv.Itoa(n), source)
+	return n
 }
 
+// JobTimeout returns the attempt time limit for a job type, falling back to
+// StuckJobTimeout for types without a specific entry.
+func (c Config) JobTimeout(t JobType) time.Duration {
+	minutes, ok := c.JobTimeouts[t]
+	if !ok {
+		minutes = c.StuckJobTimeout
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// getEnvList parses a comma-separated list, falling back to the given values
+// when the variable is unset or empty.
+func getEnvList(key string, fallback ...string) []string {
+	raw, source := lookupEnv(key)
+	var out []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		recordEnv(key, strings.Join(fallback, ","), ConfigFromDefault)
+		return fallback
+	}
+	recordEnv(key, strings.Join(out, ","), source)
+	return out
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs. Malformed
+// entries are skipped with a warning rather than aborting startup.
+func getEnvMap(key string) map[string]string {
+	raw, source := lookupEnv(key)
+	m := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			log.Printf("[config] %s: ignoring malformed entry %q", key, pair)
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if len(m) == 0 {
+		source = ConfigFromDefault
+	}
+	recordEnvMap(key, m, source)
+	return m
+}
+
+// IsBaseDomain reports whether d is one of the configured base domains.
+func (c Config) IsBaseDomain(d string) bool {
+	for _, b := range c.BaseDomains {
+		if d == b {
+			return true
+		}
+	}
+	return false
+}
+
+// DBHost returns the host:port of the WordPress MySQL server, as parsed from
+// WordPressDSN at startup. It is handed to site containers as
+// WORDPRESS_DB_HOST and used by backup/copy helpers.
 func (c Config) DBHost() string {
-	// Extract just host:port from the full DSN
-	// e.g. "control:pass@tcp(10.10.0.20:3306)/" → "10.10.0.20:3306"
-	start := strings.Index(c.WordPressDSN, "tcp(") + 4
-	end := strings.Index(c.WordPressDSN, ")/")
-	if start < 4 || end < 0 {
-		return "10.10.0.20:3306"
-	}
-	return c.WordPressDSN[start:end]
+	return c.wpDBAddr
+}
+
+// validateTenantAPIKeys rejects tenants with an empty name or key, and the
+// names authMiddleware assigns itself: a tenant called "admin" would get full
+// access and no quota, and one called "default" would share API_KEY's usage.
+func validateTenantAPIKeys(keys map[string]string) error {
+	for name, key := range keys {
+		switch {
+		case name == "":
+			return fmt.Errorf("tenant with an empty name")
+		case name == tenantAdmin || name == tenantDefault:
+			return fmt.Errorf("tenant name %q is reserved", name)
+		case key == "":
+			return fmt.Errorf("tenant %s has an empty key", name)
+		}
+	}
+	return nil
+}
+
+// parseDBAddr extracts host:port from a go-sql-driver DSN. Site containers
+// reach MySQL over the Docker network, so the DSN must use a tcp address that
+// is not loopback — a unix socket or 127.0.0.1 would point each container at
+// itself.
+func parseDBAddr(dsn string) (string, error) {
+	dc, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("parse DSN: %w", err)
+	}
+	if dc.Net != "tcp" && dc.Net != "tcp4" && dc.Net != "tcp6" {
+		return "", fmt.Errorf("DSN uses %s; site containers need a tcp(host:port) address", dc.Net)
+	}
+	host, _, err := net.SplitHostPort(dc.Addr)
+	if err != nil {
+		return "", fmt.Errorf("DSN address %q: %w", dc.Addr, err)
+	}
+	if host == "localhost" || net.ParseIP(host).IsLoopback() {
+		return "", fmt.Errorf("DSN address %s is loopback and unreachable from site containers", dc.Addr)
+	}
+	return dc.Addr, nil
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The effective configuration: every environment variable LoadConfig read,
+// the value it resolved to and whether that came from the environment, a
+// _FILE, the secrets backend or a default (see secrets.go). It is logged at startup and served to the admin key by
+// GET /api/config, so a wrong BASE_DOMAIN or DOCKER_NETWORK shows up there
+// rather than as a confusing failure later. Secrets are redacted.
+
+// Sources of a ConfigSetting.
+const (
+	ConfigFromEnv     = "env"
+	ConfigFromFile    = "file" // KEY_FILE; a secrets backend reports its own name
+	ConfigFromDefault = "default"
+	ConfigInvalid     = "default (env value invalid)"
+)
+
+// redactedValue replaces a secret in a ConfigSetting.
+const redactedValue = "[redacted]"
+
+// ConfigSetting is one resolved environment variable.
+type ConfigSetting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// resolvedEnv collects the settings the getEnv helpers resolve while
+// LoadConfig runs, keyed by variable.
+var resolvedEnv = map[string]ConfigSetting{}
+
+// recordEnv notes a resolved variable, redacting it if it is a secret.
+func recordEnv(key, value, source string) {
+	resolvedEnv[key] = ConfigSetting{Key: key, Value: redactEnv(key, value), Source: source}
+}
+
+// recordEnvMap notes a resolved KEY=k1=v1,k2=v2 variable. The keys of a
+// secret map (tenant names in TENANT_API_KEYS) stay readable.
+func recordEnvMap(key string, m map[string]string, source string) {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		if isSecretEnv(key) {
+			v = redactedValue
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	resolvedEnv[key] = ConfigSetting{Key: key, Value: strings.Join(pairs, ","), Source: source}
+}
+
+// isSecretEnv reports whether a variable holds a credential.
+func isSecretEnv(key string) bool {
+	for _, s := range []string{"KEY", "SECRET", "PASSWORD", "TOKEN"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactEnv hides the secret parts of a value: all of a credential, the
+// password of a DSN and the userinfo of a URL.
+func redactEnv(key, value string) string {
+	switch {
+	case value == "":
+		return ""
+	case isSecretEnv(key):
+		return redactedValue
+	case strings.HasSuffix(key, "_DSN"):
+		dc, err := mysql.ParseDSN(value)
+		if err != nil {
+			return redactedValue
+		}
+		if dc.Passwd != "" {
+			dc.Passwd = redactedValue
+		}
+		return dc.FormatDSN()
+	case strings.HasSuffix(key, "_URL"):
+		u, err := url.Parse(value)
+		if err != nil {
+			return redactedValue
+		}
+		if u.User != nil {
+			u.User = url.User("redacted")
+		}
+		return u.String()
+	}
+	return value
+}
+
+// effectiveConfig returns the recorded settings sorted by variable.
+func effectiveConfig() []ConfigSetting {
+	out := make([]ConfigSetting, 0, len(resolvedEnv))
+	for _, s := range resolvedEnv {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// logEffectiveConfig writes the effective configuration to the log, one
+// variable per line.
+func logEffectiveConfig(cfg Config) {
+	for _, s := range cfg.effective {
+		log.Printf("[config] %s=%q (%s)", s.Key, s.Value, s.Source)
+	}
+}
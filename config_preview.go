@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// nginxSiteConfPath is where writeNginxConfigWithDomains places the server
+// block inside each nginx sidecar.
+const nginxSiteConfPath = "/etc/nginx/conf.d/default.conf"
+
+// ConfigPreview pairs the config currently deployed in a container with the
+// config a pending change would write, plus a line diff between them.
+type ConfigPreview struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	Current   string `json:"current"`
+	Proposed  string `json:"proposed"`
+	Diff      string `json:"diff"`
+	Changed   bool   `json:"changed"`
+}
+
+// previewContainerFile reads path from the container and diffs it against
+// proposed. A missing file is treated as empty so a first write still
+// previews cleanly.
+func previewContainerFile(ctx context.Context, docker *client.Client, containerName, path, proposed string) (*ConfigPreview, error) {
+	current, err := readContainerFile(ctx, docker, containerName, path)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigPreview{
+		Container: containerName,
+		Path:      path,
+		Current:   current,
+		Proposed:  proposed,
+		Diff:      lineDiff(current, proposed, "current", "proposed"),
+		Changed:   current != proposed,
+	}, nil
+}
+
+// readContainerFile returns the contents of a single file inside a container
+// via CopyFromContainer. Returns "" without error if the file does not exist.
+func readContainerFile(ctx context.Context, docker *client.Client, containerName, path string) (string, error) {
+	rc, _, err := docker.CopyFromContainer(ctx, containerName, path)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read %s from %s: %w", path, containerName, err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		return "", fmt.Errorf("read %s from %s: %w", path, containerName, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(tr, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("read %s from %s: %w", path, containerName, err)
+	}
+	return string(data), nil
+}
+
+// lineDiff renders a unified-style diff of two small text files. Config files
+// are a few dozen lines, so a plain LCS table is fine. Returns "" when equal.
+func lineDiff(a, b, fromName, toName string) string {
+	if a == b {
+		return ""
+	}
+	al := splitLines(a)
+	bl := splitLines(b)
+
+	// lcs[i][j] = length of the LCS of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			sb.WriteString(" " + al[i] + "\n")
+			i++
+			j++
+		case i < len(al) && (j == len(bl) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("-" + al[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+" + bl[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDBAddr(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		want    string
+		wantErr string
+	}{
+		{"tcp host:port", "root:pw@tcp(mysql:3306)/", "mysql:3306", ""},
+		{"tcp with database and params", "wp:pw@tcp(10.0.0.5:3307)/wordpress?parseTime=true&tls=skip-verify", "10.0.0.5:3307", ""},
+		{"tcp default port", "root:pw@tcp(mysql)/", "mysql:3306", ""},
+		{"tcp6", "root:pw@tcp6([fd00::5]:3306)/", "[fd00::5]:3306", ""},
+		{"password with @ and :", "root:p@ss:word@tcp(db.internal:3306)/", "db.internal:3306", ""},
+		{"no user", "tcp(mysql:3306)/", "mysql:3306", ""},
+
+		{"bare default", "/", "", "loopback"},
+		{"unix socket", "root:pw@unix(/var/run/mysqld/mysqld.sock)/", "", "uses unix"},
+		{"loopback IPv4", "root:pw@tcp(127.0.0.1:3306)/", "", "loopback"},
+		{"loopback IPv6", "root:pw@tcp([::1]:3306)/", "", "loopback"},
+		{"localhost", "root:pw@tcp(localhost:3306)/", "", "loopback"},
+		{"missing slash", "root:pw@tcp(mysql:3306)", "", "parse DSN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDBAddr(tt.dsn)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseDBAddr(%q) = %q, %v; want error containing %q", tt.dsn, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDBAddr(%q): %v", tt.dsn, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseDBAddr(%q) = %q, want %q", tt.dsn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTenantAPIKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		wantErr string
+	}{
+		{"none", nil, ""},
+		{"tenants", map[string]string{"acme": "k1", "beta": "k2"}, ""},
+		{"admin", map[string]string{"acme": "k1", "admin": "k2"}, "reserved"},
+		{"default", map[string]string{"default": "k1"}, "reserved"},
+		{"empty name", map[string]string{"": "k1"}, "empty name"},
+		{"empty key", map[string]string{"acme": ""}, "empty key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTenantAPIKeys(tt.keys)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validateTenantAPIKeys: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("validateTenantAPIKeys = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
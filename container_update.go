@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/versions"
+)
+
+// Changing a live site container's resource limits. Daemons that accept
+// every limit we set in ContainerUpdate change them in place, with no
+// restart; otherwise, or if the update is refused, the container is
+// replaced: the old one is stopped and set aside, a new one is created with
+// the same volumes and environment and the new limits, and the old one is
+// put back if the new one does not come up.
+
+// minLiveUpdateAPIVersion is the first Engine API version whose
+// ContainerUpdate accepts PidsLimit, the newest of the limits we set.
+const minLiveUpdateAPIVersion = "1.40"
+
+// replaceSettleDelay is how long a replacement container must stay running
+// before the old one is removed.
+const replaceSettleDelay = 2 * time.Second
+
+// liveUpdateSupported reports whether both this client and the daemon speak
+// an API version whose ContainerUpdate can apply every limit.
+func (p *Provisioner) liveUpdateSupported(ctx context.Context) bool {
+	if versions.LessThan(p.docker.ClientVersion(), minLiveUpdateAPIVersion) {
+		return false
+	}
+	server, err := p.docker.ServerVersion(ctx)
+	if err != nil {
+		jobLogf(ctx, "provisioner", "warning: could not read daemon API version: %v", err)
+		return false
+	}
+	return versions.GreaterThanOrEqualTo(server.APIVersion, minLiveUpdateAPIVersion)
+}
+
+// updateContainerResources applies res to the running container name,
+// live where possible. recreate creates and starts name afresh with res;
+// it is only used when the live update is unsupported or refused.
+func (p *Provisioner) updateContainerResources(ctx context.Context, name string, res container.Resources, recreate func(context.Context) error) error {
+	if p.liveUpdateSupported(ctx) {
+		updateCtx, cancel := context.WithTimeout(ctx, p.cfg.DockerExecTimeout)
+		_, err := p.docker.ContainerUpdate(updateCtx, name, container.UpdateConfig{Resources: res})
+		cancel()
+		if err == nil {
+			jobLogf(ctx, "provisioner", "updated limits of %s in place", name)
+			return nil
+		}
+		jobLogf(ctx, "provisioner", "live update of %s refused, replacing the container: %v", name, err)
+	}
+	return p.replaceContainer(ctx, name, recreate)
+}
+
+// replaceContainer swaps name for a container made by recreate. The old
+// container is stopped and renamed out of the way first, and removed only
+// once the new one has stayed running for replaceSettleDelay; if the new one
+// fails, it is removed and the old one renamed back and started.
+func (p *Provisioner) replaceContainer(ctx context.Context, name string, recreate func(context.Context) error) error {
+	aside := name + "_replaced"
+	timeout := 10
+	if err := p.docker.ContainerStop(ctx, name, container.StopOptions{Timeout: &timeout}); err != nil {
+		return fmt.Errorf("stop %s: %w", name, err)
+	}
+	if err := p.docker.ContainerRename(ctx, name, aside); err != nil {
+		p.docker.ContainerStart(ctx, name, types.ContainerStartOptions{})
+		return fmt.Errorf("set aside %s: %w", name, err)
+	}
+
+	err := recreate(ctx)
+	if err == nil {
+		err = p.waitRunning(ctx, name, replaceSettleDelay)
+	}
+	if err != nil {
+		p.docker.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true})
+		if rErr := p.docker.ContainerRename(ctx, aside, name); rErr != nil {
+			log.Printf("[CRITICAL] %s: replacement failed and the old container could not be restored as %s: %v", aside, name, rErr)
+		} else if sErr := p.docker.ContainerStart(ctx, name, types.ContainerStartOptions{}); sErr != nil {
+			log.Printf("[CRITICAL] %s: replacement failed and the old container did not restart: %v", name, sErr)
+		}
+		return fmt.Errorf("replace %s (old container restored): %w", name, err)
+	}
+
+	if err := p.docker.ContainerRemove(ctx, aside, types.ContainerRemoveOptions{Force: true}); err != nil {
+		jobLogf(ctx, "provisioner", "warning: replaced container %s not removed: %v", aside, err)
+	}
+	jobLogf(ctx, "provisioner", "replaced %s", name)
+	return nil
+}
+
+// waitRunning fails unless name is still running after settle.
+func (p *Provisioner) waitRunning(ctx context.Context, name string, settle time.Duration) error {
+	select {
+	case <-time.After(settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	info, err := p.docker.ContainerInspect(ctx, name)
+	if err != nil {
+		return err
+	}
+	if info.State == nil || !info.State.Running {
+		return fmt.Errorf("%s is not running after start", name)
+	}
+	return nil
+}
+
+// updateNginxResources applies the nginx sidecar limits of a
+// RESOURCES_UPDATE job and records them once they are in effect, so a
+// failed job leaves the site with its old limits on record and in Docker.
+func (w *Worker) updateNginxResources(ctx context.Context, job *Job) error {
+	var r NginxResources
+	payload, err := w.db.GetJobPayload(job.ID)
+	if err == nil {
+		err = json.Unmarshal([]byte(payload), &r)
+	}
+	if err != nil {
+		return fmt.Errorf("missing resources payload for job")
+	}
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+
+	res := SiteResources(s)
+	p := w.provisioner
+	copts := siteContainerOptions(w.cfg, ProvisionOptions{RestartPolicy: s.RestartPolicy, NginxResources: r, FPM: s.FPM, Network: s.Network})
+	recreate := p.recreateNginxSidecar(s, copts)
+	if err := p.updateContainerResources(ctx, NginxContainerName(res), copts.Nginx, recreate); err != nil {
+		return err
+	}
+	if err := w.db.SetSiteNginxResources(job.Site, r); err != nil {
+		return fmt.Errorf("record nginx resources: %w", err)
+	}
+	log.Printf("[worker] site=%s nginx limits now %+v", job.Site, r)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/client"
+)
+
+// writeSiteCaddyConfig writes the generated Caddy config for s with the given
+// hosts: the static snippet, the path route, or the WordPress snippet. Caddy
+// must be reloaded afterwards.
+func writeSiteCaddyConfig(ctx context.Context, docker *client.Client, cfg Config, s *Site, isWP bool, defaultDomain, customDomain, wwwMode string) error {
+	switch {
+	case !isWP:
+		return NewStaticProvisioner(docker, cfg).writeCaddyConfig(ctx, s.Site, s.StaticRelease, defaultDomain, customDomain, wwwMode, s.CaddyLog, s.ErrorPage)
+	case s.PathPrefix != "":
+		return NewProvisioner(docker, cfg).writeCaddyPathRoute(ctx, s.Site, NginxContainerName(SiteResources(s)))
+	default:
+		return NewProvisioner(docker, cfg).writeCaddyConfig(ctx, s.Site, NginxContainerName(SiteResources(s)), defaultDomain, customDomain, wwwMode, s.CaddyLog, s.ErrorPage)
+	}
+}
+
+// moveDefaultDomain re-points s from its default domain to domain: nginx
+// server_name (WordPress only), then the Caddy snippet and a reload, then
+// the WordPress URLs (best effort) when no custom domain is the canonical
+// one. If Caddy fails both configs are put back. Validation and persisting
+// the domain are left to the caller.
+func moveDefaultDomain(ctx context.Context, docker *client.Client, cfg Config, s *Site, isWP bool, domain string) error {
+	p := NewProvisioner(docker, cfg)
+	nginxName, phpName := NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s))
+
+	if isWP {
+		if err := p.writeNginxConfigWithDomains(ctx, nginxName, phpName,
+			domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI,
+		); err != nil {
+			return fmt.Errorf("nginx config failed: %w", err)
+		}
+	}
+
+	err := writeSiteCaddyConfig(ctx, docker, cfg, s, isWP, domain, s.CustomDomain, s.WWWMode)
+	if err == nil {
+		err = reloadCaddy(ctx, cfg)
+	}
+	if err != nil {
+		if rbErr := writeSiteCaddyConfig(ctx, docker, cfg, s, isWP, s.Domain, s.CustomDomain, s.WWWMode); rbErr != nil {
+			log.Printf("[rollback] site=%s could not restore caddy snippet: %v", s.Site, rbErr)
+		}
+		if isWP {
+			p.writeNginxConfigWithDomains(ctx, nginxName, phpName, s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI)
+		}
+		return fmt.Errorf("caddy update failed: %w", err)
+	}
+
+	if isWP && s.CustomDomain == "" {
+		if err := p.updateWordPressURLs(ctx, SiteResources(s), "https://"+domain); err != nil {
+			log.Printf("[WARN] site=%s wp_options update failed (non-fatal): %v", s.Site, err)
+		}
+	}
+	return nil
+}
+
+// attachCustomDomain routes domain to s alongside its default domain:
+// nginx server_name (WordPress only), then the Caddy snippet and a reload,
+// then the WordPress URLs (best effort). If Caddy fails the nginx change is
+// reverted. Validation, the manual-edit guard and persisting the domain are
+// left to the caller. wwwMode also routes the domain's www variant (see
+// customDomainHosts). Shared by POST /api/sites/:site/domain and provision
+// jobs that request a custom domain.
+func attachCustomDomain(ctx context.Context, docker *client.Client, cfg Config, s *Site, isWP bool, domain, wwwMode string) error {
+	p := NewProvisioner(docker, cfg)
+
+	// Step 1 [WordPress only]: update nginx sidecar — add custom domain to
+	// server_name and switch HTTP_HOST to $host
+	if isWP {
+		if err := p.writeNginxConfigWithDomains(ctx,
+			NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s)),
+			s.Domain, domain, wwwMode, s.PathPrefix, s.NginxSnippet, s.FastCGI,
+		); err != nil {
+			return fmt.Errorf("nginx config failed: %w", err)
+		}
+	}
+
+	// Step 2: Regenerate Caddy snippet with both hostnames (gets TLS cert automatically)
+	err := writeSiteCaddyConfig(ctx, docker, cfg, s, isWP, s.Domain, domain, wwwMode)
+	if err == nil {
+		err = reloadCaddy(ctx, cfg)
+	}
+	if err != nil {
+		// Rollback Step 1: revert nginx to the previous domains
+		if isWP {
+			p.writeNginxConfigWithDomains(ctx, NginxContainerName(SiteResources(s)), PHPContainerName(SiteResources(s)), s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI)
+		}
+		return fmt.Errorf("caddy update failed: %w", err)
+	}
+
+	// Step 3 [WordPress only]: update siteurl + home in wp_options.
+	// Best-effort — WordPress tables may not exist yet if WP hasn't been installed.
+	// nginx $host passthrough means requests still work even if this fails.
+	if isWP {
+		if err := p.updateWordPressURLs(ctx, SiteResources(s), "https://"+domain); err != nil {
+			log.Printf("[WARN] site=%s wp_options update failed (non-fatal): %v", s.Site, err)
+		}
+	}
+	return nil
+}
+
+// moveCustomDomain hands from's custom domain, with its www handling, to to.
+// Caddy refuses a config that routes one host from two site blocks, so the
+// domain cannot be added to to and reloaded before it is dropped from from.
+// Instead both snippets are rewritten and applied by a single reload, which
+// switches the route atomically. Then: nginx on to first (WordPress only)
+// so it accepts the host before Caddy sends it there; if the Caddy step
+// fails, both snippets and to's nginx are put back. from's nginx and both
+// sites' WordPress URLs follow best effort — Caddy no longer routes the
+// domain to from either way. Validation and persisting are left to the
+// caller.
+func moveCustomDomain(ctx context.Context, docker *client.Client, cfg Config, from, to *Site, fromWP, toWP bool) error {
+	p := NewProvisioner(docker, cfg)
+	domain, wwwMode := from.CustomDomain, from.WWWMode
+	toNginx, toPHP := NginxContainerName(SiteResources(to)), PHPContainerName(SiteResources(to))
+
+	if toWP {
+		if err := p.writeNginxConfigWithDomains(ctx, toNginx, toPHP,
+			to.Domain, domain, wwwMode, to.PathPrefix, to.NginxSnippet, to.FastCGI,
+		); err != nil {
+			return fmt.Errorf("nginx config of %s failed: %w", to.Site, err)
+		}
+	}
+
+	// Drop before add: an unrelated reload between the two writes then
+	// briefly unroutes the domain rather than failing on a duplicate host.
+	err := writeSiteCaddyConfig(ctx, docker, cfg, from, fromWP, from.Domain, "", "")
+	if err == nil {
+		err = writeSiteCaddyConfig(ctx, docker, cfg, to, toWP, to.Domain, domain, wwwMode)
+	}
+	if err == nil {
+		err = reloadCaddy(ctx, cfg)
+	}
+	if err != nil {
+		if rbErr := writeSiteCaddyConfig(ctx, docker, cfg, to, toWP, to.Domain, to.CustomDomain, to.WWWMode); rbErr != nil {
+			log.Printf("[rollback] site=%s could not restore caddy snippet: %v", to.Site, rbErr)
+		}
+		if rbErr := writeSiteCaddyConfig(ctx, docker, cfg, from, fromWP, from.Domain, domain, wwwMode); rbErr != nil {
+			log.Printf("[rollback] site=%s could not restore caddy snippet: %v", from.Site, rbErr)
+		}
+		if toWP {
+			p.writeNginxConfigWithDomains(ctx, toNginx, toPHP, to.Domain, to.CustomDomain, to.WWWMode, to.PathPrefix, to.NginxSnippet, to.FastCGI)
+		}
+		return fmt.Errorf("caddy update failed: %w", err)
+	}
+
+	if fromWP {
+		if err := p.writeNginxConfigWithDomains(ctx,
+			NginxContainerName(SiteResources(from)), PHPContainerName(SiteResources(from)),
+			from.Domain, "", "", from.PathPrefix, from.NginxSnippet, from.FastCGI,
+		); err != nil {
+			log.Printf("[WARN] site=%s nginx revert after domain move failed (non-fatal): %v", from.Site, err)
+		}
+		if err := p.updateWordPressURLs(ctx, SiteResources(from), "https://"+from.Domain); err != nil {
+			log.Printf("[WARN] site=%s wp_options revert failed (non-fatal): %v", from.Site, err)
+		}
+	}
+	if toWP {
+		if err := p.updateWordPressURLs(ctx, SiteResources(to), "https://"+domain); err != nil {
+			log.Printf("[WARN] site=%s wp_options update failed (non-fatal): %v", to.Site, err)
+		}
+	}
+	return nil
+}
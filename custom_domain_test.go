@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A move is refused before any config is touched while either site is busy
+// or from_site is not live.
+func TestMoveDomainRefusesBusyOrInactiveSites(t *testing.T) {
+	cfg := integrationConfig(t)
+	db := integrationDB(t, cfg)
+	a := &API{db: db, cfg: cfg} // no Docker client: reaching the infra step panics
+
+	tests := []struct {
+		name       string
+		fromStatus SiteStatus
+		jobOn      string // "from", "to" or ""
+		want       int
+		code       ErrorCode
+	}{
+		{"job on from_site", SiteDomainActive, "from", http.StatusConflict, CodeJobActive},
+		{"job on to_site", SiteDomainActive, "to", http.StatusConflict, CodeJobActive},
+		{"from_site paused", SitePaused, "", http.StatusConflict, CodeSiteState},
+		{"from_site destroying", SiteDestroying, "", http.StatusConflict, CodeSiteState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := testSiteName(), testSiteName()
+			domain := from + "-shop.example.com"
+			insertTestSite(t, db, from, SiteDomain(from, cfg.BaseDomain))
+			insertTestSite(t, db, to, SiteDomain(to, cfg.BaseDomain))
+			if err := db.SetCustomDomain(from, domain, ""); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.conn.Exec(`UPDATE sites SET status=? WHERE site=?`, string(tt.fromStatus), from); err != nil {
+				t.Fatal(err)
+			}
+			switch tt.jobOn {
+			case "from":
+				insertTestJob(t, db, JobBackup, from)
+			case "to":
+				insertTestJob(t, db, JobBackup, to)
+			}
+
+			body, _ := json.Marshal(map[string]string{"domain": domain, "from_site": from, "to_site": to})
+			w := httptest.NewRecorder()
+			tenantTestRouter(a, tenantAdmin).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/domains/move", bytes.NewReader(body)))
+			var resp struct{ Code ErrorCode }
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.want || resp.Code != tt.code {
+				t.Fatalf("move = %d %s, want %d %s: %s", w.Code, resp.Code, tt.want, tt.code, w.Body)
+			}
+			if s, err := db.GetSite(from); err != nil || s.CustomDomain != domain {
+				t.Errorf("from_site lost its domain: %+v, %v", s, err)
+			}
+		})
+	}
+}
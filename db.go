@@ -2,7 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -15,33 +18,653 @@ const (
 	JobProvision       JobType   = "PROVISION"
 	JobDestroy         JobType   = "DESTROY"
 	JobStaticProvision JobType   = "STATIC_PROVISION"
+	JobClone           JobType   = "CLONE"
+	JobStaticDeploy    JobType   = "STATIC_DEPLOY"
+	JobChangeSubdomain JobType   = "CHANGE_SUBDOMAIN"
+	JobGreenCreate     JobType   = "GREEN_CREATE"
+	JobGreenCutover    JobType   = "GREEN_CUTOVER"
+	JobGreenRollback   JobType   = "GREEN_ROLLBACK"
+	JobGreenDiscard    JobType   = "GREEN_DISCARD"
+	JobBlueRetire      JobType   = "BLUE_RETIRE"
+	JobBackup          JobType   = "BACKUP"
+	JobResourcesUpdate JobType   = "RESOURCES_UPDATE"
+	JobVerifyDNS       JobType   = "VERIFY_DNS"
 	StatusPending      JobStatus = "PENDING"
 	StatusProcessing   JobStatus = "PROCESSING"
 	StatusCompleted    JobStatus = "COMPLETED"
 	StatusFailed       JobStatus = "FAILED"
 )
 
+// errJobCancelled is the cause a job's context is cancelled with by
+// POST /api/jobs/:id/cancel, and wraps the error of a cancelled job.
+var errJobCancelled = errors.New("cancelled by request")
+
+// CancelsMidRun reports whether a running job of type t may be cancelled:
+// its steps all run under the job's context and a failed step rolls back
+// what the earlier ones created (see Provisioner.Run). Any PENDING job may
+// be cancelled.
+func (t JobType) CancelsMidRun() bool {
+	return t == JobProvision || t == JobClone
+}
+
+// KeepsSiteStatus reports whether jobs of type t run against a site that
+// keeps serving throughout — a redeploy, a subdomain change, a blue/green
+// step, a scheduled backup, a resource limit change or a DNS route check —
+// so queueing, completing or failing one leaves its status as is.
+func (t JobType) KeepsSiteStatus() bool {
+	switch t {
+	case JobStaticDeploy, JobChangeSubdomain, JobBackup, JobResourcesUpdate, JobVerifyDNS,
+		JobGreenCreate, JobGreenCutover, JobGreenRollback, JobGreenDiscard, JobBlueRetire:
+		return true
+	}
+	return false
+}
+
+// SiteType records what a site was provisioned as. It decides whether domain
+// changes touch the nginx sidecar and wp_options, and which Caddy snippet
+// format is written.
+type SiteType string
+
+const (
+	SiteTypeWordPress SiteType = "wordpress"
+	SiteTypeStatic    SiteType = "static"
+)
+
 type Site struct {
 	Site         string
 	Domain       string
+	Type         SiteType // "" for legacy rows created before the column existed
 	Status       string
 	JobID        string
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	CustomDomain string
 	LastBackupAt *time.Time // nullable — nil until first backup
+	VolumeDriver string     // driver the wp_<site> volume was created with ("" for legacy rows)
+	VolumeSize   string     // size hint requested at provision time ("" = driver default)
+	NginxSnippet string     // custom rules injected into the WordPress nginx server block
+
+	// Static sites only: the release Caddy currently serves and the one kept
+	// for rollback. "" means the pre-release layout at /srv/sites/{site}.
+	StaticRelease     string
+	StaticPrevRelease string
+
+	// PathPrefix is set for sites routed as https://<Domain><PathPrefix>/
+	// instead of a subdomain; Domain is then the base domain.
+	PathPrefix string
+
+	// CaddyManual is set once an operator has replaced the generated Caddy
+	// snippet by hand; regenerateCaddy refuses to overwrite it until cleared.
+	CaddyManual bool
+
+	// CaddyLog is the site's request logging: CaddyLogOff, CaddyLogFile or
+	// CaddyLogStdout. Every generated snippet carries it.
+	CaddyLog string
+
+	// ErrorPage is the site's error page: ErrorPageOff, ErrorPageDefault or
+	// ErrorPageCustom, whose HTML is ErrorPageHTML. Every generated snippet
+	// carries it.
+	ErrorPage     string
+	ErrorPageHTML string
+
+	// Network is the Docker network the site's containers are on, fixed at
+	// provision time; "" is DOCKER_NETWORK (see SiteNetwork).
+	Network string
+
+	// Egress is the outbound network policy of the site's network:
+	// EgressOpen or EgressInternal.
+	Egress string
+
+	// The WordPress admin account the control plane created or reset (see
+	// admin_credentials.go): its login, a reference to its password, and
+	// whether the password is still waiting to be revealed.
+	AdminUser           string
+	AdminPassRef        string `json:"-"`
+	AdminPassRevealable bool
+
+	RestartPolicy string // Docker restart policy of the site's containers ("" = defaultRestartPolicy)
+
+	// NginxResources are the sidecar limits requested at provision time
+	// (zero fields = configured defaults), reapplied whenever it is recreated.
+	NginxResources NginxResources
+
+	// FastCGI is the site's nginx → PHP-FPM tuning (zero fields = the
+	// configured defaults), reapplied whenever its server block is rewritten.
+	FastCGI FastCGISettings
+
+	// FPM is the site's PHP-FPM pool plan and overrides (zero fields = the
+	// plan's, or PHP_FPM_PLAN's), written into every PHP container created
+	// for it.
+	FPM FPMPool
+
+	// BaseDomain is the base domain the site was provisioned under ("" for
+	// rows that predate multiple base domains; see SiteBaseDomain).
+	BaseDomain string
+
+	// WWWMode is the custom domain's www handling: "" (none), WWWRedirect
+	// or WWWServe. Cleared with the custom domain.
+	WWWMode string
+
+	// ExternalVolume names a pre-existing volume the site was provisioned
+	// onto instead of a fresh wp_<site> (see SiteVolume). The control plane
+	// did not create it and never deletes it.
+	ExternalVolume string
+
+	// WPConfigExtra is the site's own WORDPRESS_CONFIG_EXTRA, replacing
+	// Config.WPConfigExtra ("" = the configured default).
+	WPConfigExtra string
+
+	// Blue/green resource sets (WordPress only; see bluegreen.go and
+	// SiteResources). Resources names the live set ("" = the slug);
+	// GreenResources a clone being prepared for cutover; RetiredResources
+	// the set a cutover or rollback replaced, kept for rollback until
+	// RetireAfter and then removed.
+	Resources        string
+	GreenResources   string
+	RetiredResources string
+	RetireAfter      *time.Time
+
+	// BackupSchedule is the site's own backup schedule and retention (zero
+	// = none; the site is covered by the daily BackupAll run instead).
+	BackupSchedule BackupSchedule
+
+	// ObjectCache is the site's Redis object cache: ObjectCachePerSite,
+	// ObjectCacheShared or "" (none). See redis.go.
+	ObjectCache string
+
+	// A custom domain waiting for its DNS (status DOMAIN_PENDING through
+	// DOMAIN_ROUTING; see domain_wait.go): the domain and www mode it was
+	// set with, when the wait gives up, and why the last check failed.
+	PendingDomain      string
+	PendingWWWMode     string
+	PendingDomainUntil *time.Time
+	PendingDomainError string
+
+	// Tenant is the tenant whose API key provisioned the site; rows from
+	// before tenants existed belong to the default tenant.
+	Tenant string
+}
+
+// siteColumns is the column list shared by every query that scans a Site via scanSite.
+const siteColumns = `site, domain, COALESCE(custom_domain,''), status, COALESCE(job_id,''), created_at, updated_at, last_backup_at,
+        COALESCE(volume_driver,''), COALESCE(volume_size,''), COALESCE(type,''), COALESCE(nginx_snippet,''),
+        COALESCE(static_release,''), COALESCE(static_prev_release,''), COALESCE(path_prefix,''), COALESCE(caddy_manual,0),
+        COALESCE(restart_policy,''), COALESCE(base_domain,''),
+        COALESCE(nginx_memory_mb,0), COALESCE(nginx_cpu_millis,0), COALESCE(nginx_pids,0), COALESCE(external_volume,''), COALESCE(www_mode,''),
+        COALESCE(wp_config_extra,''),
+        COALESCE(resource_name,''), COALESCE(green_resources,''), COALESCE(retired_resources,''), retire_after,
+        COALESCE(backup_interval_hours,0), COALESCE(backup_keep,0), COALESCE(backup_max_age_days,0),
+        COALESCE(fastcgi_read_timeout,0), COALESCE(fastcgi_send_timeout,0), COALESCE(fastcgi_buffer_kb,0),
+        COALESCE(caddy_log,''), COALESCE(object_cache,''),
+        COALESCE(fpm_plan,''), COALESCE(fpm_pm,''), COALESCE(fpm_max_children,0), COALESCE(fpm_start_servers,0),
+        COALESCE(fpm_min_spare_servers,0), COALESCE(fpm_max_spare_servers,0), COALESCE(fpm_max_requests,0),
+        COALESCE(pending_domain,''), COALESCE(pending_www_mode,''), pending_domain_until, COALESCE(pending_domain_error,''),
+        COALESCE(error_page,''), COALESCE(error_page_html,''), COALESCE(network,''), COALESCE(egress,''),
+        COALESCE(admin_user,''), COALESCE(admin_pass_ref,''), admin_pass_reveal IS NOT NULL, COALESCE(tenant,'default')`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanSite scans one row selected with siteColumns.
+func scanSite(row rowScanner) (*Site, error) {
+	var s Site
+	var lastBackup, retireAfter, pendingUntil sql.NullTime
+	if err := row.Scan(&s.Site, &s.Domain, &s.CustomDomain, &s.Status, &s.JobID, &s.CreatedAt, &s.UpdatedAt, &lastBackup,
+		&s.VolumeDriver, &s.VolumeSize, &s.Type, &s.NginxSnippet,
+		&s.StaticRelease, &s.StaticPrevRelease, &s.PathPrefix, &s.CaddyManual,
+		&s.RestartPolicy, &s.BaseDomain,
+		&s.NginxResources.MemoryMB, &s.NginxResources.CPUMillis, &s.NginxResources.Pids, &s.ExternalVolume, &s.WWWMode,
+		&s.WPConfigExtra,
+		&s.Resources, &s.GreenResources, &s.RetiredResources, &retireAfter,
+		&s.BackupSchedule.IntervalHours, &s.BackupSchedule.Keep, &s.BackupSchedule.MaxAgeDays,
+		&s.FastCGI.ReadTimeoutSec, &s.FastCGI.SendTimeoutSec, &s.FastCGI.BufferSizeKB,
+		&s.CaddyLog, &s.ObjectCache,
+		&s.FPM.Plan, &s.FPM.PM, &s.FPM.MaxChildren, &s.FPM.StartServers,
+		&s.FPM.MinSpareServers, &s.FPM.MaxSpareServers, &s.FPM.MaxRequests,
+		&s.PendingDomain, &s.PendingWWWMode, &pendingUntil, &s.PendingDomainError,
+		&s.ErrorPage, &s.ErrorPageHTML, &s.Network, &s.Egress,
+		&s.AdminUser, &s.AdminPassRef, &s.AdminPassRevealable, &s.Tenant); err != nil {
+		return nil, err
+	}
+	if lastBackup.Valid {
+		s.LastBackupAt = &lastBackup.Time
+	}
+	if retireAfter.Valid {
+		s.RetireAfter = &retireAfter.Time
+	}
+	if pendingUntil.Valid {
+		s.PendingDomainUntil = &pendingUntil.Time
+	}
+	return &s, nil
+}
+
+// SetGreenResources records the green resource set being prepared for site
+// ("" = none).
+func (d *DB) SetGreenResources(site, name string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET green_resources=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, name, site)
+	return err
+}
+
+// SwitchLiveResources records a cutover or rollback in one statement: live
+// becomes the set the site is served from, retired the set kept for
+// rollback for keep. A green set that went live is cleared.
+func (d *DB) SwitchLiveResources(site, live, retired string, keep time.Duration) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites
+        SET resource_name=?, retired_resources=NULLIF(?, ''), retire_after=NOW() + INTERVAL ? SECOND,
+            green_resources=IF(green_resources=?, NULL, green_resources), updated_at=NOW()
+        WHERE site=?
+    `, live, retired, int64(keep.Seconds()), live, site)
+	return err
+}
+
+// ClearRetiredResources forgets site's retired set once it is removed.
+func (d *DB) ClearRetiredResources(site string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET retired_resources=NULL, retire_after=NULL, updated_at=NOW() WHERE site=?
+    `, site)
+	return err
+}
+
+// SetBackupSchedule records site's backup schedule (zero fields = unset).
+func (d *DB) SetBackupSchedule(site string, sched BackupSchedule) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET backup_interval_hours=NULLIF(?, 0), backup_keep=NULLIF(?, 0), backup_max_age_days=NULLIF(?, 0), updated_at=NOW()
+        WHERE site=?
+    `, sched.IntervalHours, sched.Keep, sched.MaxAgeDays, site)
+	return err
+}
+
+// ListDueBackups returns the sites with a backup schedule whose last backup
+// (scheduled or not) is at least their interval ago, or that have never been
+// backed up. Working from last_backup_at means a restart neither skips nor
+// repeats a backup.
+func (d *DB) ListDueBackups() ([]string, error) {
+	rows, err := d.conn.Query(`
+        SELECT site FROM sites
+        WHERE backup_interval_hours > 0
+          AND (last_backup_at IS NULL OR last_backup_at <= NOW() - INTERVAL backup_interval_hours HOUR)
+          AND status NOT IN ('DESTROYED','DESTROYING','FAILED','CREATED')
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []string
+	for rows.Next() {
+		var site string
+		if err := rows.Scan(&site); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// ListDueRetirements returns the live sites whose retired set is past its
+// retire_after.
+func (d *DB) ListDueRetirements() ([]string, error) {
+	rows, err := d.conn.Query(`
+        SELECT site FROM sites
+        WHERE retired_resources IS NOT NULL AND retire_after <= NOW()
+          AND status NOT IN ('DESTROYED','DESTROYING')
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []string
+	for rows.Next() {
+		var site string
+		if err := rows.Scan(&site); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// ListPendingDomains returns the sites waiting for a custom domain's DNS,
+// including any left mid-check or mid-routing by a restart.
+func (d *DB) ListPendingDomains() ([]string, error) {
+	rows, err := d.conn.Query(`
+        SELECT site FROM sites
+        WHERE pending_domain IS NOT NULL
+          AND status IN ('DOMAIN_PENDING','DOMAIN_VALIDATING','DOMAIN_ROUTING')
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []string
+	for rows.Next() {
+		var site string
+		if err := rows.Scan(&site); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// SetPendingDomain records a custom domain waiting for its DNS until wait
+// has passed.
+func (d *DB) SetPendingDomain(site, domain, wwwMode string, wait time.Duration) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites
+        SET pending_domain=?, pending_www_mode=NULLIF(?, ''), pending_domain_until=NOW() + INTERVAL ? SECOND,
+            pending_domain_error=NULL, updated_at=NOW()
+        WHERE site=?
+    `, domain, wwwMode, int64(wait.Seconds()), site)
+	return err
+}
+
+// SetPendingDomainError records why the last DNS check of a pending domain
+// failed.
+func (d *DB) SetPendingDomainError(site, msg string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET pending_domain_error=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, msg, site)
+	return err
+}
+
+// ClearPendingDomain forgets a pending domain once it is routed, abandoned
+// or cancelled.
+func (d *DB) ClearPendingDomain(site string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites
+        SET pending_domain=NULL, pending_www_mode=NULL, pending_domain_until=NULL, pending_domain_error=NULL, updated_at=NOW()
+        WHERE site=?
+    `, site)
+	return err
+}
+
+// EnsureVolumeAvailable checks that no other live site keeps its files in
+// volume, either as its own wp_<site> volume or as its external volume.
+func (d *DB) EnsureVolumeAvailable(volume, excludeSite string) error {
+	var owner string
+	err := d.conn.QueryRow(`
+		SELECT site FROM sites
+		WHERE (external_volume=? OR (external_volume IS NULL AND CONCAT(?, site)=?))
+		  AND site!=? AND status NOT IN ('DESTROYED','FAILED')
+		LIMIT 1
+	`, volume, VolumeName(""), volume, excludeSite).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check volume availability: %w", err)
+	}
+	return fmt.Errorf("volume %s is in use by site %s", volume, owner)
+}
+
+// SetNginxSnippet stores the site's custom nginx rules so every later rewrite
+// of the server block (e.g. custom domain changes) preserves them.
+func (d *DB) SetNginxSnippet(site, snippet string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET nginx_snippet=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, snippet, site)
+	return err
+}
+
+// SetSiteFastCGI records the site's nginx → PHP-FPM tuning (zero = configured
+// default), so every later rewrite of its server block keeps it.
+func (d *DB) SetSiteFastCGI(site string, f FastCGISettings) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET fastcgi_read_timeout=NULLIF(?, 0), fastcgi_send_timeout=NULLIF(?, 0), fastcgi_buffer_kb=NULLIF(?, 0), updated_at=NOW()
+        WHERE site=?
+    `, f.ReadTimeoutSec, f.SendTimeoutSec, f.BufferSizeKB, site)
+	return err
+}
+
+// SetSiteNginxResources records the nginx sidecar limits requested for the
+// site (zero = configured default), so anything that recreates the sidecar
+// can reapply them.
+func (d *DB) SetSiteNginxResources(site string, r NginxResources) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET nginx_memory_mb=NULLIF(?, 0), nginx_cpu_millis=NULLIF(?, 0), nginx_pids=NULLIF(?, 0), updated_at=NOW()
+        WHERE site=?
+    `, r.MemoryMB, r.CPUMillis, r.Pids, site)
+	return err
+}
+
+// SetCaddyManual marks (or clears) the site's Caddy snippet as hand-edited.
+func (d *DB) SetCaddyManual(site string, manual bool) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET caddy_manual=?, updated_at=NOW() WHERE site=?
+    `, manual, site)
+	return err
 }
 
-func (d *DB) SetCustomDomain(site, domain string) error {
+// SetSiteErrorPage records the site's error page mode and, for
+// ErrorPageCustom, its HTML.
+func (d *DB) SetSiteErrorPage(site, mode, html string) error {
 	_, err := d.conn.Exec(`
-        UPDATE sites SET custom_domain=?, updated_at=NOW() WHERE site=?
-    `, domain, site)
+        UPDATE sites SET error_page=NULLIF(?, ''), error_page_html=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, mode, html, site)
 	return err
 }
 
+// SetSiteAdminCredentials records the WordPress admin account the control
+// plane created or reset: the login, the password's reference, and the
+// password itself until RevealSiteAdminPassword hands it out.
+func (d *DB) SetSiteAdminCredentials(site, user, password string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET admin_user=?, admin_pass_ref=?, admin_pass_reveal=?, updated_at=NOW() WHERE site=?
+    `, user, adminPasswordRef(password), password, site)
+	return err
+}
+
+// CopySiteAdminCredentials gives site the admin account reference of from,
+// whose database it was copied from. Nothing is left to reveal: the password
+// is from's.
+func (d *DB) CopySiteAdminCredentials(from, site string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites s JOIN sites f ON f.site=?
+        SET s.admin_user=f.admin_user, s.admin_pass_ref=f.admin_pass_ref, s.admin_pass_reveal=NULL, s.updated_at=NOW()
+        WHERE s.site=?
+    `, from, site)
+	return err
+}
+
+// RevealSiteAdminPassword returns the site's admin login and password and
+// forgets the password, so it is handed out once. sql.ErrNoRows means there
+// is no password waiting: never stored, or revealed already.
+func (d *DB) RevealSiteAdminPassword(site string) (string, string, error) {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var user, password string
+	err = tx.QueryRow(`
+        SELECT COALESCE(admin_user,''), admin_pass_reveal FROM sites
+        WHERE site=? AND admin_pass_reveal IS NOT NULL
+        FOR UPDATE
+    `, site).Scan(&user, &password)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := tx.Exec(`
+        UPDATE sites SET admin_pass_reveal=NULL, updated_at=NOW() WHERE site=?
+    `, site); err != nil {
+		return "", "", err
+	}
+	return user, password, tx.Commit()
+}
+
+// SetSiteCaddyLog records the site's Caddy request logging mode.
+func (d *DB) SetSiteCaddyLog(site, mode string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET caddy_log=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, mode, site)
+	return err
+}
+
+// SetStaticReleases records which release a static site serves and which
+// one is retained for rollback.
+func (d *DB) SetStaticReleases(site, current, previous string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET static_release=NULLIF(?, ''), static_prev_release=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, current, previous, site)
+	return err
+}
+
+// HistoryEntry is one non-job event in a site's history: a status
+// transition or an API-driven change such as a custom domain update.
+type HistoryEntry struct {
+	Kind      string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// RecordSiteHistory appends an entry to the site's history. kind is a
+// lifecycle event type (e.g. EventDomainSet) or "status".
+func (d *DB) RecordSiteHistory(site, kind, detail string) error {
+	_, err := d.conn.Exec(`
+        INSERT INTO site_history (site, kind, detail) VALUES (?, ?, NULLIF(?, ''))
+    `, site, kind, detail)
+	return err
+}
+
+// ListSiteHistory returns the site's recorded history, oldest first.
+func (d *DB) ListSiteHistory(site string) ([]HistoryEntry, error) {
+	rows, err := d.conn.Query(`
+        SELECT kind, COALESCE(detail,''), created_at FROM site_history
+        WHERE site=? ORDER BY created_at ASC, id ASC
+    `, site)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.Kind, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SetSiteRouting records where a site is served: its host, the base domain
+// it sits under and, for path-routed sites, the path prefix ("" for
+// subdomain routing). All are written so re-provisioning in a different
+// mode leaves no stale host.
+func (d *DB) SetSiteRouting(site, domain, pathPrefix, baseDomain string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET domain=?, path_prefix=NULLIF(?, ''), base_domain=?, updated_at=NOW() WHERE site=?
+    `, domain, pathPrefix, baseDomain, site)
+	return err
+}
+
+// SetSiteJob points the site at its latest job without changing its status.
+// Used by jobs that run against a live site, like static redeploys.
+func (d *DB) SetSiteJob(site, jobID string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET job_id=?, updated_at=NOW() WHERE site=?
+    `, jobID, site)
+	return err
+}
+
+// CountTenantSites counts the tenant's sites that are not DESTROYED. Rows
+// from before tenants existed belong to the default tenant.
+func (d *DB) CountTenantSites(tenant string) (int, error) {
+	var n int
+	err := d.conn.QueryRow(`
+		SELECT COUNT(*) FROM sites
+		WHERE COALESCE(tenant, 'default') = ? AND status != 'DESTROYED'
+	`, tenant).Scan(&n)
+	return n, err
+}
+
+// CountHostSites counts the sites holding resources on the app server:
+// all but DESTROYED ones and FAILED ones, whose provision was rolled back.
+func (d *DB) CountHostSites() (int, error) {
+	var n int
+	err := d.conn.QueryRow(`
+		SELECT COUNT(*) FROM sites WHERE status NOT IN ('DESTROYED', 'FAILED')
+	`).Scan(&n)
+	return n, err
+}
+
+// CountPendingJobs counts jobs waiting for the worker to claim them.
+func (d *DB) CountPendingJobs() (int, error) {
+	var n int
+	err := d.conn.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status='PENDING'`).Scan(&n)
+	return n, err
+}
+
+// CountTenantProvisions counts provisioning jobs (WordPress, static and
+// clone) queued for the tenant's sites in the last 24 hours.
+func (d *DB) CountTenantProvisions(tenant string) (int, error) {
+	var n int
+	err := d.conn.QueryRow(`
+		SELECT COUNT(*) FROM jobs j
+		JOIN sites s ON s.site = j.site
+		WHERE COALESCE(s.tenant, 'default') = ?
+		  AND j.type IN ('PROVISION', 'STATIC_PROVISION', 'CLONE')
+		  AND j.created_at >= NOW() - INTERVAL 1 DAY
+	`, tenant).Scan(&n)
+	return n, err
+}
+
+func (d *DB) SetCustomDomain(site, domain, wwwMode string) error {
+	_, err := d.conn.Exec(`
+        UPDATE sites SET custom_domain=?, www_mode=NULLIF(?, ''), updated_at=NOW() WHERE site=?
+    `, domain, wwwMode, site)
+	return err
+}
+
+// errDomainMoveConflict means a domain move found either site changed
+// underneath it: from no longer holds the domain, or to gained one.
+var errDomainMoveConflict = errors.New("sites changed during the domain move")
+
+// MoveCustomDomain reassigns domain and its www mode from one site to
+// another in a single transaction, so no reader sees the domain on both
+// sites or on neither. It fails with errDomainMoveConflict, changing
+// nothing, unless from still holds domain and to has no custom domain.
+func (d *DB) MoveCustomDomain(domain, wwwMode, from, to string) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+        UPDATE sites SET custom_domain=NULL, www_mode=NULL, updated_at=NOW() WHERE site=? AND custom_domain=?
+    `, from, domain)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return errDomainMoveConflict
+	}
+	res, err = tx.Exec(`
+        UPDATE sites SET custom_domain=?, www_mode=NULLIF(?, ''), updated_at=NOW() WHERE site=? AND COALESCE(custom_domain,'')=''
+    `, domain, wwwMode, to)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return errDomainMoveConflict
+	}
+	return tx.Commit()
+}
+
 func (d *DB) RemoveCustomDomain(site string) error {
 	_, err := d.conn.Exec(`
-        UPDATE sites SET custom_domain=NULL, updated_at=NOW() WHERE site=?
+        UPDATE sites SET custom_domain=NULL, www_mode=NULL, updated_at=NOW() WHERE site=?
     `, site)
 	return err
 }
@@ -49,8 +672,8 @@ func (d *DB) RemoveCustomDomain(site string) error {
 func (d *DB) FailJob(jobID, site string, jobErr error) error {
 	msg := jobErr.Error()
 	_, err := d.conn.Exec(`
-        UPDATE jobs SET status='FAILED', error=?, updated_at=NOW() WHERE id=?
-    `, msg, jobID)
+        UPDATE jobs SET status='FAILED', error=?, error_code=?, updated_at=NOW() WHERE id=?
+    `, msg, string(jobErrorCode(jobErr)), jobID)
 	if err != nil {
 		return err
 	}
@@ -58,8 +681,43 @@ func (d *DB) FailJob(jobID, site string, jobErr error) error {
 	return d.UpdateSiteStatus(site, "FAILED")
 }
 
-func (d *DB) SetJobPayload(jobID, payload string) error {
-	_, err := d.conn.Exec(`UPDATE jobs SET payload=? WHERE id=?`, payload, jobID)
+// CancelPendingJob fails job jobID with errJobCancelled if it is still
+// PENDING, reporting whether it was — a worker claiming it first wins —
+// and leaves its site as a failed job of its type would.
+func (d *DB) CancelPendingJob(jobID string) (cancelled bool, err error) {
+	var site string
+	var jobType JobType
+	if err := d.conn.QueryRow(`SELECT site, type FROM jobs WHERE id=?`, jobID).Scan(&site, &jobType); err != nil {
+		return false, err
+	}
+	res, err := d.conn.Exec(`
+        UPDATE jobs SET status='FAILED', error=?, error_code=?, updated_at=NOW() WHERE id=? AND status='PENDING'
+    `, errJobCancelled.Error(), string(CodeJobCancelled), jobID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if jobType.KeepsSiteStatus() {
+		return true, nil
+	}
+	return true, d.UpdateSiteStatus(site, "FAILED")
+}
+
+// FailDeployJob marks a job whose type KeepsSiteStatus FAILED without
+// touching the site: a failed attempt leaves the previous release, domain
+// or resource set serving.
+func (d *DB) FailDeployJob(jobID string, jobErr error) error {
+	_, err := d.conn.Exec(`
+        UPDATE jobs SET status='FAILED', error=?, error_code=?, updated_at=NOW() WHERE id=?
+    `, jobErr.Error(), string(jobErrorCode(jobErr)), jobID)
+	return err
+}
+
+// SetJobResult stores the JSON a job reports about its latest attempt.
+func (d *DB) SetJobResult(jobID, result string) error {
+	_, err := d.conn.Exec(`UPDATE jobs SET result=? WHERE id=?`, result, jobID)
 	return err
 }
 
@@ -82,6 +740,12 @@ func (d *DB) HardDeleteSite(site string) error {
 	if err != nil {
 		return err
 	}
+	_, err = d.conn.Exec(`
+		DELETE FROM site_history WHERE site=?;
+	`, site)
+	if err != nil {
+		return err
+	}
 	_, err = d.conn.Exec(`
 		DELETE FROM sites WHERE site=?;
 	`, site)
@@ -96,11 +760,143 @@ func (d *DB) HardDeleteJob(id string) error {
 func (d *DB) MigrateSchema() error {
 	// Idempotent — ADD COLUMN IF NOT EXISTS requires MySQL 8.0.3+
 	// For older versions we use a conditional approach.
-	_, err := d.conn.Exec(`
-		ALTER TABLE sites
-		ADD COLUMN IF NOT EXISTS last_backup_at DATETIME NULL DEFAULT NULL
-	`)
-	return err
+	// Every statement is attempted even if an earlier one fails so a single
+	// unsupported ALTER does not block the rest; the first error is returned.
+	stmts := []string{
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS last_backup_at DATETIME NULL DEFAULT NULL`,
+		`ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS request_id VARCHAR(64) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS volume_driver VARCHAR(64) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS volume_size VARCHAR(16) NULL DEFAULT NULL`,
+		`CREATE TABLE IF NOT EXISTS templates (
+			name       VARCHAR(64)  NOT NULL PRIMARY KEY,
+			volume     VARCHAR(128) NOT NULL,
+			db_name    VARCHAR(64)  NOT NULL,
+			domain     VARCHAR(253) NOT NULL,
+			created_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS type VARCHAR(16) NULL DEFAULT NULL`,
+		// Backfill from the provisioning job while it still exists; rows whose
+		// job is already gone stay NULL and use the isWordPressSite fallback.
+		`UPDATE sites s JOIN jobs j ON j.id = s.job_id
+		SET s.type = IF(j.type = 'STATIC_PROVISION', 'static', 'wordpress')
+		WHERE s.type IS NULL AND j.type IN ('PROVISION', 'STATIC_PROVISION', 'CLONE')`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS nginx_snippet TEXT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS tenant VARCHAR(64) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS static_release VARCHAR(32) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS static_prev_release VARCHAR(32) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS path_prefix VARCHAR(64) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS caddy_manual BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS restart_policy VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS result MEDIUMTEXT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS base_domain VARCHAR(253) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS nginx_memory_mb INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS nginx_cpu_millis INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS nginx_pids INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS external_volume VARCHAR(255) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS www_mode VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS wp_config_extra TEXT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS resource_name VARCHAR(80) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS green_resources VARCHAR(80) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS retired_resources VARCHAR(80) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS retire_after DATETIME NULL DEFAULT NULL`,
+		`ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS error_code VARCHAR(40) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS backup_interval_hours INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS backup_keep INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS backup_max_age_days INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fastcgi_read_timeout INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fastcgi_send_timeout INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fastcgi_buffer_kb INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS caddy_log VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS object_cache VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS pending_domain VARCHAR(253) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_plan VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_pm VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_max_children INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_start_servers INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_min_spare_servers INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_max_spare_servers INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS fpm_max_requests INT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS pending_www_mode VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS pending_domain_until DATETIME NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS pending_domain_error TEXT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS error_page VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS error_page_html MEDIUMTEXT NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS network VARCHAR(128) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS egress VARCHAR(16) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS admin_user VARCHAR(60) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS admin_pass_ref CHAR(64) NULL DEFAULT NULL`,
+		`ALTER TABLE sites
+		ADD COLUMN IF NOT EXISTS admin_pass_reveal VARCHAR(255) NULL DEFAULT NULL`,
+		`CREATE TABLE IF NOT EXISTS site_history (
+			id         BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			site       VARCHAR(64)  NOT NULL,
+			kind       VARCHAR(64)  NOT NULL,
+			detail     TEXT         NULL,
+			created_at DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_site_history_site (site, created_at)
+		)`,
+		// Destroys completed before CompleteJob cleared them left the old
+		// blue/green sets on the row for the next provision to inherit.
+		`UPDATE sites SET resource_name=NULL, green_resources=NULL, retired_resources=NULL, retire_after=NULL
+		WHERE status='DESTROYED' AND (resource_name IS NOT NULL OR green_resources IS NOT NULL OR retired_resources IS NOT NULL)`,
+	}
+	var firstErr error
+	for _, stmt := range stmts {
+		if _, err := d.conn.Exec(stmt); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (d *DB) UpdateLastBackupAt(site string, t time.Time) error {
@@ -111,44 +907,81 @@ func (d *DB) UpdateLastBackupAt(site string, t time.Time) error {
 }
 
 func (d *DB) GetSite(site string) (*Site, error) {
-	var s Site
-	var lastBackup sql.NullTime
-	err := d.conn.QueryRow(`
-        SELECT site, domain, COALESCE(custom_domain,''), status, COALESCE(job_id,''), created_at, updated_at, last_backup_at
-        FROM sites WHERE site=?
-    `, site).Scan(&s.Site, &s.Domain, &s.CustomDomain, &s.Status, &s.JobID, &s.CreatedAt, &s.UpdatedAt, &lastBackup)
+	return scanSite(d.conn.QueryRow(`SELECT `+siteColumns+` FROM sites WHERE site=?`, site))
+}
+
+func (d *DB) ListSites() ([]Site, error) {
+	rows, err := d.conn.Query(`SELECT ` + siteColumns + ` FROM sites ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
-	if lastBackup.Valid {
-		s.LastBackupAt = &lastBackup.Time
+	defer rows.Close()
+
+	var sites []Site
+	for rows.Next() {
+		s, err := scanSite(rows)
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, *s)
 	}
-	return &s, nil
+	return sites, nil
 }
 
-func (d *DB) ListSites() ([]Site, error) {
+// Kinds of hostname in ListDomains.
+const (
+	DomainKindDefault      = "default"       // <site>.<base domain>, or the base domain of a path-routed site
+	DomainKindCustom       = "custom"        // the site's custom domain
+	DomainKindWWW          = "www"           // www.<custom domain>, redirected or served
+	DomainKindGreenPreview = "green_preview" // <site>-green.<base domain> while a green set exists
+)
+
+// DomainEntry is one hostname the platform serves, with the site it routes
+// to.
+type DomainEntry struct {
+	Domain     string `json:"domain"`
+	Kind       string `json:"kind"`
+	Site       string `json:"site"`
+	Status     string `json:"status"`
+	PathPrefix string `json:"path_prefix,omitempty"` // path-routed sites share their base domain
+}
+
+// ListDomains returns every hostname routed to a site — default domains,
+// custom domains with their www variants and green preview domains —
+// ordered by hostname. DESTROYED sites are left out unless withDestroyed.
+// fallbackBaseDomain stands in for rows without a base_domain (see
+// SiteBaseDomain).
+func (d *DB) ListDomains(fallbackBaseDomain string, withDestroyed bool) ([]DomainEntry, error) {
 	rows, err := d.conn.Query(`
-        SELECT site, domain, COALESCE(custom_domain,''), status, COALESCE(job_id,''), created_at, updated_at, last_backup_at
-        FROM sites ORDER BY created_at DESC
-    `)
+        SELECT domain, kind, site, status, path_prefix FROM (
+            SELECT domain, 'default' AS kind, site, status, COALESCE(path_prefix,'') AS path_prefix FROM sites
+            UNION ALL
+            SELECT custom_domain, 'custom', site, status, '' FROM sites
+            WHERE COALESCE(custom_domain,'') != ''
+            UNION ALL
+            SELECT CONCAT('www.', custom_domain), 'www', site, status, '' FROM sites
+            WHERE COALESCE(custom_domain,'') != '' AND www_mode IS NOT NULL
+            UNION ALL
+            SELECT CONCAT(site, '-green.', COALESCE(base_domain, ?)), 'green_preview', site, status, '' FROM sites
+            WHERE green_resources IS NOT NULL
+        ) d
+        WHERE ? OR status != 'DESTROYED'
+        ORDER BY domain, site
+    `, fallbackBaseDomain, withDestroyed)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var sites []Site
+	domains := []DomainEntry{}
 	for rows.Next() {
-		var s Site
-		var lastBackup sql.NullTime
-		if err := rows.Scan(&s.Site, &s.Domain, &s.CustomDomain, &s.Status, &s.JobID, &s.CreatedAt, &s.UpdatedAt, &lastBackup); err != nil {
+		var e DomainEntry
+		if err := rows.Scan(&e.Domain, &e.Kind, &e.Site, &e.Status, &e.PathPrefix); err != nil {
 			return nil, err
 		}
-		if lastBackup.Valid {
-			s.LastBackupAt = &lastBackup.Time
-		}
-		sites = append(sites, s)
+		domains = append(domains, e)
 	}
-	return sites, nil
+	return domains, rows.Err()
 }
 
 type Job struct {
@@ -159,12 +992,36 @@ type Job struct {
 	Attempts    int
 	MaxAttempts int
 	Error       *string
+	ErrorCode   string  // set on FAILED jobs; see jobErrorCode
+	RequestID   string  // X-Request-ID of the API call that queued the job
+	Result      *string // JSON reported by the job (e.g. a provision's ProvisionResult)
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	StartedAt   *time.Time
 	CompletedAt *time.Time
 }
 
+// QueueWait is how long the job waited before its latest attempt started
+// (started_at is reset on each attempt, so retries count as waiting).
+// Returns nil until the job has started.
+func (j *Job) QueueWait() *time.Duration {
+	if j.StartedAt == nil {
+		return nil
+	}
+	d := j.StartedAt.Sub(j.CreatedAt)
+	return &d
+}
+
+// Duration is the run time of the job's final attempt. Returns nil until the
+// job has completed.
+func (j *Job) Duration() *time.Duration {
+	if j.StartedAt == nil || j.CompletedAt == nil {
+		return nil
+	}
+	d := j.CompletedAt.Sub(*j.StartedAt)
+	return &d
+}
+
 type DB struct {
 	conn *sql.DB
 }
@@ -183,27 +1040,116 @@ func NewDB(dsn string) (*DB, error) {
 	return &DB{conn: conn}, nil
 }
 
-// InsertJob writes a new PENDING job and returns its ID
-func (d *DB) InsertJob(id string, jobType JobType, site string) error {
-	_, err := d.conn.Exec(`
-        INSERT INTO jobs (id, type, site, status, attempts, max_attempts)
-        VALUES (?, ?, ?, 'PENDING', 0, 3)
-    `, id, jobType, site)
+// InsertJob writes a new PENDING job and returns its ID.
+// requestID is the correlation ID of the originating API call.
+func (d *DB) InsertJob(id string, jobType JobType, site, requestID string) error {
+	return d.InsertJobWithPayload(id, jobType, site, requestID, "")
+}
+
+const insertJobSQL = `
+        INSERT INTO jobs (id, type, site, status, attempts, max_attempts, request_id, payload)
+        VALUES (?, ?, ?, 'PENDING', 0, 3, NULLIF(?, ''), NULLIF(?, ''))
+    `
+
+// InsertJobWithPayload writes a new PENDING job with its payload in the same
+// statement, so the worker can never claim it before the payload is present.
+func (d *DB) InsertJobWithPayload(id string, jobType JobType, site, requestID, payload string) error {
+	_, err := d.conn.Exec(insertJobSQL, id, jobType, site, requestID, payload)
 	return err
 }
 
-// InsertSite creates or updates the site record
-func (d *DB) UpsertSite(site, domain, status, jobID string) error {
-	_, err := d.conn.Exec(`
-        INSERT INTO sites (site, domain, status, job_id)
-        VALUES (?, ?, ?, ?)
-        ON DUPLICATE KEY UPDATE status=VALUES(status), job_id=VALUES(job_id), updated_at=NOW()
-    `, site, domain, status, jobID)
+const upsertSiteSQL = `
+        INSERT INTO sites (site, domain, status, job_id, type)
+        VALUES (?, ?, ?, ?, NULLIF(?, ''))
+        ON DUPLICATE KEY UPDATE status=VALUES(status), job_id=VALUES(job_id),
+            type=COALESCE(VALUES(type), type), caddy_manual=FALSE, caddy_log=NULL,
+            error_page=NULL, error_page_html=NULL, updated_at=NOW()
+    `
+
+// InsertSite creates or updates the site record. siteType is written when
+// non-empty; pass "" (e.g. for destroy) to keep the stored type. Both
+// provisioning and destroy replace the Caddy snippet, so any manual-edit
+// flag, request logging and error page are cleared.
+func (d *DB) UpsertSite(site, domain, status, jobID string, siteType SiteType) error {
+	_, err := d.conn.Exec(upsertSiteSQL, site, domain, status, jobID, string(siteType))
 	return err
 }
 
-// UpdateSiteStatus updates just the status of a site
+// SiteProvision is what the API records about a site when it queues the job
+// that provisions it (see QueueProvision). Empty and zero fields are stored
+// as NULL, so a slug provisioned again inherits nothing from its last site.
+type SiteProvision struct {
+	Site, Domain, PathPrefix, BaseDomain string
+	Type                                 SiteType
+	Tenant                               string
+
+	VolumeDriver, VolumeSize string
+	ExternalVolume           string // pre-existing volume the site is provisioned onto; never deleted
+
+	NginxSnippet   string
+	RestartPolicy  string
+	NginxResources NginxResources
+	WPConfigExtra  string
+	FastCGI        FastCGISettings
+	FPM            FPMPool
+	ObjectCache    string
+	Network        string
+	Egress         string
+}
+
+// QueueProvision records p's site as PROVISIONING with all of p's attributes
+// and queues its job, in one transaction: the worker never sees the job
+// without a site record that matches it, and a failed write queues nothing.
+func (d *DB) QueueProvision(p SiteProvision, jobID string, jobType JobType, requestID, payload string) error {
+	tx, err := d.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(upsertSiteSQL, p.Site, p.Domain, string(SiteProvisioning), jobID, string(p.Type)); err != nil {
+		return fmt.Errorf("record site: %w", err)
+	}
+	r, f, fpm := p.NginxResources, p.FastCGI, p.FPM
+	if _, err := tx.Exec(`
+        UPDATE sites
+        SET domain=?, path_prefix=NULLIF(?, ''), base_domain=?, tenant=?,
+            volume_driver=NULLIF(?, ''), volume_size=NULLIF(?, ''), external_volume=NULLIF(?, ''),
+            nginx_snippet=NULLIF(?, ''), restart_policy=NULLIF(?, ''),
+            nginx_memory_mb=NULLIF(?, 0), nginx_cpu_millis=NULLIF(?, 0), nginx_pids=NULLIF(?, 0),
+            wp_config_extra=NULLIF(?, ''),
+            fastcgi_read_timeout=NULLIF(?, 0), fastcgi_send_timeout=NULLIF(?, 0), fastcgi_buffer_kb=NULLIF(?, 0),
+            fpm_plan=NULLIF(?, ''), fpm_pm=NULLIF(?, ''), fpm_max_children=NULLIF(?, 0), fpm_start_servers=NULLIF(?, 0),
+            fpm_min_spare_servers=NULLIF(?, 0), fpm_max_spare_servers=NULLIF(?, 0), fpm_max_requests=NULLIF(?, 0),
+            object_cache=NULLIF(?, ''), network=NULLIF(?, ''), egress=NULLIF(?, ''), updated_at=NOW()
+        WHERE site=?
+    `, p.Domain, p.PathPrefix, p.BaseDomain, p.Tenant,
+		p.VolumeDriver, p.VolumeSize, p.ExternalVolume,
+		p.NginxSnippet, p.RestartPolicy,
+		r.MemoryMB, r.CPUMillis, r.Pids,
+		p.WPConfigExtra,
+		f.ReadTimeoutSec, f.SendTimeoutSec, f.BufferSizeKB,
+		fpm.Plan, fpm.PM, fpm.MaxChildren, fpm.StartServers,
+		fpm.MinSpareServers, fpm.MaxSpareServers, fpm.MaxRequests,
+		p.ObjectCache, p.Network, p.Egress, p.Site); err != nil {
+		return fmt.Errorf("record site attributes: %w", err)
+	}
+	if _, err := tx.Exec(insertJobSQL, jobID, jobType, p.Site, requestID, payload); err != nil {
+		return fmt.Errorf("queue job: %w", err)
+	}
+	return tx.Commit()
+}
+
+// UpdateSiteStatus updates just the status of a site. Actual changes are
+// also appended to the site's history (best effort).
 func (d *DB) UpdateSiteStatus(site, status string) error {
+	if _, err := d.conn.Exec(`
+        INSERT INTO site_history (site, kind, detail)
+        SELECT site, 'status', CONCAT(status, ' -> ', ?) FROM sites WHERE site=? AND status != ?
+    `, status, site, status); err != nil {
+		log.Printf("[db] site=%s warning: status history not recorded: %v", site, err)
+	}
+
 	_, err := d.conn.Exec(`
         UPDATE sites SET status=?, updated_at=NOW() WHERE site=?
     `, status, site)
@@ -220,8 +1166,33 @@ func (d *DB) HasActiveJob(site string) (bool, error) {
 	return count > 0, err
 }
 
-// ClaimNextJob atomically claims the next PENDING job using FOR UPDATE SKIP LOCKED
-func (d *DB) ClaimNextJob() (*Job, error) {
+// Job claim policies (JOB_CLAIM_POLICY).
+const (
+	ClaimFIFO = "fifo" // oldest pending job first
+	ClaimFair = "fair" // round-robin across sites, see ClaimNextJob
+)
+
+// claimOrder is the ORDER BY of ClaimNextJob per policy. The fair order
+// first skips sites that already have a job PROCESSING, then prefers the
+// site whose last job started longest ago (never-served sites first, as
+// MySQL sorts NULL first), and only then falls back to age. A site with a
+// burst of jobs therefore gets one turn per round instead of the whole
+// queue. The subqueries take no locks.
+var claimOrder = map[string]string{
+	ClaimFIFO: `j.created_at ASC`,
+	ClaimFair: `EXISTS (SELECT 1 FROM jobs p WHERE p.site = j.site AND p.status = 'PROCESSING') ASC,
+                 (SELECT MAX(s.started_at) FROM jobs s WHERE s.site = j.site) ASC,
+                 j.created_at ASC`,
+}
+
+// ClaimNextJob atomically claims the next PENDING job using FOR UPDATE SKIP
+// LOCKED, in the order of policy (ClaimFIFO or ClaimFair).
+func (d *DB) ClaimNextJob(policy string) (*Job, error) {
+	order, ok := claimOrder[policy]
+	if !ok {
+		order = claimOrder[ClaimFIFO]
+	}
+
 	tx, err := d.conn.Begin()
 	if err != nil {
 		return nil, err
@@ -229,16 +1200,16 @@ func (d *DB) ClaimNextJob() (*Job, error) {
 	defer tx.Rollback()
 
 	row := tx.QueryRow(`
-        SELECT id, type, site, attempts, max_attempts
-        FROM jobs
-        WHERE status='PENDING' AND attempts < max_attempts
-        ORDER BY created_at ASC
+        SELECT j.id, j.type, j.site, j.attempts, j.max_attempts, COALESCE(j.request_id,'')
+        FROM jobs j
+        WHERE j.status='PENDING' AND j.attempts < j.max_attempts
+        ORDER BY ` + order + `
         LIMIT 1
         FOR UPDATE SKIP LOCKED
     `)
 
 	var job Job
-	err = row.Scan(&job.ID, &job.Type, &job.Site, &job.Attempts, &job.MaxAttempts)
+	err = row.Scan(&job.ID, &job.Type, &job.Site, &job.Attempts, &job.MaxAttempts, &job.RequestID)
 	if err == sql.ErrNoRows {
 		return nil, nil // nothing to do
 	}
@@ -258,8 +1229,9 @@ func (d *DB) ClaimNextJob() (*Job, error) {
 	return &job, tx.Commit()
 }
 
-// CompleteJob marks job and site as done
-func (d *DB) CompleteJob(jobID, site string, jobType JobType) error {
+// CompleteJob marks job and site as done. status is the site's final
+// status when the job decided one ("" = ACTIVE, or DESTROYED for a destroy).
+func (d *DB) CompleteJob(jobID, site string, jobType JobType, status SiteStatus) error {
 	_, err := d.conn.Exec(`
         UPDATE jobs
         SET status='COMPLETED', completed_at=NOW(), updated_at=NOW(), error=NULL
@@ -269,25 +1241,68 @@ func (d *DB) CompleteJob(jobID, site string, jobType JobType) error {
 		return err
 	}
 
-	finalSiteStatus := "ACTIVE"
+	// A redeploy, subdomain change or blue/green step leaves the site
+	// serving throughout; keep its status (ACTIVE or DOMAIN_ACTIVE) as it was.
+	if jobType.KeepsSiteStatus() {
+		return nil
+	}
+
+	finalSiteStatus := SiteActive
 	if jobType == JobDestroy {
-		finalSiteStatus = "DESTROYED"
+		finalSiteStatus = SiteDestroyed
+	}
+	if status != "" {
+		finalSiteStatus = status
+	}
+	// Every blue/green set went with the site, so a slug provisioned again
+	// is back on its plain name (see SiteResources).
+	if finalSiteStatus == SiteDestroyed {
+		if _, err := d.conn.Exec(`
+            UPDATE sites SET resource_name=NULL, green_resources=NULL, retired_resources=NULL, retire_after=NULL, updated_at=NOW()
+            WHERE site=?
+        `, site); err != nil {
+			return err
+		}
 	}
-	return d.UpdateSiteStatus(site, finalSiteStatus)
+	return d.UpdateSiteStatus(site, string(finalSiteStatus))
 }
 
 // GetJob fetches a job by ID for status polling
 func (d *DB) GetJob(id string) (*Job, error) {
+	return scanJob(d.conn.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id=?`, id))
+}
+
+// ListSiteJobs returns every job recorded for a site, oldest first.
+func (d *DB) ListSiteJobs(site string) ([]Job, error) {
+	rows, err := d.conn.Query(`SELECT `+jobColumns+` FROM jobs WHERE site=? ORDER BY created_at ASC`, site)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// jobColumns is the column list shared by every query that scans a Job via scanJob.
+const jobColumns = `id, type, site, status, attempts, max_attempts, error, COALESCE(error_code,''), COALESCE(request_id,''), result, created_at, updated_at, started_at, completed_at`
+
+// scanJob scans one row selected with jobColumns.
+func scanJob(row rowScanner) (*Job, error) {
 	var job Job
-	var errStr sql.NullString
+	var errStr, result sql.NullString
 	var startedAt, completedAt sql.NullTime
 
-	err := d.conn.QueryRow(`
-        SELECT id, type, site, status, attempts, max_attempts, error, created_at, updated_at, started_at, completed_at
-        FROM jobs WHERE id=?
-    `, id).Scan(
+	err := row.Scan(
 		&job.ID, &job.Type, &job.Site, &job.Status,
-		&job.Attempts, &job.MaxAttempts, &errStr,
+		&job.Attempts, &job.MaxAttempts, &errStr, &job.ErrorCode, &job.RequestID, &result,
 		&job.CreatedAt, &job.UpdatedAt, &startedAt, &completedAt,
 	)
 	if err != nil {
@@ -297,6 +1312,9 @@ func (d *DB) GetJob(id string) (*Job, error) {
 	if errStr.Valid {
 		job.Error = &errStr.String
 	}
+	if result.Valid {
+		job.Result = &result.String
+	}
 	if startedAt.Valid {
 		job.StartedAt = &startedAt.Time
 	}
@@ -307,20 +1325,117 @@ func (d *DB) GetJob(id string) (*Job, error) {
 	return &job, nil
 }
 
-// RecoverStuckJobs resets PROCESSING jobs that have been running too long (called on startup)
-func (d *DB) RecoverStuckJobs(timeoutMinutes int) (int64, error) {
+// stuckJobCondition is the WHERE condition matching PROCESSING jobs that have
+// been running longer than their type's limit from timeouts; types not in
+// the map use defaultMinutes.
+func stuckJobCondition(timeouts map[JobType]int, defaultMinutes int) (string, []any) {
+	var caseExpr strings.Builder
+	var args []any
+	caseExpr.WriteString("CASE type")
+	for jobType, minutes := range timeouts {
+		caseExpr.WriteString(" WHEN ? THEN ?")
+		args = append(args, string(jobType), minutes)
+	}
+	caseExpr.WriteString(" ELSE ? END")
+	args = append(args, defaultMinutes)
+	return `status='PROCESSING' AND started_at < NOW() - INTERVAL (` + caseExpr.String() + `) MINUTE`, args
+}
+
+// RecoverStuckJobs resets PROCESSING jobs that have been running too long (called on startup).
+// Each row is judged against its own type's limit from timeouts; types not in
+// the map use defaultMinutes.
+func (d *DB) RecoverStuckJobs(timeouts map[JobType]int, defaultMinutes int) (int64, error) {
+	cond, args := stuckJobCondition(timeouts, defaultMinutes)
 	res, err := d.conn.Exec(`
         UPDATE jobs
         SET status='PENDING', error='recovered: was stuck in PROCESSING', updated_at=NOW()
-        WHERE status='PROCESSING'
-        AND started_at < NOW() - INTERVAL ? MINUTE
-    `, timeoutMinutes)
+        WHERE `+cond, args...)
 	if err != nil {
 		return 0, err
 	}
 	return res.RowsAffected()
 }
 
+// ListStuckJobs returns the jobs RecoverStuckJobs would reset, oldest first.
+func (d *DB) ListStuckJobs(timeouts map[JobType]int, defaultMinutes int) ([]Job, error) {
+	cond, args := stuckJobCondition(timeouts, defaultMinutes)
+	rows, err := d.conn.Query(`SELECT `+jobColumns+` FROM jobs WHERE `+cond+` ORDER BY started_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// FailedJobError is a FAILED job's error, with the names of its site that
+// normalizeJobError strips from it.
+type FailedJobError struct {
+	JobID     string
+	Type      JobType
+	Site      string
+	Domains   []string // the site's default and custom domain, when it still exists
+	Error     string
+	ErrorCode string
+	FailedAt  time.Time
+}
+
+// ListFailedJobErrors returns the errors of the jobs that FAILED within
+// since, newest first; only those of jobType unless it is "".
+func (d *DB) ListFailedJobErrors(since time.Duration, jobType JobType) ([]FailedJobError, error) {
+	rows, err := d.conn.Query(`
+		SELECT j.id, j.type, j.site, COALESCE(j.error,''), COALESCE(j.error_code,''), j.updated_at,
+		       COALESCE(s.domain,''), COALESCE(s.custom_domain,'')
+		FROM jobs j LEFT JOIN sites s ON s.site = j.site
+		WHERE j.status='FAILED' AND j.updated_at > NOW() - INTERVAL ? SECOND
+		  AND (? = '' OR j.type = ?)
+		ORDER BY j.updated_at DESC
+	`, int64(since.Seconds()), string(jobType), string(jobType))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []FailedJobError
+	for rows.Next() {
+		var f FailedJobError
+		var domain, customDomain string
+		if err := rows.Scan(&f.JobID, &f.Type, &f.Site, &f.Error, &f.ErrorCode, &f.FailedAt, &domain, &customDomain); err != nil {
+			return nil, err
+		}
+		for _, d := range []string{domain, customDomain} {
+			if d != "" {
+				f.Domains = append(f.Domains, d)
+			}
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// RecoverJob is the single-job RecoverStuckJobs: it resets jobID to PENDING
+// only if it is PROCESSING past its limit, and reports whether it did.
+func (d *DB) RecoverJob(jobID string, timeouts map[JobType]int, defaultMinutes int) (bool, error) {
+	cond, args := stuckJobCondition(timeouts, defaultMinutes)
+	res, err := d.conn.Exec(`
+        UPDATE jobs
+        SET status='PENDING', error='recovered: was stuck in PROCESSING', updated_at=NOW()
+        WHERE id=? AND `+cond, append([]any{jobID}, args...)...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
 // RetryJob puts a PROCESSING job back to PENDING for the next poll cycle to pick up
 func (d *DB) RetryJob(jobID string, jobErr error) error {
 	msg := fmt.Sprintf("attempt failed: %s", jobErr.Error())
@@ -332,6 +1447,81 @@ func (d *DB) RetryJob(jobID string, jobErr error) error {
 	return err
 }
 
+// RequeueJob puts a job interrupted by shutdown back to PENDING and gives
+// back the attempt ClaimNextJob counted, so a job cut short on its last
+// attempt can still be claimed again.
+func (d *DB) RequeueJob(jobID string, jobErr error) error {
+	msg := fmt.Sprintf("attempt interrupted: %s", jobErr.Error())
+	_, err := d.conn.Exec(`
+		UPDATE jobs
+		SET status='PENDING', attempts=GREATEST(attempts-1, 0), error=?, updated_at=NOW()
+		WHERE id=?
+	`, msg, jobID)
+	return err
+}
+
+// ResetFailedJob puts a FAILED job back to PENDING with a fresh attempt budget
+// and no error, so the worker runs it again from scratch. The status guard in
+// the WHERE clause makes concurrent retries of the same job a no-op; reset
+// reports whether this call performed the transition.
+func (d *DB) ResetFailedJob(jobID string) (reset bool, err error) {
+	res, err := d.conn.Exec(`
+		UPDATE jobs
+		SET status='PENDING', attempts=0, error=NULL, error_code=NULL, started_at=NULL, completed_at=NULL, updated_at=NOW()
+		WHERE id=? AND status='FAILED'
+	`, jobID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// jobPurgeFloor is the youngest a terminal job can be and still be purged:
+// clients may still be polling it, and quotas and the job timing stats
+// count the last day's jobs.
+const jobPurgeFloor = 24 * time.Hour
+
+// jobPurgeBatch is how many jobs one DELETE of PurgeJobs removes, so a large
+// purge never holds the jobs table locked for long.
+const jobPurgeBatch = 500
+
+// jobPurgeCondition selects the jobs in status last updated more than age
+// ago, except a site's current job, which its status still points at.
+const jobPurgeCondition = `status=? AND updated_at < NOW() - INTERVAL ? SECOND
+	AND NOT EXISTS (SELECT 1 FROM sites s WHERE s.job_id = jobs.id)`
+
+// CountPurgeableJobs counts the jobs PurgeJobs would delete.
+func (d *DB) CountPurgeableJobs(status JobStatus, age time.Duration) (int64, error) {
+	var n int64
+	err := d.conn.QueryRow(`SELECT COUNT(*) FROM jobs WHERE `+jobPurgeCondition,
+		status, int64(max(age, jobPurgeFloor).Seconds())).Scan(&n)
+	return n, err
+}
+
+// PurgeJobs deletes jobs in a terminal status that are older than age
+// (never younger than jobPurgeFloor), jobPurgeBatch at a time, and returns
+// how many it removed. Deleted batches stay deleted if a later one fails.
+func (d *DB) PurgeJobs(status JobStatus, age time.Duration) (int64, error) {
+	if status != StatusCompleted && status != StatusFailed {
+		return 0, fmt.Errorf("cannot purge %s jobs", status)
+	}
+	seconds := int64(max(age, jobPurgeFloor).Seconds())
+	var total int64
+	for {
+		res, err := d.conn.Exec(`DELETE FROM jobs WHERE `+jobPurgeCondition+` ORDER BY updated_at LIMIT ?`,
+			status, seconds, jobPurgeBatch)
+		if err != nil {
+			return total, err
+		}
+		n, err := res.RowsAffected()
+		total += n
+		if err != nil || n < jobPurgeBatch {
+			return total, err
+		}
+	}
+}
+
 // TransitionSite validates and performs a state transition using the lifecycle state machine.
 // Returns an error if the transition is not allowed.
 func (d *DB) TransitionSite(site string, to SiteStatus) error {
@@ -346,20 +1536,51 @@ func (d *DB) TransitionSite(site string, to SiteStatus) error {
 	return d.UpdateSiteStatus(site, string(to))
 }
 
-// EnsureDomainAvailable checks that no other active site has claimed this custom domain.
+// EnsureDomainAvailable checks that no other active site has claimed this
+// custom domain, directly or as the www variant of its own, and that it is
+// not the default domain of any active site, excludeSite included. The
+// stored domain is checked rather than relying on ValidateDomainNotBase, so
+// sites under a base domain that has since been removed from BASE_DOMAINS
+// cannot be shadowed either.
 func (d *DB) EnsureDomainAvailable(domain, excludeSite string) error {
-	var count int
+	var owner string
+	var isDefault bool
 	err := d.conn.QueryRow(`
-		SELECT COUNT(*) FROM sites
-		WHERE custom_domain=? AND site!=? AND status NOT IN ('DESTROYED','FAILED')
-	`, domain, excludeSite).Scan(&count)
+		SELECT site, domain=? FROM sites
+		WHERE (domain=?
+		       OR ((custom_domain=? OR (www_mode IS NOT NULL AND CONCAT('www.', custom_domain)=?)) AND site!=?))
+		  AND status NOT IN ('DESTROYED','FAILED')
+		ORDER BY domain=? DESC
+		LIMIT 1
+	`, domain, domain, domain, domain, excludeSite, domain).Scan(&owner, &isDefault)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("check domain availability: %w", err)
 	}
-	if count > 0 {
-		return fmt.Errorf("domain %s is already claimed by another site", domain)
+	if isDefault {
+		return fmt.Errorf("domain %s is the default domain of site %s", domain, owner)
 	}
-	return nil
+	return fmt.Errorf("domain %s is already claimed by another site", domain)
+}
+
+// EnsureSiteDomainAvailable checks that no other live site is served at
+// domain, as its assigned domain or as its custom domain.
+func (d *DB) EnsureSiteDomainAvailable(domain, excludeSite string) error {
+	var owner string
+	err := d.conn.QueryRow(`
+		SELECT site FROM sites
+		WHERE (domain=? OR custom_domain=?) AND site!=? AND status NOT IN ('DESTROYED','FAILED')
+		LIMIT 1
+	`, domain, domain, excludeSite).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check site domain availability: %w", err)
+	}
+	return fmt.Errorf("domain %s is already in use by site %s", domain, owner)
 }
 
 // ListCustomDomains returns all active custom domain values from the sites table.
@@ -384,3 +1605,173 @@ func (d *DB) ListCustomDomains() ([]string, error) {
 	}
 	return domains, nil
 }
+
+// PlatformSummary is the aggregate view returned by GET /api/stats.
+type PlatformSummary struct {
+	SitesByStatus      map[string]int `json:"sites_by_status"`
+	JobsByStatus       map[string]int `json:"jobs_by_status"`
+	RecentJobsFinished int            `json:"recent_jobs_finished"` // COMPLETED + FAILED in the last 24 h
+	RecentSuccessRate  float64        `json:"recent_success_rate"`  // 0..1; 0 when nothing finished
+	QueueWaitMs        *Histogram     `json:"queue_wait_ms"`        // created → started, COMPLETED jobs in the last 24 h
+	DurationMs         *Histogram     `json:"duration_ms"`          // started → completed (final attempt), same jobs
+}
+
+// jobTimingBucketsMs are the upper bounds for job timing histograms, from
+// "picked up almost immediately" to "ran for half an hour".
+var jobTimingBucketsMs = []int64{1000, 5000, 15000, 30000, 60000, 120000, 300000, 600000, 1800000}
+
+// HistogramBucket counts observations <= LeMs (cumulative, Prometheus-style).
+type HistogramBucket struct {
+	LeMs  int64 `json:"le_ms"`
+	Count int   `json:"count"`
+}
+
+// Histogram is a cumulative histogram of millisecond observations. Count is
+// the +Inf bucket.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Count   int               `json:"count"`
+	SumMs   int64             `json:"sum_ms"`
+}
+
+func newHistogram(bounds []int64) *Histogram {
+	h := &Histogram{Buckets: make([]HistogramBucket, len(bounds))}
+	for i, le := range bounds {
+		h.Buckets[i].LeMs = le
+	}
+	return h
+}
+
+func (h *Histogram) observe(ms int64) {
+	h.Count++
+	h.SumMs += ms
+	for i := range h.Buckets {
+		if ms <= h.Buckets[i].LeMs {
+			h.Buckets[i].Count++
+		}
+	}
+}
+
+// Summary returns platform-wide counts for the ops dashboard.
+func (d *DB) Summary() (*PlatformSummary, error) {
+	sum := &PlatformSummary{}
+
+	var err error
+	if sum.SitesByStatus, err = d.countByStatus(`SELECT status, COUNT(*) FROM sites GROUP BY status`); err != nil {
+		return nil, fmt.Errorf("count sites: %w", err)
+	}
+	if sum.JobsByStatus, err = d.countByStatus(`SELECT status, COUNT(*) FROM jobs GROUP BY status`); err != nil {
+		return nil, fmt.Errorf("count jobs: %w", err)
+	}
+
+	var completed, failed int
+	err = d.conn.QueryRow(`
+		SELECT
+			COALESCE(SUM(status='COMPLETED'), 0),
+			COALESCE(SUM(status='FAILED'), 0)
+		FROM jobs
+		WHERE status IN ('COMPLETED','FAILED') AND updated_at > NOW() - INTERVAL 24 HOUR
+	`).Scan(&completed, &failed)
+	if err != nil {
+		return nil, fmt.Errorf("recent job outcomes: %w", err)
+	}
+	sum.RecentJobsFinished = completed + failed
+	if sum.RecentJobsFinished > 0 {
+		sum.RecentSuccessRate = float64(completed) / float64(sum.RecentJobsFinished)
+	}
+
+	if sum.QueueWaitMs, sum.DurationMs, err = d.recentJobTimings(); err != nil {
+		return nil, fmt.Errorf("job timings: %w", err)
+	}
+	return sum, nil
+}
+
+// recentJobTimings builds queue-wait and run-duration histograms over jobs
+// COMPLETED in the last 24 h.
+func (d *DB) recentJobTimings() (queueWait, duration *Histogram, err error) {
+	rows, err := d.conn.Query(`
+		SELECT
+			TIMESTAMPDIFF(MICROSECOND, created_at, started_at) DIV 1000,
+			TIMESTAMPDIFF(MICROSECOND, started_at, completed_at) DIV 1000
+		FROM jobs
+		WHERE status='COMPLETED' AND started_at IS NOT NULL AND completed_at IS NOT NULL
+		  AND completed_at > NOW() - INTERVAL 24 HOUR
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	queueWait = newHistogram(jobTimingBucketsMs)
+	duration = newHistogram(jobTimingBucketsMs)
+	for rows.Next() {
+		var waitMs, runMs int64
+		if err := rows.Scan(&waitMs, &runMs); err != nil {
+			return nil, nil, err
+		}
+		queueWait.observe(waitMs)
+		duration.observe(runMs)
+	}
+	return queueWait, duration, rows.Err()
+}
+
+// countByStatus runs a two-column (status, count) query into a map.
+func (d *DB) countByStatus(query string) (map[string]int, error) {
+	rows, err := d.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// UpsertTemplate registers (or re-points) a WordPress template.
+func (d *DB) UpsertTemplate(t SiteTemplate) error {
+	_, err := d.conn.Exec(`
+		INSERT INTO templates (name, volume, db_name, domain)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE volume=VALUES(volume), db_name=VALUES(db_name), domain=VALUES(domain)
+	`, t.Name, t.Volume, t.Database, t.Domain)
+	return err
+}
+
+// GetTemplate fetches a template by name. Returns sql.ErrNoRows if unknown.
+func (d *DB) GetTemplate(name string) (*SiteTemplate, error) {
+	var t SiteTemplate
+	err := d.conn.QueryRow(`
+		SELECT name, volume, db_name, domain, created_at FROM templates WHERE name=?
+	`, name).Scan(&t.Name, &t.Volume, &t.Database, &t.Domain, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListTemplates returns all registered templates ordered by name.
+func (d *DB) ListTemplates() ([]SiteTemplate, error) {
+	rows, err := d.conn.Query(`SELECT name, volume, db_name, domain, created_at FROM templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []SiteTemplate
+	for rows.Next() {
+		var t SiteTemplate
+		if err := rows.Scan(&t.Name, &t.Volume, &t.Database, &t.Domain, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// insertTestJob queues a job for site and removes it when the test ends.
+func insertTestJob(t *testing.T, db *DB, jobType JobType, site string) string {
+	t.Helper()
+	id := uuid.NewString()
+	if err := db.InsertJob(id, jobType, site, ""); err != nil {
+		t.Fatalf("InsertJob: %v", err)
+	}
+	t.Cleanup(func() { db.conn.Exec(`DELETE FROM jobs WHERE id=?`, id) })
+	return id
+}
+
+// insertTestSite records an ACTIVE site served at domain and removes it when
+// the test ends.
+func insertTestSite(t *testing.T, db *DB, site, domain string) {
+	t.Helper()
+	if err := db.UpsertSite(site, domain, string(SiteActive), "", SiteTypeWordPress); err != nil {
+		t.Fatalf("UpsertSite: %v", err)
+	}
+	t.Cleanup(func() {
+		db.conn.Exec(`DELETE FROM site_history WHERE site=?`, site)
+		db.conn.Exec(`DELETE FROM sites WHERE site=?`, site)
+	})
+}
+
+func TestRequeueJobGivesBackAttempt(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	id := insertTestJob(t, db, JobProvision, testSiteName())
+
+	// Claimed on its last attempt, then interrupted by shutdown.
+	if _, err := db.conn.Exec(`UPDATE jobs SET status='PROCESSING', attempts=max_attempts WHERE id=?`, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RequeueJob(id, errors.New("context canceled")); err != nil {
+		t.Fatalf("RequeueJob: %v", err)
+	}
+
+	job, err := db.GetJob(id)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.Status != StatusPending {
+		t.Errorf("status = %s, want PENDING", job.Status)
+	}
+	if job.Attempts >= job.MaxAttempts {
+		t.Errorf("attempts = %d of %d, want one left so the job can be claimed again", job.Attempts, job.MaxAttempts)
+	}
+}
+
+// Domains that differ only in case (or a trailing dot) are the same name to
+// DNS, Caddy and nginx, so once normalized they must collide.
+func TestEnsureDomainAvailableIgnoresCase(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	owner, other := testSiteName(), testSiteName()
+	claimed := owner + "-shop.example.com"
+	insertTestSite(t, db, owner, owner+".sites.example.net")
+	if err := db.SetCustomDomain(owner, claimed, WWWRedirect); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, variant := range []string{
+		strings.ToUpper(claimed),
+		strings.ToUpper(claimed[:1]) + claimed[1:] + ".",
+		"WWW." + strings.ToUpper(claimed),
+	} {
+		domain, err := NormalizeDomain(variant)
+		if err != nil {
+			t.Fatalf("NormalizeDomain(%q): %v", variant, err)
+		}
+		err = db.EnsureDomainAvailable(domain, other)
+		if err == nil || !strings.Contains(err.Error(), "already claimed") {
+			t.Errorf("EnsureDomainAvailable(%q) = %v, want it claimed by %s", variant, err, owner)
+		}
+	}
+}
+
+// A site that queues a burst of jobs gets one turn per round under the fair
+// policy, instead of holding the worker until its burst is drained.
+func TestClaimNextJobFairInterleavesBurst(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	var pending int
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM jobs WHERE status='PENDING'`).Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if pending > 0 {
+		t.Skipf("%d pending jobs in the control DB would be claimed by this test", pending)
+	}
+
+	bursty, b, c := testSiteName(), testSiteName(), testSiteName()
+	queue := []string{bursty, bursty, bursty, bursty, b, c} // in created_at order
+	label := map[string]string{bursty: "A", b: "B", c: "C"}
+	base := time.Now().Add(-time.Hour)
+	for i, site := range queue {
+		id := insertTestJob(t, db, JobBackup, site)
+		if _, err := db.conn.Exec(`UPDATE jobs SET created_at=? WHERE id=?`, base.Add(time.Duration(i)*time.Minute), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{ClaimFair, "ABCAAA"},
+		{ClaimFIFO, "AAAABC"},
+	}
+	for _, tt := range tests {
+		// Put every job back to PENDING, never started.
+		if _, err := db.conn.Exec(`UPDATE jobs SET status='PENDING', attempts=0, started_at=NULL WHERE site IN (?, ?, ?)`, bursty, b, c); err != nil {
+			t.Fatal(err)
+		}
+		var got strings.Builder
+		for i := range queue {
+			job, err := db.ClaimNextJob(tt.policy)
+			if err != nil {
+				t.Fatalf("%s: ClaimNextJob: %v", tt.policy, err)
+			}
+			if job == nil {
+				t.Fatalf("%s: queue empty after %d claims", tt.policy, i)
+			}
+			got.WriteString(label[job.Site])
+			// Finish it, started a second after the previous claim so the
+			// order does not hang on the column's precision.
+			if _, err := db.conn.Exec(`UPDATE jobs SET status='COMPLETED', started_at=? WHERE id=?`,
+				base.Add(time.Hour+time.Duration(i)*time.Second), job.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s policy claimed %s, want %s", tt.policy, got.String(), tt.want)
+		}
+	}
+}
+
+// Another site's default domain is never available as a custom domain, and
+// neither is the site's own.
+func TestEnsureDomainAvailableRejectsDefaultDomain(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	owner, other := testSiteName(), testSiteName()
+	ownerDomain := owner + ".sites.example.net"
+	insertTestSite(t, db, owner, ownerDomain)
+	insertTestSite(t, db, other, other+".sites.example.net")
+
+	for _, claimant := range []string{other, owner} {
+		err := db.EnsureDomainAvailable(ownerDomain, claimant)
+		if err == nil || !strings.Contains(err.Error(), "is the default domain of site "+owner) {
+			t.Errorf("%s claiming %s: %v, want the default domain of %s", claimant, ownerDomain, err, owner)
+		}
+	}
+
+	// Once the owner is destroyed its default domain is free.
+	if err := db.UpdateSiteStatus(owner, string(SiteDestroyed)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnsureDomainAvailable(ownerDomain, other); err != nil {
+		t.Errorf("default domain of a destroyed site: %v, want available", err)
+	}
+}
+
+// A slug provisioned again gets exactly the attributes of the new request,
+// written together with its job.
+func TestQueueProvisionRecordsSiteWithJob(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	site := testSiteName()
+	insertTestSite(t, db, site, site+".sites.example.net")
+	if err := db.SetNginxSnippet(site, "location /old { return 410; }"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateSiteStatus(site, string(SiteDestroyed)); err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.NewString()
+	t.Cleanup(func() { db.conn.Exec(`DELETE FROM jobs WHERE id=?`, id) })
+	rec := SiteProvision{
+		Site: site, Domain: site + ".other.example.net", BaseDomain: "other.example.net",
+		Type: SiteTypeWordPress, Tenant: "acme", VolumeDriver: "quota", VolumeSize: "5G", ExternalVolume: "legacy_files",
+		RestartPolicy: "always", Network: "hostplane_acme", Egress: EgressInternal,
+	}
+	if err := db.QueueProvision(rec, id, JobProvision, "req-1", `{"volume_size":"5G"}`); err != nil {
+		t.Fatalf("QueueProvision: %v", err)
+	}
+
+	s, err := db.GetSite(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Status != string(SiteProvisioning) || s.JobID != id || s.Domain != rec.Domain || s.BaseDomain != rec.BaseDomain ||
+		s.Tenant != "acme" || s.VolumeDriver != "quota" || s.VolumeSize != "5G" || s.RestartPolicy != "always" ||
+		s.ExternalVolume != "legacy_files" || s.Network != "hostplane_acme" || s.Egress != EgressInternal || s.NginxSnippet != "" {
+		t.Errorf("site = %+v, want the attributes of the new request only", s)
+	}
+	if payload, err := db.GetJobPayload(id); err != nil || payload != `{"volume_size":"5G"}` {
+		t.Errorf("job payload = %q, %v", payload, err)
+	}
+}
+
+// A site cut over to its green set and then destroyed is provisioned again
+// under its plain slug, which is what SiteResources must name.
+func TestDestroyForgetsBlueGreenSets(t *testing.T) {
+	db := integrationDB(t, integrationConfig(t))
+	site := testSiteName()
+	insertTestSite(t, db, site, site+".sites.example.net")
+	if err := db.SetGreenResources(site, site+"_green"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SwitchLiveResources(site, site+"_green", site, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	destroy := insertTestJob(t, db, JobDestroy, site)
+	if err := db.CompleteJob(destroy, site, JobDestroy, ""); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	provision := uuid.NewString()
+	t.Cleanup(func() { db.conn.Exec(`DELETE FROM jobs WHERE id=?`, provision) })
+	rec := SiteProvision{Site: site, Domain: site + ".sites.example.net", BaseDomain: "sites.example.net", Type: SiteTypeWordPress, Tenant: tenantDefault}
+	if err := db.QueueProvision(rec, provision, JobProvision, "", ""); err != nil {
+		t.Fatalf("QueueProvision: %v", err)
+	}
+
+	s, err := db.GetSite(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SiteResources(s); got != site {
+		t.Errorf("SiteResources = %q, want %q", got, site)
+	}
+	if s.GreenResources != "" || s.RetiredResources != "" || s.RetireAfter != nil {
+		t.Errorf("blue/green sets survived the destroy: green=%q retired=%q retire_after=%v", s.GreenResources, s.RetiredResources, s.RetireAfter)
+	}
+}
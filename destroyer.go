@@ -4,8 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
@@ -22,48 +20,97 @@ func NewDestroyer(docker *client.Client, cfg Config, backupper *Backupper) *Dest
 	return &Destroyer{docker: docker, cfg: cfg, backupper: backupper}
 }
 
-func (d *Destroyer) Run(site string) error {
+// Run tears down a site: its live resource set and any green or retired
+// blue/green set (see SiteResources). ctx bounds the whole operation,
+// including the pre-destroy backup; cancelling it aborts the in-flight step.
+// A site provisioned onto an external volume (see Site.ExternalVolume)
+// keeps it: the control plane did not create it. The site's own network
+// (see Site.Network) is removed once no other site is left on it, and with
+// it any egress policy set on it.
+func (d *Destroyer) Run(ctx context.Context, s *Site) error {
+	site := s.Site
+
 	// ── Pre-destroy safety backup ─────────────────────────────────
 	// Enabled by default. Set REQUIRE_BACKUP_BEFORE_DESTROY=false to skip
 	// during development / debugging when R2 is not yet configured.
 	if d.cfg.RequireBackupBeforeDestroy {
-		if err := d.backupper.BackupSite(site); err != nil {
+		if err := d.backupper.BackupSite(ctx, site); err != nil {
 			return fmt.Errorf("pre-destroy backup failed, aborting destroy: %w", err)
 		}
 	} else {
-		log.Printf("[destroyer] site=%s REQUIRE_BACKUP_BEFORE_DESTROY=false — skipping pre-destroy backup", site)
+		jobLogf(ctx, "destroyer", "site=%s REQUIRE_BACKUP_BEFORE_DESTROY=false — skipping pre-destroy backup", site)
 	}
 
-	dbName := WPDatabaseName(site)
-	dbUser := WPDatabaseUser(site)
-	volumeName := VolumeName(site)
-	phpName := PHPContainerName(site)
-	nginxName := NginxContainerName(site)
+	sets := []string{SiteResources(s)}
+	for _, name := range []string{s.GreenResources, s.RetiredResources} {
+		if name != "" {
+			sets = append(sets, name)
+		}
+	}
+
+	// Stop and remove the containers before touching the shared volumes
+	for i, name := range sets {
+		externalVolume, volumeDriver := "", ""
+		if i == 0 {
+			externalVolume, volumeDriver = s.ExternalVolume, s.VolumeDriver
+		}
+		if err := d.removeResourceSet(ctx, name, externalVolume, volumeDriver); err != nil {
+			return err
+		}
+	}
+	if err := d.removeCaddyConfig(ctx, site); err != nil {
+		return fmt.Errorf("removeCaddyConfig: %w", err)
+	}
+	if err := reloadCaddy(ctx, d.cfg); err != nil {
+		return fmt.Errorf("reloadCaddy: %w", err)
+	}
+	// Best effort: a network left behind only costs a bridge, and the
+	// next site provisioned onto it reuses it.
+	if err := releaseSiteNetwork(ctx, d.docker, d.cfg, s.Network); err != nil {
+		jobLogf(ctx, "destroyer", "site=%s warning: %v", site, err)
+	}
+	if s.Egress != EgressOpen {
+		if err := NewProvisioner(d.docker, d.cfg).clearEgressPolicy(ctx, SiteNetwork(s, d.cfg)); err != nil {
+			jobLogf(ctx, "destroyer", "site=%s warning: %v", site, err)
+		}
+	}
+	for _, name := range sets {
+		if err := d.dropDatabase(ctx, WPDatabaseName(name), WPDatabaseUser(name)); err != nil {
+			return fmt.Errorf("dropDatabase: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Stop and remove both containers before touching the shared volume
-	if err := d.removeContainer(phpName); err != nil {
+// removeResourceSet removes the containers and volumes named after name
+// (a slug or a blue/green set); externalVolume, when set, is kept in place
+// of VolumeName(name), and volumeDriver is the driver recorded for
+// VolumeName(name) ("" = unknown). The set's database is left to
+// dropDatabase. A per-site Redis goes with the set; the shared one is never
+// named after a set, so it is left running.
+func (d *Destroyer) removeResourceSet(ctx context.Context, name, externalVolume, volumeDriver string) error {
+	if err := d.removeContainer(ctx, PHPContainerName(name)); err != nil {
 		return fmt.Errorf("removePhpContainer: %w", err)
 	}
-	if err := d.removeContainer(nginxName); err != nil {
+	if err := d.removeContainer(ctx, NginxContainerName(name)); err != nil {
 		return fmt.Errorf("removeNginxContainer: %w", err)
 	}
-	if err := d.removeVolume(volumeName); err != nil {
-		return fmt.Errorf("removeVolume: %w", err)
+	if err := d.removeContainer(ctx, RedisContainerName(name)); err != nil {
+		return fmt.Errorf("removeRedisContainer: %w", err)
 	}
-	if err := d.removeCaddyConfig(site); err != nil {
-		return fmt.Errorf("removeCaddyConfig: %w", err)
-	}
-	if err := reloadCaddy(d.cfg); err != nil {
-		return fmt.Errorf("reloadCaddy: %w", err)
+	if externalVolume != "" {
+		jobLogf(ctx, "destroyer", "%s keeping external volume %s", name, externalVolume)
+	} else if err := d.removeVolume(ctx, VolumeName(name), volumeDriver); err != nil {
+		return fmt.Errorf("removeVolume: %w", err)
 	}
-	if err := d.dropDatabase(dbName, dbUser); err != nil {
-		return fmt.Errorf("dropDatabase: %w", err)
+	if err := d.removeVolume(ctx, NginxConfVolumeName(name), ""); err != nil {
+		return fmt.Errorf("removeNginxConfVolume: %w", err)
 	}
 	return nil
 }
 
-func (d *Destroyer) removeContainer(phpName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (d *Destroyer) removeContainer(ctx context.Context, phpName string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.DockerRemoveTimeout)
 	defer cancel()
 
 	err := d.docker.ContainerRemove(ctx, phpName, types.ContainerRemoveOptions{
@@ -76,24 +123,76 @@ func (d *Destroyer) removeContainer(phpName string) error {
 	return nil
 }
 
-func (d *Destroyer) removeVolume(volumeName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// removeVolume removes the site volume regardless of which driver created it.
+// Docker resolves the driver from the volume name, so volumes created with a
+// quota plugin are released through that plugin rather than orphaned.
+// driver is the one recorded at provision ("" = unknown): a volume whose
+// plugin is not loaded reads as not found, so with a plugin driver recorded
+// "not found" is only trusted once the plugin is known to be there.
+func (d *Destroyer) removeVolume(ctx context.Context, volumeName, driver string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.DockerRemoveTimeout)
 	defer cancel()
 
-	err := d.docker.VolumeRemove(ctx, volumeName, true)
+	vol, err := d.docker.VolumeInspect(ctx, volumeName)
+	if client.IsErrNotFound(err) {
+		if driver == "" || driver == "local" {
+			return nil
+		}
+		loaded, err := d.volumeDriverLoaded(ctx, driver)
+		if err != nil {
+			return fmt.Errorf("check volume driver %s: %w", driver, err)
+		}
+		if !loaded {
+			return fmt.Errorf("volume %s was created with driver %s, which is not installed or enabled on the Docker host; enable it and retry so the volume is not orphaned", volumeName, driver)
+		}
+		return nil
+	}
+	if err == nil {
+		if driver != "" && vol.Driver != driver {
+			jobLogf(ctx, "destroyer", "warning: volume %s uses driver %s, recorded as %s", volumeName, vol.Driver, driver)
+		}
+		if vol.Driver != "local" {
+			jobLogf(ctx, "destroyer", "removing volume %s via driver %s", volumeName, vol.Driver)
+		}
+	}
+
+	err = d.docker.VolumeRemove(ctx, volumeName, true)
 	if err != nil && !client.IsErrNotFound(err) {
 		return err
 	}
 	return nil
 }
 
-func (d *Destroyer) removeCaddyConfig(site string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// volumeDriverLoaded reports whether the Docker host has volume driver
+// loaded, built in or as an enabled plugin. Managed plugins are listed with
+// their tag.
+func (d *Destroyer) volumeDriverLoaded(ctx context.Context, driver string) (bool, error) {
+	info, err := d.docker.Info(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range info.Plugins.Volume {
+		if name == driver || name == driver+":latest" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *Destroyer) removeCaddyConfig(ctx context.Context, site string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.DockerExecTimeout)
 	defer cancel()
 
+	// A site has either a snippet or, if path-routed, a route file, and
+	// possibly the preview snippet of a green set, a request log and an
+	// error page.
 	confPath := d.cfg.CaddyConfDir + "/" + CaddyConfFile(site)
+	routePath := d.cfg.CaddyConfDir + "/" + caddyPathRoutesDir + "/" + CaddyPathRouteFile(site)
+	previewPath := d.cfg.CaddyConfDir + "/" + CaddyConfFile(GreenPreviewSite(site))
+	logPath := caddyLogPath(d.cfg, site)
+	errorPagePath := d.cfg.CaddyConfDir + "/" + caddyErrorPagesDir + "/" + CaddyErrorPageFile(site)
 	execResp, err := d.docker.ContainerExecCreate(ctx, d.cfg.CaddyContainer, types.ExecConfig{
-		Cmd: []string{"rm", "-f", confPath},
+		Cmd: []string{"rm", "-f", confPath, routePath, previewPath, logPath, errorPagePath},
 	})
 	if err != nil {
 		return err
@@ -102,23 +201,26 @@ func (d *Destroyer) removeCaddyConfig(site string) error {
 	return d.docker.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{})
 }
 
-func (d *Destroyer) dropDatabase(dbName, dbUser string) error {
+func (d *Destroyer) dropDatabase(ctx context.Context, dbName, dbUser string) error {
+	if err := validateDBAccount(dbName, dbUser); err != nil {
+		return err
+	}
 	db, err := sql.Open("mysql", d.cfg.WordPressDSN)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		return fmt.Errorf("cannot reach DB: %w", err)
 	}
 
 	stmts := []string{
-		"DROP DATABASE IF EXISTS " + dbName,
-		fmt.Sprintf("DROP USER IF EXISTS '%s'@'%%'", dbUser),
+		"DROP DATABASE IF EXISTS " + quoteDBIdentifier(dbName),
+		"DROP USER IF EXISTS " + quoteDBIdentifier(dbUser) + "@'%'",
 	}
 	for _, stmt := range stmts {
-		if _, err := db.Exec(stmt); err != nil {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
 			return fmt.Errorf("sql: %w", err)
 		}
 	}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRemoveVolumeChecksRecordedDriver(t *testing.T) {
+	tests := []struct {
+		name     string
+		driver   string // recorded at provision
+		volume   string // driver of the live volume, "" = none
+		plugins  []string
+		wantErr  string
+		wantGone bool
+	}{
+		{"local", "local", "local", []string{"local"}, "", true},
+		{"plugin loaded", "quota", "quota", []string{"local", "quota:latest"}, "", true},
+		{"already gone, plugin loaded", "quota", "", []string{"local", "quota:latest"}, "", true},
+		{"already gone, nothing recorded", "", "", []string{"local"}, "", true},
+		{"plugin missing", "quota", "quota", []string{"local"}, "driver quota, which is not installed or enabled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, docker := newFakeDocker(t)
+			fake.VolumePlugins = tt.plugins
+			if tt.volume != "" {
+				fake.volumes["wp_s1"] = tt.volume
+			}
+			d := NewDestroyer(docker, fakeDockerConfig(), nil)
+
+			err := d.removeVolume(context.Background(), "wp_s1", tt.driver)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("removeVolume: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("removeVolume = %v, want error containing %q", err, tt.wantErr)
+			}
+			if _, exists := fake.volumes["wp_s1"]; exists == tt.wantGone {
+				t.Errorf("volume exists = %v after removeVolume, want %v", exists, !tt.wantGone)
+			}
+		})
+	}
+}
+
+func TestDestroyerLogsJobRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	fake, docker := newFakeDocker(t)
+	fake.VolumePlugins = []string{"local", "quota"}
+	fake.volumes["wp_s1"] = "quota"
+	d := NewDestroyer(docker, fakeDockerConfig(), nil)
+
+	// The worker puts the claimed job's request ID on the job context.
+	ctx := withRequestID(context.Background(), "req-abc")
+	if err := d.removeVolume(ctx, "wp_s1", "quota"); err != nil {
+		t.Fatalf("removeVolume: %v", err)
+	}
+	if want := "[destroyer] req=req-abc removing volume wp_s1 via driver quota"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log = %q, want a line containing %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// diskUsageCacheTTL bounds how stale GET /api/sites/:site/disk may be. du
+// walks every file of the site, which takes a while on large volumes.
+const diskUsageCacheTTL = 60 * time.Second
+
+// DiskUsage is the storage a site consumes.
+type DiskUsage struct {
+	Site string `json:"site"`
+
+	// FilesBytes is the disk space of the site's files: the WordPress
+	// volume, or every release of a static site in caddy_static_sites.
+	FilesBytes int64  `json:"files_bytes"`
+	FilesFrom  string `json:"files_from"` // "du" or "volume" (Docker's usage data)
+
+	// DatabaseBytes is the data and index size of the site's WordPress
+	// database; nil for static sites.
+	DatabaseBytes *int64 `json:"database_bytes,omitempty"`
+
+	MeasuredAt time.Time `json:"measured_at"`
+}
+
+// measureDiskUsage measures a site's storage. WordPress files are measured
+// with du in the PHP container, or from Docker's volume usage data when the
+// container is not running (e.g. a paused site); static files with du in
+// the Caddy container.
+func measureDiskUsage(ctx context.Context, docker *client.Client, cfg Config, s *Site, isWP bool) (*DiskUsage, error) {
+	u := &DiskUsage{Site: s.Site, FilesFrom: "du", MeasuredAt: time.Now().UTC()}
+
+	if !isWP {
+		n, err := duBytes(ctx, docker, cfg.CaddyContainer,
+			"/srv/sites/"+StaticSiteDir(s.Site, ""), "/srv/sites/_releases/"+s.Site)
+		if err != nil {
+			return nil, err
+		}
+		u.FilesBytes = n
+		return u, nil
+	}
+
+	n, err := duBytes(ctx, docker, PHPContainerName(SiteResources(s)), "/var/www/html")
+	if err != nil {
+		n, err = volumeUsageBytes(ctx, docker, SiteVolume(s))
+		u.FilesFrom = "volume"
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.FilesBytes = n
+
+	dbBytes, err := databaseBytes(ctx, cfg, WPDatabaseName(SiteResources(s)))
+	if err != nil {
+		return nil, err
+	}
+	u.DatabaseBytes = &dbBytes
+	return u, nil
+}
+
+// duBytes returns the combined disk usage of paths inside containerName.
+// `du -skc` is used rather than -b, which busybox (the Caddy image) lacks;
+// paths that do not exist are skipped.
+func duBytes(ctx context.Context, docker *client.Client, containerName string, paths ...string) (int64, error) {
+	res, err := runExec(ctx, docker, containerName, append([]string{"du", "-skc"}, paths...))
+	if err != nil {
+		return 0, err
+	}
+	// A missing path makes du exit 1 but it still reports the total.
+	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
+	last := strings.Fields(lines[len(lines)-1])
+	if len(last) != 2 || last[1] != "total" {
+		return 0, fmt.Errorf("du in %s exited %d: %s", containerName, res.ExitCode, strings.TrimSpace(res.Stderr))
+	}
+	kb, err := strconv.ParseInt(last[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse du output: %w", err)
+	}
+	return kb * 1024, nil
+}
+
+// volumeUsageBytes returns Docker's recorded size of volume. It comes from
+// the daemon's disk usage scan, which covers every volume on the host.
+func volumeUsageBytes(ctx context.Context, docker *client.Client, volume string) (int64, error) {
+	du, err := docker.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return 0, fmt.Errorf("docker disk usage: %w", err)
+	}
+	for _, v := range du.Volumes {
+		if v.Name == volume && v.UsageData != nil && v.UsageData.Size >= 0 {
+			return v.UsageData.Size, nil
+		}
+	}
+	return 0, fmt.Errorf("no usage data for volume %s", volume)
+}
+
+// databaseBytes returns the data and index size of dbName's tables.
+func databaseBytes(ctx context.Context, cfg Config, dbName string) (int64, error) {
+	db, err := sql.Open("mysql", cfg.WordPressDSN)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var n int64
+	err = db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(data_length + index_length), 0)
+        FROM information_schema.tables WHERE table_schema = ?
+    `, dbName).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("database size: %w", err)
+	}
+	return n, nil
+}
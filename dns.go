@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DomainResolver resolves custom domains for validation. With servers set
+// it queries them directly (e.g. 1.1.1.1, 8.8.8.8) instead of the host's
+// resolver, whose cache can still hold the old A record minutes after a
+// user updates DNS. Each lookup is bounded by timeout.
+type DomainResolver struct {
+	servers []string // host:port; empty = system resolver
+	timeout time.Duration
+}
+
+// DomainResolver returns the resolver configured by DNS_RESOLVERS and
+// DNS_LOOKUP_TIMEOUT_SEC.
+func (c Config) DomainResolver() *DomainResolver {
+	return &DomainResolver{servers: c.DNSResolvers, timeout: c.DNSLookupTimeout}
+}
+
+// LookupHost returns domain's addresses. Configured servers are tried in
+// order; a definitive "no such host" answer is returned as is, while
+// timeouts and server failures fall through to the next server.
+func (r *DomainResolver) LookupHost(domain string) ([]string, error) {
+	if len(r.servers) == 0 {
+		return r.lookup(net.DefaultResolver, domain)
+	}
+	var err error
+	for _, server := range r.servers {
+		var addrs []string
+		addrs, err = r.lookup(serverResolver(server), domain)
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return addrs, err
+		}
+	}
+	return nil, err
+}
+
+// LookupCNAME returns domain's canonical name, trying the configured
+// servers like LookupHost. A name with addresses but no CNAME is its own
+// canonical name.
+func (r *DomainResolver) LookupCNAME(domain string) (string, error) {
+	lookup := func(res *net.Resolver) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		return res.LookupCNAME(ctx, domain)
+	}
+	if len(r.servers) == 0 {
+		return lookup(net.DefaultResolver)
+	}
+	var err error
+	for _, server := range r.servers {
+		var cname string
+		cname, err = lookup(serverResolver(server))
+		var dnsErr *net.DNSError
+		if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			return cname, err
+		}
+	}
+	return "", err
+}
+
+func (r *DomainResolver) lookup(res *net.Resolver, domain string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return res.LookupHost(ctx, domain)
+}
+
+// serverResolver returns a resolver that sends every query to server,
+// bypassing /etc/resolv.conf and any local caching stub.
+func serverResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// parseDNSServers normalizes DNS_RESOLVERS entries to host:port, adding
+// port 53 where none is given.
+func parseDNSServers(entries []string) ([]string, error) {
+	var servers []string
+	for _, e := range entries {
+		host, port, err := net.SplitHostPort(e)
+		if err != nil {
+			host, port = e, "53"
+		}
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("%q is not an IP address", e)
+		}
+		servers = append(servers, net.JoinHostPort(host, port))
+	}
+	return servers, nil
+}
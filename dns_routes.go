@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// Verifying and repairing a site's tunnel DNS routes. Every hostname a site
+// serves needs a CNAME to <tunnel id>.cfargotunnel.com, created by
+// `cloudflared tunnel route dns` (TunnelManager.AddRoute). A VERIFY_DNS job
+// looks each one up through DNS_RESOLVERS and re-adds the routes that do
+// not exist together (TunnelManager.AddRoutes), so DNS converges with the
+// sites table when a route was never created or was deleted by hand.
+
+// tunnelCNAMESuffix is the zone every tunnel's CNAME target is in.
+const tunnelCNAMESuffix = ".cfargotunnel.com"
+
+// Per-hostname outcomes of a VERIFY_DNS job.
+const (
+	DNSRouteOK           = "ok"            // CNAME to this tunnel
+	DNSRouteProxied      = "proxied"       // resolves with no visible CNAME: a proxied record, whose target Cloudflare hides
+	DNSRouteMismatch     = "mismatch"      // CNAME to something else; not overwritten
+	DNSRouteRepaired     = "repaired"      // missing, and re-added
+	DNSRouteRepairFailed = "repair_failed" // missing, and re-adding failed
+	DNSRouteLookupFailed = "lookup_failed" // no answer from the resolvers
+)
+
+// dnsRouteMissing is a hostname whose name does not exist, until its route
+// is re-added as DNSRouteRepaired or DNSRouteRepairFailed.
+const dnsRouteMissing = "missing"
+
+// DNSRouteStatus is one hostname in a VERIFY_DNS job's result.
+type DNSRouteStatus struct {
+	Domain string `json:"domain"`
+	Kind   string `json:"kind"` // DomainKind*
+	Status string `json:"status"`
+	CNAME  string `json:"cname,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TunnelTarget returns the CNAME target of the tunnel's routes, from the
+// tunnel id in the cloudflared config.
+func (tm *TunnelManager) TunnelTarget() (string, error) {
+	cfg, err := tm.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if cfg.Tunnel == "" {
+		return "", fmt.Errorf("cloudflared config %s names no tunnel", tm.cfg.CloudflaredConfigPath)
+	}
+	return strings.ToLower(cfg.Tunnel) + tunnelCNAMESuffix, nil
+}
+
+// siteHostnames returns the hostnames s serves that need a tunnel route:
+// its default domain and its custom domain, with www when enabled.
+func siteHostnames(s *Site) []DomainEntry {
+	hosts := []DomainEntry{{Domain: s.Domain, Kind: DomainKindDefault}}
+	if s.CustomDomain != "" {
+		hosts = append(hosts, DomainEntry{Domain: s.CustomDomain, Kind: DomainKindCustom})
+		if s.WWWMode != "" {
+			hosts = append(hosts, DomainEntry{Domain: wwwDomain(s.CustomDomain), Kind: DomainKindWWW})
+		}
+	}
+	return hosts
+}
+
+// checkDNSRoute looks up domain's CNAME; a name that does not exist is
+// dnsRouteMissing, for the caller to add its route.
+func (w *Worker) checkDNSRoute(domain, target string) (status, cname string, err error) {
+	cname, err = w.cfg.DomainResolver().LookupCNAME(domain)
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return dnsRouteMissing, "", nil
+	case err != nil:
+		return DNSRouteLookupFailed, "", err
+	case cname == target:
+		return DNSRouteOK, cname, nil
+	case cname == strings.ToLower(domain):
+		return DNSRouteProxied, "", nil
+	}
+	return DNSRouteMismatch, cname, fmt.Errorf("CNAME points at %s, not %s", cname, target)
+}
+
+// verifyDNSRoutes runs a VERIFY_DNS job: it checks every hostname of the
+// site and stores the per-hostname status as the job result. The job fails,
+// and is retried, if a route could not be re-added or looked up; a CNAME to
+// something else is reported but left alone for an operator to resolve.
+func (w *Worker) verifyDNSRoutes(ctx context.Context, job *Job) error {
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	target, err := w.tunnel.TunnelTarget()
+	if err != nil {
+		return fmt.Errorf("tunnel target: %w", err)
+	}
+
+	var statuses []DNSRouteStatus
+	var missing []string
+	for _, h := range siteHostnames(s) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		st := DNSRouteStatus{Domain: h.Domain, Kind: h.Kind}
+		var err error
+		st.Status, st.CNAME, err = w.checkDNSRoute(h.Domain, target)
+		if err != nil {
+			st.Error = err.Error()
+		}
+		if st.Status == dnsRouteMissing {
+			missing = append(missing, h.Domain)
+		}
+		statuses = append(statuses, st)
+	}
+
+	var routeErrs map[string]error
+	if len(missing) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		routeErrs = w.tunnel.AddRoutes(missing)
+	}
+	var failed []string
+	for i := range statuses {
+		st := &statuses[i]
+		if st.Status == dnsRouteMissing {
+			st.Status = DNSRouteRepaired
+			if err := routeErrs[st.Domain]; err != nil {
+				st.Status, st.Error = DNSRouteRepairFailed, err.Error()
+			}
+		}
+		if st.Status == DNSRouteRepairFailed || st.Status == DNSRouteLookupFailed {
+			failed = append(failed, st.Domain)
+		}
+		log.Printf("[worker] site=%s dns route %s: %s", job.Site, st.Domain, st.Status)
+	}
+
+	if b, err := json.Marshal(map[string]any{"target": target, "domains": statuses}); err == nil {
+		if err := w.db.SetJobResult(job.ID, string(b)); err != nil {
+			log.Printf("[worker] job %s warning: could not store result: %v", job.ID, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("dns routes not verified for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import "net/http"
+
+// DomainCheck is the outcome of one custom-domain precondition.
+type DomainCheck struct {
+	Name    string    `json:"name"`
+	OK      bool      `json:"ok"`
+	Skipped bool      `json:"skipped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Code    ErrorCode `json:"code,omitempty"` // set when the check failed
+
+	status int       // HTTP status handleSetCustomDomain answers with when this check fails
+	code   ErrorCode // error code reported when this check fails
+}
+
+// checkCustomDomain runs the preconditions for pointing site at domain, in
+// the order handleSetCustomDomain applies them: format, DNS, then whether
+// another site has claimed it. domain must already be normalized. site may
+// be "" when no site is being considered yet. Once the format check fails
+// the remaining checks are reported as skipped. Nothing is mutated.
+func (a *API) checkCustomDomain(domain, site string) []DomainCheck {
+	checks := []DomainCheck{
+		{Name: "format", status: http.StatusBadRequest, code: CodeDomainInvalid},
+		{Name: "dns", status: http.StatusBadRequest, code: CodeDNSMismatch},
+		{Name: "available", status: http.StatusConflict, code: CodeDomainTaken},
+	}
+	run := []func() error{
+		func() error { return ValidateCustomDomain(domain, a.cfg.BaseDomains) },
+		func() error { return ValidateDomainPointsToIngress(a.cfg.DomainResolver(), domain, a.cfg.IngressIPs) },
+		func() error { return a.db.EnsureDomainAvailable(domain, site) },
+	}
+
+	for i := range checks {
+		if i > 0 && !checks[0].OK {
+			checks[i].Skipped = true
+			continue
+		}
+		if err := run[i](); err != nil {
+			checks[i].Error = err.Error()
+			checks[i].Code = checks[i].code
+			continue
+		}
+		checks[i].OK = true
+	}
+	return checks
+}
+
+// firstFailedCheck returns the first failed check, or nil if all passed.
+func firstFailedCheck(checks []DomainCheck) *DomainCheck {
+	for i := range checks {
+		if !checks[i].OK && !checks[i].Skipped {
+			return &checks[i]
+		}
+	}
+	return nil
+}
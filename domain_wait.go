@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Custom domains set before their DNS has propagated. With wait_for_dns,
+// POST /api/sites/:site/domain accepts a domain whose only failed check is
+// DNS: the site moves to DOMAIN_PENDING and the worker re-checks it every
+// DomainDNSPollInterval. Each check passes through DOMAIN_VALIDATING; once
+// the domain points at the ingress the site moves to DOMAIN_ROUTING while
+// the domain is attached, then DOMAIN_ACTIVE. A domain still not pointing
+// at the ingress after DomainDNSWaitTimeout, or claimed by another site
+// meanwhile, is abandoned and the site returns to ACTIVE. The site keeps
+// serving its default domain throughout.
+
+// PendingDomainStatus is a custom domain waiting for its DNS, in GET
+// /api/sites/:site.
+type PendingDomainStatus struct {
+	Domain    string     `json:"domain"`
+	WWW       string     `json:"www,omitempty"`
+	WaitUntil *time.Time `json:"wait_until,omitempty"` // when the wait is abandoned
+	LastError string     `json:"last_error,omitempty"` // why the last DNS check failed
+}
+
+// pendingDomainStatus returns s's pending domain, or nil when none.
+func pendingDomainStatus(s *Site) *PendingDomainStatus {
+	if s.PendingDomain == "" {
+		return nil
+	}
+	return &PendingDomainStatus{Domain: s.PendingDomain, WWW: s.PendingWWWMode, WaitUntil: s.PendingDomainUntil, LastError: s.PendingDomainError}
+}
+
+// waitForDomainDNS answers POST /api/sites/:site/domain with wait_for_dns
+// when the domain passed every check but DNS: it is recorded as pending, the
+// site moves to DOMAIN_PENDING and the worker takes it from there.
+func (a *API) waitForDomainDNS(c *gin.Context, s *Site, domain, wwwMode, dnsErr string) {
+	if !SiteStatus(s.Status).CanTransitionTo(SiteDomainPending) {
+		respondError(c, http.StatusConflict, CodeSiteState, fmt.Sprintf("site must be ACTIVE to wait for a domain's DNS (is %s)", s.Status))
+		return
+	}
+	if err := a.db.SetPendingDomain(s.Site, domain, wwwMode, a.cfg.DomainDNSWaitTimeout); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "failed to record pending domain")
+		return
+	}
+	if err := a.db.TransitionSite(s.Site, SiteDomainPending); err != nil {
+		a.db.ClearPendingDomain(s.Site)
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "failed to record pending domain: "+err.Error())
+		return
+	}
+	if err := a.db.SetPendingDomainError(s.Site, dnsErr); err != nil {
+		log.Printf("[api] site=%s warning: could not record DNS check: %v", s.Site, err)
+	}
+
+	log.Printf("[api] site=%s custom domain %s pending DNS req=%s", s.Site, domain, requestID(c))
+	a.recordHistory(s.Site, EventDomainPending, domain+": "+dnsErr)
+	a.events.Publish(Event{
+		Type: EventDomainPending, Site: s.Site, RequestID: requestID(c),
+		Data: map[string]any{"domain": domain, "www": wwwMode},
+	})
+	c.JSON(http.StatusAccepted, gin.H{
+		"site":           s.Site,
+		"default_domain": s.Domain,
+		"status":         SiteDomainPending,
+		"pending_domain": PendingDomainStatus{Domain: domain, WWW: wwwMode, LastError: dnsErr},
+		"message":        fmt.Sprintf("waiting up to %s for DNS to point at the ingress; poll GET /api/sites/%s", a.cfg.DomainDNSWaitTimeout, s.Site),
+	})
+}
+
+// cancelPendingDomain answers DELETE /api/sites/:site/domain for a site
+// still waiting for a domain's DNS: the wait is dropped and the site
+// returns to ACTIVE. A domain already being routed cannot be cancelled.
+func (a *API) cancelPendingDomain(c *gin.Context, s *Site) {
+	if SiteStatus(s.Status) == SiteDomainRouting {
+		respondError(c, http.StatusConflict, CodeSiteState, "pending domain is being routed; remove it once the site is DOMAIN_ACTIVE")
+		return
+	}
+	if err := a.db.ClearPendingDomain(s.Site); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "failed to clear pending domain")
+		return
+	}
+	if err := a.db.UpdateSiteStatus(s.Site, string(SiteActive)); err != nil {
+		respondError(c, http.StatusInternalServerError, CodePersistFailed, "pending domain cleared but failed to record ACTIVE status")
+		return
+	}
+
+	log.Printf("[api] site=%s pending custom domain %s cancelled", s.Site, s.PendingDomain)
+	a.recordHistory(s.Site, EventDomainAbandoned, s.PendingDomain+": cancelled")
+	a.events.Publish(Event{
+		Type: EventDomainAbandoned, Site: s.Site, RequestID: requestID(c),
+		Data: map[string]any{"domain": s.PendingDomain, "error": "cancelled"},
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"site":   s.Site,
+		"domain": s.Domain,
+		"status": "pending custom domain cancelled",
+	})
+}
+
+// pollPendingDomains re-checks every pending domain, at most once per
+// DomainDNSPollInterval. Sites with a job in flight are checked by a later
+// poll.
+func (w *Worker) pollPendingDomains(ctx context.Context) {
+	if time.Since(w.lastDomainPoll) < w.cfg.DomainDNSPollInterval {
+		return
+	}
+	w.lastDomainPoll = time.Now()
+
+	sites, err := w.db.ListPendingDomains()
+	if err != nil {
+		log.Printf("[worker] error listing pending domains: %v", err)
+		return
+	}
+	for _, site := range sites {
+		active, err := w.db.HasActiveJob(site)
+		if err != nil || active {
+			continue
+		}
+		s, err := w.db.GetSite(site)
+		if err != nil {
+			log.Printf("[worker] site=%s error loading pending domain: %v", site, err)
+			continue
+		}
+		w.checkPendingDomain(ctx, s)
+	}
+}
+
+// checkPendingDomain runs one DNS check of s's pending domain and routes it
+// once it points at the ingress. A site left in DOMAIN_ROUTING by a restart
+// is routed again; attaching a domain is safe to repeat.
+func (w *Worker) checkPendingDomain(ctx context.Context, s *Site) {
+	domain := s.PendingDomain
+	if SiteStatus(s.Status) != SiteDomainRouting {
+		if s.PendingDomainUntil != nil && time.Now().After(*s.PendingDomainUntil) {
+			reason := fmt.Errorf("DNS did not point at the ingress within %s", w.cfg.DomainDNSWaitTimeout)
+			if s.PendingDomainError != "" {
+				reason = fmt.Errorf("%w (last check: %s)", reason, s.PendingDomainError)
+			}
+			w.abandonPendingDomain(s, reason)
+			return
+		}
+		if SiteStatus(s.Status) == SiteDomainPending {
+			if err := w.db.TransitionSite(s.Site, SiteDomainValidating); err != nil {
+				log.Printf("[worker] site=%s pending domain %s: %v", s.Site, domain, err)
+				return
+			}
+		}
+
+		err := ValidateDomainPointsToIngress(w.cfg.DomainResolver(), domain, w.cfg.IngressIPs)
+		if err == nil && s.PendingWWWMode != "" {
+			_, err = checkWWWDNS(w.cfg.DomainResolver(), domain, w.cfg.IngressIPs)
+		}
+		if err != nil {
+			if sErr := w.db.SetPendingDomainError(s.Site, err.Error()); sErr != nil {
+				log.Printf("[worker] site=%s warning: could not record DNS check: %v", s.Site, sErr)
+			}
+			// The wait may have been cancelled during the lookup; only a site
+			// still being validated goes back to waiting.
+			if cur, gErr := w.db.GetSite(s.Site); gErr == nil && SiteStatus(cur.Status) == SiteDomainValidating {
+				if tErr := w.db.TransitionSite(s.Site, SiteDomainPending); tErr != nil {
+					log.Printf("[worker] site=%s pending domain %s: %v", s.Site, domain, tErr)
+				}
+			}
+			return
+		}
+		if err := w.db.EnsureDomainAvailable(domain, s.Site); err != nil {
+			w.abandonPendingDomain(s, err)
+			return
+		}
+		if err := w.db.TransitionSite(s.Site, SiteDomainRouting); err != nil {
+			log.Printf("[worker] site=%s pending domain %s: %v", s.Site, domain, err)
+			return
+		}
+	}
+
+	if s.CaddyManual {
+		w.abandonPendingDomain(s, errCaddyManuallyEdited)
+		return
+	}
+	// As when setting a domain directly, a www name that does not resolve
+	// at all is dropped rather than holding up the bare domain.
+	wwwMode := s.PendingWWWMode
+	if wwwMode != "" {
+		if skip, err := checkWWWDNS(w.cfg.DomainResolver(), domain, w.cfg.IngressIPs); err != nil || skip {
+			log.Printf("[worker] site=%s www handling for %s skipped: www does not point at the ingress", s.Site, domain)
+			wwwMode = ""
+		}
+	}
+	if err := attachCustomDomain(ctx, w.provisioner.docker, w.cfg, s, s.Type != SiteTypeStatic, domain, wwwMode); err != nil {
+		w.abandonPendingDomain(s, err)
+		return
+	}
+	if err := w.db.SetCustomDomain(s.Site, domain, wwwMode); err != nil {
+		// Left in DOMAIN_ROUTING, so the next poll attaches and records it again.
+		log.Printf("[CRITICAL] site=%s domain=%s infra applied but DB commit failed: %v", s.Site, domain, err)
+		return
+	}
+	if err := w.db.ClearPendingDomain(s.Site); err != nil {
+		log.Printf("[worker] site=%s warning: could not clear pending domain: %v", s.Site, err)
+	}
+	if err := w.db.TransitionSite(s.Site, SiteDomainActive); err != nil {
+		log.Printf("[CRITICAL] site=%s domain=%s attached but status not updated: %v", s.Site, domain, err)
+	}
+
+	certStatus := PollCaddyCert(ctx, w.provisioner.docker, w.cfg, domain, 30*time.Second)
+	log.Printf("[worker] site=%s pending custom domain %s attached (cert: %s)", s.Site, domain, certStatus)
+	if err := w.db.RecordSiteHistory(s.Site, EventDomainSet, domain); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", s.Site, err)
+	}
+	w.events.Publish(Event{
+		Type: EventDomainSet, Site: s.Site,
+		Data: map[string]any{"domain": domain, "www": wwwMode, "cert_status": string(certStatus)},
+	})
+}
+
+// abandonPendingDomain gives up on s's pending domain: it is forgotten and
+// the site returns to ACTIVE, still serving its default domain.
+func (w *Worker) abandonPendingDomain(s *Site, reason error) {
+	log.Printf("[worker] site=%s pending custom domain %s abandoned: %v", s.Site, s.PendingDomain, reason)
+	if err := w.db.ClearPendingDomain(s.Site); err != nil {
+		log.Printf("[worker] site=%s warning: could not clear pending domain: %v", s.Site, err)
+	}
+	if err := w.db.UpdateSiteStatus(s.Site, string(SiteActive)); err != nil {
+		log.Printf("[worker] site=%s error returning to ACTIVE: %v", s.Site, err)
+	}
+	if err := w.db.RecordSiteHistory(s.Site, EventDomainAbandoned, s.PendingDomain+": "+reason.Error()); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", s.Site, err)
+	}
+	w.events.Publish(Event{
+		Type: EventDomainAbandoned, Site: s.Site,
+		Data: map[string]any{"domain": s.PendingDomain, "error": reason.Error()},
+	})
+}
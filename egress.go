@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Outbound network policy. By default a site's containers can open
+// connections anywhere, which is what a compromised plugin needs to fetch
+// a payload or call home. EgressInternal restricts them to the WordPress
+// database server, the containers on their own network (nginx, Redis,
+// Caddy) and EGRESS_ALLOW_CIDRS — update servers, DNS resolvers.
+//
+// The policy is applied to the site's network rather than its containers,
+// whose addresses change as they are recreated: a site with a policy is
+// always provisioned onto its own network (see SiteNetworkName), and
+// rules in Docker's DOCKER-USER chain reject traffic leaving that
+// network's bridge for anywhere not allowed. The rules are written by a
+// one-shot container of EGRESS_IMAGE on the host network, tagged with the
+// network so they can be found again; they do not survive a reboot of the
+// Docker host, and POST /api/sites/:site/reconcile?repair=true puts them
+// back.
+const (
+	EgressOpen     = ""
+	EgressInternal = "internal"
+)
+
+// egressChain is the iptables chain Docker leaves to the administrator and
+// evaluates before its own forwarding rules.
+const egressChain = "DOCKER-USER"
+
+// egressTimeout bounds a run of the iptables container, including pulling
+// iptables into it.
+const egressTimeout = 2 * time.Minute
+
+// validBridgeName is the form of a Linux interface name, as Docker reports
+// a network's bridge.
+var validBridgeName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+
+// ValidateEgressPolicy accepts the egress policies; "open" is accepted as
+// an alias of EgressOpen.
+func ValidateEgressPolicy(policy string) (string, error) {
+	switch policy {
+	case EgressOpen, "open":
+		return EgressOpen, nil
+	case EgressInternal:
+		return policy, nil
+	}
+	return "", fmt.Errorf("egress must be %q or %q", "open", EgressInternal)
+}
+
+// egressRuleTag is the comment on every rule of network's policy.
+func egressRuleTag(network string) string {
+	return "hostplane-egress:" + network
+}
+
+// egressRulePattern is a quoted grep -E pattern matching the rules of
+// network's policy in iptables -S output, which quotes the comment or not.
+func egressRulePattern(network string) string {
+	tag := strings.ReplaceAll(egressRuleTag(network), ".", `\.`)
+	return `'--comment "?` + tag + `"?( |$)'`
+}
+
+// networkBridge returns the host interface of a bridge network.
+func networkBridge(res types.NetworkResource) string {
+	if name := res.Options["com.docker.network.bridge.name"]; name != "" {
+		return name
+	}
+	if len(res.ID) < 12 {
+		return ""
+	}
+	return "br-" + res.ID[:12]
+}
+
+// egressAllowed returns the destinations EgressInternal lets through: the
+// database server and EGRESS_ALLOW_CIDRS. A database host given by name
+// cannot be matched and has to be listed in EGRESS_ALLOW_CIDRS.
+func egressAllowed(cfg Config) []string {
+	var allowed []string
+	host, _, err := net.SplitHostPort(cfg.DBHost())
+	if err != nil {
+		host = cfg.DBHost()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		allowed = append(allowed, ip.String()+"/32")
+	} else {
+		log.Printf("[egress] warning: database host %q is not an IPv4 address; list it in EGRESS_ALLOW_CIDRS", host)
+	}
+	for _, n := range cfg.EgressAllowCIDRs {
+		allowed = append(allowed, n.String())
+	}
+	return allowed
+}
+
+// renderEgressScript returns the shell script that replaces network's
+// rules: any earlier ones are deleted, then for EgressInternal a reject of
+// everything leaving bridge is inserted, with a return for each allowed
+// destination above it. Traffic between containers on the bridge never
+// leaves it, so is unaffected.
+func renderEgressScript(cfg Config, network, bridge, policy string) string {
+	tag := egressRuleTag(network)
+	var b strings.Builder
+	b.WriteString("set -eu\n")
+	b.WriteString("command -v iptables >/dev/null 2>&1 || apk add --no-cache -q iptables >/dev/null\n")
+	fmt.Fprintf(&b, "iptables -S %s | grep -E -- %s | sed 's/^-A /-D /' |\n", egressChain, egressRulePattern(network))
+	b.WriteString("  while read -r rule; do eval \"iptables $rule\"; done\n")
+	if policy == EgressOpen {
+		return b.String()
+	}
+	// -I inserts at the top, so the reject goes in first.
+	fmt.Fprintf(&b, "iptables -I %s -i %s ! -o %s -m comment --comment %s -j REJECT --reject-with icmp-net-prohibited\n",
+		egressChain, bridge, bridge, tag)
+	for _, dst := range egressAllowed(cfg) {
+		fmt.Fprintf(&b, "iptables -I %s -i %s ! -o %s -d %s -m comment --comment %s -j RETURN\n",
+			egressChain, bridge, bridge, dst, tag)
+	}
+	return b.String()
+}
+
+// setEgressPolicy makes policy the egress policy of network, which must
+// exist unless policy is EgressOpen (clearing the rules of a removed
+// network).
+func (p *Provisioner) setEgressPolicy(ctx context.Context, network, policy string) error {
+	if network == "" || network == p.cfg.DockerNetwork {
+		return fmt.Errorf("egress policy needs a network of its own, not %s", p.cfg.DockerNetwork)
+	}
+	bridge := ""
+	if policy != EgressOpen {
+		res, err := p.docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+		if err != nil {
+			return fmt.Errorf("inspect network %s: %w", network, err)
+		}
+		if bridge = networkBridge(res); !validBridgeName.MatchString(bridge) {
+			return fmt.Errorf("network %s has no usable bridge interface (%q)", network, bridge)
+		}
+	}
+	if _, err := p.runEgressScript(ctx, network, renderEgressScript(p.cfg, network, bridge, policy)); err != nil {
+		return fmt.Errorf("set egress policy of %s: %w", network, err)
+	}
+	log.Printf("[egress] network=%s policy=%q applied", network, policy)
+	return nil
+}
+
+// clearEgressPolicy removes network's rules once the network itself is
+// gone (see releaseSiteNetwork); while it exists, another resource set of
+// the site may still be on it.
+func (p *Provisioner) clearEgressPolicy(ctx context.Context, network string) error {
+	if _, err := p.docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{}); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return fmt.Errorf("inspect network %s: %w", network, err)
+	}
+	return p.setEgressPolicy(ctx, network, EgressOpen)
+}
+
+// egressDrift reports how network's rules differ from what policy needs,
+// "" when they match. Only their presence on the current bridge is
+// checked, not each allowed destination.
+func (p *Provisioner) egressDrift(ctx context.Context, network, policy string) (string, error) {
+	script := fmt.Sprintf("set -eu\ncommand -v iptables >/dev/null 2>&1 || apk add --no-cache -q iptables >/dev/null\n"+
+		"iptables -S %s | grep -E -- %s || true\n", egressChain, egressRulePattern(network))
+	out, err := p.runEgressScript(ctx, network, script)
+	if err != nil {
+		return "", err
+	}
+	rules := strings.TrimSpace(out)
+	if policy == EgressOpen {
+		if rules != "" {
+			return "egress rules are set for an open site", nil
+		}
+		return "", nil
+	}
+	res, err := p.docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("inspect network %s: %w", network, err)
+	}
+	if bridge := networkBridge(res); !strings.Contains(rules, "-i "+bridge+" ") {
+		return "no egress rules for bridge " + bridge + " of " + network, nil
+	}
+	return "", nil
+}
+
+// runEgressScript runs script in a one-shot EGRESS_IMAGE container on the
+// host network with NET_ADMIN, returning its stdout.
+func (p *Provisioner) runEgressScript(ctx context.Context, network, script string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, egressTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	name := fmt.Sprintf("egress_%s_%d", network, time.Now().UnixNano())
+	exitCode, err := p.runOneShotOutput(ctx, name,
+		&container.Config{
+			Image: p.cfg.EgressImage,
+			Cmd:   []string{"sh", "-c", script},
+		},
+		&container.HostConfig{
+			NetworkMode: container.NetworkMode("host"),
+			CapAdd:      []string{"NET_ADMIN", "NET_RAW"},
+		},
+		&limitedWriter{w: &stdout, n: execMaxOutput},
+		&limitedWriter{w: &stderr, n: execMaxOutput},
+	)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("iptables exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
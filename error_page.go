@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Per-site error pages. A site can have Caddy answer the 5xx errors Caddy
+// itself raises — its nginx sidecar is down or unreachable, so the proxy
+// fails — with a friendly page instead of a bare gateway error. The page
+// is either the platform's (ERROR_PAGE_HTML, or ERROR_PAGE_HTML_FILE) or
+// one uploaded for the site, and is kept as a file in caddyErrorPagesDir
+// that the site block's handle_errors serves with the error's status.
+// Errors nginx or WordPress answer themselves, such as WordPress's own 503
+// maintenance page, are passed through unchanged.
+const (
+	ErrorPageOff     = ""
+	ErrorPageDefault = "default"
+	ErrorPageCustom  = "custom"
+)
+
+// caddyErrorPagesDir is the subdirectory of CaddyConfDir holding the error
+// pages, outside the *.caddy import of the main Caddyfile.
+const caddyErrorPagesDir = "errors"
+
+// errorPageMaxLen bounds an uploaded error page.
+const errorPageMaxLen = 64 * 1024
+
+// defaultErrorPageHTML is the platform error page unless ERROR_PAGE_HTML
+// replaces it.
+const defaultErrorPageHTML = `<!doctype html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="60">
+<title>Temporarily unavailable</title></head>
+<body><h1>This site is temporarily unavailable</h1>
+<p>Please try again in a few minutes.</p></body></html>
+`
+
+// ValidateErrorPageMode accepts the error page modes; "off" is accepted as
+// an alias of ErrorPageOff.
+func ValidateErrorPageMode(mode string) (string, error) {
+	switch mode {
+	case ErrorPageOff, "off":
+		return ErrorPageOff, nil
+	case ErrorPageDefault, ErrorPageCustom:
+		return mode, nil
+	}
+	return "", fmt.Errorf("page must be %q, %q or %q", ErrorPageDefault, ErrorPageCustom, "off")
+}
+
+// CaddyErrorPageFile returns the name of a site's error page in
+// caddyErrorPagesDir.
+func CaddyErrorPageFile(site string) string {
+	return site + ".html"
+}
+
+// siteErrorPageHTML returns the page a site in mode serves: its own for
+// ErrorPageCustom, otherwise the platform's.
+func siteErrorPageHTML(cfg Config, mode, custom string) string {
+	if mode == ErrorPageCustom {
+		return custom
+	}
+	return cfg.ErrorPageHTML
+}
+
+// renderCaddyErrorPage returns the handle_errors block for a site's block,
+// indented to sit inside it, or "" when the site has no error page. The
+// rewrite pins every failed request to the site's page, so nothing else in
+// the directory can be reached through it.
+func renderCaddyErrorPage(cfg Config, site, mode string) string {
+	if mode == ErrorPageOff {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("    handle_errors {\n")
+	b.WriteString("        @server_error expression `{err.status_code} >= 500`\n")
+	b.WriteString("        handle @server_error {\n")
+	fmt.Fprintf(&b, "            root * %s/%s\n", cfg.CaddyConfDir, caddyErrorPagesDir)
+	fmt.Fprintf(&b, "            rewrite * /%s\n", CaddyErrorPageFile(site))
+	b.WriteString("            file_server\n")
+	b.WriteString("        }\n")
+	b.WriteString("    }\n")
+	return b.String()
+}
+
+// writeErrorPage writes a site's error page into the Caddy container. The
+// snippet that serves it is written separately.
+func (p *Provisioner) writeErrorPage(ctx context.Context, site, html string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerExecTimeout)
+	defer cancel()
+
+	dir := p.cfg.CaddyConfDir + "/" + caddyErrorPagesDir
+	if err := ensureCaddyConfDir(ctx, p.docker, p.cfg.CaddyContainer, dir); err != nil {
+		return err
+	}
+	return copyCaddyFile(ctx, p.docker, p.cfg, dir, CaddyErrorPageFile(site), html)
+}
@@ -0,0 +1,90 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// ErrorCode identifies an API error for clients. Codes are stable: a client
+// may switch on them, so existing ones must not be renamed or reused for a
+// different failure. Messages are for humans and may change freely.
+type ErrorCode string
+
+const (
+	// Request
+	CodeInvalidRequest  ErrorCode = "INVALID_REQUEST"   // malformed body or an invalid field
+	CodeInvalidSiteName ErrorCode = "INVALID_SITE_NAME" // slug is not lowercase letters and numbers
+	CodeUnauthorized    ErrorCode = "UNAUTHORIZED"      // missing or unknown API key
+	CodeForbidden       ErrorCode = "FORBIDDEN"         // client IP not allowed
+	CodeAdminRequired   ErrorCode = "ADMIN_REQUIRED"    // needs the admin API key
+	CodeReadOnly        ErrorCode = "READ_ONLY"         // control plane is in maintenance mode
+	CodeConfirmRequired ErrorCode = "CONFIRM_REQUIRED"  // destroy needs "confirm" set to the site name
+	CodeUploadTooLarge  ErrorCode = "UPLOAD_TOO_LARGE"
+	CodeInvalidUpload   ErrorCode = "INVALID_UPLOAD" // not a multipart form or not a zip
+
+	// Sites
+	CodeSiteNotFound        ErrorCode = "SITE_NOT_FOUND"
+	CodeSiteExists          ErrorCode = "SITE_EXISTS"
+	CodeSiteState           ErrorCode = "SITE_STATE"            // site's status does not allow the operation
+	CodeUnsupportedSiteType ErrorCode = "UNSUPPORTED_SITE_TYPE" // e.g. a WordPress-only operation on a static site
+	CodeUnsupportedRouting  ErrorCode = "UNSUPPORTED_ROUTING"   // not available to path-routed sites
+	CodeCaddyManual         ErrorCode = "CADDY_MANUAL"          // Caddy config is manually edited
+	CodeCaddyLogOff         ErrorCode = "CADDY_LOG_OFF"         // site does not log requests to a file
+	CodeResourceConflict    ErrorCode = "RESOURCE_CONFLICT"     // leftover containers or volumes for the slug
+	CodeVolumeUnavailable   ErrorCode = "VOLUME_UNAVAILABLE"
+	CodeTemplateNotFound    ErrorCode = "TEMPLATE_NOT_FOUND"
+	CodeNoPreviousRelease   ErrorCode = "NO_PREVIOUS_RELEASE"
+	CodeWPNotInstalled      ErrorCode = "WP_NOT_INSTALLED"
+	CodeQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeGreenExists         ErrorCode = "GREEN_EXISTS"   // blue/green: a green set already exists
+	CodeNoGreen             ErrorCode = "NO_GREEN"       // blue/green: no green set to cut over to or discard
+	CodeNoRetired           ErrorCode = "NO_RETIRED"     // blue/green: no retired set to roll back to or retire
+	CodeNoCredentials       ErrorCode = "NO_CREDENTIALS" // no admin password waiting to be revealed
+
+	// Domains
+	CodeDomainInvalid     ErrorCode = "DOMAIN_INVALID" // bad format, or a platform base domain
+	CodeDomainTaken       ErrorCode = "DOMAIN_TAKEN"
+	CodeSubdomainReserved ErrorCode = "SUBDOMAIN_RESERVED"
+	CodeDNSMismatch       ErrorCode = "DNS_MISMATCH" // does not resolve, or not to the ingress
+	CodeNoCustomDomain    ErrorCode = "NO_CUSTOM_DOMAIN"
+
+	// Jobs
+	CodeJobNotFound       ErrorCode = "JOB_NOT_FOUND"
+	CodeJobActive         ErrorCode = "JOB_ACTIVE" // site already has a pending or processing job
+	CodeJobNotRetryable   ErrorCode = "JOB_NOT_RETRYABLE"
+	CodeJobNotStuck       ErrorCode = "JOB_NOT_STUCK"       // not PROCESSING past its timeout, or still running
+	CodeJobNotCancellable ErrorCode = "JOB_NOT_CANCELLABLE" // finished, or running a step that cannot be cancelled
+	CodeJobCancelled      ErrorCode = "JOB_CANCELLED"       // reported on FAILED jobs cancelled by request
+
+	// Server side
+	CodeBackupNotConfigured ErrorCode = "BACKUP_NOT_CONFIGURED"
+	CodeFeatureDisabled     ErrorCode = "FEATURE_DISABLED"
+	CodeInfraFailed         ErrorCode = "INFRA_FAILED"   // a Docker, Caddy, nginx or R2 operation failed
+	CodeHostDiskFull        ErrorCode = "HOST_DISK_FULL" // Docker host out of disk; reported on FAILED jobs, not retried
+	CodeAtCapacity          ErrorCode = "AT_CAPACITY"    // MAX_SITES_PER_HOST or MAX_CONTAINERS_PER_HOST reached
+	CodePersistFailed       ErrorCode = "PERSIST_FAILED" // change applied but not recorded; retry the request
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+// errorBody is the error envelope every API error is answered with:
+//
+//	{"error": "<message>", "code": "<ErrorCode>", "status": <HTTP status>}
+//
+// Extra fields (e.g. "conflicts", "checks") sit alongside, see
+// respondErrorWith.
+func errorBody(status int, code ErrorCode, msg string) gin.H {
+	return gin.H{"error": msg, "code": code, "status": status}
+}
+
+// respondError writes the error envelope with status.
+func respondError(c *gin.Context, status int, code ErrorCode, msg string) {
+	c.JSON(status, errorBody(status, code, msg))
+}
+
+// respondErrorWith writes the error envelope with status plus the fields in
+// extra.
+func respondErrorWith(c *gin.Context, status int, code ErrorCode, msg string, extra gin.H) {
+	body := errorBody(status, code, msg)
+	for k, v := range extra {
+		body[k] = v
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifecycle event types. Job events are "<job type>.<phase>", e.g.
+// "provision.started", "static_provision.failed", "destroy.completed".
+const (
+	EventDomainSet     = "domain.set"
+	EventDomainRemoved = "domain.removed"
+	EventDomainMoved   = "domain.moved"
+	EventSiteDeleted   = "site.deleted"
+	EventJobRetried    = "job.retried"
+	EventJobRecovered  = "job.recovered"
+	EventJobCancelled  = "job.cancelled"
+
+	EventNginxSnippetSet       = "nginx_snippet.set"
+	EventFastCGISet            = "fastcgi.set"
+	EventCaddySnippetSet       = "caddy_snippet.set"
+	EventCaddyLogSet           = "caddy_log.set"
+	EventErrorPageSet          = "error_page.set"
+	EventCaddySnippetReset     = "caddy_snippet.reset"
+	EventReleaseRolledBack     = "release.rolled_back"
+	EventConfigRedeployed      = "config.redeployed"
+	EventSiteReconciled        = "site.reconciled"
+	EventSitePaused            = "site.paused"
+	EventSiteResumed           = "site.resumed"
+	EventSubdomainChanged      = "site.subdomain_changed"
+	EventGreenCreated          = "green.created"
+	EventGreenCutover          = "green.cutover"
+	EventGreenRolledBack       = "green.rolled_back"
+	EventGreenDiscarded        = "green.discarded"
+	EventBlueRetired           = "blue.retired"
+	EventBackupScheduleSet     = "backup_schedule.set"
+	EventDomainPending         = "domain.pending"
+	EventDomainAbandoned       = "domain.abandoned"
+	EventAdminPasswordSet      = "admin_password.set"
+	EventAdminPasswordRevealed = "admin_password.revealed"
+)
+
+// jobEventType returns the event type for a job reaching the given phase
+// ("started", "completed" or "failed").
+func jobEventType(t JobType, phase string) string {
+	return strings.ToLower(string(t)) + "." + phase
+}
+
+// Event is a lifecycle notification published to the configured event sink.
+type Event struct {
+	Type      string         `json:"type"`
+	Site      string         `json:"site"`
+	JobID     string         `json:"job_id,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Time      time.Time      `json:"time"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// EventPublisher delivers lifecycle events to an external system. Publish
+// must never block the caller: provisioning and API handlers call it inline.
+type EventPublisher interface {
+	Publish(e Event)
+	// Close flushes queued events until ctx is done, then stops.
+	Close(ctx context.Context)
+}
+
+// NewEventPublisher returns the publisher selected by EVENTS_BACKEND:
+// "webhook", "nats", "redis", or "" / "none" for a no-op.
+func NewEventPublisher(cfg Config) (EventPublisher, error) {
+	var s eventSink
+	switch cfg.EventsBackend {
+	case "", "none":
+		return noopPublisher{}, nil
+	case "webhook":
+		if cfg.EventsWebhookURL == "" {
+			return nil, fmt.Errorf("EVENTS_WEBHOOK_URL is required for the webhook backend")
+		}
+		s = &webhookSink{url: cfg.EventsWebhookURL, client: &http.Client{Timeout: 10 * time.Second}}
+	case "nats":
+		u, err := url.Parse(cfg.EventsURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid EVENTS_URL %q for nats backend", cfg.EventsURL)
+		}
+		s = &natsSink{url: u, subject: cfg.EventsTopic}
+	case "redis":
+		u, err := url.Parse(cfg.EventsURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid EVENTS_URL %q for redis backend", cfg.EventsURL)
+		}
+		s = &redisSink{url: u, stream: cfg.EventsTopic}
+	default:
+		return nil, fmt.Errorf("unknown EVENTS_BACKEND %q", cfg.EventsBackend)
+	}
+	return newAsyncPublisher(cfg.EventsBackend, s), nil
+}
+
+// noopPublisher discards every event. Used when no backend is configured.
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(Event)         {}
+func (noopPublisher) Close(context.Context) {}
+
+// eventSink sends one encoded event. Implementations may hold a connection
+// and are only ever called from the single asyncPublisher goroutine.
+type eventSink interface {
+	send(ctx context.Context, payload []byte) error
+	close()
+}
+
+// eventQueueSize bounds events buffered while the sink is slow or down.
+// When full, new events are dropped (and logged) rather than blocking.
+const eventQueueSize = 1024
+
+// asyncPublisher queues events in memory and delivers them from a single
+// background goroutine, so a down broker never stalls a job or a request.
+type asyncPublisher struct {
+	name  string
+	sink  eventSink
+	queue chan Event
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newAsyncPublisher(name string, sink eventSink) *asyncPublisher {
+	p := &asyncPublisher{
+		name:  name,
+		sink:  sink,
+		queue: make(chan Event, eventQueueSize),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *asyncPublisher) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	select {
+	case p.queue <- e:
+	default:
+		log.Printf("[events] %s queue full — dropped %s site=%s", p.name, e.Type, e.Site)
+	}
+}
+
+func (p *asyncPublisher) Close(ctx context.Context) {
+	p.once.Do(func() { close(p.queue) })
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		log.Printf("[events] %s shutdown before queue drained (%d pending)", p.name, len(p.queue))
+	}
+}
+
+func (p *asyncPublisher) run() {
+	defer close(p.done)
+	defer p.sink.close()
+	for e := range p.queue {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("[events] cannot encode %s: %v", e.Type, err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = p.sink.send(ctx, payload)
+		cancel()
+		if err != nil {
+			log.Printf("[events] %s publish %s site=%s failed: %v", p.name, e.Type, e.Site, err)
+		}
+	}
+}
+
+// ── Webhook ─────────────────────────────────────────────────────────
+
+// webhookSink POSTs each event as JSON to a fixed URL.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) send(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) close() {}
+
+// ── NATS ────────────────────────────────────────────────────────────
+
+// natsSink publishes to a NATS subject using the core text protocol
+// (CONNECT / PUB / PING). Each publish is confirmed with a PING/PONG round
+// trip so a silently dropped connection is detected and redialled.
+type natsSink struct {
+	url     *url.URL
+	subject string
+	conn    net.Conn
+	r       *bufio.Reader
+}
+
+func (s *natsSink) send(ctx context.Context, payload []byte) error {
+	if err := s.publish(ctx, payload); err != nil {
+		// One reconnect per event: the server may have closed an idle connection.
+		s.close()
+		return s.publish(ctx, payload)
+	}
+	return nil
+}
+
+func (s *natsSink) publish(ctx context.Context, payload []byte) error {
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	}
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", s.subject, len(payload), payload)
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		return err
+	}
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			s.conn.Write([]byte("PONG\r\n"))
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", line)
+		}
+	}
+}
+
+func (s *natsSink) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.url.Host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r := bufio.NewReader(conn)
+	if _, err := r.ReadString('\n'); err != nil { // INFO {...}
+		conn.Close()
+		return fmt.Errorf("nats handshake: %w", err)
+	}
+
+	opts := map[string]any{"verbose": false, "pedantic": false, "name": "hostplane"}
+	if s.url.User != nil {
+		opts["user"] = s.url.User.Username()
+		if pass, ok := s.url.User.Password(); ok {
+			opts["pass"] = pass
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	if _, err := conn.Write([]byte("CONNECT " + string(connect) + "\r\n")); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.r = conn, r
+	return nil
+}
+
+func (s *natsSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+	}
+}
+
+// ── Redis ───────────────────────────────────────────────────────────
+
+// redisSink appends each event to a Redis stream with XADD, speaking RESP
+// directly. The URL may carry a password (redis://:pass@host:6379).
+type redisSink struct {
+	url    *url.URL
+	stream string
+	conn   net.Conn
+	r      *bufio.Reader
+}
+
+func (s *redisSink) send(ctx context.Context, payload []byte) error {
+	if err := s.xadd(ctx, payload); err != nil {
+		s.close()
+		return s.xadd(ctx, payload)
+	}
+	return nil
+}
+
+func (s *redisSink) xadd(ctx context.Context, payload []byte) error {
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	}
+	return s.command("XADD", s.stream, "*", "event", string(payload))
+}
+
+func (s *redisSink) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.url.Host)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	if pass, ok := s.url.User.Password(); ok {
+		args := []string{"AUTH", pass}
+		if user := s.url.User.Username(); user != "" {
+			args = []string{"AUTH", user, pass}
+		}
+		if err := s.command(args...); err != nil {
+			s.close()
+			return fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return nil
+}
+
+// command writes a RESP array and reads a single-line reply. XADD returns a
+// bulk string (the entry ID), which is consumed and discarded.
+func (s *redisSink) command(args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := s.conn.Write([]byte(b.String())); err != nil {
+		return err
+	}
+
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch line[0] {
+	case '-':
+		return fmt.Errorf("redis: %s", strings.TrimSpace(line[1:]))
+	case '$':
+		_, err = s.r.ReadString('\n') // bulk payload
+		return err
+	}
+	return nil
+}
+
+func (s *redisSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+	}
+}
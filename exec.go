@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// execMaxOutput caps captured stdout/stderr per stream so a chatty command
+// (e.g. `wp plugin list` on a huge site) cannot balloon an API response.
+const execMaxOutput = 64 * 1024
+
+// ExecResult is the captured outcome of a command exec'd in a container.
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// runExec runs cmd inside containerName, waits for it to exit and returns its
+// output and exit code. A non-zero exit is not an error; callers inspect
+// ExitCode. The exec runs without a shell, so arguments are passed verbatim.
+func runExec(ctx context.Context, docker *client.Client, containerName string, cmd []string) (*ExecResult, error) {
+	execResp, err := docker.ContainerExecCreate(ctx, containerName, types.ExecConfig{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exec create: %w", err)
+	}
+
+	attach, err := docker.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("exec attach: %w", err)
+	}
+	defer attach.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(
+		&limitedWriter{w: &stdout, n: execMaxOutput},
+		&limitedWriter{w: &stderr, n: execMaxOutput},
+		attach.Reader,
+	); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("exec read output: %w", err)
+	}
+
+	inspect, err := docker.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return nil, fmt.Errorf("exec inspect: %w", err)
+	}
+
+	return &ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: inspect.ExitCode,
+	}, nil
+}
+
+// limitedWriter keeps the first n bytes and silently discards the rest, so
+// the exec stream is always drained to completion.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		keep := p
+		if len(keep) > l.n {
+			keep = keep[:l.n]
+		}
+		written, err := l.w.Write(keep)
+		l.n -= written
+		if err != nil {
+			return written, err
+		}
+	}
+	return len(p), nil
+}
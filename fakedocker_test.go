@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+// fakeDocker is a Docker Engine API serving the container and volume calls
+// the provisioner and destroyer make, from in-memory tables, for unit tests
+// that must not need a Docker host. StartErr makes every start fail as the
+// daemon would report it, leaving the container "created".
+type fakeDocker struct {
+	mu            sync.Mutex
+	containers    map[string]string // name → state
+	volumes       map[string]string // name → driver
+	VolumePlugins []string          // volume drivers /info reports as loaded
+	StartErr      string
+	Logs          string // what the container wrote before failing
+	Creates       int
+	Removes       int
+}
+
+// newFakeDocker starts a fakeDocker and returns a client for it.
+func newFakeDocker(t *testing.T) (*fakeDocker, *client.Client) {
+	t.Helper()
+	f := &fakeDocker{containers: map[string]string{}, volumes: map[string]string{}, VolumePlugins: []string{"local"}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	docker, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(srv.URL, "http://")),
+		client.WithHTTPClient(srv.Client()),
+		client.WithVersion("1.44"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { docker.Close() })
+	return f, docker
+}
+
+// state returns the state of container name, "" when it does not exist.
+func (f *fakeDocker) state(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.containers[name]
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1.44")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/info":
+		json.NewEncoder(w).Encode(map[string]any{"Plugins": map[string]any{"Volume": f.VolumePlugins}})
+		return
+	case len(parts) == 2 && parts[0] == "volumes":
+		f.serveVolume(w, r, parts[1])
+		return
+	}
+	if len(parts) < 2 || parts[0] != "containers" {
+		fakeDockerError(w, http.StatusNotImplemented, "fakeDocker: "+r.Method+" "+path)
+		return
+	}
+	name := parts[1]
+	state, exists := f.containers[name]
+
+	switch {
+	case r.Method == http.MethodPost && name == "create":
+		name = r.URL.Query().Get("name")
+		if _, ok := f.containers[name]; ok {
+			fakeDockerError(w, http.StatusConflict, "Conflict. The container name \"/"+name+"\" is already in use")
+			return
+		}
+		f.containers[name] = "created"
+		f.Creates++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"Id": name, "Warnings": []string{}})
+	case !exists:
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+name)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "start":
+		if f.StartErr != "" {
+			fakeDockerError(w, http.StatusInternalServerError, f.StartErr)
+			return
+		}
+		f.containers[name] = "running"
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "json":
+		st := map[string]any{"Status": state, "Running": state == "running"}
+		if state == "created" && f.StartErr != "" {
+			st["ExitCode"] = 128
+			st["Error"] = f.StartErr
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Id": name, "Name": "/" + name, "State": st})
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "logs":
+		// A non-TTY container's logs are multiplexed: an 8-byte header
+		// (stream, 0, 0, 0, big-endian length) before each frame.
+		hdr := make([]byte, 8)
+		hdr[0] = 2 // stderr
+		binary.BigEndian.PutUint32(hdr[4:], uint32(len(f.Logs)))
+		w.Write(append(hdr, f.Logs...))
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		delete(f.containers, name)
+		f.Removes++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeDockerError(w, http.StatusNotImplemented, "fakeDocker: "+r.Method+" "+path)
+	}
+}
+
+// serveVolume inspects or removes volume name. A volume whose driver is not
+// among VolumePlugins reads as not found, as it does on a daemon whose
+// plugin is missing.
+func (f *fakeDocker) serveVolume(w http.ResponseWriter, r *http.Request, name string) {
+	driver, exists := f.volumes[name]
+	loaded := false
+	for _, p := range f.VolumePlugins {
+		loaded = loaded || p == driver || p == driver+":latest"
+	}
+	if !exists || !loaded {
+		fakeDockerError(w, http.StatusNotFound, "get "+name+": no such volume")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]any{"Name": name, "Driver": driver})
+	case http.MethodDelete:
+		delete(f.volumes, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeDockerError(w, http.StatusNotImplemented, "fakeDocker: "+r.Method+" /volumes/"+name)
+	}
+}
+
+func fakeDockerError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
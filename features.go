@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Feature names an optional capability a deployment can switch off with
+// FEATURES, so a minimal and a full control plane run from the same binary.
+type Feature string
+
+const (
+	FeatureWPCLI     Feature = "wpcli"     // POST /api/sites/:site/wp-cli
+	FeatureBackups   Feature = "backups"   // on-demand and scheduled backups, restores, the daily run
+	FeatureRedis     Feature = "redis"     // object_cache on provision
+	FeatureBlueGreen Feature = "bluegreen" // /api/sites/:site/green*
+	FeatureHooks     Feature = "hooks"     // post-provision hooks, per request or POST_PROVISION_HOOK
+	FeatureStatic    Feature = "static"    // static sites: provision, deploy, rollback
+	FeatureClone     Feature = "clone"     // POST /api/sites/:site/clone
+)
+
+// allFeatures is every Feature, in the order GET /api/version lists them.
+var allFeatures = []Feature{
+	FeatureWPCLI, FeatureBackups, FeatureRedis, FeatureBlueGreen,
+	FeatureHooks, FeatureStatic, FeatureClone,
+}
+
+// parseFeatures turns FEATURES into the enabled set. Unset (or "all")
+// enables everything, so deployments that predate the flags are unchanged;
+// "none" enables nothing. Unknown names are an error rather than silently
+// ignored, since a typo would quietly disable a feature.
+func parseFeatures(names []string) (map[Feature]bool, error) {
+	enabled := map[Feature]bool{}
+	if len(names) == 0 || (len(names) == 1 && names[0] == "all") {
+		for _, f := range allFeatures {
+			enabled[f] = true
+		}
+		return enabled, nil
+	}
+	if len(names) == 1 && names[0] == "none" {
+		return enabled, nil
+	}
+	known := map[Feature]bool{}
+	for _, f := range allFeatures {
+		known[f] = true
+	}
+	for _, name := range names {
+		f := Feature(strings.ToLower(name))
+		if !known[f] {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		enabled[f] = true
+	}
+	return enabled, nil
+}
+
+// FeatureEnabled reports whether f is enabled in this deployment.
+func (c Config) FeatureEnabled(f Feature) bool {
+	return c.Features[f]
+}
+
+// enabledFeatures returns the enabled features, sorted.
+func (c Config) enabledFeatures() []string {
+	out := []string{}
+	for f, on := range c.Features {
+		if on {
+			out = append(out, string(f))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// respondFeatureDisabled answers 501 for a request needing feature f.
+func respondFeatureDisabled(c *gin.Context, f Feature) {
+	respondError(c, http.StatusNotImplemented, CodeFeatureDisabled,
+		fmt.Sprintf("the %s feature is disabled on this control plane (FEATURES)", f))
+}
+
+// requireFeature rejects every request to the route with 501 unless f is
+// enabled.
+func requireFeature(cfg Config, f Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.FeatureEnabled(f) {
+			respondFeatureDisabled(c, f)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// workerStalePolls is how many poll intervals may pass without a poll
+// before the worker is reported unhealthy.
+const workerStalePolls = 5
+
+// WorkerHeartbeat is the worker loop's liveness record, kept in memory and
+// read by the API. It tells "no jobs" apart from "worker wedged": an idle
+// worker still polls every WorkerPollInterval, and a busy one is expected
+// back by its job's timeout.
+type WorkerHeartbeat struct {
+	mu           sync.Mutex
+	pollInterval time.Duration
+	startedAt    time.Time
+	lastPollAt   time.Time
+	lastDoneAt   time.Time // last job that COMPLETED
+	lastFailAt   time.Time // last attempt that failed
+	busySince    time.Time // zero when idle
+	busyUntil    time.Time // the running job's deadline
+	busyJob      string    // ID of the running job ("" when idle)
+	busyType     JobType
+	busySite     string
+	busyAttempt  int
+	busyCancel   context.CancelCauseFunc
+}
+
+// WorkerStatus is a snapshot of the heartbeat as reported by the API.
+type WorkerStatus struct {
+	Healthy         bool       `json:"healthy"`
+	Detail          string     `json:"detail,omitempty"`
+	LastPollAt      *time.Time `json:"last_poll_at"`
+	LastCompletedAt *time.Time `json:"last_completed_at"`
+	LastFailedAt    *time.Time `json:"last_failed_at,omitempty"`
+	BusySince       *time.Time `json:"busy_since,omitempty"`
+}
+
+// WorkerJob is a job the worker is running, as reported by
+// GET /api/admin/worker.
+type WorkerJob struct {
+	ID         string    `json:"id"`
+	Type       JobType   `json:"type"`
+	Site       string    `json:"site"`
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	Deadline   time.Time `json:"deadline"` // when the job's timeout cancels it
+	ElapsedSec int64     `json:"elapsed_sec"`
+}
+
+func NewWorkerHeartbeat(pollInterval time.Duration) *WorkerHeartbeat {
+	return &WorkerHeartbeat{pollInterval: pollInterval, startedAt: time.Now().UTC()}
+}
+
+// polled records one pass of the poll loop, whether or not it found a job.
+func (h *WorkerHeartbeat) polled() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastPollAt = time.Now().UTC()
+}
+
+// started records that the worker is running job, due by deadline; cancel
+// cancels the attempt's context (see Cancel).
+func (h *WorkerHeartbeat) started(job *Job, deadline time.Time, cancel context.CancelCauseFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.busySince = time.Now().UTC()
+	h.busyUntil = deadline
+	h.busyJob = job.ID
+	h.busyType, h.busySite, h.busyAttempt = job.Type, job.Site, job.Attempts
+	h.busyCancel = cancel
+}
+
+// Cancel cancels the context of job jobID with errJobCancelled if this
+// process's worker is running it, and reports whether it was.
+func (h *WorkerHeartbeat) Cancel(jobID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busyJob == "" || h.busyJob != jobID {
+		return false
+	}
+	h.busyCancel(errJobCancelled)
+	return true
+}
+
+// Running reports whether this process's worker is currently on job jobID.
+func (h *WorkerHeartbeat) Running(jobID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.busyJob != "" && h.busyJob == jobID
+}
+
+// finished records the end of a job attempt.
+func (h *WorkerHeartbeat) finished(ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now().UTC()
+	if ok {
+		h.lastDoneAt = now
+	} else {
+		h.lastFailAt = now
+	}
+	h.busySince, h.busyUntil, h.busyJob, h.busyCancel = time.Time{}, time.Time{}, "", nil
+	h.busyType, h.busySite, h.busyAttempt = "", "", 0
+}
+
+// Jobs returns the jobs this process's worker is running: none or one, as
+// the worker runs one job at a time.
+func (h *WorkerHeartbeat) Jobs() []WorkerJob {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	jobs := []WorkerJob{}
+	if h.busyJob == "" {
+		return jobs
+	}
+	return append(jobs, WorkerJob{
+		ID:         h.busyJob,
+		Type:       h.busyType,
+		Site:       h.busySite,
+		Attempt:    h.busyAttempt,
+		StartedAt:  h.busySince,
+		Deadline:   h.busyUntil.UTC(),
+		ElapsedSec: int64(time.Since(h.busySince).Seconds()),
+	})
+}
+
+// PollInterval is the interval the worker polls for jobs at.
+func (h *WorkerHeartbeat) PollInterval() time.Duration {
+	return h.pollInterval
+}
+
+// StartedAt is when the worker's heartbeat began, at process start.
+func (h *WorkerHeartbeat) StartedAt() time.Time {
+	return h.startedAt
+}
+
+// Status reports the heartbeat. The worker is unhealthy when it has not
+// polled for workerStalePolls intervals while idle, or is still on a job
+// well past that job's timeout.
+func (h *WorkerHeartbeat) Status() WorkerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st := WorkerStatus{
+		Healthy:         true,
+		LastPollAt:      timePtr(h.lastPollAt),
+		LastCompletedAt: timePtr(h.lastDoneAt),
+		LastFailedAt:    timePtr(h.lastFailAt),
+		BusySince:       timePtr(h.busySince),
+	}
+	now := time.Now()
+	stale := workerStalePolls * h.pollInterval
+	switch {
+	case !h.busySince.IsZero():
+		if over := now.Sub(h.busyUntil); over > stale {
+			st.Healthy = false
+			st.Detail = "job still running " + over.Round(time.Second).String() + " past its timeout"
+		}
+	case h.lastPollAt.IsZero():
+		if now.Sub(h.startedAt) > stale {
+			st.Healthy = false
+			st.Detail = "worker has not polled since startup"
+		}
+	case now.Sub(h.lastPollAt) > stale:
+		st.Healthy = false
+		st.Detail = "no poll for " + now.Sub(h.lastPollAt).Round(time.Second).String()
+	}
+	return st
+}
+
+// timePtr returns nil for the zero time.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
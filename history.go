@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// TimelineEntry is one item in a site's history: either a job (with its
+// outcome) or a recorded HistoryEntry such as a status transition.
+type TimelineEntry struct {
+	Time   time.Time    `json:"time"`
+	Kind   string       `json:"kind"` // "job", "status" or a lifecycle event type
+	Detail string       `json:"detail,omitempty"`
+	Job    *TimelineJob `json:"job,omitempty"`
+}
+
+// TimelineJob is the job summary embedded in a "job" timeline entry.
+type TimelineJob struct {
+	ID          string     `json:"id"`
+	Type        JobType    `json:"type"`
+	Status      JobStatus  `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       *string    `json:"error,omitempty"`
+	RequestID   string     `json:"request_id,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	DurationMs  *int64     `json:"duration_ms,omitempty"`
+}
+
+// buildSiteTimeline merges a site's jobs and recorded history into one
+// chronological list. Jobs are placed at the time they were queued.
+func buildSiteTimeline(jobs []Job, history []HistoryEntry) []TimelineEntry {
+	timeline := make([]TimelineEntry, 0, len(jobs)+len(history))
+	for i := range jobs {
+		j := &jobs[i]
+		timeline = append(timeline, TimelineEntry{
+			Time:   j.CreatedAt,
+			Kind:   "job",
+			Detail: string(j.Type) + " " + string(j.Status),
+			Job: &TimelineJob{
+				ID:          j.ID,
+				Type:        j.Type,
+				Status:      j.Status,
+				Attempts:    j.Attempts,
+				Error:       j.Error,
+				RequestID:   j.RequestID,
+				StartedAt:   j.StartedAt,
+				CompletedAt: j.CompletedAt,
+				DurationMs:  durationMs(j.Duration()),
+			},
+		})
+	}
+	for _, h := range history {
+		timeline = append(timeline, TimelineEntry{Time: h.CreatedAt, Kind: h.Kind, Detail: h.Detail})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Time.Before(timeline[j].Time)
+	})
+	return timeline
+}
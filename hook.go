@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// PostProvisionHook is custom setup run as the last step of a WordPress
+// provision: installing a plugin set, seeding content, wiring up an
+// integration. Command runs under `sh -c`, either exec'd in the site's PHP
+// container or, when Image is set, in a one-shot container of that image
+// with the site volume at /var/www/html on the backend network.
+type PostProvisionHook struct {
+	Command string `json:"command"`
+	Image   string `json:"image,omitempty"`
+
+	// Blocking makes a failing hook (error or non-zero exit) fail the
+	// provision, which rolls the site back. Otherwise the failure is logged
+	// and reported in the job result, and the site stays up.
+	Blocking bool `json:"blocking,omitempty"`
+}
+
+// hookCommandMaxLen caps a hook command supplied with a provision request.
+const hookCommandMaxLen = 4096
+
+// HookResult is the captured outcome of a post-provision hook, stored in the
+// job result.
+type HookResult struct {
+	Command    string `json:"command"`
+	Image      string `json:"image,omitempty"`
+	Blocking   bool   `json:"blocking"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ProvisionResult is what a provision reports beyond success or failure.
+// Stored as JSON in jobs.result.
+type ProvisionResult struct {
+	Install *InstallResult `json:"install,omitempty"`
+	Hook    *HookResult    `json:"hook,omitempty"`
+}
+
+// postProvisionHook returns the hook to run for a provision: the one given
+// with the request, else the global POST_PROVISION_HOOK, else nil.
+func postProvisionHook(cfg Config, opts ProvisionOptions) *PostProvisionHook {
+	if !cfg.FeatureEnabled(FeatureHooks) {
+		return nil
+	}
+	if opts.Hook != nil {
+		return opts.Hook
+	}
+	if cfg.PostProvisionHook == "" {
+		return nil
+	}
+	return &PostProvisionHook{
+		Command:  cfg.PostProvisionHook,
+		Image:    cfg.PostProvisionHookImage,
+		Blocking: cfg.PostProvisionHookBlocking,
+	}
+}
+
+// runHook runs hook against site, whose files are in volName and whose
+// containers are on network, bounded by PostProvisionHookTimeout. The
+// returned result is always non-nil; err is set when the hook could not run
+// or exited non-zero.
+func (p *Provisioner) runHook(ctx context.Context, site, volName, network string, hook *PostProvisionHook) (*HookResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.PostProvisionHookTimeout)
+	defer cancel()
+
+	res := &HookResult{Command: hook.Command, Image: hook.Image, Blocking: hook.Blocking}
+	cmd := []string{"sh", "-c", hook.Command}
+	start := time.Now()
+
+	var err error
+	if hook.Image == "" {
+		var exec *ExecResult
+		if exec, err = runExec(ctx, p.docker, PHPContainerName(site), cmd); err == nil {
+			res.Stdout, res.Stderr, res.ExitCode = exec.Stdout, exec.Stderr, exec.ExitCode
+		}
+	} else {
+		err = p.runHookContainer(ctx, site, volName, network, hook.Image, cmd, res)
+	}
+	res.DurationMs = time.Since(start).Milliseconds()
+
+	if err == nil && res.ExitCode != 0 {
+		err = fmt.Errorf("exited with code %d", res.ExitCode)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res, err
+	}
+	jobLogf(ctx, "provisioner", "site=%s post-provision hook completed in %dms", site, res.DurationMs)
+	return res, nil
+}
+
+// runHookContainer runs cmd in a one-shot container of image with the site
+// volume mounted where the PHP container has it, on the site's network, and
+// the site's database credentials in the environment, filling in res.
+func (p *Provisioner) runHookContainer(ctx context.Context, site, volName, network, image string, cmd []string, res *HookResult) error {
+	name := fmt.Sprintf("hook_%s_%d", site, time.Now().UnixNano())
+	var stdout, stderr bytes.Buffer
+
+	exitCode, err := p.runOneShotOutput(ctx, name,
+		&container.Config{
+			Image:      image,
+			Cmd:        cmd,
+			WorkingDir: "/var/www/html",
+			Env: []string{
+				"WORDPRESS_DB_HOST=" + p.cfg.DBHost(),
+				"WORDPRESS_DB_NAME=" + WPDatabaseName(site),
+				"WORDPRESS_DB_USER=" + WPDatabaseUser(site),
+				"WORDPRESS_DB_PASSWORD=" + WPDatabasePass(site),
+			},
+		},
+		&container.HostConfig{
+			NetworkMode: container.NetworkMode(network),
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: volName, Target: "/var/www/html"},
+			},
+		},
+		&limitedWriter{w: &stdout, n: execMaxOutput},
+		&limitedWriter{w: &stderr, n: execMaxOutput},
+	)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	res.ExitCode = int(exitCode)
+	return err
+}
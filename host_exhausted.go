@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// exhaustionMarkers are substrings of Docker daemon, storage driver and MySQL
+// errors that mean the host has run out of a resource. Retrying cannot help
+// until an operator frees space, so such failures are not retried.
+var exhaustionMarkers = []string{
+	"no space left on device",
+	"disk quota exceeded",
+	"not enough free space",
+	"thin pool has", // devicemapper: "thin pool has N free data blocks ..."
+	"errcode: 28",   // MySQL: "Error writing file ... (Errcode: 28 - No space left on device)"
+	"the table is full",
+}
+
+// HostExhaustedError marks a job failure caused by the Docker host running
+// out of disk. Detail carries what the daemon reported about its storage, or
+// is empty when that could not be read.
+type HostExhaustedError struct {
+	Err    error
+	Detail string
+}
+
+func (e *HostExhaustedError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("host out of disk — free space on the Docker host, then retry the job: %v", e.Err)
+	}
+	return fmt.Sprintf("host out of disk (%s) — free space on the Docker host, then retry the job: %v", e.Detail, e.Err)
+}
+
+func (e *HostExhaustedError) Unwrap() error { return e.Err }
+
+// isHostExhausted reports whether err, anywhere in its chain, is a
+// resource-exhaustion failure: an already classified HostExhaustedError, a
+// local ENOSPC/EDQUOT, or a daemon error whose message says so.
+func isHostExhausted(err error) bool {
+	if err == nil {
+		return false
+	}
+	var he *HostExhaustedError
+	if errors.As(err, &he) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range exhaustionMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// jobErrorCode is the code recorded on a FAILED job, letting clients tell a
+// host problem apart from an ordinary infrastructure failure.
+func jobErrorCode(err error) ErrorCode {
+	if isHostExhausted(err) {
+		return CodeHostDiskFull
+	}
+	if errors.Is(err, errJobCancelled) {
+		return CodeJobCancelled
+	}
+	return CodeInfraFailed
+}
+
+// classifyExhaustion wraps err in a HostExhaustedError when it is a
+// resource-exhaustion failure, attaching the daemon's storage stats; any
+// other error is returned unchanged. It uses a fresh context because the job
+// context may already be done.
+func (p *Provisioner) classifyExhaustion(err error) error {
+	if !isHostExhausted(err) {
+		return err
+	}
+	var he *HostExhaustedError
+	if errors.As(err, &he) {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info, infoErr := p.docker.Info(ctx)
+	if infoErr != nil {
+		log.Printf("[provisioner] docker info after disk-full error: %v", infoErr)
+		return &HostExhaustedError{Err: err}
+	}
+	detail := []string{"driver=" + info.Driver, "root=" + info.DockerRootDir}
+	for _, kv := range info.DriverStatus {
+		// devicemapper and friends report free space here; overlay2 does not.
+		if strings.Contains(strings.ToLower(kv[0]), "space") {
+			detail = append(detail, kv[0]+"="+kv[1])
+		}
+	}
+	return &HostExhaustedError{Err: err, Detail: strings.Join(detail, " ")}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+)
+
+// Integration tests run against a real control DB, Docker host and
+// WordPress database server, configured by the same environment as the
+// control plane itself (see LoadConfig). They are skipped unless
+// HOSTPLANE_INTEGRATION=1; point them at a disposable host.
+
+// integrationConfig loads the control plane's configuration, skipping the
+// test unless integration tests are enabled.
+func integrationConfig(t *testing.T) Config {
+	t.Helper()
+	if os.Getenv("HOSTPLANE_INTEGRATION") != "1" {
+		t.Skip("integration test: set HOSTPLANE_INTEGRATION=1 and the control plane's environment to run")
+	}
+	return LoadConfig()
+}
+
+// integrationDB connects to the control DB and migrates it.
+func integrationDB(t *testing.T, cfg Config) *DB {
+	t.Helper()
+	db, err := NewDB(cfg.ControlDSN)
+	if err != nil {
+		t.Fatalf("control DB: %v", err)
+	}
+	if err := db.MigrateSchema(); err != nil {
+		t.Logf("schema migration warning: %v", err)
+	}
+	t.Cleanup(func() { db.conn.Close() })
+	return db
+}
+
+// integrationDocker connects to the Docker host the way main does.
+func integrationDocker(t *testing.T, cfg Config) *client.Client {
+	t.Helper()
+	docker, err := client.NewClientWithOpts(
+		client.WithHost(cfg.DockerHost),
+		client.WithTLSClientConfig(
+			cfg.DockerCertDir+"/ca.pem",
+			cfg.DockerCertDir+"/cert.pem",
+			cfg.DockerCertDir+"/key.pem",
+		),
+		client.WithVersion("1.44"),
+	)
+	if err != nil {
+		t.Fatalf("docker client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := docker.Info(ctx); err != nil {
+		t.Fatalf("docker: %v", err)
+	}
+	t.Cleanup(func() { docker.Close() })
+	return docker
+}
+
+// testSiteName returns a site slug no other run uses, short enough for
+// every resource name derived from it.
+func testSiteName() string {
+	return "it" + uuid.NewString()[:8]
+}
+
+// destroyTestSite removes whatever a test left of site, so a failed
+// assertion does not leak resources into the next run.
+func destroyTestSite(t *testing.T, docker *client.Client, cfg Config, site string) {
+	t.Helper()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		for _, name := range append(siteContainerNames(site), RedisContainerName(site)) {
+			docker.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true})
+		}
+		for _, name := range siteVolumeNames(site) {
+			docker.VolumeRemove(ctx, name, true)
+		}
+		p := NewProvisioner(docker, cfg)
+		p.dropDatabase(WPDatabaseName(site), WPDatabaseUser(site))
+		p.removeCaddyConfig(site)
+		reloadCaddy(ctx, cfg)
+	})
+}
+
+// provisionTestSite provisions site for a test that needs a live one.
+func provisionTestSite(t *testing.T, docker *client.Client, cfg Config, site string) {
+	t.Helper()
+	destroyTestSite(t, docker, cfg, site)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := NewProvisioner(docker, cfg).Run(ctx, site, ProvisionOptions{}); err != nil {
+		t.Fatalf("provision %s: %v", site, err)
+	}
+}
+
+// waitForContainer polls until container name exists or ctx is done.
+func waitForContainer(ctx context.Context, docker *client.Client, name string) error {
+	for {
+		if _, err := docker.ContainerInspect(ctx, name); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container %s never appeared: %w", name, ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// assertRolledBack fails t if anything provisioning site creates is left:
+// its containers, volumes, database or database user.
+func assertRolledBack(t *testing.T, docker *client.Client, cfg Config, site string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, name := range []string{PHPContainerName(site), NginxContainerName(site), RedisContainerName(site)} {
+		if _, err := docker.ContainerInspect(ctx, name); !client.IsErrNotFound(err) {
+			t.Errorf("container %s still exists after rollback (err=%v)", name, err)
+		}
+	}
+	for _, name := range []string{VolumeName(site), NginxConfVolumeName(site)} {
+		if _, err := docker.VolumeInspect(ctx, name); !client.IsErrNotFound(err) {
+			t.Errorf("volume %s still exists after rollback (err=%v)", name, err)
+		}
+	}
+
+	db, err := sql.Open("mysql", cfg.WordPressDSN)
+	if err != nil {
+		t.Fatalf("WordPress DB: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME=?`, WPDatabaseName(site)).Scan(&n); err != nil {
+		t.Fatalf("query schemata: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("database %s still exists after rollback", WPDatabaseName(site))
+	}
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM mysql.user WHERE User=?`, WPDatabaseUser(site)).Scan(&n); err != nil {
+		t.Fatalf("query users: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("database user %s still exists after rollback", WPDatabaseUser(site))
+	}
+}
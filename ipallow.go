@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseCIDRList parses CIDRs for the API allowlist. A bare address is taken
+// as a single-host range.
+func parseCIDRList(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", e)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", e)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func cidrsContain(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowMiddleware rejects requests whose client address is outside
+// API_ALLOW_CIDRS with 403. It must run before authMiddleware so callers
+// outside the management network learn nothing about key validity. A no-op
+// when no CIDRs are configured.
+func ipAllowMiddleware(cfg Config) gin.HandlerFunc {
+	if len(cfg.APIAllowCIDRs) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		ip := resolveClientIP(c.Request, cfg)
+		if ip == nil || !cidrsContain(cfg.APIAllowCIDRs, ip) {
+			log.Printf("[api] rejected %s %s from %s: not in API_ALLOW_CIDRS req=%s",
+				c.Request.Method, c.Request.URL.Path, ip, requestID(c))
+			c.AbortWithStatusJSON(http.StatusForbidden, errorBody(http.StatusForbidden, CodeForbidden, "forbidden"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// resolveClientIP returns the address the allowlist is checked against. The
+// TCP peer is used unless it is a trusted proxy and APIClientIPHeader is set;
+// then the header is walked right to left, skipping further trusted proxies,
+// so a client cannot spoof its way in by prepending entries.
+func resolveClientIP(r *http.Request, cfg Config) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || cfg.APIClientIPHeader == "" || !cidrsContain(cfg.APITrustedProxies, peer) {
+		return peer
+	}
+
+	hops := strings.Split(r.Header.Get(cfg.APIClientIPHeader), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return nil // malformed header from a trusted proxy — fail closed
+		}
+		if !cidrsContain(cfg.APITrustedProxies, ip) {
+			return ip
+		}
+	}
+	return peer
+}
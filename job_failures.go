@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Grouping failed jobs by cause. Job errors embed the site they failed for
+// — container and database names, domains, ids — so no two provisions fail
+// with the same text. normalizeJobError reduces an error to its class by
+// replacing those parts with placeholders, and GET /api/jobs/failures
+// counts recent failures per class: "18 failed with 'cannot reach DB', 2
+// with 'nginx reload'" rather than 20 messages to read.
+
+// jobErrorPatterns are the variable parts of an error message, in the
+// order they are replaced: later patterns must not match an earlier
+// placeholder.
+var jobErrorPatterns = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<id>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{12,64}\b`), "<id>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<addr>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ns|µs|ms|s|m|h)\b`), "<duration>"},
+	{regexp.MustCompile(`\b\d{5,}\b`), "<n>"},
+}
+
+// jobErrorClassMax bounds the length of an error class; the rest of a long
+// message (a command's output, say) rarely helps tell causes apart.
+const jobErrorClassMax = 200
+
+// normalizeJobError returns the class of a job's error: msg with the site's
+// name and domains, ids, addresses, durations and large numbers replaced by
+// placeholders.
+func normalizeJobError(msg, site string, domains []string) string {
+	// Domains first: the default domain contains the site name.
+	for _, d := range domains {
+		msg = replaceFold(msg, d, "<domain>")
+	}
+	if site != "" {
+		msg = replaceFold(msg, site, "<site>")
+	}
+	for _, p := range jobErrorPatterns {
+		msg = p.re.ReplaceAllString(msg, p.placeholder)
+	}
+	msg = strings.Join(strings.Fields(msg), " ")
+	if len(msg) > jobErrorClassMax {
+		msg = strings.ToValidUTF8(msg[:jobErrorClassMax], "") + "…"
+	}
+	return msg
+}
+
+// replaceFold replaces the case-insensitive occurrences of old in s that
+// are not part of a longer word, so a short site name such as "db" is
+// replaced in "wp_db" but not in "mysqldb". An underscore counts as a
+// separator: container and database names join the site name with one.
+func replaceFold(s, old, repl string) string {
+	re := regexp.MustCompile(`(?i)(^|[^a-z0-9])` + regexp.QuoteMeta(old) + `($|[^a-z0-9])`)
+	// Twice, since adjacent occurrences share the separator between them.
+	for range 2 {
+		s = re.ReplaceAllString(s, "${1}"+strings.ReplaceAll(repl, "$", "$$")+"${2}")
+	}
+	return s
+}
+
+// jobFailureSampleSites is how many sites a JobFailureClass lists.
+const jobFailureSampleSites = 5
+
+// JobFailureClass is one error class in GET /api/jobs/failures.
+type JobFailureClass struct {
+	Error        string         `json:"error"`
+	ErrorCode    string         `json:"error_code,omitempty"`
+	Count        int            `json:"count"`
+	Types        map[string]int `json:"types"`
+	Sites        []string       `json:"sites"` // the most recent few, distinct
+	ExampleJobID string         `json:"example_job_id"`
+	ExampleError string         `json:"example_error"` // the newest failure's message as stored
+	LastSeen     time.Time      `json:"last_seen"`
+}
+
+// summarizeJobFailures groups failures, newest first, by error code and
+// class, most frequent class first.
+func summarizeJobFailures(failures []FailedJobError) []JobFailureClass {
+	byClass := map[string]*JobFailureClass{}
+	var classes []*JobFailureClass
+	for _, f := range failures {
+		class := normalizeJobError(f.Error, f.Site, f.Domains)
+		key := f.ErrorCode + "\x00" + class
+		jc, ok := byClass[key]
+		if !ok {
+			jc = &JobFailureClass{
+				Error:        class,
+				ErrorCode:    f.ErrorCode,
+				Types:        map[string]int{},
+				Sites:        []string{},
+				ExampleJobID: f.JobID,
+				ExampleError: f.Error,
+				LastSeen:     f.FailedAt,
+			}
+			byClass[key] = jc
+			classes = append(classes, jc)
+		}
+		jc.Count++
+		jc.Types[string(f.Type)]++
+		if len(jc.Sites) < jobFailureSampleSites && !slices.Contains(jc.Sites, f.Site) {
+			jc.Sites = append(jc.Sites, f.Site)
+		}
+	}
+
+	// Stable, so classes with equal counts stay newest first.
+	sort.SliceStable(classes, func(i, j int) bool { return classes[i].Count > classes[j].Count })
+	out := make([]JobFailureClass, len(classes))
+	for i, jc := range classes {
+		out[i] = *jc
+	}
+	return out
+}
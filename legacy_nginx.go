@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// Static sites were once routed by an edge nginx, from a <site>.conf server
+// block in its conf.d. Caddy is now their only edge (see CaddyConfFile), but
+// a block left on the old edge keeps answering for the site's domains
+// wherever traffic still reaches it — after a domain change, and after
+// destroy. Those blocks are removed once for every site at startup
+// (removeLegacyNginxConfs) and by reconcile's legacy_nginx_conf step. Both
+// do nothing unless EDGE_NGINX_CONTAINER names the old edge.
+
+// legacyNginxConfPath returns where the edge nginx kept site's server block.
+func legacyNginxConfPath(cfg Config, site string) string {
+	return cfg.EdgeNginxConfDir + "/" + site + ".conf"
+}
+
+// legacyNginxConfExists reports whether the edge nginx still has a server
+// block for site. A missing edge container has none.
+func legacyNginxConfExists(ctx context.Context, docker *client.Client, cfg Config, site string) (bool, error) {
+	if cfg.EdgeNginxContainer == "" {
+		return false, nil
+	}
+	conf, err := readContainerFile(ctx, docker, cfg.EdgeNginxContainer, legacyNginxConfPath(cfg, site))
+	return conf != "", err
+}
+
+// removeLegacyNginxConf deletes site's server block from the edge nginx and
+// reloads it.
+func removeLegacyNginxConf(ctx context.Context, docker *client.Client, cfg Config, site string) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.DockerReloadTimeout)
+	defer cancel()
+
+	path := legacyNginxConfPath(cfg, site)
+	res, err := runExec(ctx, docker, cfg.EdgeNginxContainer, []string{"rm", "-f", path})
+	if err != nil {
+		return fmt.Errorf("remove %s from %s: %w", path, cfg.EdgeNginxContainer, err)
+	}
+	if res.ExitCode != 0 {
+		return fmt.Errorf("remove %s from %s: %s", path, cfg.EdgeNginxContainer, strings.TrimSpace(res.Stderr))
+	}
+	return reloadNginx(ctx, docker, cfg, cfg.EdgeNginxContainer)
+}
+
+// removeLegacyNginxConfs removes the edge nginx server block of every site
+// that still has one. Failures are logged; reconcile reports what is left.
+func removeLegacyNginxConfs(ctx context.Context, docker *client.Client, cfg Config, db *DB) {
+	if cfg.EdgeNginxContainer == "" {
+		return
+	}
+	sites, err := db.ListSites()
+	if err != nil {
+		log.Printf("[legacy-nginx] cannot list sites: %v", err)
+		return
+	}
+	removed := 0
+	for _, s := range sites {
+		exists, err := legacyNginxConfExists(ctx, docker, cfg, s.Site)
+		if err != nil {
+			log.Printf("[legacy-nginx] site=%s: %v", s.Site, err)
+			continue
+		}
+		if !exists {
+			continue
+		}
+		if err := removeLegacyNginxConf(ctx, docker, cfg, s.Site); err != nil {
+			log.Printf("[legacy-nginx] site=%s: %v", s.Site, err)
+			continue
+		}
+		removed++
+		log.Printf("[legacy-nginx] site=%s: removed %s from %s", s.Site, legacyNginxConfPath(cfg, s.Site), cfg.EdgeNginxContainer)
+	}
+	if removed > 0 {
+		log.Printf("[legacy-nginx] removed %d orphaned server block(s); Caddy routes those sites", removed)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net"
 	"regexp"
 	"strings"
+
+	"golang.org/x/net/idna"
 )
 
 // SiteStatus represents the lifecycle state of a site.
@@ -20,6 +22,7 @@ const (
 	SiteDomainRouting    SiteStatus = "DOMAIN_ROUTING"
 	SiteDomainActive     SiteStatus = "DOMAIN_ACTIVE"
 	SiteDomainRemoving   SiteStatus = "DOMAIN_REMOVING"
+	SitePaused           SiteStatus = "PAUSED" // containers stopped, volume and database kept
 	SiteDestroying       SiteStatus = "DESTROYING"
 	SiteDestroyed        SiteStatus = "DESTROYED"
 	SiteFailed           SiteStatus = "FAILED"
@@ -30,12 +33,13 @@ const (
 var allowedTransitions = map[SiteStatus][]SiteStatus{
 	SiteCreated:          {SiteProvisioning},
 	SiteProvisioning:     {SiteActive, SiteFailed},
-	SiteActive:           {SiteDomainPending, SiteDestroying},
+	SiteActive:           {SiteDomainPending, SitePaused, SiteDestroying},
 	SiteDomainPending:    {SiteDomainValidating, SiteActive},
 	SiteDomainValidating: {SiteDomainRouting, SiteDomainPending, SiteActive},
 	SiteDomainRouting:    {SiteDomainActive, SiteActive},
-	SiteDomainActive:     {SiteDomainRemoving, SiteDestroying},
+	SiteDomainActive:     {SiteDomainRemoving, SitePaused, SiteDestroying},
 	SiteDomainRemoving:   {SiteActive, SiteFailed},
+	SitePaused:           {SiteActive, SiteDomainActive, SiteDestroying},
 	SiteDestroying:       {SiteDestroyed, SiteFailed},
 	SiteFailed:           {SiteProvisioning, SiteDestroying},
 }
@@ -74,16 +78,42 @@ func (s SiteStatus) AllowsCustomDomain() bool {
 
 // AllowsDestroy returns whether the site can be destroyed from this state.
 func (s SiteStatus) AllowsDestroy() bool {
-	return s == SiteActive || s == SiteDomainActive || s == SiteFailed
+	return s == SiteActive || s == SiteDomainActive || s == SiteFailed || s == SitePaused
 }
 
 // ── Domain Validation ────────────────────────────────────────────────────────
 
+// domainRegexp is a coarse shape check on an already-normalized (lowercase,
+// ASCII/punycode) domain. Per-label length is enforced separately in
+// ValidateDomainFormat because RE2 cannot express it for every label.
 var domainRegexp = regexp.MustCompile(
-	`^([a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`,
+	`^([a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?\.)+([a-z]{2,63}|xn--[a-z0-9\-]{1,59})$`,
 )
 
-// ValidateDomainFormat checks basic domain name format validity.
+// NormalizeDomain trims and lowercases a user-supplied domain, drops the
+// trailing dot of a fully qualified name and converts internationalized
+// (Unicode) names to their punycode form. Every domain entering the system —
+// API requests, base domains from config — goes through it, so the value
+// that is validated, stored, looked up for availability and written into
+// Caddy/nginx config is always the same ASCII string.
+func NormalizeDomain(domain string) (string, error) {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if d == "" {
+		return "", fmt.Errorf("domain cannot be empty")
+	}
+	ascii, err := idna.Lookup.ToASCII(d)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain %s: %w", domain, err)
+	}
+	return ascii, nil
+}
+
+// ValidateDomainFormat checks domain name format validity. The domain must
+// already be normalized (see NormalizeDomain). A trailing dot (fully
+// qualified form) is rejected explicitly as a guard against callers that
+// skipped normalization: Caddy and nginx treat "example.com." and
+// "example.com" as different hosts, so accepting both would allow duplicate
+// claims on the same name.
 func ValidateDomainFormat(domain string) error {
 	if domain == "" {
 		return fmt.Errorf("domain cannot be empty")
@@ -94,31 +124,65 @@ func ValidateDomainFormat(domain string) error {
 	if strings.HasPrefix(domain, "*.") {
 		return fmt.Errorf("wildcard domains are not supported")
 	}
+	if strings.HasSuffix(domain, ".") {
+		return fmt.Errorf("domain must not end with a dot: %s", domain)
+	}
+	if domain != strings.ToLower(domain) {
+		return fmt.Errorf("domain must be lowercase: %s", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" {
+			return fmt.Errorf("domain has an empty label: %s", domain)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("domain label %q too long (max 63 characters)", label)
+		}
+	}
 	if !domainRegexp.MatchString(domain) {
 		return fmt.Errorf("invalid domain format: %s", domain)
 	}
 	return nil
 }
 
-// ValidateDomainNotBase rejects domains that are or overlap with the base domain.
-func ValidateDomainNotBase(domain, baseDomain string) error {
-	if strings.HasSuffix(domain, "."+baseDomain) || domain == baseDomain {
-		return fmt.Errorf("cannot use %s as custom domain (conflicts with base domain %s)", domain, baseDomain)
+// ValidateDomainNotBase rejects domains that are or overlap with any of the
+// base domains.
+func ValidateDomainNotBase(domain string, baseDomains []string) error {
+	for _, baseDomain := range baseDomains {
+		if strings.HasSuffix(domain, "."+baseDomain) || domain == baseDomain {
+			return fmt.Errorf("cannot use %s as custom domain (conflicts with base domain %s)", domain, baseDomain)
+		}
+	}
+	return nil
+}
+
+// defaultReservedSubdomains is used when RESERVED_SUBDOMAINS is unset.
+var defaultReservedSubdomains = []string{
+	"www", "mail", "smtp", "imap", "pop", "mx", "ns1", "ns2",
+	"api", "admin", "app", "dashboard", "status", "cdn", "static", "ftp",
+}
+
+// ValidateSubdomainNotReserved rejects site slugs whose subdomain is on the
+// reserved list.
+func ValidateSubdomainNotReserved(site string, reserved []string) error {
+	for _, r := range reserved {
+		if strings.EqualFold(site, r) {
+			return fmt.Errorf("%s is a reserved subdomain and cannot be used as a site name", site)
+		}
 	}
 	return nil
 }
 
 // ValidateCustomDomain runs all synchronous domain validations.
-func ValidateCustomDomain(domain, baseDomain string) error {
+func ValidateCustomDomain(domain string, baseDomains []string) error {
 	if err := ValidateDomainFormat(domain); err != nil {
 		return err
 	}
-	return ValidateDomainNotBase(domain, baseDomain)
+	return ValidateDomainNotBase(domain, baseDomains)
 }
 
 // ValidateDomainDNS checks that the domain resolves (async-safe, used by reconciler).
-func ValidateDomainDNS(domain string) error {
-	addrs, err := net.LookupHost(domain)
+func ValidateDomainDNS(r *DomainResolver, domain string) error {
+	addrs, err := r.LookupHost(domain)
 	if err != nil {
 		return fmt.Errorf("domain %s does not resolve: %w", domain, err)
 	}
@@ -130,40 +194,87 @@ func ValidateDomainDNS(domain string) error {
 
 // DNSCheckResult holds the result of a live DNS lookup for a custom domain.
 type DNSCheckResult struct {
-	Resolved   []string // all A records currently returned by DNS
-	PointsToIP bool     // true if any resolved IP matches expectedIP
+	Resolved   []string // all A and AAAA records currently returned by DNS
+	PointsToIP bool     // true if any resolved IP matches one of the expected ingress IPs
 }
 
 // CheckDomainDNS performs a live DNS lookup and returns the resolved IPs and
-// whether the domain is pointing to the expected ingress IP. Unlike
+// whether the domain is pointing at any of the expected ingress IPs. Unlike
 // ValidateDomainPointsToIngress it never returns an error for wrong IPs —
 // it just reports them so the UI can show the current state.
-func CheckDomainDNS(domain, expectedIP string) DNSCheckResult {
-	addrs, err := net.LookupHost(domain)
+func CheckDomainDNS(r *DomainResolver, domain string, expectedIPs []string) DNSCheckResult {
+	addrs, err := r.LookupHost(domain)
 	if err != nil {
 		return DNSCheckResult{Resolved: []string{}, PointsToIP: false}
 	}
-	for _, addr := range addrs {
-		if addr == expectedIP {
-			return DNSCheckResult{Resolved: addrs, PointsToIP: true}
-		}
-	}
-	return DNSCheckResult{Resolved: addrs, PointsToIP: false}
+	return DNSCheckResult{Resolved: addrs, PointsToIP: anyIPMatches(addrs, expectedIPs)}
 }
 
-// ValidateDomainPointsToIngress verifies the domain's A record resolves to the
-// expected public ingress IP (the VPS TCP forwarder). Custom domains must point
-// here before Caddy can obtain a TLS certificate for them.
-func ValidateDomainPointsToIngress(domain, expectedIP string) error {
-	addrs, err := net.LookupHost(domain)
+// ValidateDomainPointsToIngress verifies the domain's A or AAAA records
+// resolve to one of the expected public ingress IPs (the VPS TCP forwarder).
+// Custom domains must point here before Caddy can obtain a TLS certificate
+// for them. On mismatch the error names the record type(s) to fix.
+func ValidateDomainPointsToIngress(r *DomainResolver, domain string, expectedIPs []string) error {
+	addrs, err := r.LookupHost(domain)
 	if err != nil {
 		return fmt.Errorf("domain %s does not resolve: %w", domain, err)
 	}
-	for _, addr := range addrs {
-		if addr == expectedIP {
-			return nil
+	if anyIPMatches(addrs, expectedIPs) {
+		return nil
+	}
+
+	wantV4, wantV6 := splitIPFamilies(expectedIPs)
+	gotV4, gotV6 := splitIPFamilies(addrs)
+
+	var fixes []string
+	if len(wantV4) > 0 {
+		fixes = append(fixes, fmt.Sprintf("A record to %s (currently: %s)",
+			strings.Join(wantV4, " or "), joinOrNone(gotV4)))
+	}
+	if len(wantV6) > 0 {
+		fixes = append(fixes, fmt.Sprintf("AAAA record to %s (currently: %s)",
+			strings.Join(wantV6, " or "), joinOrNone(gotV6)))
+	}
+	return fmt.Errorf("domain %s does not point to this platform — set an %s first",
+		domain, strings.Join(fixes, ", or an "))
+}
+
+// anyIPMatches reports whether any resolved address equals any expected one.
+// Addresses are compared parsed so equivalent IPv6 spellings match.
+func anyIPMatches(resolved, expected []string) bool {
+	for _, r := range resolved {
+		rip := net.ParseIP(r)
+		if rip == nil {
+			continue
+		}
+		for _, e := range expected {
+			if rip.Equal(net.ParseIP(e)) {
+				return true
+			}
 		}
 	}
-	return fmt.Errorf("domain %s does not point to %s (resolved: %s) — set an A record to %s first",
-		domain, expectedIP, strings.Join(addrs, ", "), expectedIP)
+	return false
+}
+
+// splitIPFamilies partitions addresses into IPv4 and IPv6. Unparseable
+// entries are dropped.
+func splitIPFamilies(addrs []string) (v4, v6 []string) {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		switch {
+		case ip == nil:
+		case ip.To4() != nil:
+			v4 = append(v4, a)
+		default:
+			v6 = append(v6, a)
+		}
+	}
+	return v4, v6
+}
+
+func joinOrNone(addrs []string) string {
+	if len(addrs) == 0 {
+		return "none"
+	}
+	return strings.Join(addrs, ", ")
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAndValidateDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string // normalized form; "" when NormalizeDomain fails
+		wantErr string // substring of the first error; "" when valid
+	}{
+		{"plain", "example.com", "example.com", ""},
+		{"subdomain", "blog.example.co.uk", "blog.example.co.uk", ""},
+		{"hyphen and digits", "my-site-2.example.com", "my-site-2.example.com", ""},
+		{"mixed case and spaces", "  Example.COM ", "example.com", ""},
+		{"trailing dot", "example.com.", "example.com", ""},
+		{"IDN", "münchen.de", "xn--mnchen-3ya.de", ""},
+		{"IDN uppercase", "MÜNCHEN.de", "xn--mnchen-3ya.de", ""},
+		{"IDN TLD", "example.рф", "example.xn--p1ai", ""},
+		{"punycode already", "xn--mnchen-3ya.de", "xn--mnchen-3ya.de", ""},
+
+		{"empty", "   ", "", "cannot be empty"},
+		{"invalid IDN", "exa_mple.com", "", "invalid internationalized domain"},
+		{"wildcard", "*.example.com", "", "invalid internationalized domain"},
+		{"single label", "localhost", "localhost", "invalid domain format"},
+		{"empty label", "a..example.com", "", "empty label"},
+		{"leading hyphen", "-a.example.com", "", "invalid internationalized domain"},
+		{"numeric TLD", "example.123", "example.123", "invalid domain format"},
+		{"label too long", strings.Repeat("a", 64) + ".com", "", "too long (max 63"},
+		{"too long", strings.Repeat(strings.Repeat("a", 60)+".", 5) + "com", "", "too long (max 253"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeDomain(tt.in)
+			if err == nil {
+				err = ValidateDomainFormat(got)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("%q: unexpected error: %v", tt.in, err)
+				}
+				if got != tt.want {
+					t.Errorf("NormalizeDomain(%q) = %q, want %q", tt.in, got, tt.want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("%q: error = %v, want it to contain %q", tt.in, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// ValidateDomainFormat must also reject what only NormalizeDomain would have
+// fixed, for callers that skipped it.
+func TestValidateDomainFormatUnnormalized(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{"example.com.", "must not end with a dot"},
+		{"Example.com", "must be lowercase"},
+		{"*.example.com", "wildcard"},
+		{"münchen.de", "invalid domain format"},
+		{"", "cannot be empty"},
+	}
+	for _, tt := range tests {
+		err := ValidateDomainFormat(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ValidateDomainFormat(%q) = %v, want it to contain %q", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizeDomainCaseVariantsCollide(t *testing.T) {
+	want, err := NormalizeDomain("shop.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, variant := range []string{"SHOP.EXAMPLE.COM", "Shop.Example.com", "shop.EXAMPLE.com.", " sHoP.example.CoM "} {
+		got, err := NormalizeDomain(variant)
+		if err != nil {
+			t.Fatalf("NormalizeDomain(%q): %v", variant, err)
+		}
+		if got != want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", variant, got, want)
+		}
+	}
+}
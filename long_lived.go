@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiReadTimeout and apiWriteTimeout bound ordinary API requests. main
+// passes them to newAPIServer, and they apply to every route that is not
+// wrapped in longLived.
+const (
+	apiReadTimeout  = 10 * time.Second
+	apiWriteTimeout = 10 * time.Second
+)
+
+// newAPIServer returns the API's http.Server with the given read and write
+// timeouts; longLived lifts them per request.
+func newAPIServer(addr string, h http.Handler, readTimeout, writeTimeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      h,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+}
+
+// longLived replaces the server's read and write deadlines for one request
+// with timeout from now, or removes them when timeout is 0. Routes that
+// stream, upload or work synchronously for longer than apiWriteTimeout
+// would otherwise be cut off mid-response with no error to the client.
+// Register it per route, ahead of the handler.
+func longLived(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		rc := http.NewResponseController(c.Writer)
+		if err := rc.SetReadDeadline(deadline); err != nil {
+			log.Printf("[api] %s %s warning: could not extend read deadline: %v", c.Request.Method, c.FullPath(), err)
+		}
+		if err := rc.SetWriteDeadline(deadline); err != nil {
+			log.Printf("[api] %s %s warning: could not extend write deadline: %v", c.Request.Method, c.FullPath(), err)
+		}
+		c.Next()
+	}
+}
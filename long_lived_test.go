@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A handler working past the server's write timeout loses its response
+// unless the route is registered with longLived. The server stands in for
+// apiWriteTimeout with a much shorter one.
+func TestLongLivedOutlastsWriteTimeout(t *testing.T) {
+	const writeTimeout = 200 * time.Millisecond
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	slow := func(c *gin.Context) {
+		time.Sleep(writeTimeout + 300*time.Millisecond)
+		c.String(http.StatusOK, "done")
+	}
+	r.POST("/long", longLived(time.Minute), slow)
+	r.POST("/short", slow)
+
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config = newAPIServer("", r, apiReadTimeout, writeTimeout)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		path string
+		want string // "" = response cut off
+	}{
+		{"/long", "done"},
+		{"/short", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+			resp, err := srv.Client().Post(srv.URL+tt.path, "application/json", nil)
+			var body []byte
+			if err == nil {
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("POST %s = %q, want the response cut off", tt.path, body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("POST %s: %v", tt.path, err)
+			}
+			if resp.StatusCode != http.StatusOK || string(body) != tt.want {
+				t.Errorf("POST %s = %d %q, want 200 %q", tt.path, resp.StatusCode, body, tt.want)
+			}
+		})
+	}
+}
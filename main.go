@@ -13,8 +13,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// version is the build's version, set with -ldflags "-X main.version=...".
+var version = "dev"
+
 func main() {
 	cfg := LoadConfig()
+	logEffectiveConfig(cfg)
 
 	// ── Control plane DB ─────────────────────────────────────────────
 	db, err := NewDB(cfg.ControlDSN)
@@ -53,23 +57,82 @@ func main() {
 	}
 	log.Printf("[main] connected to docker on app-01 (containers: %d)", info.Containers)
 
+	// ── Tunnel service target ───────────────────────────
+	if err := ValidateServiceTarget(cfg.ServiceTarget); err != nil {
+		log.Fatalf("[main] %v", err)
+	}
+	probeCtx, probeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := ProbeServiceTarget(probeCtx, cfg.ServiceTarget); err != nil {
+		log.Printf("[main] warning: %v — tunnel traffic will 502 until it is up", err)
+	}
+	probeCancel()
+
 	// ── R2 backup client ─────────────────────────────────
 	r2, r2Err := NewR2Client(cfg)
 	if r2Err != nil {
 		log.Printf("[main] R2 not configured (%v) — backups disabled", r2Err)
 	}
 
+	// ── Event publisher ──────────────────────────────────
+	events, err := NewEventPublisher(cfg)
+	if err != nil {
+		log.Fatalf("[main] invalid events config: %v", err)
+	}
+	if cfg.EventsBackend != "" && cfg.EventsBackend != "none" {
+		log.Printf("[main] publishing lifecycle events via %s", cfg.EventsBackend)
+	}
+
+	maint := NewMaintenance(cfg.ReadOnlyMode, cfg.ReadOnlyReason)
+	heartbeat := NewWorkerHeartbeat(time.Duration(cfg.WorkerPollInterval) * time.Second)
+
+	if len(cfg.APIAllowCIDRs) > 0 {
+		log.Printf("[main] API restricted to %d allowed CIDR(s)", len(cfg.APIAllowCIDRs))
+	}
+
 	// ── Wire up components ───────────────────────────────
 	tunnel := NewTunnelManager(cfg)
+	if cfg.TunnelSelfTestHost != "" {
+		go func() {
+			selfCtx, selfCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer selfCancel()
+			if check := tunnel.SelfTest(selfCtx, cfg.TunnelSelfTestHost); !check.OK {
+				log.Printf("[main] warning: tunnel self-test for %s failed: %s", check.Host, check.Error)
+			} else {
+				log.Printf("[main] tunnel self-test for %s passed", check.Host)
+			}
+		}()
+	}
 	provisioner := NewProvisioner(docker, cfg)
 	staticProvisioner := NewStaticProvisioner(docker, cfg)
 	backupper := NewBackupper(docker, cfg, r2, db)
 	destroyer := NewDestroyer(docker, cfg, backupper)
-	worker := NewWorker(db, provisioner, destroyer, staticProvisioner, cfg)
-	go worker.Start()
+	worker := NewWorker(db, provisioner, destroyer, staticProvisioner, events, maint, heartbeat, tunnel, cfg)
+
+	// ── Startup self-test ────────────────────────────────
+	if cfg.StartupSelfTest {
+		stCtx, stCancel := context.WithTimeout(context.Background(), cfg.StartupSelfTestTimeout)
+		err := runStartupSelfTest(stCtx, docker, cfg, staticProvisioner)
+		stCancel()
+		if err != nil {
+			log.Fatalf("[main] startup self-test FAILED: %v", err)
+		}
+		log.Println("[main] startup self-test passed")
+	}
+
+	// Static sites are routed by Caddy alone; drop what the old edge nginx
+	// still has for them.
+	go removeLegacyNginxConfs(context.Background(), docker, cfg, db)
+
+	// workerCtx is cancelled on shutdown so an in-flight job aborts promptly.
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	workerDone := make(chan struct{})
+	go func() {
+		worker.Start(workerCtx)
+		close(workerDone)
+	}()
 	log.Println("[main] worker started")
 
-	if r2 != nil {
+	if r2 != nil && cfg.FeatureEnabled(FeatureBackups) {
 		backupWorker := NewBackupWorker(backupper, cfg)
 		go backupWorker.Start()
 		log.Println("[main] backup worker started")
@@ -78,18 +141,18 @@ func main() {
 	// ── HTTP API ─────────────────────────────────────────────────────
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Logger())
+	router.Use(requestIDMiddleware())
+	router.Use(requestLogger())
+	router.Use(ipAllowMiddleware(cfg))
 	router.Use(gin.Recovery())
+	if cfg.APICompression {
+		router.Use(compressMiddleware(cfg.APICompressMinBytes))
+	}
 
-	api := NewAPI(db, cfg, docker, tunnel, backupper)
+	api := NewAPI(db, cfg, docker, tunnel, backupper, events, maint, heartbeat)
 	api.RegisterRoutes(router)
 
-	srv := &http.Server{
-		Addr:         ":" + cfg.APIPort,
-		Handler:      router,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-	}
+	srv := newAPIServer(":"+cfg.APIPort, router, apiReadTimeout, apiWriteTimeout)
 
 	go func() {
 		log.Printf("[main] API listening on :%s", cfg.APIPort)
@@ -98,6 +161,16 @@ func main() {
 		}
 	}()
 
+	// SIGUSR1 enters read-only mode and SIGUSR2 leaves it, for when the API
+	// itself is unreachable (PUT /api/maintenance does the same).
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range toggle {
+			maint.Set(sig == syscall.SIGUSR1, "entered via SIGUSR1")
+		}
+	}()
+
 	// ── Graceful shutdown ────────────────────────────────────────────
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
@@ -110,5 +183,13 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("[main] forced shutdown: %v", err)
 	}
+
+	stopWorker()
+	select {
+	case <-workerDone:
+	case <-shutdownCtx.Done():
+		log.Println("[main] worker did not stop before shutdown deadline")
+	}
+	events.Close(shutdownCtx)
 	log.Println("[main] stopped cleanly")
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maintenance is the control plane's runtime read-only switch, for
+// maintenance windows on app-01: while enabled, mutating API requests are
+// rejected with 503 and the worker claims no new jobs, but reads and health
+// keep being served. A job already in flight runs to completion.
+type Maintenance struct {
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+// MaintenanceState is the read-only mode as reported by the API.
+type MaintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// NewMaintenance returns the switch in its startup state (READ_ONLY_MODE).
+func NewMaintenance(enabled bool, reason string) *Maintenance {
+	m := &Maintenance{}
+	m.Set(enabled, reason)
+	return m
+}
+
+// Set enters or leaves read-only mode, logging the change. Setting the
+// current state again only updates the reason.
+func (m *Maintenance) Set(enabled bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enabled {
+		reason = ""
+	}
+	if enabled != m.enabled {
+		if enabled {
+			log.Printf("[maintenance] entering read-only mode: %s", orDefault(reason, "no reason given"))
+		} else {
+			log.Printf("[maintenance] leaving read-only mode after %s", time.Since(m.since).Round(time.Second))
+		}
+		m.since = time.Now().UTC()
+	}
+	m.enabled = enabled
+	m.reason = reason
+}
+
+// Enabled reports whether the control plane is read-only.
+func (m *Maintenance) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// State returns the current mode.
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state := MaintenanceState{Enabled: m.enabled, Reason: m.reason}
+	if m.enabled {
+		since := m.since
+		state.Since = &since
+	}
+	return state
+}
+
+// maintenancePath is exempt from read-only mode so it can be switched off.
+const maintenancePath = "/api/maintenance"
+
+// readOnlyMiddleware rejects every request that could mutate state — any
+// method but GET, HEAD and OPTIONS — with 503 while read-only mode is on.
+func readOnlyMiddleware(m *Maintenance) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		if c.Request.URL.Path == maintenancePath {
+			c.Next()
+			return
+		}
+		state := m.State()
+		if !state.Enabled {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", "300")
+		body := errorBody(http.StatusServiceUnavailable, CodeReadOnly, "control plane is in maintenance (read-only) mode; try again later")
+		body["reason"] = state.Reason
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+	}
+}
+
+func orDefault(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
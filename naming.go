@@ -1,5 +1,11 @@
 package main
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
 // Centralized naming conventions for infrastructure resources.
 // All components MUST use these functions instead of inline string concatenation.
 // This ensures naming consistency and makes convention changes a single-point edit.
@@ -14,6 +20,50 @@ func VolumeName(site string) string {
 	return "wp_" + site
 }
 
+// SiteVolume returns the volume holding a WordPress site's files: the
+// external volume it was provisioned onto, else VolumeName.
+func SiteVolume(s *Site) string {
+	if s.ExternalVolume != "" {
+		return s.ExternalVolume
+	}
+	return VolumeName(SiteResources(s))
+}
+
+// SiteResources returns the name a WordPress site's live containers,
+// volumes and database are derived from: the slug, unless a blue/green
+// cutover moved the site onto another set (see bluegreen.go). Pass it to
+// the naming helpers below instead of the slug wherever a *Site is at hand.
+func SiteResources(s *Site) string {
+	if s.Resources != "" {
+		return s.Resources
+	}
+	return s.Site
+}
+
+// Blue/green resource sets alternate between these suffixes, so a site's
+// live and green sets never share a name. Slugs are [a-z0-9]+, so no site
+// can own a suffixed name.
+const (
+	greenSuffix = "_green"
+	blueSuffix  = "_blue"
+)
+
+// GreenResources returns the name for a new green set of s: whichever of
+// <site>_green and <site>_blue is not live.
+func GreenResources(s *Site) string {
+	if SiteResources(s) == s.Site+greenSuffix {
+		return s.Site + blueSuffix
+	}
+	return s.Site + greenSuffix
+}
+
+// GreenPreviewSite returns the name a site's green set is routed under
+// while it is previewed: its Caddy snippet is CaddyConfFile of it and its
+// hostname SiteDomain of it. The hyphen keeps it clear of every slug.
+func GreenPreviewSite(site string) string {
+	return site + "-green"
+}
+
 // WPDatabaseName returns the MySQL database name for a site.
 func WPDatabaseName(site string) string {
 	return "wp_" + site
@@ -29,12 +79,105 @@ func WPDatabasePass(site string) string {
 	return "pass_" + site
 }
 
+// MySQL's limits on database and user names.
+const (
+	mysqlDatabaseNameMax = 64
+	mysqlUserNameMax     = 32
+)
+
+// validDBIdentifier is the form of a database or user name the control
+// plane puts into SQL: nothing that needs escaping inside backticks or
+// quotes, nor in the mysqldump/mysql command lines.
+var validDBIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// ValidateDBIdentifier checks a database or user name: letters, digits and
+// underscores, at most maxLen bytes.
+func ValidateDBIdentifier(name string, maxLen int) error {
+	if !validDBIdentifier.MatchString(name) {
+		return fmt.Errorf("%q is not a valid MySQL identifier", name)
+	}
+	if len(name) > maxLen {
+		return fmt.Errorf("%q is longer than MySQL's %d-character limit", name, maxLen)
+	}
+	return nil
+}
+
+// ValidateSiteDBIdentifiers checks the database, user and password of the
+// resource set name (see SiteResources). The password is quoted rather than
+// an identifier, but is held to the same characters.
+func ValidateSiteDBIdentifiers(name string) error {
+	if err := validateDBAccount(WPDatabaseName(name), WPDatabaseUser(name)); err != nil {
+		return err
+	}
+	if !validDBIdentifier.MatchString(WPDatabasePass(name)) {
+		return fmt.Errorf("database password of %q has characters that would need escaping", name)
+	}
+	return nil
+}
+
+// validateDBAccount checks a database and user name pair before SQL that
+// creates or drops them.
+func validateDBAccount(dbName, dbUser string) error {
+	if err := ValidateDBIdentifier(dbName, mysqlDatabaseNameMax); err != nil {
+		return fmt.Errorf("database name: %w", err)
+	}
+	if err := ValidateDBIdentifier(dbUser, mysqlUserNameMax); err != nil {
+		return fmt.Errorf("database user: %w", err)
+	}
+	return nil
+}
+
+// quoteDBIdentifier backtick-quotes a database or user name validated by
+// ValidateDBIdentifier.
+func quoteDBIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// quoteGrantDatabase quotes dbName for the ON clause of a GRANT, where _ and
+// % are wildcards: wp_a would otherwise also match wpxa.
+func quoteGrantDatabase(dbName string) string {
+	return quoteDBIdentifier(strings.NewReplacer("_", `\_`, "%", `\%`).Replace(dbName))
+}
+
+// TmpUploadContainer returns the name of the temporary container that copies
+// a static site's files into the shared caddy_static_sites volume.
+func TmpUploadContainer(site string) string {
+	return "tmp_static_" + site
+}
+
 // SiteDomain returns the default domain for a site.
 func SiteDomain(site, baseDomain string) string {
 	return site + "." + baseDomain
 }
 
-// CaddyConfFile returns the Caddy snippet filename for a site.
+// SiteBaseDomain returns the base domain s is served under. Rows written
+// before the base_domain column existed were all provisioned under
+// fallback, the default BaseDomain.
+func SiteBaseDomain(s *Site, fallback string) string {
+	if s.BaseDomain != "" {
+		return s.BaseDomain
+	}
+	return fallback
+}
+
+// SitePathPrefix returns the URL path a path-routed site is served under
+// on the base domain.
+func SitePathPrefix(site string) string {
+	return "/" + site
+}
+
+// CaddyPathRouteFile returns the Caddy route filename for a path-routed site.
+// It lives in the paths/ subdirectory of CaddyConfDir so the *.caddy import
+// of the main Caddyfile does not pick it up as a site block.
+func CaddyPathRouteFile(site string) string {
+	return site + ".route"
+}
+
+// CaddyConfFile returns the Caddy snippet filename for a site. Caddy is the
+// only edge: WordPress and static sites alike are routed by this one file
+// (or, path-routed, by CaddyPathRouteFile), written at provision, rewritten
+// on domain changes and removed on destroy. Slugs are [a-z0-9]+, so no site
+// can claim a platform file such as _paths.caddy.
 func CaddyConfFile(site string) string {
 	return site + ".caddy"
 }
@@ -44,7 +187,40 @@ func NginxContainerName(site string) string {
 	return "nginx_" + site
 }
 
-// NginxConfFile returns the nginx server block filename for a site.
-func NginxConfFile(site string) string {
-	return site + ".conf"
+// RedisContainerName returns the Docker container name for a site's own
+// Redis object cache (REDIS_MODE=per-site).
+func RedisContainerName(site string) string {
+	return "redis_" + site
+}
+
+// NginxConfVolumeName returns the Docker volume holding a site's nginx
+// conf.d, so the server block survives the sidecar being recreated.
+func NginxConfVolumeName(site string) string {
+	return "nginxconf_" + site
+}
+
+// invalidNetworkChars are the characters a tenant name may have that a
+// Docker network name may not.
+var invalidNetworkChars = regexp.MustCompile(`[^a-z0-9_.-]`)
+
+// TenantNetworkName returns the network of a tenant's sites under
+// NETWORK_ISOLATION=tenant.
+func TenantNetworkName(tenant string) string {
+	return "wp_tenant_" + invalidNetworkChars.ReplaceAllString(strings.ToLower(tenant), "_")
+}
+
+// SiteNetworkName returns the network of a site under
+// NETWORK_ISOLATION=site.
+func SiteNetworkName(site string) string {
+	return "wp_site_" + site
+}
+
+// StaticSiteDir returns the directory, relative to the caddy_static_sites
+// volume root, holding a static site's files for the given release. The
+// empty release is the original single-directory layout.
+func StaticSiteDir(site, release string) string {
+	if release == "" {
+		return site
+	}
+	return "_releases/" + site + "/" + release
 }
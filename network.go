@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Network isolation. By default every site's containers join the one
+// DOCKER_NETWORK, so any site's PHP container can reach every other site's
+// nginx, PHP and Redis. NETWORK_ISOLATION gives each tenant, or each site,
+// a bridge network of its own instead. The edge containers — Caddy, which
+// proxies to every site's nginx by name, and the shared Redis — are
+// connected to each of them. A site keeps the network it was provisioned
+// on (Site.Network); sites from before isolation have none and stay on
+// DOCKER_NETWORK. The admin key can also pin a site to a network by name.
+// Once a destroy leaves no site containers on a network the control plane
+// created, the network is removed.
+const (
+	NetworkShared = "shared" // everything on DOCKER_NETWORK
+	NetworkTenant = "tenant" // one network per tenant
+	NetworkSite   = "site"   // one network per site
+)
+
+// networkManagedLabel marks the networks the control plane created, the
+// only ones releaseSiteNetwork removes.
+const networkManagedLabel = "hostplane.managed"
+
+// validNetworkName is the form of a network a site may be pinned to.
+var validNetworkName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+
+// ValidateNetworkName checks a network a site is pinned to. Docker's
+// built-in host and none networks cannot take the edge containers.
+func ValidateNetworkName(name string) error {
+	if !validNetworkName.MatchString(name) {
+		return fmt.Errorf("network must be 1-128 letters, digits, '_', '.' or '-'")
+	}
+	if name == "host" || name == "none" {
+		return fmt.Errorf("network %q cannot be used for a site", name)
+	}
+	return nil
+}
+
+// NetworkFor returns the network a new site of tenant is provisioned on
+// under NETWORK_ISOLATION, "" for DOCKER_NETWORK.
+func (c Config) NetworkFor(tenant, site string) string {
+	switch c.NetworkIsolation {
+	case NetworkTenant:
+		return TenantNetworkName(tenant)
+	case NetworkSite:
+		return SiteNetworkName(site)
+	}
+	return ""
+}
+
+// SiteNetwork returns the network s's containers are on.
+func SiteNetwork(s *Site, cfg Config) string {
+	if s.Network != "" {
+		return s.Network
+	}
+	return cfg.DockerNetwork
+}
+
+// edgeContainers are the containers every isolated network is joined to.
+func edgeContainers(cfg Config) []string {
+	edges := []string{cfg.CaddyContainer}
+	if cfg.RedisMode == ObjectCacheShared {
+		edges = append(edges, cfg.RedisSharedContainer)
+	}
+	return edges
+}
+
+// ensureSiteNetwork creates network if it does not exist and connects the
+// edge containers to it. DOCKER_NETWORK itself is left alone. A shared Redis
+// that has not been created yet is connected when it is (ensureObjectCache).
+func ensureSiteNetwork(ctx context.Context, docker *client.Client, cfg Config, network string) error {
+	if network == "" || network == cfg.DockerNetwork {
+		return nil
+	}
+	res, err := docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if client.IsErrNotFound(err) {
+		if _, err := docker.NetworkCreate(ctx, network, types.NetworkCreate{
+			CheckDuplicate: true,
+			Driver:         "bridge",
+			Labels:         map[string]string{networkManagedLabel: "true"},
+		}); err != nil {
+			return fmt.Errorf("create network %s: %w", network, err)
+		}
+		log.Printf("[network] created %s", network)
+		res, err = docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("inspect network %s: %w", network, err)
+	}
+
+	for _, name := range edgeContainers(cfg) {
+		if onNetwork(res, name) {
+			continue
+		}
+		if _, err := docker.ContainerInspect(ctx, name); client.IsErrNotFound(err) && name != cfg.CaddyContainer {
+			continue
+		}
+		if err := docker.NetworkConnect(ctx, network, name, nil); err != nil {
+			return fmt.Errorf("connect %s to network %s: %w", name, network, err)
+		}
+	}
+	return nil
+}
+
+// connectToNetwork joins container name to network unless it is on it.
+func connectToNetwork(ctx context.Context, docker *client.Client, network, name string) error {
+	res, err := docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("inspect network %s: %w", network, err)
+	}
+	if onNetwork(res, name) {
+		return nil
+	}
+	if err := docker.NetworkConnect(ctx, network, name, nil); err != nil {
+		return fmt.Errorf("connect %s to network %s: %w", name, network, err)
+	}
+	return nil
+}
+
+// onNetwork reports whether container name has an endpoint on res.
+func onNetwork(res types.NetworkResource, name string) bool {
+	for _, ep := range res.Containers {
+		if ep.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseSiteNetwork removes network once only edge containers are left on
+// it: they are disconnected, then the network removed. A network some site
+// container is still on, one the control plane did not create (such as
+// DOCKER_NETWORK) and one already gone are left alone. If the removal fails the edge containers are connected again, so
+// a site provisioned onto the network meanwhile stays routable.
+func releaseSiteNetwork(ctx context.Context, docker *client.Client, cfg Config, network string) error {
+	if network == "" || network == cfg.DockerNetwork {
+		return nil
+	}
+	res, err := docker.NetworkInspect(ctx, network, types.NetworkInspectOptions{})
+	if client.IsErrNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("inspect network %s: %w", network, err)
+	}
+	if res.Labels[networkManagedLabel] != "true" {
+		return nil
+	}
+
+	edges := map[string]bool{}
+	for _, name := range edgeContainers(cfg) {
+		edges[name] = true
+	}
+	var attached []string
+	for _, ep := range res.Containers {
+		if !edges[ep.Name] {
+			return nil // still in use
+		}
+		attached = append(attached, ep.Name)
+	}
+
+	for _, name := range attached {
+		if err := docker.NetworkDisconnect(ctx, network, name, true); err != nil {
+			return fmt.Errorf("disconnect %s from network %s: %w", name, network, err)
+		}
+	}
+	if err := docker.NetworkRemove(ctx, network); err != nil {
+		if rbErr := ensureSiteNetwork(context.Background(), docker, cfg, network); rbErr != nil {
+			log.Printf("[network] warning: could not reconnect edge containers to %s: %v", network, rbErr)
+		}
+		return fmt.Errorf("remove network %s: %w", network, err)
+	}
+	log.Printf("[network] removed %s", network)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nginxSnippetMaxLen bounds a per-site snippet; anything larger is almost
+// certainly a pasted full config rather than a handful of extra rules.
+const nginxSnippetMaxLen = 8 * 1024
+
+// nginxSnippetDirectives is the set of directives a per-site snippet may use
+// inside the WordPress server block. Anything that loads files, talks to
+// other upstreams or changes the document root is deliberately absent.
+var nginxSnippetDirectives = map[string]bool{
+	"location":             true,
+	"return":               true,
+	"rewrite":              true,
+	"deny":                 true,
+	"allow":                true,
+	"add_header":           true,
+	"expires":              true,
+	"etag":                 true,
+	"try_files":            true,
+	"error_page":           true,
+	"internal":             true,
+	"access_log":           true,
+	"log_not_found":        true,
+	"gzip":                 true,
+	"gzip_types":           true,
+	"gzip_min_length":      true,
+	"limit_except":         true,
+	"if_modified_since":    true,
+	"client_max_body_size": true,
+}
+
+// ValidateNginxSnippet checks that a per-site snippet only uses allowlisted
+// directives and has balanced braces. It is a syntactic pre-filter; the
+// rendered server block is still checked with `nginx -t` before reload.
+func ValidateNginxSnippet(snippet string) error {
+	if len(snippet) > nginxSnippetMaxLen {
+		return fmt.Errorf("nginx snippet exceeds %d bytes", nginxSnippetMaxLen)
+	}
+
+	// Strip comments line by line so '#' inside them cannot hide directives.
+	var body strings.Builder
+	for _, line := range strings.Split(snippet, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+
+	depth := 0
+	var stmt strings.Builder
+	flush := func() error {
+		fields := strings.Fields(stmt.String())
+		stmt.Reset()
+		if len(fields) == 0 {
+			return nil
+		}
+		if !nginxSnippetDirectives[fields[0]] {
+			return fmt.Errorf("nginx directive %q is not allowed in a site snippet", fields[0])
+		}
+		return nil
+	}
+
+	for _, r := range body.String() {
+		switch r {
+		case ';', '{':
+			if err := flush(); err != nil {
+				return err
+			}
+			if r == '{' {
+				depth++
+			}
+		case '}':
+			if strings.TrimSpace(stmt.String()) != "" {
+				return fmt.Errorf("nginx snippet: missing ';' before '}'")
+			}
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("nginx snippet: unbalanced '}'")
+			}
+		default:
+			stmt.WriteRune(r)
+		}
+	}
+	if strings.TrimSpace(stmt.String()) != "" {
+		return fmt.Errorf("nginx snippet: trailing statement without ';'")
+	}
+	if depth != 0 {
+		return fmt.Errorf("nginx snippet: unbalanced '{'")
+	}
+	return nil
+}
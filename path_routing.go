@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Path-routed WordPress sites are served at https://<BaseDomain>/<site>/
+// instead of on their own subdomain. Caddy cannot have two site blocks for
+// the same host, so all path sites share one block for the base domain
+// (caddyPathSitesFile) that imports a route file per site from
+// caddyPathRoutesDir. Each route uses handle_path, which strips the prefix
+// before proxying to the site's nginx sidecar; see renderNginxConfig for
+// how the prefix is restored for WordPress.
+//
+// The base domain must not be served by any other Caddy site block.
+const (
+	caddyPathSitesFile = "_paths.caddy"
+	caddyPathRoutesDir = "paths"
+)
+
+// renderCaddyPathSites returns the shared site block for the base domain.
+func renderCaddyPathSites(baseDomain, routesDir string) string {
+	return fmt.Sprintf("%s {\n    encode gzip\n    import %s/*.route\n}\n", baseDomain, routesDir)
+}
+
+// renderCaddyPathRoute returns the route serving one path-routed site. The
+// bare prefix is redirected to the trailing-slash form so relative links
+// resolve under the site.
+func renderCaddyPathRoute(pathPrefix, nginxName string) string {
+	return fmt.Sprintf(`redir %[1]s %[1]s/ 308
+handle_path %[1]s/* {
+    reverse_proxy %[2]s:80
+}
+`, pathPrefix, nginxName)
+}
+
+// writeCaddyPathRoute writes the site's route file and (re)writes the shared
+// base-domain block that imports it. Caddy must be reloaded afterwards.
+func (p *Provisioner) writeCaddyPathRoute(ctx context.Context, site, nginxName string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerExecTimeout)
+	defer cancel()
+
+	routesDir := p.cfg.CaddyConfDir + "/" + caddyPathRoutesDir
+	if err := ensureCaddyConfDir(ctx, p.docker, p.cfg.CaddyContainer, routesDir); err != nil {
+		return err
+	}
+	if err := copyCaddyFile(ctx, p.docker, p.cfg, routesDir, CaddyPathRouteFile(site),
+		renderCaddyPathRoute(SitePathPrefix(site), nginxName)); err != nil {
+		return err
+	}
+	return copyCaddyFile(ctx, p.docker, p.cfg, p.cfg.CaddyConfDir, caddyPathSitesFile,
+		renderCaddyPathSites(p.cfg.BaseDomain, routesDir))
+}
+
+// removeCaddyPathRoute deletes the site's route file. The shared base-domain
+// block is left in place; with no routes it simply serves nothing.
+func (p *Provisioner) removeCaddyPathRoute(site string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerExecTimeout)
+	defer cancel()
+
+	routePath := p.cfg.CaddyConfDir + "/" + caddyPathRoutesDir + "/" + CaddyPathRouteFile(site)
+	execResp, err := p.docker.ContainerExecCreate(ctx, p.cfg.CaddyContainer, types.ExecConfig{
+		Cmd: []string{"rm", "-f", routePath},
+	})
+	if err != nil {
+		log.Printf("[rollback] cannot create exec for caddy route removal (%s): %v", site, err)
+		return
+	}
+	p.docker.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{})
+	log.Printf("[rollback] removed caddy path route for %s", site)
+}
+
+// wordPressPathConfig returns the WORDPRESS_CONFIG_EXTRA that pins a
+// path-routed site's home and site URLs, so the web installer and every
+// generated link include the prefix.
+func wordPressPathConfig(siteURL string) string {
+	return fmt.Sprintf("define('WP_HOME', '%[1]s');\ndefine('WP_SITEURL', '%[1]s');", siteURL)
+}
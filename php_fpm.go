@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PHP-FPM pool sizing of a WordPress site's PHP container. The resolved pool
+// is written as a www.conf override into the container before it first
+// starts, so every container the control plane creates for the site —
+// provision, reconcile, clone, green set — runs with it.
+
+// Limits of the PHP container (see Provisioner.createContainer). A pool
+// must fit in them: each worker is a process, and a WordPress request
+// routinely peaks around fpmChildMemoryMB.
+const (
+	phpMemoryMB  = 512
+	phpPidsLimit = 100
+
+	fpmChildMemoryMB = 40
+	fpmMasterMB      = 32 // the FPM master, opcache and shared memory
+	fpmPidHeadroom   = 20 // the master, wp-cli and cron execs
+)
+
+// fpmPoolPath is the pool override inside the PHP container. The image reads
+// php-fpm.d/*.conf in order, so this lands after its own docker.conf and
+// zz-docker.conf and wins.
+const fpmPoolPath = "/usr/local/etc/php-fpm.d/zz-hostplane.conf"
+
+// FPM process managers.
+const (
+	FPMStatic   = "static"
+	FPMDynamic  = "dynamic"
+	FPMOndemand = "ondemand"
+)
+
+// FPMPool is a site's PHP-FPM pool: a plan (one of fpmPlans) and per-field
+// overrides of it. Zero fields come from the plan, which defaults to
+// PHP_FPM_PLAN; with neither, the image's own defaults apply.
+type FPMPool struct {
+	Plan            string `json:"plan,omitempty"`
+	PM              string `json:"pm,omitempty"` // static, dynamic or ondemand
+	MaxChildren     int    `json:"max_children,omitempty"`
+	StartServers    int    `json:"start_servers,omitempty"`     // dynamic only
+	MinSpareServers int    `json:"min_spare_servers,omitempty"` // dynamic only
+	MaxSpareServers int    `json:"max_spare_servers,omitempty"` // dynamic only
+	MaxRequests     int    `json:"max_requests,omitempty"`      // requests before a worker is recycled; 0 = never
+}
+
+// fpmImageDefaults is the pool the wordpress:fpm image ships with.
+var fpmImageDefaults = FPMPool{PM: FPMDynamic, MaxChildren: 5, StartServers: 2, MinSpareServers: 1, MaxSpareServers: 3}
+
+// fpmPlans are the pool presets a site or PHP_FPM_PLAN can pick by name.
+var fpmPlans = map[string]FPMPool{
+	"small":  {PM: FPMOndemand, MaxChildren: 3, MaxRequests: 500},
+	"medium": {PM: FPMDynamic, MaxChildren: 6, StartServers: 2, MinSpareServers: 1, MaxSpareServers: 3, MaxRequests: 500},
+	"large":  {PM: FPMDynamic, MaxChildren: 12, StartServers: 4, MinSpareServers: 2, MaxSpareServers: 6, MaxRequests: 1000},
+}
+
+// fpmPlanNames lists fpmPlans for error messages.
+func fpmPlanNames() string {
+	names := make([]string, 0, len(fpmPlans))
+	for name := range fpmPlans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// fpmMaxChildren is the most workers the PHP container's limits allow.
+func fpmMaxChildren() int {
+	byMemory := (phpMemoryMB - fpmMasterMB) / fpmChildMemoryMB
+	byPids := phpPidsLimit - fpmPidHeadroom
+	return min(byMemory, byPids)
+}
+
+// resolveFPMPool fills the zero fields of a site's pool from its plan, or
+// PHP_FPM_PLAN, or the image defaults. The plan must be known (see
+// FPMPool.validate); Plan is set to the plan used.
+func resolveFPMPool(cfg Config, p FPMPool) FPMPool {
+	if p.Plan == "" {
+		p.Plan = cfg.FPMPlan
+	}
+	base, ok := fpmPlans[p.Plan]
+	if !ok {
+		base = fpmImageDefaults
+	}
+	if p.PM == "" {
+		p.PM = base.PM
+	}
+	if p.MaxChildren == 0 {
+		p.MaxChildren = base.MaxChildren
+	}
+	if p.StartServers == 0 {
+		p.StartServers = base.StartServers
+	}
+	if p.MinSpareServers == 0 {
+		p.MinSpareServers = base.MinSpareServers
+	}
+	if p.MaxSpareServers == 0 {
+		p.MaxSpareServers = base.MaxSpareServers
+	}
+	if p.MaxRequests == 0 {
+		p.MaxRequests = base.MaxRequests
+	}
+	return p
+}
+
+// validate checks a resolved pool the way php-fpm would refuse to start on,
+// and against the PHP container's memory and pid limits; field names are
+// prefixed with prefix in errors.
+func (p FPMPool) validate(prefix string) error {
+	if p.Plan != "" {
+		if _, ok := fpmPlans[p.Plan]; !ok {
+			return fmt.Errorf("%splan must be one of %s", prefix, fpmPlanNames())
+		}
+	}
+	if p.PM != FPMStatic && p.PM != FPMDynamic && p.PM != FPMOndemand {
+		return fmt.Errorf("%spm must be %q, %q or %q", prefix, FPMStatic, FPMDynamic, FPMOndemand)
+	}
+	if limit := fpmMaxChildren(); p.MaxChildren < 1 || p.MaxChildren > limit {
+		return fmt.Errorf("%smax_children must be between 1 and %d (the PHP container is limited to %dMB and %d pids)",
+			prefix, limit, phpMemoryMB, phpPidsLimit)
+	}
+	if p.MaxRequests < 0 || p.MaxRequests > 100000 {
+		return fmt.Errorf("%smax_requests must be between 0 (never recycle) and 100000", prefix)
+	}
+	if p.PM == FPMDynamic {
+		switch {
+		case p.MinSpareServers < 1 || p.MaxSpareServers < p.MinSpareServers:
+			return fmt.Errorf("%smin_spare_servers must be at least 1 and at most max_spare_servers", prefix)
+		case p.MaxSpareServers > p.MaxChildren:
+			return fmt.Errorf("%smax_spare_servers must not exceed max_children", prefix)
+		case p.StartServers < p.MinSpareServers || p.StartServers > p.MaxSpareServers:
+			return fmt.Errorf("%sstart_servers must be between min_spare_servers and max_spare_servers", prefix)
+		}
+	}
+	return nil
+}
+
+// renderFPMPool returns the pool override for a resolved pool.
+func renderFPMPool(p FPMPool) string {
+	plan := p.Plan
+	if plan == "" {
+		plan = "image defaults"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Written by the control plane (%s); changes are lost when the container is recreated.\n", plan)
+	b.WriteString("[www]\n")
+	fmt.Fprintf(&b, "pm = %s\npm.max_children = %d\n", p.PM, p.MaxChildren)
+	switch p.PM {
+	case FPMDynamic:
+		fmt.Fprintf(&b, "pm.start_servers = %d\npm.min_spare_servers = %d\npm.max_spare_servers = %d\n",
+			p.StartServers, p.MinSpareServers, p.MaxSpareServers)
+	case FPMOndemand:
+		b.WriteString("pm.process_idle_timeout = 10s\n")
+	}
+	fmt.Fprintf(&b, "pm.max_requests = %d\n", p.MaxRequests)
+	return b.String()
+}
@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -15,7 +19,8 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/go-sql-driver/mysql"
 )
 
 type Provisioner struct {
@@ -27,113 +32,552 @@ func NewProvisioner(docker *client.Client, cfg Config) *Provisioner {
 	return &Provisioner{docker: docker, cfg: cfg}
 }
 
-func (p *Provisioner) Run(site string) error {
-	dbName := WPDatabaseName(site)
-	dbUser := WPDatabaseUser(site)
-	dbPass := WPDatabasePass(site)
-	volName := VolumeName(site)
-	phpName := PHPContainerName(site)
-	nginxName := NginxContainerName(site)
-	domain := SiteDomain(site, p.cfg.BaseDomain)
+// ProvisionOptions carries per-site settings from the provision request to
+// the worker. Stored as JSON in jobs.payload for PROVISION jobs; an empty
+// payload means all defaults.
+type ProvisionOptions struct {
+	VolumeSize string        `json:"volume_size,omitempty"` // size hint passed to the volume driver
+	Template   *SiteTemplate `json:"template,omitempty"`    // resolved at request time; nil = blank install
+	CloneFrom  string        `json:"clone_from,omitempty"`  // source site for CLONE jobs (Template points at its data)
+
+	// AdminPassword is AdminPasswordReset to give a clone's admin account a
+	// new password rather than the source's (see cloneAdminCredentials).
+	AdminPassword string `json:"admin_password,omitempty"`
+
+	NginxSnippet string `json:"nginx_snippet,omitempty"` // validated custom rules for the server block
+	PathMode     bool   `json:"path_mode,omitempty"`     // serve at https://<BaseDomain>/<site>/ instead of a subdomain
+	BaseDomain   string `json:"base_domain,omitempty"`   // one of Config.BaseDomains; "" = Config.BaseDomain
+
+	LogMaxSize string `json:"log_max_size,omitempty"` // per-site override of the log driver's max-size
+	LogMaxFile string `json:"log_max_file,omitempty"` // per-site override of the log driver's max-file
+
+	RestartPolicy string `json:"restart_policy,omitempty"` // one of restartPolicies; "" = defaultRestartPolicy
+
+	NginxResources NginxResources `json:"nginx_resources"` // per-site sidecar limits; zero fields use the configured defaults
+
+	FastCGI FastCGISettings `json:"fastcgi"` // per-site nginx → PHP-FPM tuning; zero fields use the configured defaults
+
+	FPM FPMPool `json:"fpm"` // PHP-FPM pool plan and overrides; see php_fpm.go
+
+	// ExistingVolume is a populated volume already on app-01 (a migration)
+	// to mount instead of creating wp_<site>. It is never removed on
+	// rollback or destroy.
+	ExistingVolume string `json:"existing_volume,omitempty"`
+
+	// CustomDomain is attached by the worker once the site is up, leaving
+	// it DOMAIN_ACTIVE; one whose DNS does not point at the ingress yet is
+	// left pending, as with wait_for_dns (see Worker.attachProvisionDomain).
+	CustomDomain string `json:"custom_domain,omitempty"`
+
+	// Hook overrides the global POST_PROVISION_HOOK for this site.
+	Hook *PostProvisionHook `json:"hook,omitempty"`
+
+	// Locale and Plugins have the worker install WordPress in that locale
+	// and with those plugins active (see Provisioner.setupWordPress).
+	// AdminEmail is the installer's admin address ("" = admin@<domain>).
+	Locale     string   `json:"locale,omitempty"`
+	Plugins    []string `json:"plugins,omitempty"`
+	AdminEmail string   `json:"admin_email,omitempty"`
+
+	// WPConfigExtra replaces Config.WPConfigExtra for this site; see
+	// siteConfigExtra.
+	WPConfigExtra string `json:"wp_config_extra,omitempty"`
+
+	// ObjectCache gives the site a Redis object cache: ObjectCachePerSite or
+	// ObjectCacheShared, "" for none (see redis.go).
+	ObjectCache string `json:"object_cache,omitempty"`
+
+	// Resources names the containers, volumes and database instead of the
+	// slug passed to Run, which then only names the route: a blue/green
+	// green set is provisioned as Resources=<site>_green under the preview
+	// name GreenPreviewSite(site). See SiteResources.
+	Resources string `json:"resources,omitempty"`
+
+	// Network is the Docker network the site's containers join, created on
+	// first use; "" is DOCKER_NETWORK (see network.go).
+	Network string `json:"network,omitempty"`
+
+	// Egress is the outbound policy set on Network before any container
+	// joins it (see egress.go); EgressOpen sets none.
+	Egress string `json:"egress,omitempty"`
+}
+
+// defaultRestartPolicy is used for site containers unless the provision
+// request picks another of restartPolicies.
+const defaultRestartPolicy = "unless-stopped"
+
+// restartPolicies are the Docker restart policies a site may request.
+var restartPolicies = map[string]bool{
+	"no":             true,
+	"on-failure":     true,
+	"always":         true,
+	"unless-stopped": true,
+}
+
+// onFailureMaxRetries caps restarts under the on-failure policy so a
+// crash-looping container eventually stays down.
+const onFailureMaxRetries = 5
+
+// containerRestartPolicy returns the Docker restart policy for name,
+// falling back to defaultRestartPolicy for "".
+func containerRestartPolicy(name string) container.RestartPolicy {
+	if name == "" {
+		name = defaultRestartPolicy
+	}
+	policy := container.RestartPolicy{Name: name}
+	if name == "on-failure" {
+		policy.MaximumRetryCount = onFailureMaxRetries
+	}
+	return policy
+}
+
+// NginxResources are per-site limits for the nginx sidecar, which serves
+// the static assets and so carries most of a busy site's concurrency. Zero
+// fields fall back to NGINX_MEMORY_MB, NGINX_CPU_MILLIS and NGINX_PIDS_LIMIT.
+type NginxResources struct {
+	MemoryMB  int `json:"memory_mb,omitempty"`
+	CPUMillis int `json:"cpu_millis,omitempty"` // 1000 = one CPU
+	Pids      int `json:"pids,omitempty"`
+}
+
+// validate checks per-site overrides against sane bounds for a sidecar.
+func (r NginxResources) validate() error {
+	switch {
+	case r.MemoryMB != 0 && (r.MemoryMB < 32 || r.MemoryMB > 4096):
+		return fmt.Errorf("nginx_resources.memory_mb must be between 32 and 4096")
+	case r.CPUMillis != 0 && (r.CPUMillis < 100 || r.CPUMillis > 8000):
+		return fmt.Errorf("nginx_resources.cpu_millis must be between 100 and 8000")
+	case r.Pids != 0 && (r.Pids < 10 || r.Pids > 1000):
+		return fmt.Errorf("nginx_resources.pids must be between 10 and 1000")
+	}
+	return nil
+}
+
+// nginxContainerResources resolves the sidecar's Docker resource limits.
+// Swap is pinned at twice the memory, Docker's default when only the memory
+// limit is given.
+func nginxContainerResources(cfg Config, r NginxResources) container.Resources {
+	memoryMB, cpuMillis, pids := cfg.NginxMemoryMB, cfg.NginxCPUMillis, cfg.NginxPidsLimit
+	if r.MemoryMB > 0 {
+		memoryMB = r.MemoryMB
+	}
+	if r.CPUMillis > 0 {
+		cpuMillis = r.CPUMillis
+	}
+	if r.Pids > 0 {
+		pids = r.Pids
+	}
+	pidsLimit := int64(pids)
+	return container.Resources{
+		Memory:     int64(memoryMB) << 20,
+		MemorySwap: int64(memoryMB) << 21,
+		NanoCPUs:   int64(cpuMillis) * 1_000_000,
+		PidsLimit:  &pidsLimit,
+	}
+}
+
+// FastCGISettings tune the nginx → PHP-FPM hop of a WordPress site's server
+// block, for pages that run long (imports, slow plugins) or send large
+// headers. Zero fields fall back to FASTCGI_READ_TIMEOUT_SEC,
+// FASTCGI_SEND_TIMEOUT_SEC and FASTCGI_BUFFER_SIZE_KB.
+type FastCGISettings struct {
+	ReadTimeoutSec int `json:"read_timeout_sec,omitempty"`
+	SendTimeoutSec int `json:"send_timeout_sec,omitempty"`
+	BufferSizeKB   int `json:"buffer_size_kb,omitempty"` // fastcgi_buffer_size, and the size of each fastcgi_buffers buffer
+}
+
+// fastcgiBufferCount is the number of fastcgi_buffers. With buffers the size
+// of fastcgi_buffer_size, nginx's default busy-buffers size (two buffers)
+// always stays below the total minus one buffer, as nginx -t requires.
+const fastcgiBufferCount = 16
+
+// validate checks FastCGI settings against sane bounds; field names are
+// prefixed with prefix in errors.
+func (f FastCGISettings) validate(prefix string) error {
+	switch {
+	case f.ReadTimeoutSec != 0 && (f.ReadTimeoutSec < 1 || f.ReadTimeoutSec > 3600):
+		return fmt.Errorf("%sread_timeout_sec must be between 1 and 3600", prefix)
+	case f.SendTimeoutSec != 0 && (f.SendTimeoutSec < 1 || f.SendTimeoutSec > 3600):
+		return fmt.Errorf("%ssend_timeout_sec must be between 1 and 3600", prefix)
+	case f.BufferSizeKB != 0 && (f.BufferSizeKB < 4 || f.BufferSizeKB > 256):
+		return fmt.Errorf("%sbuffer_size_kb must be between 4 and 256", prefix)
+	}
+	return nil
+}
+
+// resolveFastCGI fills the zero fields of a site's override from cfg.
+func resolveFastCGI(cfg Config, f FastCGISettings) FastCGISettings {
+	if f.ReadTimeoutSec == 0 {
+		f.ReadTimeoutSec = cfg.FastCGI.ReadTimeoutSec
+	}
+	if f.SendTimeoutSec == 0 {
+		f.SendTimeoutSec = cfg.FastCGI.SendTimeoutSec
+	}
+	if f.BufferSizeKB == 0 {
+		f.BufferSizeKB = cfg.FastCGI.BufferSizeKB
+	}
+	return f
+}
+
+// containerOptions are the per-site HostConfig settings of a WordPress
+// site's PHP and nginx containers.
+type containerOptions struct {
+	Log     container.LogConfig
+	Restart container.RestartPolicy
+	Nginx   container.Resources // nginx sidecar only
+	FPM     FPMPool             // PHP container only, resolved
+	Network string              // Docker network, resolved
+}
+
+// siteContainerOptions resolves the container settings for a provision.
+func siteContainerOptions(cfg Config, opts ProvisionOptions) containerOptions {
+	return containerOptions{
+		Log:     containerLogConfig(cfg, opts.LogMaxSize, opts.LogMaxFile),
+		Restart: containerRestartPolicy(opts.RestartPolicy),
+		Nginx:   nginxContainerResources(cfg, opts.NginxResources),
+		FPM:     resolveFPMPool(cfg, opts.FPM),
+		Network: SiteNetwork(&Site{Network: opts.Network}, cfg),
+	}
+}
+
+// Log rotation applied when the driver supports it and LOG_OPTS does not
+// say otherwise.
+const (
+	defaultLogMaxSize = "10m"
+	defaultLogMaxFile = "3"
+)
+
+// logDriverRotates reports whether a Docker log driver understands the
+// max-size / max-file options.
+func logDriverRotates(driver string) bool {
+	return driver == "json-file" || driver == "local"
+}
+
+// containerLogConfig returns the Docker log config for a site container:
+// the configured driver and options, with default rotation for drivers that
+// rotate, and maxSize / maxFile (when non-empty) overriding both.
+func containerLogConfig(cfg Config, maxSize, maxFile string) container.LogConfig {
+	opts := map[string]string{}
+	if logDriverRotates(cfg.LogDriver) {
+		opts["max-size"] = defaultLogMaxSize
+		opts["max-file"] = defaultLogMaxFile
+	}
+	for k, v := range cfg.LogOpts {
+		opts[k] = v
+	}
+	if maxSize != "" {
+		opts["max-size"] = maxSize
+	}
+	if maxFile != "" {
+		opts["max-file"] = maxFile
+	}
+	return container.LogConfig{Type: cfg.LogDriver, Config: opts}
+}
+
+// Run provisions a WordPress site. ctx bounds the whole operation: cancelling
+// it (job timeout, shutdown) aborts the in-flight Docker/SQL call and triggers
+// rollback. Rollback itself uses fresh contexts so cleanup still runs after
+// ctx is done. The result is non-nil whenever a post-provision hook ran,
+// including when a blocking hook failed the provision.
+func (p *Provisioner) Run(ctx context.Context, site string, opts ProvisionOptions) (*ProvisionResult, error) {
+	resources := site
+	if opts.Resources != "" {
+		resources = opts.Resources
+	}
+	// The API only queues valid slugs, but resource names also come from
+	// stored sites and blue/green sets; nothing below runs for one that
+	// cannot be put into SQL.
+	if err := ValidateSiteDBIdentifiers(resources); err != nil {
+		return nil, fmt.Errorf("invalid site %q: %w", resources, err)
+	}
+	dbName := WPDatabaseName(resources)
+	dbUser := WPDatabaseUser(resources)
+	dbPass := WPDatabasePass(resources)
+	volName := VolumeName(resources)
+	if opts.ExistingVolume != "" {
+		volName = opts.ExistingVolume
+	}
+	phpName := PHPContainerName(resources)
+	nginxName := NginxContainerName(resources)
+	nginxConfVol := NginxConfVolumeName(resources)
+	baseDomain := p.cfg.BaseDomain
+	if opts.BaseDomain != "" {
+		baseDomain = opts.BaseDomain
+	}
+	domain := SiteDomain(site, baseDomain)
+	pathPrefix := ""
+	if opts.PathMode {
+		domain = p.cfg.BaseDomain
+		pathPrefix = SitePathPrefix(site)
+	}
+	siteURL := "https://" + domain + pathPrefix
 
 	// Track what succeeded for rollback
-	var dbCreated, volCreated, phpCreated, nginxCreated, caddyWritten bool
+	var dbCreated, volCreated, redisCreated, phpCreated, nginxCreated, caddyWritten, egressSet bool
 
 	// Rollback in reverse order
 	rollback := func(reason error) error {
 		log.Printf("[rollback] triggered for %s: %v", site, reason)
 
 		if caddyWritten {
-			p.removeCaddyConfig(site)
-			reloadCaddy(p.cfg)
+			if opts.PathMode {
+				p.removeCaddyPathRoute(site)
+			} else {
+				p.removeCaddyConfig(site)
+			}
+			reloadCaddy(context.Background(), p.cfg)
 		}
 		if nginxCreated {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
 			defer cancel()
 			p.docker.ContainerStop(ctx, nginxName, container.StopOptions{})
 			p.docker.ContainerRemove(ctx, nginxName, types.ContainerRemoveOptions{Force: true})
+			p.docker.VolumeRemove(ctx, nginxConfVol, true)
 		}
 		if phpCreated {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
 			defer cancel()
 			p.docker.ContainerStop(ctx, phpName, container.StopOptions{})
 			p.docker.ContainerRemove(ctx, phpName, types.ContainerRemoveOptions{Force: true})
 		}
+		if redisCreated {
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
+			defer cancel()
+			p.docker.ContainerRemove(ctx, RedisContainerName(resources), types.ContainerRemoveOptions{Force: true})
+		}
 		if volCreated {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
 			defer cancel()
 			p.docker.VolumeRemove(ctx, volName, true)
 		}
 		if dbCreated {
 			p.dropDatabase(dbName, dbUser)
 		}
+		if phpCreated || nginxCreated || redisCreated || egressSet {
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
+			defer cancel()
+			if err := releaseSiteNetwork(ctx, p.docker, p.cfg, opts.Network); err != nil {
+				log.Printf("[rollback] warning: %v", err)
+			}
+		}
+		if egressSet {
+			if err := p.clearEgressPolicy(context.Background(), opts.Network); err != nil {
+				log.Printf("[rollback] warning: %v", err)
+			}
+		}
 
-		return fmt.Errorf("provisioning failed (rolled back): %w", reason)
+		return p.classifyExhaustion(fmt.Errorf("provisioning failed (rolled back): %w", reason))
+	}
+
+	// stopped rolls back once the job's context is done — cancelled by
+	// POST /api/jobs/:id/cancel, or out of time — so a step that does not
+	// watch ctx is not started only to be orphaned.
+	stopped := func(next string) error {
+		if err := ctx.Err(); err != nil {
+			return rollback(fmt.Errorf("%s: %w", next, context.Cause(ctx)))
+		}
+		return nil
+	}
+
+	// Step 0 [PROVISION_PLACEHOLDER only]: serve the "being set up" page on
+	// the domain until step 6 writes the real snippet. Not for path-routed
+	// sites, which share the base domain's block, nor for a green set, whose
+	// site is already live. The page is cosmetic: failing to write it only
+	// warns, and once written rollback removes it like the real snippet.
+	if p.cfg.ProvisionPlaceholder && !opts.PathMode && opts.Resources == "" {
+		written, err := p.writeProvisioningPage(ctx, site, domain)
+		caddyWritten = written
+		if err != nil {
+			log.Printf("[WARN] site=%s provisioning page not served: %v", site, err)
+		}
 	}
 
 	// Step 1: Create database and user on state-01
-	if err := p.createDatabase(dbName, dbUser, dbPass); err != nil {
-		return rollback(fmt.Errorf("createDatabase: %w", err))
+	if err := p.createDatabase(ctx, dbName, dbUser, dbPass); err != nil {
+		return nil, rollback(fmt.Errorf("createDatabase: %w", err))
 	}
 	dbCreated = true
 
-	// Step 2: Create wp_<site> Docker volume
-	if err := p.createVolume(volName); err != nil {
-		return rollback(fmt.Errorf("createVolume: %w", err))
+	if err := stopped("createVolume"); err != nil {
+		return nil, err
+	}
+
+	// Step 2: Create wp_<site> Docker volume, or check the existing one is
+	// still there. volCreated stays false for it so rollback keeps it.
+	if opts.ExistingVolume != "" {
+		if _, err := p.docker.VolumeInspect(ctx, volName); err != nil {
+			return nil, rollback(fmt.Errorf("inspectExistingVolume: %w", err))
+		}
+		jobLogf(ctx, "provisioner", "site=%s using existing volume %s", site, volName)
+	} else {
+		if err := p.createVolume(ctx, volName, opts.VolumeSize); err != nil {
+			return nil, rollback(fmt.Errorf("createVolume: %w", err))
+		}
+		volCreated = true
+	}
+
+	// Step 2b [template only]: seed volume + database before the PHP container
+	// starts, so the wordpress image entrypoint sees a populated volume and
+	// skips copying in a blank core.
+	if opts.Template != nil {
+		if err := p.copyVolume(ctx, opts.Template.Volume, volName); err != nil {
+			return nil, rollback(fmt.Errorf("seedVolume: %w", err))
+		}
+		if err := p.copyDatabase(ctx, opts.Template.Database, dbName); err != nil {
+			return nil, rollback(fmt.Errorf("seedDatabase: %w", err))
+		}
+		jobLogf(ctx, "provisioner", "site=%s seeded from template %s", site, opts.Template.Name)
+	}
+
+	if err := stopped("createPhpContainer"); err != nil {
+		return nil, err
 	}
-	volCreated = true
 
 	// Step 3: Start PHP-FPM container (wordpress:php8.2-fpm, mounts wp_<site>)
-	if err := p.createContainer(phpName, volName, dbName, dbUser, dbPass); err != nil {
-		return rollback(fmt.Errorf("createPhpContainer: %w", err))
+	pathSiteURL := ""
+	if opts.PathMode {
+		pathSiteURL = siteURL
 	}
+	configExtra := siteConfigExtra(p.cfg, opts.WPConfigExtra, pathSiteURL)
+	copts := siteContainerOptions(p.cfg, opts)
+
+	// Step 2c [egress policy only]: restrict the network before anything
+	// of the site's runs on it.
+	if opts.Egress != EgressOpen {
+		if err := ensureSiteNetwork(ctx, p.docker, p.cfg, copts.Network); err != nil {
+			return nil, rollback(fmt.Errorf("ensureSiteNetwork: %w", err))
+		}
+		egressSet = true
+		if err := p.setEgressPolicy(ctx, copts.Network, opts.Egress); err != nil {
+			return nil, rollback(fmt.Errorf("setEgressPolicy: %w", err))
+		}
+	}
+
+	// Step 3a [object cache only]: Redis comes up before PHP so the
+	// drop-in finds it as soon as it is enabled.
+	if opts.ObjectCache != "" {
+		created, err := p.ensureObjectCache(ctx, opts.ObjectCache, resources, copts)
+		redisCreated = created
+		if err != nil {
+			return nil, rollback(fmt.Errorf("ensureObjectCache: %w", err))
+		}
+		configExtra = withObjectCache(p.cfg, configExtra, opts.ObjectCache, resources)
+	}
+	// Marked before the call: a create aborted by a cancelled ctx may still
+	// have made the container on the daemon, and rollback removes it by name.
 	phpCreated = true
+	if err := p.createContainer(ctx, phpName, volName, dbName, dbUser, dbPass, configExtra, copts); err != nil {
+		return nil, rollback(fmt.Errorf("createPhpContainer: %w", err))
+	}
+
+	// Step 3b [template only]: rewrite the template's URLs to this site's.
+	// Runs WP-CLI, so it needs the PHP container started above.
+	if opts.Template != nil {
+		if err := p.updateWordPressURLs(ctx, resources, siteURL); err != nil {
+			return nil, rollback(fmt.Errorf("rewriteTemplateURLs: %w", err))
+		}
+	}
+
+	// Step 3c [locale/plugins/object cache only]: run the installer and
+	// install the language pack, plugins and object cache drop-in. Plugin
+	// and drop-in failures are only reported.
+	result := &ProvisionResult{}
+	if opts.Locale != "" || len(opts.Plugins) > 0 || opts.ObjectCache != "" {
+		install, err := p.setupWordPress(ctx, resources, domain, siteURL, opts)
+		result.Install = install
+		if err != nil {
+			return result, rollback(fmt.Errorf("setupWordPress: %w", err))
+		}
+	}
+
+	if err := stopped("createNginxContainer"); err != nil {
+		return nil, err
+	}
 
 	// Step 4: Start nginx sidecar (mounts same volume, serves static + proxies PHP)
-	if err := p.createNginxContainer(nginxName, volName); err != nil {
-		return rollback(fmt.Errorf("createNginxContainer: %w", err))
+	nginxCreated = true // before the call, as for the PHP container
+	if err := p.createNginxContainer(ctx, nginxName, volName, nginxConfVol, copts); err != nil {
+		return nil, rollback(fmt.Errorf("createNginxContainer: %w", err))
+	}
+
+	if err := stopped("writeNginxConfig"); err != nil {
+		return nil, err
 	}
-	nginxCreated = true
 
 	// Step 5: Write nginx server block into the sidecar and reload nginx
-	if err := p.writeNginxConfig(nginxName, phpName, domain); err != nil {
-		return rollback(fmt.Errorf("writeNginxConfig: %w", err))
+	if err := p.writeNginxConfig(ctx, nginxName, phpName, domain, pathPrefix, opts.NginxSnippet, opts.FastCGI); err != nil {
+		return nil, rollback(fmt.Errorf("writeNginxConfig: %w", err))
 	}
 
-	// Step 6: Write per-site Caddy snippet (reverse_proxy → nginx sidecar)
-	if err := p.writeCaddyConfig(site, nginxName, domain); err != nil {
-		return rollback(fmt.Errorf("writeCaddyConfig: %w", err))
+	if err := stopped("writeCaddyConfig"); err != nil {
+		return nil, err
+	}
+
+	// Step 6: Write per-site Caddy snippet (reverse_proxy → nginx sidecar),
+	// replacing the provisioning page if any, or the path route under the
+	// shared base-domain block
+	if opts.PathMode {
+		if err := p.writeCaddyPathRoute(ctx, site, nginxName); err != nil {
+			return nil, rollback(fmt.Errorf("writeCaddyPathRoute: %w", err))
+		}
+	} else if err := p.writeCaddyConfig(ctx, site, nginxName, domain, "", "", CaddyLogOff, ErrorPageOff); err != nil {
+		return nil, rollback(fmt.Errorf("writeCaddyConfig: %w", err))
 	}
 	caddyWritten = true
 
+	if err := stopped("reloadCaddy"); err != nil {
+		return nil, err
+	}
+
 	// Step 7: Reload Caddy — site goes live instantly
-	if err := reloadCaddy(p.cfg); err != nil {
-		return rollback(fmt.Errorf("reloadCaddy: %w", err))
+	if err := reloadCaddy(ctx, p.cfg); err != nil {
+		return nil, rollback(fmt.Errorf("reloadCaddy: %w", err))
 	}
 
 	// Step 8: Poll for TLS cert readiness (non-fatal — Caddy retries in background).
 	// This prevents the job from completing while the cert is still pending,
 	// giving the caller an accurate cert_status signal via GET /api/sites/:site.
-	certStatus := PollCaddyCert(p.docker, p.cfg, domain, 30*time.Second)
-	log.Printf("[provisioner] site=%s cert_status=%s", site, certStatus)
+	certStatus := PollCaddyCert(ctx, p.docker, p.cfg, domain, 30*time.Second)
+	jobLogf(ctx, "provisioner", "site=%s cert_status=%s", site, certStatus)
 
-	return nil
+	// Step 9: Post-provision hook, per request or global. A blocking hook's
+	// failure rolls the site back; otherwise it is only reported.
+	hook := postProvisionHook(p.cfg, opts)
+	if hook == nil {
+		if result.Install == nil {
+			return nil, nil
+		}
+		return result, nil
+	}
+	res, err := p.runHook(ctx, resources, volName, copts.Network, hook)
+	result.Hook = res
+	if err != nil {
+		if hook.Blocking {
+			return result, rollback(fmt.Errorf("postProvisionHook: %w", err))
+		}
+		log.Printf("[WARN] site=%s post-provision hook failed (non-fatal): %v", site, err)
+	}
+	return result, nil
 }
 
 func (p *Provisioner) dropDatabase(dbName, dbUser string) {
+	if err := validateDBAccount(dbName, dbUser); err != nil {
+		log.Printf("[rollback] not dropping DB: %v", err)
+		return
+	}
 	db, err := sql.Open("mysql", p.cfg.WordPressDSN)
 	if err != nil {
 		log.Printf("[rollback] cannot open DB connection: %v", err)
 		return
 	}
 	defer db.Close()
-	db.Exec("DROP DATABASE IF EXISTS " + dbName)
-	db.Exec("DROP USER IF EXISTS '" + dbUser + "'@'%'")
+	db.Exec("DROP DATABASE IF EXISTS " + quoteDBIdentifier(dbName))
+	db.Exec("DROP USER IF EXISTS " + quoteDBIdentifier(dbUser) + "@'%'")
 	log.Printf("[rollback] dropped DB %s and user %s", dbName, dbUser)
 }
 
 func (p *Provisioner) removeCaddyConfig(site string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerExecTimeout)
 	defer cancel()
 
 	confPath := p.cfg.CaddyConfDir + "/" + CaddyConfFile(site)
@@ -148,64 +592,125 @@ func (p *Provisioner) removeCaddyConfig(site string) {
 	log.Printf("[rollback] removed caddy config for %s", site)
 }
 
-func (p *Provisioner) createDatabase(dbName, dbUser, dbPass string) error {
+func (p *Provisioner) createDatabase(ctx context.Context, dbName, dbUser, dbPass string) error {
+	if err := validateDBAccount(dbName, dbUser); err != nil {
+		return err
+	}
+	if !validDBIdentifier.MatchString(dbPass) {
+		return fmt.Errorf("database password for %s has characters that would need escaping", dbUser)
+	}
 	db, err := sql.Open("mysql", p.cfg.WordPressDSN)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		return fmt.Errorf("cannot reach DB: %w", err)
 	}
+	return createDatabaseAccount(ctx, db, dbName, dbUser, dbPass)
+}
+
+// sqlExecer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
 
-	stmts := []string{
-		"CREATE DATABASE IF NOT EXISTS " + dbName,
-		fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'%%' IDENTIFIED BY '%s'", dbUser, dbPass),
-		fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO '%s'@'%%'", dbName, dbUser),
-		"FLUSH PRIVILEGES",
+// createDatabaseAccount creates database dbName and user dbUser with all
+// privileges on it, stopping at the first statement that fails.
+func createDatabaseAccount(ctx context.Context, db sqlExecer, dbName, dbUser, dbPass string) error {
+	// Errors name the step rather than quoting the SQL: the create-user
+	// statement carries the password.
+	steps := []struct {
+		name string
+		stmt string
+	}{
+		{"create database", "CREATE DATABASE IF NOT EXISTS " + quoteDBIdentifier(dbName)},
+		{"create user", fmt.Sprintf("CREATE USER IF NOT EXISTS %s@'%%' IDENTIFIED BY '%s'", quoteDBIdentifier(dbUser), dbPass)},
+		{"grant privileges", fmt.Sprintf("GRANT ALL PRIVILEGES ON %s.* TO %s@'%%'", quoteGrantDatabase(dbName), quoteDBIdentifier(dbUser))},
+		{"flush privileges", "FLUSH PRIVILEGES"},
 	}
-	for _, stmt := range stmts {
-		if _, err := db.Exec(stmt); err != nil {
-			return fmt.Errorf("sql(%s): %w", stmt[:20], err)
+	for _, step := range steps {
+		if _, err := db.ExecContext(ctx, step.stmt); err != nil {
+			return fmt.Errorf("%s (db=%s user=%s): %w", step.name, dbName, dbUser, err)
 		}
 	}
 	return nil
 }
 
-func (p *Provisioner) createVolume(volumeName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// createVolume creates the site volume using the configured driver. When the
+// driver supports a size option (VolumeSizeOpt), the per-site size hint — or
+// VolumeDefaultSize — is passed through so quota-enforcing drivers can cap it.
+func (p *Provisioner) createVolume(ctx context.Context, volumeName, size string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerCreateTimeout)
 	defer cancel()
 
-	_, err := p.docker.VolumeCreate(ctx, volume.CreateOptions{Name: volumeName})
+	driverOpts := map[string]string{}
+	for k, v := range p.cfg.VolumeDriverOpts {
+		driverOpts[k] = v
+	}
+	if size == "" {
+		size = p.cfg.VolumeDefaultSize
+	}
+	if size != "" && p.cfg.VolumeSizeOpt != "" {
+		driverOpts[p.cfg.VolumeSizeOpt] = size
+	}
+
+	_, err := p.docker.VolumeCreate(ctx, volume.CreateOptions{
+		Name:       volumeName,
+		Driver:     p.cfg.VolumeDriver,
+		DriverOpts: driverOpts,
+	})
 	return err
 }
 
-func (p *Provisioner) createContainer(phpName, volumeName, dbName, dbUser, dbPass string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+// startExisting starts a site container left over from an earlier attempt,
+// first reapplying the requested restart policy (the only HostConfig setting
+// Docker can change in place).
+func (p *Provisioner) startExisting(ctx context.Context, name string, copts containerOptions) error {
+	if _, err := p.docker.ContainerUpdate(ctx, name, container.UpdateConfig{RestartPolicy: copts.Restart}); err != nil {
+		return fmt.Errorf("update restart policy of %s: %w", name, err)
+	}
+	return p.docker.ContainerStart(ctx, name, types.ContainerStartOptions{})
+}
+
+// createContainer starts the site's PHP-FPM container. configExtra, when
+// non-empty, is passed as WORDPRESS_CONFIG_EXTRA for wp-config.php. The
+// pool override (copts.FPM) is copied in before the first start, so FPM
+// never runs without it.
+func (p *Provisioner) createContainer(ctx context.Context, phpName, volumeName, dbName, dbUser, dbPass, configExtra string, copts containerOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerCreateTimeout)
 	defer cancel()
 
 	// Idempotent — container already exists, just ensure it's running
-	_, err := p.docker.ContainerInspect(ctx, phpName)
-	if err == nil {
-		return p.docker.ContainerStart(ctx, phpName, types.ContainerStartOptions{})
+	if p.reusableContainer(ctx, phpName) {
+		return p.startExisting(ctx, phpName, copts)
 	}
+	if err := ensureSiteNetwork(ctx, p.docker, p.cfg, copts.Network); err != nil {
+		return err
+	}
+
+	pids := int64(phpPidsLimit)
 
-	pids := int64(100)
+	env := []string{
+		"WORDPRESS_DB_HOST=" + p.cfg.DBHost(),
+		"WORDPRESS_DB_USER=" + dbUser,
+		"WORDPRESS_DB_PASSWORD=" + dbPass,
+		"WORDPRESS_DB_NAME=" + dbName,
+	}
+	if configExtra != "" {
+		env = append(env, "WORDPRESS_CONFIG_EXTRA="+configExtra)
+	}
 
 	resp, err := p.docker.ContainerCreate(
 		ctx,
 		&container.Config{
 			Image: "wordpress:php8.2-fpm",
-			Env: []string{
-				"WORDPRESS_DB_HOST=" + p.cfg.DBHost(),
-				"WORDPRESS_DB_USER=" + dbUser,
-				"WORDPRESS_DB_PASSWORD=" + dbPass,
-				"WORDPRESS_DB_NAME=" + dbName,
-			},
+			Env:   env,
 		},
 		&container.HostConfig{
-			RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+			RestartPolicy: copts.Restart,
+			LogConfig:     copts.Log,
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
@@ -214,14 +719,14 @@ func (p *Provisioner) createContainer(phpName, volumeName, dbName, dbUser, dbPas
 				},
 			},
 			Resources: container.Resources{
-				Memory:    512 * 1024 * 1024,
+				Memory:    phpMemoryMB * 1024 * 1024,
 				NanoCPUs:  1_000_000_000,
 				PidsLimit: &pids,
 			},
 		},
 		&network.NetworkingConfig{
 			EndpointsConfig: map[string]*network.EndpointSettings{
-				p.cfg.DockerNetwork: {},
+				copts.Network: {},
 			},
 		},
 		nil,
@@ -231,19 +736,113 @@ func (p *Provisioner) createContainer(phpName, volumeName, dbName, dbUser, dbPas
 		return fmt.Errorf("container create: %w", err)
 	}
 
-	return p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte(renderFPMPool(copts.FPM))
+	tw.WriteHeader(&tar.Header{
+		Name:    path.Base(fpmPoolPath),
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	tw.Write(content)
+	tw.Close()
+	if err := p.docker.CopyToContainer(ctx, resp.ID, path.Dir(fpmPoolPath), &buf, types.CopyToContainerOptions{}); err != nil {
+		p.discardCreated(phpName)
+		return fmt.Errorf("write fpm pool: %w", err)
+	}
+
+	if err := p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return p.failedStart(phpName, err)
+	}
+	return nil
 }
 
-// writeCaddyConfig writes a per-site Caddy snippet into the CaddyConfDir inside
-// the Caddy container. Caddy simply reverse-proxies by hostname to the site's
-// nginx sidecar — no FastCGI from Caddy's side.
-func (p *Provisioner) writeCaddyConfig(site, nginxName, defaultDomain string, customDomain ...string) error {
-	hosts := defaultDomain
-	if len(customDomain) > 0 && customDomain[0] != "" {
-		hosts = defaultDomain + ", " + customDomain[0]
+// discardCreated force-removes a container this attempt created but did
+// not get running. The caller's rollback only removes containers that came
+// up, so without this a failed or cancelled start would leave one behind.
+// It uses a fresh context because ctx may be the cancelled job's.
+func (p *Provisioner) discardCreated(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
+	defer cancel()
+	if err := p.docker.ContainerRemove(ctx, name, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.Printf("[provisioner] warning: could not remove unstarted container %s: %v", name, err)
+	}
+}
+
+// reusableContainer reports whether container name exists and can be
+// started as it is. One that was created but never started is what a start
+// failure leaves behind when discardCreated could not remove it; starting
+// it again would fail the same way on every retry, so it is removed here
+// and the caller creates it afresh.
+func (p *Provisioner) reusableContainer(ctx context.Context, name string) bool {
+	info, err := p.docker.ContainerInspect(ctx, name)
+	if err != nil {
+		return false
+	}
+	if info.State != nil && info.State.Status == "created" {
+		jobLogf(ctx, "provisioner", "removing never-started container %s left by an earlier attempt", name)
+		p.discardCreated(name)
+		return false
 	}
+	return true
+}
+
+// startFailureLogLines and startFailureLogMax bound the container output
+// failedStart adds to its error.
+const (
+	startFailureLogLines = "20"
+	startFailureLogMax   = 2048
+)
+
+// failedStart handles a container this attempt created that would not
+// start (bad env, a port or resource conflict, a missing mount): it returns
+// startErr with the container's state, exit code and last log lines, then
+// removes the container so a retry creates it clean. It uses a fresh
+// context because ctx may be the cancelled job's.
+func (p *Provisioner) failedStart(name string, startErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerExecTimeout)
+	defer cancel()
+
+	var detail []string
+	if info, err := p.docker.ContainerInspect(ctx, name); err == nil && info.State != nil {
+		st := info.State
+		detail = append(detail, fmt.Sprintf("state=%s exit=%d", st.Status, st.ExitCode))
+		if st.OOMKilled {
+			detail = append(detail, "oom-killed")
+		}
+		if st.Error != "" && !strings.Contains(startErr.Error(), st.Error) {
+			detail = append(detail, "error="+st.Error)
+		}
+	}
+	if logs, err := p.docker.ContainerLogs(ctx, name, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       startFailureLogLines,
+	}); err == nil {
+		var out bytes.Buffer
+		stdcopy.StdCopy(&out, &out, io.LimitReader(logs, startFailureLogMax))
+		logs.Close()
+		if s := strings.Join(strings.Fields(out.String()), " "); s != "" {
+			detail = append(detail, "logs: "+s)
+		}
+	}
+	p.discardCreated(name)
 
-	conf := fmt.Sprintf("%s {\n    encode gzip\n    reverse_proxy %s:80\n}\n", hosts, nginxName)
+	if len(detail) == 0 {
+		return fmt.Errorf("start %s: %w", name, startErr)
+	}
+	return fmt.Errorf("start %s: %w (%s)", name, startErr, strings.Join(detail, "; "))
+}
+
+// writeCaddyConfig writes a per-site Caddy snippet into the CaddyConfDir inside
+// the Caddy container. Caddy simply reverse-proxies by hostname to the site's
+// nginx sidecar — no FastCGI from Caddy's side. logMode is the site's
+// request logging (see caddy_log.go) and errorPage its error page mode (see
+// error_page.go).
+func (p *Provisioner) writeCaddyConfig(ctx context.Context, site, nginxName, defaultDomain, customDomain, wwwMode, logMode, errorPage string) error {
+	conf := renderCaddyConfig(nginxName, defaultDomain, customDomain, wwwMode,
+		renderCaddyLog(p.cfg, site, logMode), renderCaddyErrorPage(p.cfg, site, errorPage))
 
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
@@ -257,7 +856,7 @@ func (p *Provisioner) writeCaddyConfig(site, nginxName, defaultDomain string, cu
 	tw.Write(content)
 	tw.Close()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerExecTimeout)
 	defer cancel()
 
 	if err := ensureCaddyConfDir(ctx, p.docker, p.cfg.CaddyContainer, p.cfg.CaddyConfDir); err != nil {
@@ -268,28 +867,43 @@ func (p *Provisioner) writeCaddyConfig(site, nginxName, defaultDomain string, cu
 		p.cfg.CaddyConfDir, &buf, types.CopyToContainerOptions{})
 }
 
+// renderCaddyConfig returns the Caddy snippet for a WordPress site, proxying
+// the default (and optional custom) hostname to the nginx sidecar, plus the
+// www redirect block if the custom domain has one. logDirective is the
+// rendered `log` block and errorsDirective the rendered handle_errors
+// block, each or "".
+func renderCaddyConfig(nginxName, defaultDomain, customDomain, wwwMode, logDirective, errorsDirective string) string {
+	hosts := strings.Join(append([]string{defaultDomain}, customDomainHosts(customDomain, wwwMode)...), ", ")
+	return fmt.Sprintf("%s {\n%s    encode gzip\n    reverse_proxy %s:80\n%s}\n", hosts, logDirective, nginxName, errorsDirective) +
+		renderWWWRedirect(customDomain, wwwMode)
+}
+
 // createNginxContainer starts an nginx:alpine sidecar for a site.
 // It shares the same wp_<site> volume as the PHP-FPM container so nginx can
 // serve static assets directly. The server block is written separately via
-// writeNginxConfig after the container is running.
-func (p *Provisioner) createNginxContainer(nginxName, volumeName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+// writeNginxConfig after the container is running; conf.d is backed by its
+// own volume (confVolume) so the block is not lost if the sidecar is
+// recreated from the image.
+func (p *Provisioner) createNginxContainer(ctx context.Context, nginxName, volumeName, confVolume string, copts containerOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerCreateTimeout)
 	defer cancel()
 
 	// Idempotent — container already exists, just ensure it's running
-	_, err := p.docker.ContainerInspect(ctx, nginxName)
-	if err == nil {
-		return p.docker.ContainerStart(ctx, nginxName, types.ContainerStartOptions{})
+	if p.reusableContainer(ctx, nginxName) {
+		return p.startExisting(ctx, nginxName, copts)
+	}
+	if err := ensureSiteNetwork(ctx, p.docker, p.cfg, copts.Network); err != nil {
+		return err
 	}
 
-	pids := int64(50)
 	resp, err := p.docker.ContainerCreate(
 		ctx,
 		&container.Config{
 			Image: "nginx:alpine",
 		},
 		&container.HostConfig{
-			RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+			RestartPolicy: copts.Restart,
+			LogConfig:     copts.Log,
 			Mounts: []mount.Mount{
 				{
 					Type:     mount.TypeVolume,
@@ -297,16 +911,17 @@ func (p *Provisioner) createNginxContainer(nginxName, volumeName string) error {
 					Target:   "/var/www/html",
 					ReadOnly: true,
 				},
+				{
+					Type:   mount.TypeVolume,
+					Source: confVolume,
+					Target: nginxConfDir,
+				},
 			},
-			Resources: container.Resources{
-				Memory:    128 * 1024 * 1024,
-				NanoCPUs:  500_000_000,
-				PidsLimit: &pids,
-			},
+			Resources: copts.Nginx,
 		},
 		&network.NetworkingConfig{
 			EndpointsConfig: map[string]*network.EndpointSettings{
-				p.cfg.DockerNetwork: {},
+				copts.Network: {},
 			},
 		},
 		nil,
@@ -316,14 +931,58 @@ func (p *Provisioner) createNginxContainer(nginxName, volumeName string) error {
 		return fmt.Errorf("nginx container create: %w", err)
 	}
 
-	return p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err := p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return p.failedStart(nginxName, err)
+	}
+	return nil
+}
+
+// nginxConfDir is where the sidecar's server blocks live, on the site's
+// NginxConfVolumeName volume.
+const nginxConfDir = "/etc/nginx/conf.d"
+
+// nginxConfMounted reports whether the sidecar nginxName has conf.d on a
+// volume. Sidecars created before the volume existed keep their server
+// block in the container's own filesystem, so it is lost whenever they are
+// recreated.
+func (p *Provisioner) nginxConfMounted(ctx context.Context, nginxName string) (bool, error) {
+	info, err := p.docker.ContainerInspect(ctx, nginxName)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range info.Mounts {
+		if m.Destination == nginxConfDir {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recreateNginxSidecar returns a replaceContainer recreate func for s's
+// sidecar: a new container with copts, conf.d on its volume, and the server
+// block written again from the site record. Writing it is a no-op for a
+// sidecar whose volume already holds it, and moves a sidecar from before
+// the volume onto it.
+func (p *Provisioner) recreateNginxSidecar(s *Site, copts containerOptions) func(context.Context) error {
+	res := SiteResources(s)
+	return func(ctx context.Context) error {
+		if err := p.createNginxContainer(ctx, NginxContainerName(res), SiteVolume(s), NginxConfVolumeName(res), copts); err != nil {
+			return err
+		}
+		return p.writeNginxConfigWithDomains(ctx, NginxContainerName(res), PHPContainerName(res),
+			s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI)
+	}
 }
 
+// errNginxConfigRejected marks a server block that failed `nginx -t`, as
+// opposed to a Docker/exec failure while writing it.
+var errNginxConfigRejected = errors.New("nginx config rejected by nginx -t")
+
 // writeNginxConfig injects the nginx server block into the running nginx_<site>
 // sidecar container and reloads nginx. The config routes static file requests
 // directly from the WordPress volume and proxies PHP to the FPM container.
-func (p *Provisioner) writeNginxConfig(nginxName, phpName, domain string) error {
-	return p.writeNginxConfigWithDomains(nginxName, phpName, domain, "")
+func (p *Provisioner) writeNginxConfig(ctx context.Context, nginxName, phpName, domain, pathPrefix, snippet string, fastcgi FastCGISettings) error {
+	return p.writeNginxConfigWithDomains(ctx, nginxName, phpName, domain, "", "", pathPrefix, snippet, fastcgi)
 }
 
 // writeNginxConfigWithDomains writes a multi-domain nginx server block.
@@ -331,15 +990,123 @@ func (p *Provisioner) writeNginxConfig(nginxName, phpName, domain string) error
 // HTTP_HOST becomes $host so WordPress receives the correct hostname per request.
 // When customDomain is empty, the config is a single-domain block with a
 // hardcoded HTTP_HOST — used for initial provisioning and domain removal.
-func (p *Provisioner) writeNginxConfigWithDomains(nginxName, phpName, defaultDomain, customDomain string) error {
-	serverName := defaultDomain
+// wwwMode is the custom domain's www handling (see customDomainHosts).
+// pathPrefix is the site's path on the base domain for path-routed sites.
+// snippet is the site's custom nginx rules ("" for none); the new block is
+// checked with `nginx -t` and the previous one restored if it fails.
+// fastcgi is the site's override of the FASTCGI_* defaults.
+func (p *Provisioner) writeNginxConfigWithDomains(ctx context.Context, nginxName, phpName, defaultDomain, customDomain, wwwMode, pathPrefix, snippet string, fastcgi FastCGISettings) error {
+	conf := renderNginxConfig(phpName, defaultDomain, customDomain, wwwMode, pathPrefix, snippet, resolveFastCGI(p.cfg, fastcgi))
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerReloadTimeout)
+	defer cancel()
+
+	previous, err := readContainerFile(ctx, p.docker, nginxName, nginxSiteConfPath)
+	if err != nil {
+		return err
+	}
+
+	if err := p.copyNginxConf(ctx, nginxName, conf); err != nil {
+		return err
+	}
+
+	test, err := runExec(ctx, p.docker, nginxName, []string{"nginx", "-t"})
+	if err != nil {
+		return fmt.Errorf("nginx -t: %w", err)
+	}
+	if test.ExitCode != 0 {
+		if previous != "" {
+			if err := p.copyNginxConf(ctx, nginxName, previous); err != nil {
+				jobLogf(ctx, "provisioner", "%s: failed to restore previous nginx config: %v", nginxName, err)
+			}
+		}
+		return fmt.Errorf("%w: %s", errNginxConfigRejected, strings.TrimSpace(test.Stderr))
+	}
+
+	// Reload nginx to apply the new server block
+	return reloadNginx(ctx, p.docker, p.cfg, nginxName)
+}
+
+// reloadNginx runs `nginx -s reload` in container nginxName, retrying
+// transient failures (e.g. the master's pid file not yet written just after
+// start). A failure that `nginx -t` explains is returned as
+// errNginxConfigRejected.
+func reloadNginx(ctx context.Context, docker *client.Client, cfg Config, nginxName string) error {
+	nginx := func(args ...string) (*ExecResult, error) {
+		return runExec(ctx, docker, nginxName, append([]string{"nginx"}, args...))
+	}
+	return retryReload(ctx, cfg, "nginx",
+		func() (string, error) {
+			res, err := nginx("-s", "reload")
+			if err != nil {
+				return "", err
+			}
+			if res.ExitCode != 0 {
+				return res.Stderr, fmt.Errorf("exit code %d", res.ExitCode)
+			}
+			return "", nil
+		},
+		func() error {
+			res, err := nginx("-t")
+			if err != nil {
+				return nil // cannot tell — treat as transient
+			}
+			if res.ExitCode != 0 {
+				return fmt.Errorf("%w: %s", errNginxConfigRejected, strings.TrimSpace(res.Stderr))
+			}
+			return nil
+		})
+}
+
+// copyNginxConf writes conf as the sidecar's default.conf without reloading.
+func (p *Provisioner) copyNginxConf(ctx context.Context, nginxName, conf string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte(conf)
+	tw.WriteHeader(&tar.Header{
+		Name:    "default.conf",
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	})
+	tw.Write(content)
+	tw.Close()
+
+	if err := p.docker.CopyToContainer(ctx, nginxName,
+		"/etc/nginx/conf.d/", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("copy nginx config: %w", err)
+	}
+	return nil
+}
+
+// renderNginxConfig returns the nginx server block for a WordPress site.
+// When customDomain is non-empty, server_name includes both domains and
+// HTTP_HOST becomes $host; otherwise HTTP_HOST is pinned to defaultDomain.
+// For a path-routed site Caddy strips pathPrefix before proxying, so it is
+// put back on REQUEST_URI for PHP — WordPress then sees the URLs it links to.
+// A non-empty snippet is inserted at the end of the server block, after the
+// built-in locations.
+func renderNginxConfig(phpName, defaultDomain, customDomain, wwwMode, pathPrefix, snippet string, fastcgi FastCGISettings) string {
+	serverName := strings.Join(append([]string{defaultDomain}, customDomainHosts(customDomain, wwwMode)...), " ")
 	httpHost := defaultDomain
 	if customDomain != "" {
-		serverName = defaultDomain + " " + customDomain
 		httpHost = "$host"
 	}
 
-	conf := fmt.Sprintf(`server {
+	requestURI := ""
+	if pathPrefix != "" {
+		requestURI = "\n        fastcgi_param REQUEST_URI " + pathPrefix + "$request_uri;"
+	}
+
+	custom := ""
+	if snippet = strings.TrimSpace(snippet); snippet != "" {
+		custom = "\n    # site snippet\n"
+		for _, line := range strings.Split(snippet, "\n") {
+			custom += "    " + strings.TrimRight(line, " \t\r") + "\n"
+		}
+	}
+
+	return fmt.Sprintf(`server {
     listen 80;
     root /var/www/html;
     index index.php;
@@ -356,46 +1123,89 @@ func (p *Provisioner) writeNginxConfigWithDomains(nginxName, phpName, defaultDom
         include fastcgi_params;
         fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
         fastcgi_param HTTPS on;
-        fastcgi_param HTTP_HOST %s;
+        fastcgi_param HTTP_HOST %s;%s
+        fastcgi_read_timeout %ds;
+        fastcgi_send_timeout %ds;
+        fastcgi_buffer_size %dk;
+        fastcgi_buffers %d %dk;
     }
+%s}
+`, serverName, phpName, httpHost, requestURI,
+		fastcgi.ReadTimeoutSec, fastcgi.SendTimeoutSec, fastcgi.BufferSizeKB, fastcgiBufferCount, fastcgi.BufferSizeKB,
+		custom)
 }
-`, serverName, phpName, httpHost)
 
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	content := []byte(conf)
-	tw.WriteHeader(&tar.Header{
-		Name:    "default.conf",
-		Mode:    0644,
-		Size:    int64(len(content)),
-		ModTime: time.Now(),
-	})
-	tw.Write(content)
-	tw.Close()
+// errWordPressNotInstalled is returned when a site's database has no
+// wp_options table yet because the WordPress installer has not been run.
+var errWordPressNotInstalled = errors.New("WordPress is not installed yet — no wp_options table")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+// mysqlErrNoSuchTable is MySQL's ER_NO_SUCH_TABLE.
+const mysqlErrNoSuchTable = 1146
 
-	if err := p.docker.CopyToContainer(ctx, nginxName,
-		"/etc/nginx/conf.d/", &buf, types.CopyToContainerOptions{}); err != nil {
-		return fmt.Errorf("copy nginx config: %w", err)
+// readWordPressURLs returns siteurl and home from the site's wp_options —
+// the values updateWordPressURLs writes. A missing option reads as "".
+func (p *Provisioner) readWordPressURLs(ctx context.Context, site string) (siteURL, home string, err error) {
+	db, err := sql.Open("mysql", p.cfg.WordPressDSN+WPDatabaseName(site))
+	if err != nil {
+		return "", "", fmt.Errorf("open site DB: %w", err)
 	}
+	defer db.Close()
 
-	// Reload nginx to apply the new server block
-	execResp, err := p.docker.ContainerExecCreate(ctx, nginxName, types.ExecConfig{
-		Cmd: []string{"nginx", "-s", "reload"},
-	})
+	rows, err := db.QueryContext(ctx,
+		"SELECT option_name, option_value FROM wp_options WHERE option_name IN ('siteurl', 'home')")
 	if err != nil {
-		return fmt.Errorf("nginx reload exec create: %w", err)
+		var myErr *mysql.MySQLError
+		if errors.As(err, &myErr) && myErr.Number == mysqlErrNoSuchTable {
+			return "", "", errWordPressNotInstalled
+		}
+		return "", "", fmt.Errorf("read wp_options: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return "", "", err
+		}
+		if name == "siteurl" {
+			siteURL = value
+		} else {
+			home = value
+		}
 	}
-	return p.docker.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{})
+	return siteURL, home, rows.Err()
 }
 
-// updateWordPressURLs sets siteurl and home in wp_options so WordPress serves
-// on the given URL. Call with "https://<customDomain>" when adding a custom
-// domain and "https://<defaultDomain>" when removing one.
-// Non-fatal if WordPress tables don't exist yet (site not installed).
-func (p *Provisioner) updateWordPressURLs(site, url string) error {
+// updateWordPressURLs moves a WordPress site to url. Absolute links to the
+// stored siteurl are rewritten throughout the content tables with
+// `wp search-replace` in the PHP container, which also fixes the lengths of
+// PHP-serialized strings; siteurl and home are then set directly. Call with
+// "https://<customDomain>" when adding a custom domain and
+// "https://<defaultDomain>" when removing one; the PHP container must be
+// running.
+//
+// Idempotent: once siteurl equals url nothing is done, so a retry never
+// re-applies the replacement (which would compound when url extends the old
+// URL, as with path routing). Skipped if WordPress is not installed yet.
+// A failed search-replace is logged and only the two options are updated.
+func (p *Provisioner) updateWordPressURLs(ctx context.Context, site, url string) error {
+	current, _, err := p.readWordPressURLs(ctx, site)
+	if errors.Is(err, errWordPressNotInstalled) {
+		jobLogf(ctx, "provisioner", "site=%s wordpress not installed yet, URL update skipped", site)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current == url {
+		return nil
+	}
+	if current != "" {
+		if err := p.searchReplaceURL(ctx, site, current, url); err != nil {
+			jobLogf(ctx, "provisioner", "site=%s search-replace %s → %s failed, updating siteurl/home only: %v", site, current, url, err)
+		}
+	}
+
 	dsn := p.cfg.WordPressDSN + WPDatabaseName(site)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -403,12 +1213,12 @@ func (p *Provisioner) updateWordPressURLs(site, url string) error {
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
+	if err = db.PingContext(ctx); err != nil {
 		return fmt.Errorf("cannot reach site DB: %w", err)
 	}
 
 	for _, opt := range []string{"siteurl", "home"} {
-		if _, err := db.Exec(
+		if _, err := db.ExecContext(ctx,
 			"UPDATE wp_options SET option_value=? WHERE option_name=?",
 			url, opt,
 		); err != nil {
@@ -416,6 +1226,21 @@ func (p *Provisioner) updateWordPressURLs(site, url string) error {
 		}
 	}
 
-	log.Printf("[provisioner] site=%s wordpress URLs set to %s", site, url)
+	jobLogf(ctx, "provisioner", "site=%s wordpress URLs set to %s", site, url)
+	return nil
+}
+
+// searchReplaceURL runs `wp search-replace from to` over every table with
+// the site's prefix. GUIDs are left alone, as WordPress requires.
+func (p *Provisioner) searchReplaceURL(ctx context.Context, site, from, to string) error {
+	result, err := runWPCLI(ctx, p.docker, p.cfg, site, "search-replace",
+		[]string{from, to, "--all-tables-with-prefix", "--skip-columns=guid", "--report-changed-only"})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("wp search-replace exited %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	jobLogf(ctx, "provisioner", "site=%s search-replace %s → %s done", site, from, to)
 	return nil
 }
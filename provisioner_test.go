@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// cancelProvisionAt provisions site and cancels it once container name
+// exists, returning Run's error.
+func cancelProvisionAt(t *testing.T, docker *client.Client, cfg Config, site, name string) error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewProvisioner(docker, cfg).Run(ctx, site, ProvisionOptions{})
+		done <- err
+	}()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer waitCancel()
+	if err := waitForContainer(waitCtx, docker, name); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(3 * time.Minute):
+		t.Fatal("Run did not return after cancel")
+		return nil
+	}
+}
+
+func TestProvisionCancelledMidwayRollsBack(t *testing.T) {
+	cfg := integrationConfig(t)
+	docker := integrationDocker(t, cfg)
+	site := testSiteName()
+	destroyTestSite(t, docker, cfg, site)
+
+	// The database and volume exist once the PHP container appears.
+	err := cancelProvisionAt(t, docker, cfg, site, PHPContainerName(site))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want one wrapping context.Canceled", err)
+	}
+	assertRolledBack(t, docker, cfg, site)
+}
+
+// Cancelled during step 4 (the nginx sidecar), the provision must undo
+// steps 1-3: database and user, volume, PHP container — and the sidecar
+// itself and any Caddy snippet.
+func TestProvisionCancelledDuringNginxStepRollsBack(t *testing.T) {
+	cfg := integrationConfig(t)
+	docker := integrationDocker(t, cfg)
+	site := testSiteName()
+	destroyTestSite(t, docker, cfg, site)
+
+	err := cancelProvisionAt(t, docker, cfg, site, NginxContainerName(site))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want one wrapping context.Canceled", err)
+	}
+	assertRolledBack(t, docker, cfg, site)
+	if caddySnippetExists(docker, cfg, caddySnippetPath(cfg, &Site{Site: site})) {
+		t.Errorf("Caddy snippet of %s still exists after rollback", site)
+	}
+}
+
+func TestNginxServerBlockSurvivesSidecarRestart(t *testing.T) {
+	cfg := integrationConfig(t)
+	docker := integrationDocker(t, cfg)
+	site := testSiteName()
+	provisionTestSite(t, docker, cfg, site)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	p := NewProvisioner(docker, cfg)
+	nginxName := NginxContainerName(site)
+	domain := SiteDomain(site, cfg.BaseDomain)
+	assertServerBlock := func(when string) {
+		t.Helper()
+		conf, err := readContainerFile(ctx, docker, nginxName, nginxSiteConfPath)
+		if err != nil {
+			t.Fatalf("%s: read server block: %v", when, err)
+		}
+		if !strings.Contains(conf, domain) {
+			t.Fatalf("%s: server block does not serve %s:\n%s", when, domain, conf)
+		}
+	}
+
+	if err := docker.ContainerRestart(ctx, nginxName, container.StopOptions{}); err != nil {
+		t.Fatalf("restart sidecar: %v", err)
+	}
+	if err := p.waitRunning(ctx, nginxName, replaceSettleDelay); err != nil {
+		t.Fatal(err)
+	}
+	assertServerBlock("after restart")
+
+	// Recreated from the image without writing the block again: only the
+	// conf.d volume can have kept it.
+	copts := siteContainerOptions(cfg, ProvisionOptions{})
+	recreate := func(ctx context.Context) error {
+		return p.createNginxContainer(ctx, nginxName, VolumeName(site), NginxConfVolumeName(site), copts)
+	}
+	if err := p.replaceContainer(ctx, nginxName, recreate); err != nil {
+		t.Fatalf("recreate sidecar: %v", err)
+	}
+	assertServerBlock("after recreate")
+
+	if mounted, err := p.nginxConfMounted(ctx, nginxName); err != nil || !mounted {
+		t.Errorf("nginxConfMounted = %t, %v; want true", mounted, err)
+	}
+}
+
+// fakeDockerConfig is the configuration the container calls need.
+func fakeDockerConfig() Config {
+	return Config{
+		DockerNetwork:       "wp_net",
+		DockerCreateTimeout: 10 * time.Second,
+		DockerExecTimeout:   10 * time.Second,
+		DockerRemoveTimeout: 10 * time.Second,
+	}
+}
+
+func TestCreateNginxContainerStartFailure(t *testing.T) {
+	fake, docker := newFakeDocker(t)
+	cfg := fakeDockerConfig()
+	p := NewProvisioner(docker, cfg)
+	copts := siteContainerOptions(cfg, ProvisionOptions{})
+	ctx := context.Background()
+
+	fake.StartErr = "driver failed programming external connectivity: Bind for 0.0.0.0:80 failed: port is already allocated"
+	fake.Logs = "nginx: [emerg] bind() to 0.0.0.0:80 failed\n"
+	err := p.createNginxContainer(ctx, "nginx_s1", "wp_s1", "nginxconf_s1", copts)
+	if err == nil {
+		t.Fatal("createNginxContainer succeeded although the container did not start")
+	}
+	for _, want := range []string{"start nginx_s1", "port is already allocated", "state=created exit=128", "logs: nginx: [emerg] bind()"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if st := fake.state("nginx_s1"); st != "" {
+		t.Errorf("container left behind in state %q; want it removed", st)
+	}
+
+	// The retry creates the container afresh instead of finding the dead one.
+	fake.StartErr = ""
+	if err := p.createNginxContainer(ctx, "nginx_s1", "wp_s1", "nginxconf_s1", copts); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if fake.Creates != 2 || fake.state("nginx_s1") != "running" {
+		t.Errorf("after retry: creates=%d state=%q; want 2, running", fake.Creates, fake.state("nginx_s1"))
+	}
+}
+
+func TestReusableContainerDiscardsNeverStarted(t *testing.T) {
+	fake, docker := newFakeDocker(t)
+	p := NewProvisioner(docker, fakeDockerConfig())
+	fake.containers["php_s1"] = "created"
+	fake.containers["nginx_s1"] = "exited"
+
+	if p.reusableContainer(context.Background(), "php_s1") {
+		t.Error("a never-started container was reused")
+	}
+	if st := fake.state("php_s1"); st != "" {
+		t.Errorf("never-started container left in state %q", st)
+	}
+	if !p.reusableContainer(context.Background(), "nginx_s1") {
+		t.Error("a stopped container was not reused")
+	}
+}
+
+// A container that is created but cannot start fails the provision, which
+// rolls back like any other failed step. The syslog log driver pointed at a
+// closed port makes the daemon refuse the start after the create.
+func TestProvisionStartFailureRollsBack(t *testing.T) {
+	cfg := integrationConfig(t)
+	docker := integrationDocker(t, cfg)
+	site := testSiteName()
+	destroyTestSite(t, docker, cfg, site)
+
+	cfg.LogDriver = "syslog"
+	cfg.LogOpts = map[string]string{"syslog-address": "tcp://127.0.0.1:1"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	_, err := NewProvisioner(docker, cfg).Run(ctx, site, ProvisionOptions{})
+	if err == nil {
+		t.Fatal("provision succeeded although its containers cannot start")
+	}
+	if !strings.Contains(err.Error(), "start "+PHPContainerName(site)) || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("error %q does not report the failed start and the rollback", err)
+	}
+	assertRolledBack(t, docker, cfg, site)
+}
+
+// failingExecer records statements and fails the first one starting with
+// failPrefix.
+type failingExecer struct {
+	failPrefix string
+	stmts      []string
+}
+
+func (f *failingExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	f.stmts = append(f.stmts, query)
+	if strings.HasPrefix(query, f.failPrefix) {
+		return nil, errors.New("Error 1044 (42000): Access denied for user 'provisioner'@'%' to database 'wp_s1'")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func TestCreateDatabaseAccountGrantFailure(t *testing.T) {
+	db := &failingExecer{failPrefix: "GRANT"}
+	err := createDatabaseAccount(context.Background(), db, "wp_s1", "wpu_s1", "s3cretpass")
+	if err == nil {
+		t.Fatal("createDatabaseAccount succeeded although GRANT failed")
+	}
+	if !strings.HasPrefix(err.Error(), "grant privileges (db=wp_s1 user=wpu_s1): ") {
+		t.Errorf("error %q does not name the grant privileges step", err)
+	}
+	if !strings.Contains(err.Error(), "Access denied") {
+		t.Errorf("error %q does not wrap the server error", err)
+	}
+	if strings.Contains(err.Error(), "s3cretpass") {
+		t.Errorf("error %q leaks the password", err)
+	}
+	if n := len(db.stmts); n != 3 || strings.HasPrefix(db.stmts[n-1], "FLUSH") {
+		t.Errorf("statements run = %q; want it to stop at GRANT", db.stmts)
+	}
+}
+
+func TestRenderNginxConfigFastCGIDirectives(t *testing.T) {
+	cfg := Config{FastCGI: FastCGISettings{ReadTimeoutSec: 60, SendTimeoutSec: 60, BufferSizeKB: 16}}
+	tests := []struct {
+		name string
+		site FastCGISettings
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{
+				"fastcgi_read_timeout 60s;",
+				"fastcgi_send_timeout 60s;",
+				"fastcgi_buffer_size 16k;",
+				"fastcgi_buffers 16 16k;",
+			},
+		},
+		{
+			name: "site override",
+			site: FastCGISettings{ReadTimeoutSec: 300, BufferSizeKB: 64},
+			want: []string{
+				"fastcgi_read_timeout 300s;",
+				"fastcgi_send_timeout 60s;",
+				"fastcgi_buffer_size 64k;",
+				"fastcgi_buffers 16 64k;",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := renderNginxConfig("php_s1", "s1.example.com", "", "", "", "", resolveFastCGI(cfg, tt.site))
+			for _, want := range tt.want {
+				if strings.Count(conf, want) != 1 {
+					t.Errorf("server block has %d of %q, want 1:\n%s", strings.Count(conf, want), want, conf)
+				}
+			}
+			if !strings.Contains(conf, "fastcgi_pass php_s1:9000;") {
+				t.Errorf("server block does not pass to php_s1:\n%s", conf)
+			}
+		})
+	}
+}
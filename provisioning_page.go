@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// The "being set up" page. With PROVISION_PLACEHOLDER on, a WordPress
+// provision first writes a Caddy snippet for the site's domain that answers
+// with this page, so the domain shows the site is on its way instead of
+// nothing while the job is queued and running. Step 6 replaces the snippet
+// with the real reverse proxy; a rollback removes it with the rest of the
+// site. Caddy also starts on the certificate early.
+
+// provisioningPage is the page served while a site provisions. Caddy expands
+// {placeholders} in a respond body, so it has no braces, and it is quoted
+// in backticks, so it has none of those either.
+const provisioningPage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="30">
+<title>Being set up</title></head>
+<body><h1>This site is being set up</h1>
+<p>It will be ready in a few minutes. This page reloads itself.</p></body></html>`
+
+// provisioningRetryAfter is the Retry-After, in seconds, sent with the page.
+const provisioningRetryAfter = 30
+
+// renderProvisioningCaddy returns the snippet serving provisioningPage on
+// domain with a 503, so crawlers and monitors do not take it for the site.
+func renderProvisioningCaddy(domain string) string {
+	return fmt.Sprintf("%s {\n"+
+		"    header Content-Type \"text/html; charset=utf-8\"\n"+
+		"    header Cache-Control no-store\n"+
+		"    header Retry-After %d\n"+
+		"    respond `%s` 503\n"+
+		"}\n", domain, provisioningRetryAfter, provisioningPage)
+}
+
+// writeProvisioningPage writes the placeholder snippet for site and reloads
+// Caddy, reporting whether the snippet is in place. A site that already has
+// a snippet keeps it. If the reload fails the snippet is removed again, so
+// it cannot break later reloads.
+func (p *Provisioner) writeProvisioningPage(ctx context.Context, site, domain string) (bool, error) {
+	if caddySnippetExists(p.docker, p.cfg, p.cfg.CaddyConfDir+"/"+CaddyConfFile(site)) {
+		return false, nil
+	}
+	if err := ensureCaddyConfDir(ctx, p.docker, p.cfg.CaddyContainer, p.cfg.CaddyConfDir); err != nil {
+		return false, err
+	}
+	if err := copyCaddyFile(ctx, p.docker, p.cfg, p.cfg.CaddyConfDir, CaddyConfFile(site), renderProvisioningCaddy(domain)); err != nil {
+		return false, err
+	}
+	if err := reloadCaddy(ctx, p.cfg); err != nil {
+		p.removeCaddyConfig(site)
+		return false, err
+	}
+	jobLogf(ctx, "provisioner", "site=%s serving the provisioning page on %s", site, domain)
+	return true, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// PruneReport lists the orphaned resources a prune removed — or, on a dry
+// run, would remove — and the space reclaimed.
+type PruneReport struct {
+	DryRun         bool     `json:"dry_run"`
+	Volumes        []string `json:"volumes"`
+	Images         []string `json:"images"`
+	Skipped        []string `json:"skipped,omitempty"` // per-site volumes kept, with the reason
+	SpaceReclaimed uint64   `json:"space_reclaimed_bytes"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// volumeSite returns the resource set a per-site volume belongs to — a slug,
+// or a blue/green set such as <site>_green — judged by the prefixes of the
+// naming helpers. ok is false for any other volume.
+func volumeSite(name string) (resources string, ok bool) {
+	for _, prefix := range []string{VolumeName(""), NginxConfVolumeName("")} {
+		s, found := strings.CutPrefix(name, prefix)
+		if !found {
+			continue
+		}
+		slug, _ := strings.CutSuffix(s, greenSuffix)
+		if slug == s {
+			slug, _ = strings.CutSuffix(s, blueSuffix)
+		}
+		if validSite.MatchString(slug) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// PruneOrphans garbage-collects infrastructure left behind by failed
+// provisions and incomplete destroys: per-site volumes whose site has no
+// live row (anything other than DESTROYED) and dangling images. A volume is
+// kept if it backs a template or any container still references it. With
+// dryRun nothing is removed.
+func PruneOrphans(ctx context.Context, docker *client.Client, db *DB, dryRun bool) (*PruneReport, error) {
+	sites, err := db.ListSites()
+	if err != nil {
+		return nil, fmt.Errorf("list sites: %w", err)
+	}
+	live := map[string]bool{}
+	externalVolumes := map[string]string{}
+	for _, s := range sites {
+		if s.Status != string(SiteDestroyed) {
+			live[s.Site] = true
+			for _, name := range []string{s.Resources, s.GreenResources, s.RetiredResources} {
+				if name != "" {
+					live[name] = true
+				}
+			}
+			if s.ExternalVolume != "" {
+				externalVolumes[s.ExternalVolume] = s.Site
+			}
+		}
+	}
+	templates, err := db.ListTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	templateVolumes := map[string]bool{}
+	for _, t := range templates {
+		templateVolumes[t.Volume] = true
+	}
+
+	// DiskUsage rather than VolumeList: it also reports size and how many
+	// containers reference each volume.
+	usage, err := docker.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if err != nil {
+		return nil, fmt.Errorf("list volumes: %w", err)
+	}
+
+	report := &PruneReport{DryRun: dryRun, Volumes: []string{}, Images: []string{}}
+	for _, v := range usage.Volumes {
+		resources, ok := volumeSite(v.Name)
+		if !ok {
+			continue
+		}
+		switch {
+		case live[resources]:
+			continue
+		case externalVolumes[v.Name] != "":
+			report.Skipped = append(report.Skipped, v.Name+": external volume of site "+externalVolumes[v.Name])
+			continue
+		case templateVolumes[v.Name]:
+			report.Skipped = append(report.Skipped, v.Name+": template volume")
+			continue
+		case v.UsageData != nil && v.UsageData.RefCount > 0:
+			report.Skipped = append(report.Skipped, v.Name+": in use by a container")
+			continue
+		}
+
+		if !dryRun {
+			if err := docker.VolumeRemove(ctx, v.Name, false); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("remove volume %s: %v", v.Name, err))
+				continue
+			}
+			log.Printf("[prune] removed orphaned volume %s", v.Name)
+		}
+		report.Volumes = append(report.Volumes, v.Name)
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			report.SpaceReclaimed += uint64(v.UsageData.Size)
+		}
+	}
+
+	dangling := filters.NewArgs(filters.Arg("dangling", "true"))
+	if dryRun {
+		images, err := docker.ImageList(ctx, types.ImageListOptions{Filters: dangling})
+		if err != nil {
+			return nil, fmt.Errorf("list dangling images: %w", err)
+		}
+		for _, img := range images {
+			report.Images = append(report.Images, img.ID)
+			report.SpaceReclaimed += uint64(img.Size)
+		}
+		return report, nil
+	}
+
+	pruned, err := docker.ImagesPrune(ctx, dangling)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("prune images: %v", err))
+		return report, nil
+	}
+	for _, img := range pruned.ImagesDeleted {
+		if img.Deleted != "" {
+			report.Images = append(report.Images, img.Deleted)
+		}
+	}
+	report.SpaceReclaimed += pruned.SpaceReclaimed
+	log.Printf("[prune] removed %d volume(s) and %d image(s), reclaimed %d bytes",
+		len(report.Volumes), len(report.Images), report.SpaceReclaimed)
+	return report, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant identities assigned by authMiddleware. Tenants configured via
+// TenantAPIKeys use their own names.
+const (
+	tenantDefault = "default" // the shared API_KEY; also owns legacy rows
+	tenantAdmin   = "admin"   // ADMIN_API_KEY — exempt from quotas
+)
+
+// tenantKey is the gin context key holding the caller's tenant name.
+const tenantKey = "tenant"
+
+// tenant returns the tenant the current request authenticated as.
+func tenant(c *gin.Context) string {
+	if t := c.GetString(tenantKey); t != "" {
+		return t
+	}
+	return tenantDefault
+}
+
+// ownsSite reports whether the caller may see and act on s: it belongs to
+// the caller's tenant, or the caller has the admin key.
+func ownsSite(c *gin.Context, s *Site) bool {
+	return c.GetBool(fullAccessKey) || s.Tenant == tenant(c)
+}
+
+// ensureSiteOwner answers 404 unless ownsSite, so a tenant key can neither
+// act on nor learn about another tenant's sites. Returns false if a
+// response has been written.
+func ensureSiteOwner(c *gin.Context, s *Site) bool {
+	if ownsSite(c, s) {
+		return true
+	}
+	respondError(c, http.StatusNotFound, CodeSiteNotFound, "site not found")
+	return false
+}
+
+// tenantScopeMiddleware applies ownsSite to every route naming a site in its
+// path (/sites/:site/...) or a job (/jobs/:id/..., by the job's site), and
+// answers 404 for another tenant's. Unknown sites and jobs pass through for
+// the handler to 404. Handlers that take a site in the body check it
+// themselves with ensureSiteOwner.
+func (a *API) tenantScopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(fullAccessKey) {
+			c.Next()
+			return
+		}
+		site, code, msg := c.Param("site"), CodeSiteNotFound, "site not found"
+		if site == "" && strings.HasPrefix(c.FullPath(), "/api/jobs/:id") {
+			job, err := a.db.GetJob(c.Param("id"))
+			if err != nil && err != sql.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, errorBody(http.StatusInternalServerError, CodeInternal, "failed to fetch job"))
+				return
+			}
+			if job != nil {
+				site, code, msg = job.Site, CodeJobNotFound, "job not found"
+			}
+		}
+		if site == "" {
+			c.Next()
+			return
+		}
+		s, err := a.db.GetSite(strings.ToLower(site))
+		if err != nil && err != sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errorBody(http.StatusInternalServerError, CodeInternal, "failed to fetch site"))
+			return
+		}
+		if s != nil && !ownsSite(c, s) {
+			c.AbortWithStatusJSON(http.StatusNotFound, errorBody(http.StatusNotFound, code, msg))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Quota is the effective limit set for one tenant. Zero means unlimited.
+type Quota struct {
+	MaxSites            int `json:"max_sites"`
+	MaxProvisionsPerDay int `json:"max_provisions_per_day"`
+}
+
+// QuotaFor returns the tenant's limits: per-tenant overrides where set,
+// otherwise the global defaults. The admin tenant is never limited.
+func (c Config) QuotaFor(tenant string) Quota {
+	if tenant == tenantAdmin {
+		return Quota{}
+	}
+	return Quota{
+		MaxSites:            quotaOverride(c.TenantMaxSites, tenant, c.QuotaMaxSites),
+		MaxProvisionsPerDay: quotaOverride(c.TenantMaxProvisionsPerDay, tenant, c.QuotaMaxProvisionsPerDay),
+	}
+}
+
+func quotaOverride(overrides map[string]string, tenant string, fallback int) int {
+	v, ok := overrides[tenant]
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("[config] ignoring invalid quota %q for tenant %s", v, tenant)
+		return fallback
+	}
+	return n
+}
+
+// TenantUsage is a tenant's current consumption against its quota.
+type TenantUsage struct {
+	Tenant          string `json:"tenant"`
+	ActiveSites     int    `json:"active_sites"`
+	ProvisionsToday int    `json:"provisions_last_24h"`
+	Limits          Quota  `json:"limits"`
+}
+
+// tenantUsage loads the caller's current usage and limits.
+func (a *API) tenantUsage(name string) (*TenantUsage, error) {
+	sites, err := a.db.CountTenantSites(name)
+	if err != nil {
+		return nil, fmt.Errorf("count sites: %w", err)
+	}
+	provisions, err := a.db.CountTenantProvisions(name)
+	if err != nil {
+		return nil, fmt.Errorf("count provisions: %w", err)
+	}
+	return &TenantUsage{
+		Tenant:          name,
+		ActiveSites:     sites,
+		ProvisionsToday: provisions,
+		Limits:          a.cfg.QuotaFor(name),
+	}, nil
+}
+
+// enforceQuota is the shared pre-provision quota check. It refuses with 403
+// when the tenant is at its site limit and 429 when it has used its daily
+// provision allowance. Re-provisioning a slug that still has a live row
+// (e.g. FAILED) does not add a site, so only the daily limit applies to it.
+// Returns false if a response has been written.
+func (a *API) enforceQuota(c *gin.Context, site string) bool {
+	name := tenant(c)
+	limits := a.cfg.QuotaFor(name)
+	if limits.MaxSites == 0 && limits.MaxProvisionsPerDay == 0 {
+		return true
+	}
+
+	usage, err := a.tenantUsage(name)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check quota: "+err.Error())
+		return false
+	}
+	existing, err := a.db.GetSite(site)
+	addsSite := err != nil || existing.Status == string(SiteDestroyed)
+	if addsSite && limits.MaxSites > 0 && usage.ActiveSites >= limits.MaxSites {
+		respondErrorWith(c, http.StatusForbidden, CodeQuotaExceeded,
+			fmt.Sprintf("site quota reached: tenant %s has %d of %d sites — destroy a site or ask for a higher limit",
+				name, usage.ActiveSites, limits.MaxSites),
+			gin.H{"usage": usage})
+		return false
+	}
+	if limits.MaxProvisionsPerDay > 0 && usage.ProvisionsToday >= limits.MaxProvisionsPerDay {
+		respondErrorWith(c, http.StatusTooManyRequests, CodeRateLimited,
+			fmt.Sprintf("provision rate limit reached: tenant %s has provisioned %d of %d sites in the last 24h",
+				name, usage.ProvisionsToday, limits.MaxProvisionsPerDay),
+			gin.H{"usage": usage})
+		return false
+	}
+	return true
+}
+
+// HostCapacity is the app server's use of its configured ceilings, sent
+// with an AT_CAPACITY error. Zero limits are unlimited.
+type HostCapacity struct {
+	Sites         int `json:"sites"`
+	MaxSites      int `json:"max_sites"`
+	Containers    int `json:"containers,omitempty"`
+	MaxContainers int `json:"max_containers"`
+}
+
+// enforceHostCapacity is the platform-wide pre-provision check, made after
+// the tenant's quota. It refuses with 503 when the host already has
+// MaxSitesPerHost sites, or — a secondary guard against containers the
+// sites table does not know about — MaxContainersPerHost containers by the
+// daemon's count. The container count is skipped, with a warning, when the
+// daemon cannot be asked. Re-provisioning a slug that still holds resources
+// adds nothing, so it is let through. Returns false if a response has been
+// written.
+func (a *API) enforceHostCapacity(c *gin.Context, site string) bool {
+	if a.cfg.MaxSitesPerHost == 0 && a.cfg.MaxContainersPerHost == 0 {
+		return true
+	}
+	existing, err := a.db.GetSite(site)
+	if err == nil && existing.Status != string(SiteDestroyed) && existing.Status != string(SiteFailed) {
+		return true
+	}
+
+	capacity := HostCapacity{MaxSites: a.cfg.MaxSitesPerHost, MaxContainers: a.cfg.MaxContainersPerHost}
+	if capacity.Sites, err = a.db.CountHostSites(); err != nil {
+		respondError(c, http.StatusInternalServerError, CodeInternal, "failed to check host capacity: "+err.Error())
+		return false
+	}
+	if capacity.MaxSites > 0 && capacity.Sites >= capacity.MaxSites {
+		log.Printf("[api] site=%s refused: host at capacity (%d of %d sites) req=%s", site, capacity.Sites, capacity.MaxSites, requestID(c))
+		respondErrorWith(c, http.StatusServiceUnavailable, CodeAtCapacity,
+			fmt.Sprintf("at capacity: the host has %d of %d sites — add capacity or destroy sites", capacity.Sites, capacity.MaxSites),
+			gin.H{"capacity": capacity})
+		return false
+	}
+
+	if capacity.MaxContainers > 0 {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		info, err := a.docker.Info(ctx)
+		if err != nil {
+			log.Printf("[api] site=%s warning: container capacity not checked: %v", site, err)
+			return true
+		}
+		capacity.Containers = info.Containers
+		if capacity.Containers >= capacity.MaxContainers {
+			log.Printf("[api] site=%s refused: host at capacity (%d of %d containers) req=%s", site, capacity.Containers, capacity.MaxContainers, requestID(c))
+			respondErrorWith(c, http.StatusServiceUnavailable, CodeAtCapacity,
+				fmt.Sprintf("at capacity: the Docker host has %d of %d containers — add capacity or prune unused containers", capacity.Containers, capacity.MaxContainers),
+				gin.H{"capacity": capacity})
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantTestRouter serves the site- and job-scoped routes under test behind
+// tenantScopeMiddleware, authenticated as name (tenantAdmin = full access).
+// Path routes answer 204 once they get past the middleware.
+func tenantTestRouter(a *API, name string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(fullAccessKey, name == tenantAdmin)
+		c.Set(tenantKey, name)
+		c.Next()
+	}, a.tenantScopeMiddleware())
+	reached := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	r.POST("/api/sites/:site/admin-credentials/reveal", reached)
+	r.POST("/api/sites/:site/wp-cli", reached)
+	r.GET("/api/jobs/:id", reached)
+	r.POST("/api/destroy", a.handleDestroy)
+	r.POST("/api/domains/move", a.handleMoveDomain)
+	r.GET("/api/sites", a.handleListSites)
+	return r
+}
+
+func TestTenantKeyCannotReachOtherTenantsSites(t *testing.T) {
+	cfg := integrationConfig(t)
+	db := integrationDB(t, cfg)
+	a := &API{db: db, cfg: cfg}
+
+	site, other := testSiteName(), testSiteName()
+	insertTestSite(t, db, site, SiteDomain(site, cfg.BaseDomain))
+	insertTestSite(t, db, other, SiteDomain(other, cfg.BaseDomain))
+	if _, err := db.conn.Exec(`UPDATE sites SET tenant='acme' WHERE site IN (?, ?)`, site, other); err != nil {
+		t.Fatal(err)
+	}
+	jobID := insertTestJob(t, db, JobProvision, site)
+	move, _ := json.Marshal(map[string]string{"domain": site + "-shop.example.com", "from_site": site, "to_site": other})
+	destroy, _ := json.Marshal(map[string]string{"site": site, "confirm": site})
+
+	tests := []struct {
+		name, tenant, method, path string
+		body                       []byte
+		want                       int
+	}{
+		{"reveal, other tenant", "beta", http.MethodPost, "/api/sites/" + site + "/admin-credentials/reveal", nil, http.StatusNotFound},
+		{"wp-cli, other tenant", "beta", http.MethodPost, "/api/sites/" + site + "/wp-cli", nil, http.StatusNotFound},
+		{"job, other tenant", "beta", http.MethodGet, "/api/jobs/" + jobID, nil, http.StatusNotFound},
+		{"destroy, other tenant", "beta", http.MethodPost, "/api/destroy", destroy, http.StatusNotFound},
+		{"move domain, other tenant", "beta", http.MethodPost, "/api/domains/move", move, http.StatusNotFound},
+		{"default key", tenantDefault, http.MethodPost, "/api/sites/" + site + "/wp-cli", nil, http.StatusNotFound},
+		{"wp-cli, owner", "acme", http.MethodPost, "/api/sites/" + site + "/wp-cli", nil, http.StatusNoContent},
+		{"job, owner", "acme", http.MethodGet, "/api/jobs/" + jobID, nil, http.StatusNoContent},
+		{"reveal, admin", tenantAdmin, http.MethodPost, "/api/sites/" + site + "/admin-credentials/reveal", nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tenantTestRouter(a, tt.tenant).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("%s %s as %s = %d, want %d: %s", tt.method, tt.path, tt.tenant, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	if s, err := db.GetSite(site); err != nil || s.Status != string(SiteActive) {
+		t.Errorf("site after the denied destroy = %+v, %v; want it still ACTIVE", s, err)
+	}
+
+	w := httptest.NewRecorder()
+	tenantTestRouter(a, "beta").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/sites", nil))
+	var list struct{ Sites []Site }
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range list.Sites {
+		if s.Site == site || s.Site == other {
+			t.Errorf("GET /api/sites as beta lists %s of tenant acme", s.Site)
+		}
+	}
+}
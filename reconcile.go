@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ReconcileCheck is the outcome of one drift check for a site. With repair,
+// Repaired or RepairError says what became of a failed check.
+type ReconcileCheck struct {
+	Name        string `json:"name"`
+	OK          bool   `json:"ok"`
+	Detail      string `json:"detail,omitempty"` // the drift found
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// reconcileStep is one drift check and, when the drift can be fixed in
+// place, its repair. check returns "" when there is no drift.
+type reconcileStep struct {
+	name   string
+	check  func(ctx context.Context) (string, error)
+	repair func(ctx context.Context) error // nil = not repairable
+}
+
+// reconcileSite compares a live site with what the control plane expects:
+// volume, containers, the sidecar's conf.d volume and egress rules
+// (WordPress only), the nginx server block, a static site's leftover block
+// on the old edge nginx, the Caddy snippet and the TLS cert. With repair,
+// each failed check is fixed where possible — containers are started or
+// recreated (a sidecar from before the conf.d volume onto it), egress rules,
+// nginx and Caddy configs rewritten, the old edge's block removed, Caddy
+// reloaded for the cert — and re-checked. A missing volume is only reported: the site's
+// files are gone.
+func (a *API) reconcileSite(ctx context.Context, s *Site, repair bool) []ReconcileCheck {
+	p := NewProvisioner(a.docker, a.cfg)
+	isWP := a.isWordPressSite(s)
+	activeDomain := s.Domain
+	if s.CustomDomain != "" {
+		activeDomain = s.CustomDomain
+	}
+
+	var steps []reconcileStep
+	if isWP {
+		res := SiteResources(s)
+		copts := siteContainerOptions(a.cfg, ProvisionOptions{RestartPolicy: s.RestartPolicy, NginxResources: s.NginxResources, FPM: s.FPM, Network: s.Network})
+		pathSiteURL := ""
+		if s.PathPrefix != "" {
+			pathSiteURL = "https://" + s.Domain + s.PathPrefix
+		}
+		configExtra := withObjectCache(a.cfg, siteConfigExtra(a.cfg, s.WPConfigExtra, pathSiteURL), s.ObjectCache, res)
+
+		steps = append(steps,
+			reconcileStep{
+				name: "volume",
+				check: func(ctx context.Context) (string, error) {
+					_, err := a.docker.VolumeInspect(ctx, SiteVolume(s))
+					if client.IsErrNotFound(err) {
+						return "volume " + SiteVolume(s) + " is missing", nil
+					}
+					return "", err
+				},
+			},
+			reconcileStep{
+				name:  "php_container",
+				check: func(ctx context.Context) (string, error) { return a.containerDrift(ctx, PHPContainerName(res)) },
+				repair: func(ctx context.Context) error {
+					return p.createContainer(ctx, PHPContainerName(res), SiteVolume(s),
+						WPDatabaseName(res), WPDatabaseUser(res), WPDatabasePass(res), configExtra, copts)
+				},
+			},
+			reconcileStep{
+				name:  "nginx_container",
+				check: func(ctx context.Context) (string, error) { return a.containerDrift(ctx, NginxContainerName(res)) },
+				repair: func(ctx context.Context) error {
+					return p.createNginxContainer(ctx, NginxContainerName(res), SiteVolume(s), NginxConfVolumeName(res), copts)
+				},
+			},
+			reconcileStep{
+				name: "nginx_conf_volume",
+				check: func(ctx context.Context) (string, error) {
+					mounted, err := p.nginxConfMounted(ctx, NginxContainerName(res))
+					if client.IsErrNotFound(err) {
+						return "", nil // reported by nginx_container
+					}
+					if err != nil {
+						return "", err
+					}
+					if !mounted {
+						return "sidecar keeps " + nginxConfDir + " in its own filesystem; the server block is lost when it is recreated", nil
+					}
+					return "", nil
+				},
+				repair: func(ctx context.Context) error {
+					return p.replaceContainer(ctx, NginxContainerName(res), p.recreateNginxSidecar(s, copts))
+				},
+			},
+			reconcileStep{
+				name: "nginx_conf",
+				check: func(ctx context.Context) (string, error) {
+					conf, err := readContainerFile(ctx, a.docker, NginxContainerName(res), nginxSiteConfPath)
+					if err != nil {
+						return "", err
+					}
+					if conf == "" {
+						return "server block " + nginxSiteConfPath + " is missing", nil
+					}
+					for _, d := range []string{s.Domain, s.CustomDomain} {
+						if d != "" && !strings.Contains(conf, d) {
+							return "server block does not mention " + d, nil
+						}
+					}
+					return "", nil
+				},
+				repair: func(ctx context.Context) error {
+					return p.writeNginxConfigWithDomains(ctx, NginxContainerName(res), PHPContainerName(res),
+						s.Domain, s.CustomDomain, s.WWWMode, s.PathPrefix, s.NginxSnippet, s.FastCGI)
+				},
+			},
+		)
+		if s.Egress != EgressOpen {
+			steps = append(steps, reconcileStep{
+				name:   "egress",
+				check:  func(ctx context.Context) (string, error) { return p.egressDrift(ctx, copts.Network, s.Egress) },
+				repair: func(ctx context.Context) error { return p.setEgressPolicy(ctx, copts.Network, s.Egress) },
+			})
+		}
+		if s.ObjectCache != "" {
+			redisName := objectCacheHost(a.cfg, s.ObjectCache, res)
+			steps = append(steps, reconcileStep{
+				name:  "redis_container",
+				check: func(ctx context.Context) (string, error) { return a.containerDrift(ctx, redisName) },
+				repair: func(ctx context.Context) error {
+					_, err := p.ensureObjectCache(ctx, s.ObjectCache, res, copts)
+					return err
+				},
+			})
+		}
+	}
+
+	if !isWP && a.cfg.EdgeNginxContainer != "" {
+		steps = append(steps, reconcileStep{
+			name: "legacy_nginx_conf",
+			check: func(ctx context.Context) (string, error) {
+				exists, err := legacyNginxConfExists(ctx, a.docker, a.cfg, s.Site)
+				if err != nil || !exists {
+					return "", err
+				}
+				return "edge nginx " + a.cfg.EdgeNginxContainer + " still routes the site from " + legacyNginxConfPath(a.cfg, s.Site), nil
+			},
+			repair: func(ctx context.Context) error { return removeLegacyNginxConf(ctx, a.docker, a.cfg, s.Site) },
+		})
+	}
+
+	// Path-routed sites have a route file instead, which names their
+	// prefix rather than a host (as in GET /api/sites/:site).
+	snippetPath := caddySnippetPath(a.cfg, s)
+	routes := activeDomain
+	if s.PathPrefix != "" {
+		routes = s.PathPrefix
+	}
+	steps = append(steps,
+		reconcileStep{
+			name: "caddy_snippet",
+			check: func(ctx context.Context) (string, error) {
+				if !caddySnippetExists(a.docker, a.cfg, snippetPath) {
+					return "snippet " + snippetPath + " is missing", nil
+				}
+				if !caddySnippetContainsDomain(a.docker, a.cfg, snippetPath, routes) {
+					return "snippet does not route " + routes, nil
+				}
+				return "", nil
+			},
+			repair: func(ctx context.Context) error {
+				return a.regenerateCaddy(ctx, s.Site, s.Domain, s.CustomDomain, s.WWWMode)
+			},
+		},
+		reconcileStep{
+			name: "cert",
+			check: func(ctx context.Context) (string, error) {
+				if !caddyHasCert(a.docker, a.cfg, activeDomain) {
+					return "no TLS certificate issued for " + activeDomain, nil
+				}
+				return "", nil
+			},
+			// Same as POST /api/sites/:site/cert-retry: a reload re-queues ACME.
+			repair: func(ctx context.Context) error {
+				if err := reloadCaddy(ctx, a.cfg); err != nil {
+					return err
+				}
+				if PollCaddyCert(ctx, a.docker, a.cfg, activeDomain, 30*time.Second) != CertIssued {
+					return errors.New("certificate still pending after reload; Caddy keeps retrying in the background")
+				}
+				return nil
+			},
+		},
+	)
+
+	checks := make([]ReconcileCheck, 0, len(steps))
+	for _, step := range steps {
+		check := ReconcileCheck{Name: step.name}
+		drift, err := step.check(ctx)
+		if err != nil {
+			drift = fmt.Sprintf("check failed: %v", err)
+		}
+		check.OK = drift == ""
+		check.Detail = drift
+
+		if !check.OK && repair {
+			switch {
+			case step.repair == nil:
+				check.RepairError = "not repairable automatically"
+			case err != nil:
+				check.RepairError = "not attempted: the check itself failed"
+			default:
+				if rErr := step.repair(ctx); rErr != nil {
+					check.RepairError = rErr.Error()
+				} else if after, cErr := step.check(ctx); cErr != nil {
+					check.RepairError = fmt.Sprintf("re-check failed after repair: %v", cErr)
+				} else if after != "" {
+					check.RepairError = "drift remains after repair: " + after
+				} else {
+					check.Repaired = true
+				}
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// containerDrift reports a site container that is missing or not running.
+func (a *API) containerDrift(ctx context.Context, name string) (string, error) {
+	info, err := a.docker.ContainerInspect(ctx, name)
+	if client.IsErrNotFound(err) {
+		return "container " + name + " is missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.State == nil || !info.State.Running {
+		state := "unknown"
+		if info.State != nil {
+			state = info.State.Status
+		}
+		return "container " + name + " is " + state, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// Redis object cache for WordPress sites. A site that opts in gets a Redis
+// on the site network — its own redis_<site> container, or the one shared
+// container named by REDIS_SHARED_CONTAINER, depending on REDIS_MODE — plus
+// the redis-cache plugin's wp-config constants and its object-cache.php
+// drop-in. The mode a site was given is stored with it (Site.ObjectCache) so
+// containers recreated later get the same constants.
+const (
+	ObjectCachePerSite = "per-site"
+	ObjectCacheShared  = "shared"
+)
+
+// redisPort is the port every Redis listens on inside the site network.
+const redisPort = 6379
+
+// redisCachePlugin is the wordpress.org plugin providing the object-cache.php
+// drop-in and the `wp redis` command.
+const redisCachePlugin = "redis-cache"
+
+// objectCacheHost returns the Redis a site in mode connects to.
+func objectCacheHost(cfg Config, mode, resources string) string {
+	if mode == ObjectCacheShared {
+		return cfg.RedisSharedContainer
+	}
+	return RedisContainerName(resources)
+}
+
+// withObjectCache appends the redis-cache constants for mode to a site's
+// WORDPRESS_CONFIG_EXTRA; extra is returned unchanged when mode is "". The
+// key prefix keeps sites (and blue/green sets) apart on a shared Redis.
+func withObjectCache(cfg Config, extra, mode, resources string) string {
+	if mode == "" {
+		return extra
+	}
+	defines := fmt.Sprintf("define('WP_REDIS_HOST', '%s');\ndefine('WP_REDIS_PORT', %d);\ndefine('WP_REDIS_PREFIX', '%s:');",
+		objectCacheHost(cfg, mode, resources), redisPort, resources)
+	return strings.TrimSpace(extra + "\n" + defines)
+}
+
+// ensureObjectCache makes sure the Redis for a site in mode exists and is
+// running, creating it if needed. created reports whether a per-site Redis
+// was created by this call, so a failed provision knows to remove it; the
+// shared Redis is never reported as created, keeps the default restart
+// policy whatever the site asked for, and lives on DOCKER_NETWORK, joined to
+// the site's network as well when that is another.
+func (p *Provisioner) ensureObjectCache(ctx context.Context, mode, resources string, copts containerOptions) (created bool, err error) {
+	if mode == ObjectCacheShared {
+		shared := copts
+		shared.Restart = containerRestartPolicy("")
+		shared.Network = p.cfg.DockerNetwork
+		if _, err := p.createRedisContainer(ctx, p.cfg.RedisSharedContainer, p.cfg.RedisSharedMemoryMB, shared); err != nil {
+			return false, err
+		}
+		if copts.Network == p.cfg.DockerNetwork {
+			return false, nil
+		}
+		if err := ensureSiteNetwork(ctx, p.docker, p.cfg, copts.Network); err != nil {
+			return false, err
+		}
+		return false, connectToNetwork(ctx, p.docker, copts.Network, p.cfg.RedisSharedContainer)
+	}
+	return p.createRedisContainer(ctx, RedisContainerName(resources), p.cfg.RedisMemoryMB, copts)
+}
+
+// createRedisContainer starts a cache-only Redis (no persistence, LRU
+// eviction at memoryMB) named name on the site network. An existing
+// container is only started (see startExisting); created reports whether it
+// was made here.
+func (p *Provisioner) createRedisContainer(ctx context.Context, name string, memoryMB int, copts containerOptions) (created bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerCreateTimeout)
+	defer cancel()
+
+	if _, err := p.docker.ContainerInspect(ctx, name); err == nil {
+		return false, p.startExisting(ctx, name, copts)
+	} else if !client.IsErrNotFound(err) {
+		return false, fmt.Errorf("inspect %s: %w", name, err)
+	}
+	if err := ensureSiteNetwork(ctx, p.docker, p.cfg, copts.Network); err != nil {
+		return false, err
+	}
+
+	pids := int64(64)
+	resp, err := p.docker.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: p.cfg.RedisImage,
+			Cmd: []string{
+				"redis-server",
+				"--save", "", "--appendonly", "no",
+				"--maxmemory", fmt.Sprintf("%dmb", memoryMB),
+				"--maxmemory-policy", "allkeys-lru",
+			},
+		},
+		&container.HostConfig{
+			RestartPolicy: copts.Restart,
+			LogConfig:     copts.Log,
+			Resources: container.Resources{
+				// Headroom over maxmemory for Redis' own overhead.
+				Memory:    int64(memoryMB+32) * 1024 * 1024,
+				NanoCPUs:  500_000_000,
+				PidsLimit: &pids,
+			},
+		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				copts.Network: {},
+			},
+		},
+		nil,
+		name,
+	)
+	if err != nil {
+		return false, fmt.Errorf("redis container create: %w", err)
+	}
+	if err := p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return true, err
+	}
+	jobLogf(ctx, "provisioner", "created redis container %s (maxmemory %dmb)", name, memoryMB)
+	return true, nil
+}
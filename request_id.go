@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header used to accept and echo the correlation ID
+// that ties an API call to the job and infra operations it triggers.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID.
+const requestIDKey = "request_id"
+
+// validRequestID bounds caller-supplied IDs so they are safe to log and store
+// in the jobs.request_id column. Anything else is replaced with a fresh UUID.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestIDMiddleware accepts an X-Request-ID from the caller (or generates
+// one), stores it in the gin context and echoes it on the response. Must be
+// registered before the logger and auth middleware so every line and every
+// rejection carries the ID.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// requestID returns the correlation ID for the current request, or "" when
+// the middleware has not run (e.g. in handlers invoked outside the router).
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger replaces gin.Logger with an access log line that includes the
+// request ID, so API lines can be grepped alongside worker lines for a job.
+func requestLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		reqID, _ := p.Keys[requestIDKey].(string)
+		return fmt.Sprintf("[api] %s | req=%s | %d | %s | %s | %s %s\n",
+			p.TimeStamp.Format(time.RFC3339),
+			reqID,
+			p.StatusCode,
+			p.Latency,
+			p.ClientIP,
+			p.Method,
+			p.Path,
+		)
+	})
+}
+
+// requestIDCtxKey is the context key under which the worker stores the
+// request ID of the job it is running.
+type requestIDCtxKey struct{}
+
+// withRequestID returns ctx carrying the request ID of the job it runs, so
+// the provisioner and destroyer can log it. An empty id is not stored.
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDCtxKey{}, id)
+}
+
+// jobRequestID returns the request ID stored by withRequestID, or "".
+func jobRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
+
+// jobLogf logs a [component] line for work done on behalf of a job, with
+// the job's request ID after the prefix so infra lines can be grepped
+// alongside the API and worker lines for the same request.
+func jobLogf(ctx context.Context, component, format string, args ...any) {
+	prefix := "[" + component + "]"
+	if id := jobRequestID(ctx); id != "" {
+		prefix += " req=" + id
+	}
+	log.Printf(prefix+" "+format, args...)
+}
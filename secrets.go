@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Where configuration values come from besides the environment. Any
+// variable KEY may instead name a file in KEY_FILE (the Docker secrets
+// convention), and the credentials in secretKeys may also come from a
+// secrets backend (SECRETS_BACKEND), so they need not sit in the process
+// environment at all. Precedence is KEY_FILE, then the backend, then KEY.
+
+// secretKeys are the variables a secrets backend is asked for.
+var secretKeys = map[string]bool{
+	"API_KEY":              true,
+	"ADMIN_API_KEY":        true,
+	"TENANT_API_KEYS":      true,
+	"CONTROL_DSN":          true,
+	"WP_DSN":               true,
+	"R2_ACCESS_KEY_ID":     true,
+	"R2_SECRET_ACCESS_KEY": true,
+	"EVENTS_WEBHOOK_URL":   true,
+	"EVENTS_URL":           true,
+}
+
+// SecretSource is a secrets backend. Lookup returns ok=false for a key it
+// does not hold, so the environment is used instead.
+type SecretSource interface {
+	Name() string
+	Lookup(key string) (value string, ok bool)
+}
+
+// secretSource is the backend LoadConfig consults; nil without one.
+var secretSource SecretSource
+
+// newSecretSource sets up the backend named by SECRETS_BACKEND, or returns
+// nil when it is unset. The backend's own settings are read from the
+// environment, or their _FILE variables.
+func newSecretSource() (SecretSource, error) {
+	switch backend := strings.ToLower(getEnv("SECRETS_BACKEND", "")); backend {
+	case "":
+		return nil, nil
+	case "vault":
+		v, err := newVaultSource(
+			getEnv("VAULT_ADDR", ""),
+			getEnv("VAULT_TOKEN", ""),
+			getEnv("VAULT_NAMESPACE", ""),
+			getEnv("VAULT_SECRET_PATH", "secret/data/hostplane"),
+			getEnv("VAULT_CACERT", ""),
+		)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[config] reading secrets from vault (%d fields)", len(v.data))
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want vault)", backend)
+	}
+}
+
+// lookupEnv returns the value of key and where it came from: KEY_FILE, the
+// secrets backend or KEY. An unreadable KEY_FILE stops the control plane
+// rather than silently falling back.
+func lookupEnv(key string) (value, source string) {
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("%s_FILE: %v", key, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), ConfigFromFile
+	}
+	if secretSource != nil && secretKeys[key] {
+		if v, ok := secretSource.Lookup(key); ok && v != "" {
+			return v, secretSource.Name()
+		}
+	}
+	return os.Getenv(key), ConfigFromEnv
+}
+
+// vaultSource reads one Vault KV secret at startup; each of its fields is a
+// variable, e.g. `vault kv put secret/hostplane API_KEY=... CONTROL_DSN=...`.
+type vaultSource struct {
+	data map[string]string
+}
+
+func (v *vaultSource) Name() string { return "vault" }
+
+func (v *vaultSource) Lookup(key string) (string, bool) {
+	s, ok := v.data[key]
+	return s, ok
+}
+
+// vaultTimeout bounds the read of the secret at startup.
+const vaultTimeout = 10 * time.Second
+
+// newVaultSource reads the secret at path — a KV v2 path includes "data/",
+// e.g. secret/data/hostplane — with token.
+func newVaultSource(addr, token, namespace, path, caCert string) (*vaultSource, error) {
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN (or VAULT_TOKEN_FILE) are required")
+	}
+	client := &http.Client{Timeout: vaultTimeout}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("VAULT_CACERT: no certificates in %s", caCert)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("read %s: vault answered %s", path, resp.Status)
+	}
+
+	// KV v2 nests the fields in data.data; KV v1 has them in data.
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	fields := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		fields = nil
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	data := make(map[string]string, len(fields))
+	for k, raw := range fields {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			log.Printf("[config] vault %s: ignoring non-string field %s", path, k)
+			continue
+		}
+		data[k] = s
+	}
+	return &vaultSource{data: data}, nil
+}
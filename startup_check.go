@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+)
+
+// selfTestRouteWait bounds how long the startup self-test waits for Caddy to
+// serve the throwaway site after the reload.
+const selfTestRouteWait = 15 * time.Second
+
+// runStartupSelfTest checks the provisioning pipeline end to end before the
+// control plane takes traffic: the site network exists, the WordPress MySQL
+// answers, and a throwaway static site can be extracted into the static
+// volume, routed by Caddy and reached through the tunnel's service target.
+// The site never touches the control DB and is always torn down again. Any
+// failure is returned so the caller can refuse to start.
+func runStartupSelfTest(ctx context.Context, docker *client.Client, cfg Config, sp *StaticProvisioner) error {
+	if _, err := docker.NetworkInspect(ctx, cfg.DockerNetwork, types.NetworkInspectOptions{}); err != nil {
+		return fmt.Errorf("docker network %s: %w", cfg.DockerNetwork, err)
+	}
+
+	db, err := sql.Open("mysql", cfg.WordPressDSN)
+	if err != nil {
+		return fmt.Errorf("open WordPress DB: %w", err)
+	}
+	err = db.PingContext(ctx)
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("cannot reach WordPress DB: %w", err)
+	}
+
+	// A hyphen-free slug that no customer request can have produced.
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	site := "selftest" + id[:10]
+	token := "hostplane-selftest-" + id
+	zipPath, err := writeSelfTestZip(token)
+	if err != nil {
+		return fmt.Errorf("build self-test zip: %w", err)
+	}
+	defer os.Remove(zipPath)
+
+	domain := SiteDomain(site, cfg.BaseDomain)
+	log.Printf("[selftest] provisioning throwaway static site %s", domain)
+	if err := sp.Run(ctx, site, cfg.BaseDomain, zipPath, newStaticRelease()); err != nil {
+		return fmt.Errorf("static provision: %w", err)
+	}
+
+	routeErr := waitSelfTestRoute(ctx, cfg.ServiceTarget, domain, token)
+
+	sp.removeCaddyConfig(site)
+	if err := reloadCaddy(context.Background(), cfg); err != nil && routeErr == nil {
+		routeErr = fmt.Errorf("reload Caddy after removing %s: %w", site, err)
+	}
+	if err := sp.removeAllSiteFiles(site); err != nil && routeErr == nil {
+		routeErr = fmt.Errorf("remove static files of %s: %w", site, err)
+	}
+	return routeErr
+}
+
+// writeSelfTestZip writes a one-page site whose body is token to a temp file.
+func writeSelfTestZip(token string) (string, error) {
+	f, err := os.CreateTemp("", "selftest-*.zip")
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("index.html")
+	if err == nil {
+		_, err = io.WriteString(w, "<!doctype html><title>self-test</title>"+token+"\n")
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// waitSelfTestRoute polls the service target with Host: domain until it
+// answers 200 with token in the body, the way cloudflared would reach Caddy.
+func waitSelfTestRoute(ctx context.Context, target, domain, token string) error {
+	httpClient := &http.Client{
+		Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	url := strings.TrimSuffix(target, "/") + "/"
+	deadline := time.Now().Add(selfTestRouteWait)
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Host = domain
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode != http.StatusOK:
+				err = fmt.Errorf("%s answered %d for %s", target, resp.StatusCode, domain)
+			case !strings.Contains(string(body), token):
+				err = fmt.Errorf("%s served %s but not the self-test page", target, domain)
+			default:
+				return nil
+			}
+		}
+		lastErr = err
+		if time.Now().After(deadline) {
+			return fmt.Errorf("route check: %w", lastErr)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("route check: %w (last: %v)", ctx.Err(), lastErr)
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -9,7 +9,8 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -27,12 +28,36 @@ func NewStaticProvisioner(docker *client.Client, cfg Config) *StaticProvisioner
 	return &StaticProvisioner{docker: docker, cfg: cfg}
 }
 
+// staticUploadSlots bounds the upload temp containers running at once. It is
+// shared by every StaticProvisioner — handlers build their own — and sized
+// from StaticUploadConcurrency on first use.
+var (
+	staticUploadSlots     chan struct{}
+	staticUploadSlotsOnce sync.Once
+)
+
+// acquireUploadSlot blocks until an upload slot is free or ctx is done, and
+// returns the function releasing it.
+func (p *StaticProvisioner) acquireUploadSlot(ctx context.Context) (func(), error) {
+	staticUploadSlotsOnce.Do(func() {
+		staticUploadSlots = make(chan struct{}, max(p.cfg.StaticUploadConcurrency, 1))
+	})
+	select {
+	case staticUploadSlots <- struct{}{}:
+		return func() { <-staticUploadSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an upload slot: %w", ctx.Err())
+	}
+}
+
 // Run provisions a static site.
 // Files from the uploaded zip are extracted into the shared caddy_static_sites
-// volume under /{site}/, then a Caddy snippet is written and Caddy is reloaded.
+// volume under the release directory (see StaticSiteDir), then a Caddy snippet
+// is written and Caddy is reloaded.
 // No per-site container is created — Caddy's file_server handles serving directly.
-func (p *StaticProvisioner) Run(site, zipPath string) error {
-	domain := SiteDomain(site, p.cfg.BaseDomain)
+// The site is served at <site>.<baseDomain>.
+func (p *StaticProvisioner) Run(ctx context.Context, site, baseDomain, zipPath, release string) error {
+	domain := SiteDomain(site, baseDomain)
 
 	var filesUploaded, caddyWritten bool
 
@@ -41,53 +66,114 @@ func (p *StaticProvisioner) Run(site, zipPath string) error {
 
 		if caddyWritten {
 			p.removeCaddyConfig(site)
-			reloadCaddy(p.cfg)
+			reloadCaddy(context.Background(), p.cfg)
 		}
 		if filesUploaded {
-			p.removeStaticSiteFiles(site)
+			p.removeStaticSiteFiles(site, release)
 		}
 
 		return fmt.Errorf("static provisioning failed (rolled back): %w", reason)
 	}
 
-	// Step 1: extract zip into caddy_static_sites volume under /{site}/
-	if err := p.uploadZipToStaticSites(site, zipPath); err != nil {
+	// Step 1: extract zip into caddy_static_sites volume under the release dir
+	if err := p.uploadZipToStaticSites(ctx, site, release, zipPath); err != nil {
 		return rollback(fmt.Errorf("uploadZip: %w", err))
 	}
 	filesUploaded = true
 
-	// Step 2: write Caddy snippet that serves /srv/sites/{site} via file_server
-	if err := p.writeCaddyConfig(site, domain); err != nil {
+	// Step 2: write Caddy snippet that serves the release via file_server
+	if err := p.writeCaddyConfig(ctx, site, release, domain, "", "", CaddyLogOff, ErrorPageOff); err != nil {
 		return rollback(fmt.Errorf("writeCaddyConfig: %w", err))
 	}
 	caddyWritten = true
 
 	// Step 3: reload Caddy
-	if err := reloadCaddy(p.cfg); err != nil {
+	if err := reloadCaddy(ctx, p.cfg); err != nil {
 		return rollback(fmt.Errorf("reloadCaddy: %w", err))
 	}
 
 	// Step 4: Poll for TLS cert readiness (non-fatal — Caddy retries in background).
-	certStatus := PollCaddyCert(p.docker, p.cfg, domain, 30*time.Second)
+	certStatus := PollCaddyCert(ctx, p.docker, p.cfg, domain, 30*time.Second)
 	log.Printf("[static_provisioner] site=%s cert_status=%s", site, certStatus)
 
 	os.Remove(zipPath)
 	return nil
 }
 
+// Deploy publishes a new release of an existing static site without taking
+// it offline. The zip is extracted into a fresh release directory next to the
+// one being served, and only once it is complete is the Caddy root switched
+// over with a graceful reload — requests in flight finish against the old
+// files. On any failure the new directory is removed and the previous release
+// keeps serving. The zip is left in place for the caller to remove.
+func (p *StaticProvisioner) Deploy(ctx context.Context, s *Site, zipPath, release string) error {
+	if err := p.uploadZipToStaticSites(ctx, s.Site, release, zipPath); err != nil {
+		p.removeStaticSiteFiles(s.Site, release)
+		return fmt.Errorf("uploadZip: %w", err)
+	}
+	if err := p.activateRelease(ctx, s, release); err != nil {
+		p.removeStaticSiteFiles(s.Site, release)
+		return err
+	}
+	return nil
+}
+
+// activateRelease points the site's Caddy snippet at release and reloads
+// Caddy. If the write or reload fails, the snippet for the release currently
+// recorded on s is restored so Caddy keeps serving it.
+func (p *StaticProvisioner) activateRelease(ctx context.Context, s *Site, release string) error {
+	restore := func(reason error) error {
+		log.Printf("[rollback] static %s: restoring release %q: %v", s.Site, s.StaticRelease, reason)
+		restoreCtx, cancel := context.WithTimeout(context.Background(), 2*p.cfg.DockerReloadTimeout)
+		defer cancel()
+		if err := p.writeCaddyConfig(restoreCtx, s.Site, s.StaticRelease, s.Domain, s.CustomDomain, s.WWWMode, s.CaddyLog, s.ErrorPage); err != nil {
+			log.Printf("[CRITICAL] static %s: cannot restore caddy config: %v", s.Site, err)
+		} else if err := reloadCaddy(restoreCtx, p.cfg); err != nil {
+			log.Printf("[CRITICAL] static %s: caddy reload after restore failed: %v", s.Site, err)
+		}
+		return reason
+	}
+
+	if err := p.writeCaddyConfig(ctx, s.Site, release, s.Domain, s.CustomDomain, s.WWWMode, s.CaddyLog, s.ErrorPage); err != nil {
+		return restore(fmt.Errorf("writeCaddyConfig: %w", err))
+	}
+	if err := reloadCaddy(ctx, p.cfg); err != nil {
+		return restore(fmt.Errorf("reloadCaddy: %w", err))
+	}
+	return nil
+}
+
+// newStaticRelease returns a release ID for a deploy starting now. IDs sort
+// chronologically and only use characters safe in paths and shell words.
+func newStaticRelease() string {
+	return time.Now().UTC().Format("20060102T150405.000Z")
+}
+
 // uploadZipToStaticSites extracts the zip into the shared caddy_static_sites
-// Docker volume under the site's own subdirectory (/{site}/).
+// Docker volume under StaticSiteDir(site, release).
 // It uses a temporary busybox container to perform the copy.
-func (p *StaticProvisioner) uploadZipToStaticSites(site, zipPath string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+func (p *StaticProvisioner) uploadZipToStaticSites(ctx context.Context, site, release, zipPath string) error {
+	releaseSlot, err := p.acquireUploadSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer releaseSlot()
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerCreateTimeout)
 	defer cancel()
 
-	tmpName := "tmp_static_" + site
+	// A container left by an interrupted upload for this site would make the
+	// create below fail on the name.
+	tmpName := TmpUploadContainer(site)
+	if err := p.docker.ContainerRemove(ctx, tmpName, types.ContainerRemoveOptions{Force: true}); err != nil && !client.IsErrNotFound(err) {
+		return fmt.Errorf("remove stale temp container: %w", err)
+	}
 
 	resp, err := p.docker.ContainerCreate(
 		ctx,
 		&container.Config{Image: "busybox", Cmd: []string{"sh"}},
 		&container.HostConfig{
+			LogConfig: containerLogConfig(p.cfg, "", ""),
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
@@ -103,13 +189,13 @@ func (p *StaticProvisioner) uploadZipToStaticSites(site, zipPath string) error {
 	}
 	defer p.docker.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
 
-	tarBuf, err := zipToTar(zipPath, site)
+	tarBuf, err := zipToTar(zipPath, StaticSiteDir(site, release))
 	if err != nil {
 		return fmt.Errorf("zip to tar: %w", err)
 	}
 
-	// Copy to /data/ with files prefixed as {site}/<file> so Docker creates
-	// the subdirectory automatically — /data/{site}/ becomes /srv/sites/{site}/ in Caddy
+	// Copy to /data/ with files prefixed by the site dir so Docker creates
+	// it automatically — /data/{dir}/ becomes /srv/sites/{dir}/ in Caddy
 	if err = p.docker.CopyToContainer(ctx, resp.ID, "/data/", tarBuf, types.CopyToContainerOptions{}); err != nil {
 		return fmt.Errorf("copy to container: %w", err)
 	}
@@ -117,21 +203,57 @@ func (p *StaticProvisioner) uploadZipToStaticSites(site, zipPath string) error {
 	return nil
 }
 
-// removeStaticSiteFiles deletes /data/{site}/ from the shared caddy_static_sites
-// volume using a temporary busybox container.
-func (p *StaticProvisioner) removeStaticSiteFiles(site string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// removeStaticSiteFiles deletes one release of a static site from the shared
+// caddy_static_sites volume.
+func (p *StaticProvisioner) removeStaticSiteFiles(site, release string) {
+	if err := p.runInStaticVolume("tmp_rmstatic_"+site, "rm", "-rf", "/data/"+StaticSiteDir(site, release)); err != nil {
+		log.Printf("[rollback] cannot remove static files for %s: %v", site, err)
+		return
+	}
+	log.Printf("[rollback] removed static site files for %s", site)
+}
 
-	tmpName := "tmp_rmstatic_" + site
+// removeAllSiteFiles deletes every release of a static site, and the
+// pre-release directory, from the shared caddy_static_sites volume. Used on
+// destroy once the site's Caddy snippet is gone.
+func (p *StaticProvisioner) removeAllSiteFiles(site string) error {
+	return p.runInStaticVolume("tmp_rmstatic_"+site, "rm", "-rf",
+		"/data/"+StaticSiteDir(site, ""), "/data/_releases/"+site)
+}
+
+// pruneReleases deletes every release directory of the site except keep.
+// Releases are only ever named by newStaticRelease, so they are safe to
+// interpolate into the shell command.
+func (p *StaticProvisioner) pruneReleases(site string, keep ...string) error {
+	var names []string
+	for _, k := range keep {
+		if k != "" {
+			names = append(names, k)
+		}
+	}
+	pattern := "''"
+	if len(names) > 0 {
+		pattern = strings.Join(names, "|")
+	}
+	script := fmt.Sprintf(`cd /data/_releases/%s 2>/dev/null || exit 0
+for d in *; do case "$d" in %s) ;; *) rm -rf "$d" ;; esac; done`, site, pattern)
+	return p.runInStaticVolume("tmp_prunestatic_"+site, "sh", "-c", script)
+}
+
+// runInStaticVolume runs cmd in a temporary busybox container with the
+// caddy_static_sites volume mounted at /data and waits for it to exit.
+func (p *StaticProvisioner) runInStaticVolume(tmpName string, cmd ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerRemoveTimeout)
+	defer cancel()
 
 	resp, err := p.docker.ContainerCreate(
 		ctx,
 		&container.Config{
 			Image: "busybox",
-			Cmd:   []string{"rm", "-rf", "/data/" + site},
+			Cmd:   cmd,
 		},
 		&container.HostConfig{
+			LogConfig: containerLogConfig(p.cfg, "", ""),
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
@@ -143,24 +265,81 @@ func (p *StaticProvisioner) removeStaticSiteFiles(site string) {
 		nil, nil, tmpName,
 	)
 	if err != nil {
-		log.Printf("[rollback] cannot create rm container for static %s: %v", site, err)
-		return
+		return fmt.Errorf("create temp container: %w", err)
 	}
 	defer p.docker.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
 
-	p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	if err := p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("start temp container: %w", err)
+	}
 
 	statusCh, errCh := p.docker.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
 		if err != nil {
-			log.Printf("[rollback] wait error removing static files for %s: %v", site, err)
+			return fmt.Errorf("wait: %w", err)
+		}
+	case st := <-statusCh:
+		if st.StatusCode != 0 {
+			return fmt.Errorf("%s exited with code %d", cmd[0], st.StatusCode)
 		}
-	case <-statusCh:
 	}
-	log.Printf("[rollback] removed static site files for %s", site)
+	return nil
 }
 
+// zipCommonRoot returns the single top-level directory that wraps every
+// file in the archive, with its trailing slash ("my-site-main/" for a
+// GitHub download), or "" when any file sits at the root or files sit under
+// more than one top-level directory. Directory entries are ignored.
+func zipCommonRoot(files []*zip.File) string {
+	root := ""
+	for _, f := range files {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		dir, _, nested := strings.Cut(f.Name, "/")
+		if !nested {
+			return ""
+		}
+		switch root {
+		case "":
+			root = dir
+		case dir:
+		default:
+			return ""
+		}
+	}
+	if root == "" {
+		return ""
+	}
+	return root + "/"
+}
+
+// staticIndex is the page Caddy's file_server serves at a static site's root.
+const staticIndex = "index.html"
+
+// placeholderIndex is served at the root of a static site uploaded without
+// an index.html (allow_no_index), instead of an empty 404.
+const placeholderIndex = `<!doctype html>
+<html><head><meta charset="utf-8"><title>No index page</title></head>
+<body><p>This site has no index page.</p></body></html>
+`
+
+// zipHasRootIndex reports whether the archive has an index.html at its root,
+// after the wrapping directory zipToTar strips.
+func zipHasRootIndex(files []*zip.File) bool {
+	strip := zipCommonRoot(files)
+	for _, f := range files {
+		if !f.FileInfo().IsDir() && strings.TrimPrefix(f.Name, strip) == staticIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// zipToTar converts the uploaded zip into a tar of the release directory
+// for CopyToContainer. An archive without a root index.html gets
+// placeholderIndex; the API only accepts one with allow_no_index.
 func zipToTar(zipPath, sitePrefix string) (io.Reader, error) {
 	zr, err := zip.OpenReader(zipPath)
 	if err != nil {
@@ -170,6 +349,16 @@ func zipToTar(zipPath, sitePrefix string) (io.Reader, error) {
 
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
+	strip := zipCommonRoot(zr.File)
+
+	if !zipHasRootIndex(zr.File) {
+		tw.WriteHeader(&tar.Header{
+			Name: sitePrefix + "/" + staticIndex,
+			Mode: 0644,
+			Size: int64(len(placeholderIndex)),
+		})
+		tw.Write([]byte(placeholderIndex))
+	}
 
 	for _, f := range zr.File {
 		if f.FileInfo().IsDir() {
@@ -187,13 +376,9 @@ func zipToTar(zipPath, sitePrefix string) (io.Reader, error) {
 			return nil, err
 		}
 
-		// Strip top-level directory from zip if present, then prefix with site name.
-		// This makes files land at /data/{site}/<file> when copied to /data/.
-		name := filepath.Base(f.Name)
-		if filepath.Dir(f.Name) != "." {
-			name = f.Name
-		}
-		name = sitePrefix + "/" + name
+		// Strip the wrapping directory, if any, then prefix with the site dir.
+		// This makes files land at /data/{dir}/<file> when copied to /data/.
+		name := sitePrefix + "/" + strings.TrimPrefix(f.Name, strip)
 
 		tw.WriteHeader(&tar.Header{
 			Name: name,
@@ -209,46 +394,65 @@ func zipToTar(zipPath, sitePrefix string) (io.Reader, error) {
 
 // writeCaddyConfig writes a Caddy snippet that serves the static site via
 // file_server. The Caddy container must have caddy_static_sites mounted at
-// /srv/sites, so each site's files live at /srv/sites/{site}/.
-func (p *StaticProvisioner) writeCaddyConfig(site, defaultDomain string, customDomain ...string) error {
-	hosts := defaultDomain
-	if len(customDomain) > 0 && customDomain[0] != "" {
-		hosts = defaultDomain + ", " + customDomain[0]
-	}
-
-	conf := fmt.Sprintf(`%s {
-    root * /srv/sites/%s
-    file_server
-    encode gzip
-}
-`, hosts, site)
-
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	content := []byte(conf)
-	tw.WriteHeader(&tar.Header{
-		Name:    CaddyConfFile(site),
-		Mode:    0644,
-		Size:    int64(len(content)),
-		ModTime: time.Now(),
-	})
-	tw.Write(content)
-	tw.Close()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// /srv/sites, so each release lives at /srv/sites/{StaticSiteDir}/.
+// logMode is the site's request logging (see caddy_log.go) and errorPage its
+// error page mode (see error_page.go).
+//
+// Every reload validates the whole sites directory, so one broken snippet
+// would block config changes for every site. The snippet is therefore
+// validated on its own first (checkCaddySnippet), then the whole config with
+// it in place; if that fails the previous snippet is put back, or the new
+// one removed, and the error wraps errCaddyConfigRejected.
+func (p *StaticProvisioner) writeCaddyConfig(ctx context.Context, site, release, defaultDomain, customDomain, wwwMode, logMode, errorPage string) error {
+	conf := renderStaticCaddyConfig(site, release, defaultDomain, customDomain, wwwMode,
+		renderCaddyLog(p.cfg, site, logMode), renderCaddyErrorPage(p.cfg, site, errorPage))
+	name := CaddyConfFile(site)
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.DockerReloadTimeout)
 	defer cancel()
 
+	if err := checkCaddySnippet(ctx, p.docker, p.cfg, name, conf); err != nil {
+		return err
+	}
 	if err := ensureCaddyConfDir(ctx, p.docker, p.cfg.CaddyContainer, p.cfg.CaddyConfDir); err != nil {
 		return err
 	}
+	previous, err := readContainerFile(ctx, p.docker, p.cfg.CaddyContainer, p.cfg.CaddyConfDir+"/"+name)
+	if err != nil {
+		return err
+	}
 
-	return p.docker.CopyToContainer(ctx, p.cfg.CaddyContainer,
-		p.cfg.CaddyConfDir, &buf, types.CopyToContainerOptions{})
+	if err := copyCaddyFile(ctx, p.docker, p.cfg, p.cfg.CaddyConfDir, name, conf); err != nil {
+		return err
+	}
+	if err := validateCaddy(ctx, p.cfg); err != nil {
+		if previous == "" {
+			p.removeCaddyConfig(site)
+		} else if rbErr := copyCaddyFile(context.Background(), p.docker, p.cfg, p.cfg.CaddyConfDir, name, previous); rbErr != nil {
+			log.Printf("[CRITICAL] static %s: cannot restore previous caddy config: %v", site, rbErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// renderStaticCaddyConfig returns the Caddy snippet serving one release of a
+// static site from /srv/sites, plus the custom domain's www redirect block
+// if it has one. logDirective is the rendered `log` block and
+// errorsDirective the rendered handle_errors block, each or "".
+func renderStaticCaddyConfig(site, release, defaultDomain, customDomain, wwwMode, logDirective, errorsDirective string) string {
+	hosts := strings.Join(append([]string{defaultDomain}, customDomainHosts(customDomain, wwwMode)...), ", ")
+	return fmt.Sprintf(`%s {
+%s    root * /srv/sites/%s
+    file_server
+    encode gzip
+%s}
+`, hosts, logDirective, StaticSiteDir(site, release), errorsDirective) + renderWWWRedirect(customDomain, wwwMode)
 }
 
 // removeCaddyConfig removes the per-site Caddy snippet from the Caddy container.
 func (p *StaticProvisioner) removeCaddyConfig(site string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.DockerExecTimeout)
 	defer cancel()
 
 	confPath := p.cfg.CaddyConfDir + "/" + CaddyConfFile(site)
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestZip writes a zip holding files (name → content) to a temporary
+// file and returns its path. A name ending in "/" is a directory entry.
+func writeTestZip(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestTar returns the files in a tar stream, name → content.
+func readTestTar(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+}
+
+func TestZipToTarStripsWrappingDirectory(t *testing.T) {
+	tests := []struct {
+		name string
+		zip  map[string]string
+		want map[string]string
+	}{
+		{
+			name: "wrapped",
+			zip: map[string]string{
+				"my-site-main/":                 "",
+				"my-site-main/index.html":       "home",
+				"my-site-main/css/":             "",
+				"my-site-main/css/site.css":     "body{}",
+				"my-site-main/about/index.html": "about",
+			},
+			want: map[string]string{
+				"rel/index.html":       "home",
+				"rel/css/site.css":     "body{}",
+				"rel/about/index.html": "about",
+			},
+		},
+		{
+			name: "flat",
+			zip: map[string]string{
+				"index.html":   "home",
+				"css/site.css": "body{}",
+			},
+			want: map[string]string{
+				"rel/index.html":   "home",
+				"rel/css/site.css": "body{}",
+			},
+		},
+		{
+			// A file at the root next to a directory: nothing is stripped.
+			name: "mixed root file and directory",
+			zip: map[string]string{
+				"index.html":        "home",
+				"site/about.html":   "about",
+				"site/css/site.css": "body{}",
+			},
+			want: map[string]string{
+				"rel/index.html":        "home",
+				"rel/site/about.html":   "about",
+				"rel/site/css/site.css": "body{}",
+			},
+		},
+		{
+			// Two top-level directories: nothing is stripped, and with no
+			// root index.html the placeholder is added.
+			name: "mixed top-level directories",
+			zip: map[string]string{
+				"public/index.html": "home",
+				"assets/app.js":     "js",
+			},
+			want: map[string]string{
+				"rel/public/index.html": "home",
+				"rel/assets/app.js":     "js",
+				"rel/index.html":        placeholderIndex,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := zipToTar(writeTestZip(t, tt.zip), "rel")
+			if err != nil {
+				t.Fatalf("zipToTar: %v", err)
+			}
+			if got := readTestTar(t, r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tar holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A static site is routed by its Caddy snippet alone, from provision through
+// a custom domain being attached and removed to destroy, and nothing is left
+// on the old edge nginx.
+func TestStaticSiteLifecycleWithCustomDomain(t *testing.T) {
+	cfg := integrationConfig(t)
+	cfg.RequireBackupBeforeDestroy = false
+	docker := integrationDocker(t, cfg)
+	db := integrationDB(t, cfg)
+	site := testSiteName()
+	destroyTestSite(t, docker, cfg, site)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	domain := SiteDomain(site, cfg.BaseDomain)
+	custom := site + "-shop.example.com"
+	if err := db.UpsertSite(site, domain, string(SiteProvisioning), "", SiteTypeStatic); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.conn.Exec(`DELETE FROM sites WHERE site=?`, site) })
+
+	// Provision.
+	sp := NewStaticProvisioner(docker, cfg)
+	release := newStaticRelease()
+	zipPath := writeTestZip(t, map[string]string{"index.html": "hello " + site})
+	if err := sp.Run(ctx, site, cfg.BaseDomain, zipPath, release); err != nil {
+		t.Fatalf("static provision: %v", err)
+	}
+	if err := db.SetStaticReleases(site, release, ""); err != nil {
+		t.Fatal(err)
+	}
+	s, err := db.GetSite(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snippet := caddySnippetPath(cfg, s)
+	if !caddySnippetContainsDomain(docker, cfg, snippet, domain) {
+		t.Fatalf("snippet %s does not route %s after provision", snippet, domain)
+	}
+
+	// A block the old edge nginx kept for the site is found and removed.
+	if cfg.EdgeNginxContainer != "" {
+		block := "server { listen 80; server_name " + domain + "; return 404; }\n"
+		res, err := runExec(ctx, docker, cfg.EdgeNginxContainer,
+			[]string{"sh", "-c", "printf '%s' \"$1\" > " + legacyNginxConfPath(cfg, site), "sh", block})
+		if err != nil || res.ExitCode != 0 {
+			t.Fatalf("plant legacy block: %v %+v", err, res)
+		}
+		if exists, err := legacyNginxConfExists(ctx, docker, cfg, site); err != nil || !exists {
+			t.Fatalf("legacy block not found: exists=%v err=%v", exists, err)
+		}
+		removeLegacyNginxConfs(ctx, docker, cfg, db)
+		if exists, err := legacyNginxConfExists(ctx, docker, cfg, site); err != nil || exists {
+			t.Errorf("legacy block left behind: exists=%v err=%v", exists, err)
+		}
+	}
+
+	// Attach a custom domain: the same snippet routes both names.
+	if err := writeSiteCaddyConfig(ctx, docker, cfg, s, false, domain, custom, ""); err != nil {
+		t.Fatalf("attach %s: %v", custom, err)
+	}
+	if err := reloadCaddy(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCustomDomain(site, custom, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{domain, custom} {
+		if !caddySnippetContainsDomain(docker, cfg, snippet, d) {
+			t.Errorf("snippet %s does not route %s with the custom domain attached", snippet, d)
+		}
+	}
+	if s, err = db.GetSite(site); err != nil {
+		t.Fatal(err)
+	}
+	checks := (&API{db: db, docker: docker, cfg: cfg}).reconcileSite(ctx, s, false)
+	for _, c := range checks {
+		if c.Name == "caddy_snippet" || c.Name == "legacy_nginx_conf" {
+			if !c.OK {
+				t.Errorf("reconcile %s: %s", c.Name, c.Detail)
+			}
+		}
+	}
+
+	// Remove it again.
+	if err := writeSiteCaddyConfig(ctx, docker, cfg, s, false, domain, "", ""); err != nil {
+		t.Fatalf("remove %s: %v", custom, err)
+	}
+	if err := reloadCaddy(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if caddySnippetContainsDomain(docker, cfg, snippet, custom) {
+		t.Errorf("snippet %s still routes %s after removal", snippet, custom)
+	}
+	if err := db.SetCustomDomain(site, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Destroy: snippet and files are gone.
+	if s, err = db.GetSite(site); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDestroyer(docker, cfg, nil).Run(ctx, s); err != nil {
+		t.Fatalf("destroy: %v", err)
+	}
+	if err := sp.removeAllSiteFiles(site); err != nil {
+		t.Fatalf("remove static files: %v", err)
+	}
+	if caddySnippetExists(docker, cfg, snippet) {
+		t.Errorf("snippet %s left after destroy", snippet)
+	}
+	index, err := readContainerFile(ctx, docker, cfg.CaddyContainer, "/srv/sites/"+StaticSiteDir(site, release)+"/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(index, site) {
+		t.Error("static files left after destroy")
+	}
+}
+
+// A static snippet Caddy cannot parse is rejected on its own, before it
+// joins the sites directory, and the site keeps its previous snippet.
+func TestStaticWriteCaddyConfigRejectsBrokenSnippet(t *testing.T) {
+	cfg := integrationConfig(t)
+	docker := integrationDocker(t, cfg)
+	site := testSiteName()
+	destroyTestSite(t, docker, cfg, site)
+	t.Cleanup(func() { NewStaticProvisioner(docker, cfg).removeAllSiteFiles(site) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	sp := NewStaticProvisioner(docker, cfg)
+	release := newStaticRelease()
+	domain := SiteDomain(site, cfg.BaseDomain)
+	if err := sp.Run(ctx, site, cfg.BaseDomain, writeTestZip(t, map[string]string{"index.html": "hello"}), release); err != nil {
+		t.Fatalf("static provision: %v", err)
+	}
+	snippet := cfg.CaddyConfDir + "/" + CaddyConfFile(site)
+	before, err := readContainerFile(ctx, docker, cfg.CaddyContainer, snippet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unbalanced brace in the site address breaks the whole snippet.
+	err = sp.writeCaddyConfig(ctx, site, release, domain, "broken.example.com {", "", CaddyLogOff, ErrorPageOff)
+	if !errors.Is(err, errCaddyConfigRejected) {
+		t.Fatalf("writeCaddyConfig = %v, want errCaddyConfigRejected", err)
+	}
+	after, err := readContainerFile(ctx, docker, cfg.CaddyContainer, snippet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != before {
+		t.Errorf("snippet changed by a rejected write:\n%s", after)
+	}
+	checkDir := caddyCheckDir + "/" + site
+	if leftover, _ := readContainerFile(ctx, docker, cfg.CaddyContainer, checkDir+"/"+CaddyConfFile(site)); leftover != "" {
+		t.Errorf("check files left in %s", checkDir)
+	}
+	if err := validateCaddy(ctx, cfg); err != nil {
+		t.Errorf("Caddy config no longer validates: %v", err)
+	}
+}
+
+// A zip accepted with allow_no_index is served with the placeholder page at
+// its root; an index.html of its own is never replaced.
+func TestZipToTarPlaceholderIndex(t *testing.T) {
+	got := readTestTar(t, mustZipToTar(t, map[string]string{"app.js": "js", "docs/index.html": "docs"}))
+	if got["rel/"+staticIndex] != placeholderIndex {
+		t.Errorf("root index = %q, want the placeholder", got["rel/"+staticIndex])
+	}
+	if got["rel/docs/index.html"] != "docs" {
+		t.Errorf("docs/index.html = %q, want it kept", got["rel/docs/index.html"])
+	}
+
+	got = readTestTar(t, mustZipToTar(t, map[string]string{"site-main/index.html": "home"}))
+	if len(got) != 1 || got["rel/"+staticIndex] != "home" {
+		t.Errorf("tar holds %v, want only the site's own index.html", got)
+	}
+}
+
+func mustZipToTar(t *testing.T, files map[string]string) io.Reader {
+	t.Helper()
+	r, err := zipToTar(writeTestZip(t, files), "rel")
+	if err != nil {
+		t.Fatalf("zipToTar: %v", err)
+	}
+	return r
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// SiteTemplate is a pre-built WordPress site that new sites can be seeded
+// from: a Docker volume holding wp-content/core files and a MySQL database on
+// state-01 holding the content. Domain is the URL baked into the template DB;
+// it is rewritten to the new site's domain after import.
+type SiteTemplate struct {
+	Name      string    `json:"name"`
+	Volume    string    `json:"volume"`
+	Database  string    `json:"database"`
+	Domain    string    `json:"domain"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// copyVolume copies the full contents of srcVolume into dstVolume using a
+// temporary alpine container with the source mounted read-only. Used to seed
+// a site from a template and to clone one site into another.
+func (p *Provisioner) copyVolume(ctx context.Context, srcVolume, dstVolume string) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+
+	name := fmt.Sprintf("copy_vol_%s_%d", dstVolume, time.Now().UnixNano())
+	exitCode, err := p.runOneShot(ctx, name,
+		&container.Config{
+			Image: "alpine:latest",
+			Cmd:   []string{"sh", "-c", "cp -a /from/. /to/"},
+		},
+		&container.HostConfig{
+			Mounts: []mount.Mount{
+				{Type: mount.TypeVolume, Source: srcVolume, Target: "/from", ReadOnly: true},
+				{Type: mount.TypeVolume, Source: dstVolume, Target: "/to"},
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("copy volume %s → %s: %w", srcVolume, dstVolume, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("copy volume %s → %s: cp exited with code %d", srcVolume, dstVolume, exitCode)
+	}
+	jobLogf(ctx, "provisioner", "copied volume %s → %s", srcVolume, dstVolume)
+	return nil
+}
+
+// copyDatabase pipes a mysqldump of srcDB into dstDB on state-01 from a
+// temporary mysql:8 container on the backend network. dstDB must already exist.
+func (p *Provisioner) copyDatabase(ctx context.Context, srcDB, dstDB string) error {
+	// Both names go into a shell script.
+	for _, name := range []string{srcDB, dstDB} {
+		if err := ValidateDBIdentifier(name, mysqlDatabaseNameMax); err != nil {
+			return fmt.Errorf("copy database: %w", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
+	defer cancel()
+
+	dbUser, dbPass := parseDSNCredentials(p.cfg.WordPressDSN)
+	dbHost, dbPort, err := net.SplitHostPort(p.cfg.DBHost())
+	if err != nil {
+		dbHost = p.cfg.DBHost()
+		dbPort = "3306"
+	}
+
+	// Credentials go through MYSQL_PWD so they never appear in the process list.
+	script := fmt.Sprintf(
+		"set -o pipefail; mysqldump -h %s -P %s -u %s --single-transaction --quick --set-gtid-purged=OFF %s | mysql -h %s -P %s -u %s %s",
+		dbHost, dbPort, dbUser, srcDB, dbHost, dbPort, dbUser, dstDB,
+	)
+
+	name := fmt.Sprintf("copy_db_%s_%d", dstDB, time.Now().UnixNano())
+	exitCode, err := p.runOneShot(ctx, name,
+		&container.Config{
+			Image: "mysql:8",
+			Cmd:   []string{"bash", "-c", script},
+			Env:   []string{"MYSQL_PWD=" + dbPass},
+		},
+		&container.HostConfig{
+			NetworkMode: container.NetworkMode(p.cfg.DockerNetwork),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("copy database %s → %s: %w", srcDB, dstDB, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("copy database %s → %s: exited with code %d", srcDB, dstDB, exitCode)
+	}
+	jobLogf(ctx, "provisioner", "copied database %s → %s", srcDB, dstDB)
+	return nil
+}
+
+// runOneShot creates and starts a short-lived container, waits for it to
+// exit and removes it. Returns the container's exit code.
+func (p *Provisioner) runOneShot(ctx context.Context, name string, cfg *container.Config, hostCfg *container.HostConfig) (int64, error) {
+	return p.runOneShotOutput(ctx, name, cfg, hostCfg, nil, nil)
+}
+
+// runOneShotOutput is runOneShot that also copies the container's stdout and
+// stderr into the given writers before removing it. Nil writers skip
+// collecting the logs.
+func (p *Provisioner) runOneShotOutput(ctx context.Context, name string, cfg *container.Config, hostCfg *container.HostConfig, stdout, stderr io.Writer) (int64, error) {
+	resp, err := p.docker.ContainerCreate(ctx, cfg, hostCfg, nil, nil, name)
+	if err != nil {
+		return -1, fmt.Errorf("create %s: %w", name, err)
+	}
+	defer func() {
+		cleanCtx, cleanCancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cleanCancel()
+		p.docker.ContainerRemove(cleanCtx, resp.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	if err := p.docker.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return -1, fmt.Errorf("start %s: %w", name, err)
+	}
+
+	var exitCode int64
+	statusCh, errCh := p.docker.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return -1, fmt.Errorf("wait %s: %w", name, err)
+	case status := <-statusCh:
+		if status.Error != nil {
+			return -1, fmt.Errorf("wait %s: %s", name, status.Error.Message)
+		}
+		exitCode = status.StatusCode
+	}
+
+	if stdout != nil && stderr != nil {
+		logs, err := p.docker.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+		if err != nil {
+			return exitCode, fmt.Errorf("logs %s: %w", name, err)
+		}
+		defer logs.Close()
+		if _, err := stdcopy.StdCopy(stdout, stderr, logs); err != nil && err != io.EOF {
+			return exitCode, fmt.Errorf("logs %s: %w", name, err)
+		}
+	}
+	return exitCode, nil
+}
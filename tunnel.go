@@ -2,16 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// NOTE: AddRoute and RemoveRoute still use os/exec for `cloudflared tunnel route dns`.
+// NOTE: AddRoute and RemoveRoute still use os/exec for `cloudflared tunnel route dns`;
+// AddRoutes fans AddRoute out over a bounded worker pool for bulk additions
+// (a VERIFY_DNS job's missing routes).
 // UpdateConfig and RemoveConfig no longer restart cloudflared — the catch-all
 // ingress rule routes all tunnel traffic to ServiceTarget, so DNS routes alone
 // control which domains are served. This eliminates the ~30s downtime window.
@@ -87,6 +95,47 @@ func (tm *TunnelManager) AddRoute(domain string) error {
 	return nil
 }
 
+// AddRoutes creates DNS routes for many domains at once, running up to
+// TunnelRouteWorkers AddRoute calls in parallel so a site's hostnames are
+// not serialised behind one cloudflared invocation per domain. Existing
+// routes are skipped as in AddRoute. Every domain is attempted; the result
+// maps each domain that failed to its error, and is nil when none did.
+func (tm *TunnelManager) AddRoutes(domains []string) map[string]error {
+	workers := tm.cfg.TunnelRouteWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed map[string]error
+		sem    = make(chan struct{}, workers)
+	)
+	for _, domain := range domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := tm.AddRoute(domain); err != nil {
+				mu.Lock()
+				if failed == nil {
+					failed = map[string]error{}
+				}
+				failed[domain] = err
+				mu.Unlock()
+			}
+		}(domain)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		log.Printf("[tunnel] %d of %d DNS routes failed", len(failed), len(domains))
+	}
+	return failed
+}
+
 // RemoveRoute removes a DNS route. Currently a no-op because the cloudflared CLI
 // does not support route deletion — requires Cloudflare API.
 func (tm *TunnelManager) RemoveRoute(domain string) error {
@@ -183,3 +232,112 @@ func (tm *TunnelManager) ensureCatchAll(cfg *CloudflaredConfig) {
 		last.Service = tm.cfg.ServiceTarget
 	}
 }
+
+// ValidateServiceTarget checks that the tunnel's upstream is a well-formed
+// http(s) URL with a host. Every catch-all and per-domain ingress rule points
+// here, so a bad value breaks routing for all sites at once.
+func ValidateServiceTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("TUNNEL_SERVICE_TARGET %q is not a URL: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("TUNNEL_SERVICE_TARGET %q must use http or https (got %q)", target, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("TUNNEL_SERVICE_TARGET %q has no host", target)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return fmt.Errorf("TUNNEL_SERVICE_TARGET %q must not include a path", target)
+	}
+	if u.Scheme == "https" {
+		log.Printf("[tunnel] warning: TUNNEL_SERVICE_TARGET uses https — Caddy's tunnel listener normally serves plain http; cloudflared will fail TLS unless originRequest.noTLSVerify is set")
+	}
+	return nil
+}
+
+// ProbeServiceTarget opens a TCP connection to the service target to confirm
+// something is listening there.
+func ProbeServiceTarget(ctx context.Context, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return fmt.Errorf("service target %s unreachable: %w", target, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// TunnelCheck is the outcome of a tunnel routing self-test.
+type TunnelCheck struct {
+	Host         string `json:"host"`
+	OriginStatus int    `json:"origin_status,omitempty"` // status from ServiceTarget with Host: <host>
+	PublicStatus int    `json:"public_status,omitempty"` // status from https://<host> through Cloudflare
+	OK           bool   `json:"ok"`
+	Error        string `json:"error,omitempty"`
+}
+
+// SelfTest confirms a known hostname is served end to end: first directly
+// from the service target with the Host header set (Caddy knows the site),
+// then publicly through Cloudflare (the tunnel reaches Caddy). A 5xx from the
+// public request — Cloudflare's 502/530 — means the tunnel cannot reach its
+// origin, which is the "every site 502s" failure.
+func (tm *TunnelManager) SelfTest(ctx context.Context, host string) TunnelCheck {
+	check := TunnelCheck{Host: host}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	status := func(rawURL, hostHeader string) (int, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		if hostHeader != "" {
+			req.Host = hostHeader
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	var err error
+	check.OriginStatus, err = status(strings.TrimSuffix(tm.cfg.ServiceTarget, "/")+"/", host)
+	if err != nil {
+		check.Error = "origin: " + err.Error()
+		return check
+	}
+	if check.OriginStatus >= 500 {
+		check.Error = fmt.Sprintf("origin returned %d for %s", check.OriginStatus, host)
+		return check
+	}
+
+	check.PublicStatus, err = status("https://"+host+"/", "")
+	if err != nil {
+		check.Error = "public: " + err.Error()
+		return check
+	}
+	if check.PublicStatus >= 500 {
+		check.Error = fmt.Sprintf("public request returned %d — tunnel is not reaching %s", check.PublicStatus, tm.cfg.ServiceTarget)
+		return check
+	}
+	check.OK = true
+	return check
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadMemory is how much of a multipart form is held in memory; larger
+// files spill to temp files, which maxUploadBody keeps bounded.
+const uploadMemory = 8 << 20
+
+// zipMagic is the local file header signature every non-empty zip starts with.
+var zipMagic = []byte("PK\x03\x04")
+
+// zipContentTypes are the Content-Type values clients declare for a zip part.
+// Browsers and curl commonly fall back to application/octet-stream.
+var zipContentTypes = map[string]bool{
+	"":                             true,
+	"application/zip":              true,
+	"application/x-zip":            true,
+	"application/x-zip-compressed": true,
+	"application/octet-stream":     true,
+	"multipart/x-zip":              true,
+}
+
+// maxUploadBody caps the request body at maxBytes and parses the multipart
+// form up front, so an oversized upload is refused with 413 before it can
+// fill /tmp and before handlers read any form field. A declared
+// Content-Length over the limit is refused before any of the body is read.
+// Register it on upload routes ahead of the handler.
+func maxUploadBody(maxBytes int64) gin.HandlerFunc {
+	tooLarge := func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, errorBody(http.StatusRequestEntityTooLarge,
+			CodeUploadTooLarge, fmt.Sprintf("upload exceeds the %d MB limit", maxBytes>>20)))
+	}
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			// Ask net/http to close the connection instead of draining
+			// the rest of the oversized body after the response.
+			c.Header("Connection", "close")
+			tooLarge(c)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		if err := c.Request.ParseMultipartForm(uploadMemory); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				tooLarge(c)
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorBody(http.StatusBadRequest, CodeInvalidUpload, "request must be a multipart form"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// formZip returns the "zip" file of a multipart upload after checking that
+// it is one: a .zip name, a zip (or generic) declared content type, and the
+// zip magic bytes. On failure it writes a 400 and returns false.
+func formZip(c *gin.Context) (*multipart.FileHeader, bool) {
+	file, err := c.FormFile("zip")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidUpload, "zip file is required")
+		return nil, false
+	}
+	if err := validateZipUpload(file); err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidUpload, err.Error())
+		return nil, false
+	}
+	return file, true
+}
+
+// ensureZipIndex checks that an uploaded static site has an index.html at
+// its root (or under its single wrapping directory), so it does not go live
+// serving nothing at /. allow_no_index=true accepts it anyway — for sites
+// that only serve assets or API responses — and a placeholder page is put
+// at the root. On failure it writes a 400 and returns false.
+func ensureZipIndex(c *gin.Context, file *multipart.FileHeader) bool {
+	if c.PostForm("allow_no_index") == "true" {
+		return true
+	}
+	f, err := file.Open()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidUpload, "cannot read uploaded file: "+err.Error())
+		return false
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, file.Size)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidUpload, "uploaded file is not a readable zip archive: "+err.Error())
+		return false
+	}
+	if !zipHasRootIndex(zr.File) {
+		respondError(c, http.StatusBadRequest, CodeInvalidUpload,
+			"zip has no index.html at its root; add one, or set allow_no_index=true to serve a placeholder page at /")
+		return false
+	}
+	return true
+}
+
+// validateZipUpload checks the uploaded part's name, declared content type
+// and leading bytes.
+func validateZipUpload(file *multipart.FileHeader) error {
+	if !strings.EqualFold(path.Ext(file.Filename), ".zip") {
+		return fmt.Errorf("uploaded file must have a .zip extension")
+	}
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(file.Header.Get("Content-Type"), ";")[0]))
+	if !zipContentTypes[contentType] {
+		return fmt.Errorf("uploaded file has content type %s, expected application/zip", contentType)
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("cannot read uploaded file: %w", err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, zipMagic) {
+		return fmt.Errorf("uploaded file is not a zip archive (or is empty)")
+	}
+	return nil
+}
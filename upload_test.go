@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadTestZip posts the zip at zipPath, plus fields, to an upload route
+// that only runs formZip and ensureZipIndex, and returns the response.
+func uploadTestZip(t *testing.T, zipPath string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, err := mw.CreateFormFile("zip", "site.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/upload", maxUploadBody(64<<20), func(c *gin.Context) {
+		file, ok := formZip(c)
+		if !ok || !ensureZipIndex(c, file) {
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestStaticUploadWithoutIndex(t *testing.T) {
+	tests := []struct {
+		name   string
+		zip    map[string]string
+		fields map[string]string
+		status int
+	}{
+		{"root index", map[string]string{"index.html": "home", "app.js": "js"}, nil, http.StatusNoContent},
+		{"wrapped index", map[string]string{"site-main/index.html": "home"}, nil, http.StatusNoContent},
+		{"no index", map[string]string{"app.js": "js", "css/site.css": "body{}"}, nil, http.StatusBadRequest},
+		{"index only in a subdirectory", map[string]string{"docs/index.html": "docs", "app.js": "js"}, nil, http.StatusBadRequest},
+		{"no index, allowed", map[string]string{"app.js": "js"}, map[string]string{"allow_no_index": "true"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := uploadTestZip(t, writeTestZip(t, tt.zip), tt.fields)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusBadRequest {
+				return
+			}
+			var resp struct {
+				Code  ErrorCode `json:"code"`
+				Error string    `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != CodeInvalidUpload || !strings.Contains(resp.Error, "allow_no_index=true") {
+				t.Errorf("response %+v, want %s naming allow_no_index", resp, CodeInvalidUpload)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 )
 
@@ -11,25 +15,39 @@ type Worker struct {
     provisioner       *Provisioner
     destroyer         *Destroyer
     staticProvisioner *StaticProvisioner
+    events            EventPublisher
+    maint             *Maintenance
+    heartbeat         *WorkerHeartbeat
+    tunnel            *TunnelManager
     cfg               Config
+
+    lastRetireSweep time.Time // see queueDueRetirements
+    lastBackupSweep time.Time // see queueDueBackups
+    lastDomainPoll  time.Time // see pollPendingDomains
 }
 
-func NewWorker(db *DB, provisioner *Provisioner, destroyer *Destroyer, staticProvisioner *StaticProvisioner, cfg Config) *Worker {
+func NewWorker(db *DB, provisioner *Provisioner, destroyer *Destroyer, staticProvisioner *StaticProvisioner, events EventPublisher, maint *Maintenance, heartbeat *WorkerHeartbeat, tunnel *TunnelManager, cfg Config) *Worker {
     return &Worker{
         db:                db,
         provisioner:       provisioner,
         destroyer:         destroyer,
         staticProvisioner: staticProvisioner,
+        events:            events,
+        maint:             maint,
+        heartbeat:         heartbeat,
+        tunnel:            tunnel,
         cfg:               cfg,
     }
 }
 
-func (w *Worker) Start() {
+// Start runs the poll loop until ctx is cancelled. A job in flight when ctx is
+// cancelled is aborted via its context and requeued. Blocks — call via go.
+func (w *Worker) Start(ctx context.Context) {
 	log.Println("[worker] starting")
 
 	// On startup, recover any jobs that were stuck mid-flight
 	// when control-01 last crashed or restarted
-	recovered, err := w.db.RecoverStuckJobs(w.cfg.StuckJobTimeout)
+	recovered, err := w.db.RecoverStuckJobs(w.cfg.JobTimeouts, w.cfg.StuckJobTimeout)
 	if err != nil {
 		log.Printf("[worker] stuck job recovery error: %v", err)
 	} else if recovered > 0 {
@@ -39,13 +57,27 @@ func (w *Worker) Start() {
 	ticker := time.NewTicker(time.Duration(w.cfg.WorkerPollInterval) * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		w.processNext()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("[worker] stopped")
+			return
+		case <-ticker.C:
+			w.processNext(ctx)
+		}
 	}
 }
 
-func (w *Worker) processNext() {
-	job, err := w.db.ClaimNextJob()
+func (w *Worker) processNext(ctx context.Context) {
+	w.heartbeat.polled()
+	if w.maint.Enabled() {
+		return // read-only mode: pending jobs wait until it is lifted
+	}
+	w.queueDueRetirements()
+	w.queueDueBackups()
+	w.pollPendingDomains(ctx)
+
+	job, err := w.db.ClaimNextJob(w.cfg.JobClaimPolicy)
 	if err != nil {
 		log.Printf("[worker] error claiming job: %v", err)
 		return
@@ -54,37 +86,154 @@ func (w *Worker) processNext() {
 		return // nothing pending, silent
 	}
 
-	log.Printf("[worker] claimed job %s | type=%s site=%s attempt=%d/%d",
-		job.ID, job.Type, job.Site, job.Attempts, job.MaxAttempts)
+	log.Printf("[worker] claimed job %s | type=%s site=%s attempt=%d/%d req=%s",
+		job.ID, job.Type, job.Site, job.Attempts, job.MaxAttempts, job.RequestID)
+
+	w.events.Publish(Event{
+		Type: jobEventType(job.Type, "started"), Site: job.Site, JobID: job.ID, RequestID: job.RequestID,
+		Data: map[string]any{"attempt": job.Attempts, "max_attempts": job.MaxAttempts},
+	})
+
+	// Each attempt is bounded by its job type's timeout; cancelling the
+	// context aborts the in-flight Docker/SQL call and triggers rollback.
+	// POST /api/jobs/:id/cancel cancels it with errJobCancelled.
+	cancelCtx, cancelJob := context.WithCancelCause(ctx)
+	defer cancelJob(nil)
+	jobCtx, cancel := context.WithTimeout(cancelCtx, w.cfg.JobTimeout(job.Type))
+	defer cancel()
+	jobCtx = withRequestID(jobCtx, job.RequestID) // see jobLogf
 
 	var jobErr error
+	var finalStatus SiteStatus // set by a job that decides the site's status itself
+	deadline, _ := jobCtx.Deadline()
+	w.heartbeat.started(job, deadline, cancelJob)
+	defer func() { w.heartbeat.finished(jobErr == nil) }()
 
 	switch job.Type {
 	case JobProvision:
-		jobErr = w.provisioner.Run(job.Site)
+		opts, err := w.provisionOptions(job.ID)
+		if err != nil {
+			jobErr = err
+		} else {
+			jobErr = w.runProvision(jobCtx, job, job.Site, opts)
+			if jobErr == nil && opts.CustomDomain != "" {
+				finalStatus = w.attachProvisionDomain(jobCtx, job, opts.CustomDomain)
+			}
+		}
+	case JobClone:
+		// A clone is a provision seeded from the source site's live volume and
+		// database — the API stores the source as opts.Template.
+		opts, err := w.provisionOptions(job.ID)
+		if err != nil {
+			jobErr = err
+		} else if opts.Template == nil {
+			jobErr = fmt.Errorf("clone job has no source")
+		} else {
+			jobErr = w.runProvision(jobCtx, job, job.Site, opts)
+			if jobErr == nil {
+				w.cloneAdminCredentials(jobCtx, job, opts)
+			}
+		}
 	case JobDestroy:
-		jobErr = w.destroyer.Run(job.Site)
+		if s, err := w.db.GetSite(job.Site); err != nil {
+			jobErr = fmt.Errorf("load site: %w", err)
+		} else {
+			jobErr = w.destroyer.Run(jobCtx, s)
+			// A static site's files live in the shared caddy_static_sites
+			// volume rather than one of its own.
+			if jobErr == nil && s.Type == SiteTypeStatic {
+				if err := w.staticProvisioner.removeAllSiteFiles(job.Site); err != nil {
+					jobErr = fmt.Errorf("removeStaticFiles: %w", err)
+				}
+			}
+		}
 	case JobStaticProvision:
 		payload, err := w.db.GetJobPayload(job.ID)
 		if err != nil || payload == "" {
 			jobErr = fmt.Errorf("missing zip payload for job")
+		} else if s, err := w.db.GetSite(job.Site); err != nil {
+			jobErr = fmt.Errorf("load site: %w", err)
 		} else {
-			jobErr = w.staticProvisioner.Run(job.Site, payload)
+			release := newStaticRelease()
+			jobErr = w.staticProvisioner.Run(jobCtx, job.Site, SiteBaseDomain(s, w.cfg.BaseDomain), payload, release)
+			if jobErr == nil {
+				jobErr = w.db.SetStaticReleases(job.Site, release, "")
+			}
 		}
+	case JobStaticDeploy:
+		payload, err := w.db.GetJobPayload(job.ID)
+		if err != nil || payload == "" {
+			jobErr = fmt.Errorf("missing zip payload for job")
+		} else {
+			jobErr = w.deployStatic(jobCtx, job.Site, payload)
+		}
+	case JobChangeSubdomain:
+		jobErr = w.changeSubdomain(jobCtx, job)
+	case JobGreenCreate:
+		jobErr = w.createGreen(jobCtx, job)
+	case JobGreenCutover:
+		jobErr = w.cutoverGreen(jobCtx, job)
+	case JobGreenRollback:
+		jobErr = w.rollbackGreen(jobCtx, job)
+	case JobGreenDiscard:
+		jobErr = w.discardGreen(jobCtx, job)
+	case JobBlueRetire:
+		jobErr = w.retireBlue(jobCtx, job)
+	case JobBackup:
+		jobErr = w.runBackup(jobCtx, job)
+	case JobResourcesUpdate:
+		jobErr = w.updateNginxResources(jobCtx, job)
+	case JobVerifyDNS:
+		jobErr = w.verifyDNSRoutes(jobCtx, job)
 	default:
 		jobErr = fmt.Errorf("unknown job type: %s", job.Type)
 	}
 
+	if jobErr != nil && jobCtx.Err() == context.DeadlineExceeded {
+		jobErr = fmt.Errorf("timed out after %s: %w", w.cfg.JobTimeout(job.Type), jobErr)
+	}
+	cancelled := jobErr != nil && ctx.Err() == nil && errors.Is(context.Cause(jobCtx), errJobCancelled)
+	if cancelled {
+		jobErr = fmt.Errorf("%w: %v", errJobCancelled, jobErr)
+	}
+
+	// Shutdown interrupted the attempt — requeue it with the attempt given
+	// back, so the next process start picks it up even if it was the last.
+	if jobErr != nil && ctx.Err() != nil {
+		log.Printf("[worker] job %s interrupted by shutdown, requeueing", job.ID)
+		if err := w.db.RequeueJob(job.ID, jobErr); err != nil {
+			log.Printf("[worker] error requeueing job: %v", err)
+		}
+		return
+	}
+
 	if jobErr != nil {
-		log.Printf("[worker] job %s FAILED (attempt %d): %v", job.ID, job.Attempts, jobErr)
+		log.Printf("[worker] job %s FAILED (attempt %d) req=%s: %v", job.ID, job.Attempts, job.RequestID, jobErr)
 
 		// If we've hit max attempts, mark FAILED permanently
 		// If not, mark PENDING again so the next poll retries it
-		if job.Attempts >= job.MaxAttempts {
-			log.Printf("[worker] job %s exhausted all %d attempts, marking FAILED", job.ID, job.MaxAttempts)
-			if err := w.db.FailJob(job.ID, job.Site, jobErr); err != nil {
+		// A host out of disk stays that way until an operator acts, so
+		// further attempts would only repeat the failure.
+		// A cancelled job is not retried either.
+		hostExhausted := isHostExhausted(jobErr)
+		if hostExhausted {
+			log.Printf("[CRITICAL] job %s site=%s: Docker host out of disk, not retrying: %v", job.ID, job.Site, jobErr)
+		}
+		if hostExhausted || cancelled || job.Attempts >= job.MaxAttempts {
+			if !hostExhausted && !cancelled {
+				log.Printf("[worker] job %s exhausted all %d attempts, marking FAILED", job.ID, job.MaxAttempts)
+			}
+			failJob := func() error { return w.db.FailJob(job.ID, job.Site, jobErr) }
+			if job.Type.KeepsSiteStatus() {
+				failJob = func() error { return w.db.FailDeployJob(job.ID, jobErr) }
+			}
+			if err := failJob(); err != nil {
 				log.Printf("[worker] error marking job failed: %v", err)
 			}
+			w.events.Publish(Event{
+				Type: jobEventType(job.Type, "failed"), Site: job.Site, JobID: job.ID, RequestID: job.RequestID,
+				Data: map[string]any{"error": jobErr.Error(), "error_code": jobErrorCode(jobErr), "attempts": job.Attempts},
+			})
 		} else {
 			log.Printf("[worker] job %s will retry (%d attempts remaining)", job.ID, job.MaxAttempts-job.Attempts)
 			if err := w.db.RetryJob(job.ID, jobErr); err != nil {
@@ -94,8 +243,228 @@ func (w *Worker) processNext() {
 		return
 	}
 
-	log.Printf("[worker] job %s COMPLETED | site=%s", job.ID, job.Site)
-	if err := w.db.CompleteJob(job.ID, job.Site, job.Type); err != nil {
+	log.Printf("[worker] job %s COMPLETED | site=%s req=%s", job.ID, job.Site, job.RequestID)
+	if err := w.db.CompleteJob(job.ID, job.Site, job.Type, finalStatus); err != nil {
 		log.Printf("[worker] error marking job complete: %v", err)
 	}
+	w.events.Publish(Event{Type: jobEventType(job.Type, "completed"), Site: job.Site, JobID: job.ID, RequestID: job.RequestID})
+}
+
+// provisionOptions decodes the PROVISION job payload. Jobs queued before
+// options existed have no payload and get the zero value (all defaults).
+func (w *Worker) provisionOptions(jobID string) (ProvisionOptions, error) {
+	var opts ProvisionOptions
+	payload, err := w.db.GetJobPayload(jobID)
+	if err != nil {
+		return opts, fmt.Errorf("load provision payload: %w", err)
+	}
+	if payload == "" {
+		return opts, nil
+	}
+	if err := json.Unmarshal([]byte(payload), &opts); err != nil {
+		return opts, fmt.Errorf("decode provision payload: %w", err)
+	}
+	return opts, nil
+}
+
+// runProvision runs a WordPress provision of site and stores what it
+// reports (the WordPress install and post-provision hook results) as the
+// job result, whether or not it failed. An admin password the installer
+// generated goes to the site record instead.
+func (w *Worker) runProvision(ctx context.Context, job *Job, site string, opts ProvisionOptions) error {
+	result, err := w.provisioner.Run(ctx, site, opts)
+	if err == nil && result != nil && result.Install != nil && result.Install.AdminPassword != "" {
+		if sErr := w.db.SetSiteAdminCredentials(site, result.Install.AdminUser, result.Install.AdminPassword); sErr != nil {
+			log.Printf("[worker] site=%s warning: could not store admin credentials: %v", site, sErr)
+		}
+		result.Install.AdminPassword = ""
+	}
+	if result != nil {
+		if b, mErr := json.Marshal(result); mErr == nil {
+			if sErr := w.db.SetJobResult(job.ID, string(b)); sErr != nil {
+				log.Printf("[worker] job %s warning: could not store result: %v", job.ID, sErr)
+			}
+		}
+	}
+	return err
+}
+
+// cloneAdminCredentials gives a clone the admin account reference of the
+// site it was copied from, whose database brought the account along, or
+// with opts.AdminPassword reset sets a new password on the account. The
+// clone is up by then, so a failed reset does not fail the job: the site
+// keeps the source's password and the reason goes to its history.
+func (w *Worker) cloneAdminCredentials(ctx context.Context, job *Job, opts ProvisionOptions) {
+	if err := w.db.CopySiteAdminCredentials(opts.CloneFrom, job.Site); err != nil {
+		log.Printf("[worker] site=%s warning: could not copy admin credentials of %s: %v", job.Site, opts.CloneFrom, err)
+	}
+	if opts.AdminPassword != AdminPasswordReset {
+		return
+	}
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		log.Printf("[worker] site=%s admin password not reset: load site: %v", job.Site, err)
+		return
+	}
+	user := siteAdminUser(s)
+	password, err := resetAdminPassword(ctx, w.provisioner.docker, w.cfg, job.Site, user)
+	if err != nil {
+		log.Printf("[WARN] site=%s admin password not reset (non-fatal): %v", job.Site, err)
+		if hErr := w.db.RecordSiteHistory(job.Site, EventAdminPasswordSet, "reset failed: "+err.Error()); hErr != nil {
+			log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, hErr)
+		}
+		return
+	}
+	if err := w.db.SetSiteAdminCredentials(job.Site, user, password); err != nil {
+		log.Printf("[CRITICAL] site=%s admin password reset but not stored: %v", job.Site, err)
+		return
+	}
+	log.Printf("[worker] site=%s admin password of %s reset", job.Site, user)
+	if err := w.db.RecordSiteHistory(job.Site, EventAdminPasswordSet, user+": reset on clone"); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	w.events.Publish(Event{
+		Type: EventAdminPasswordSet, Site: job.Site, JobID: job.ID, RequestID: job.RequestID,
+		Data: map[string]any{"user": user},
+	})
+}
+
+// attachProvisionDomain attaches the custom domain requested with a provision
+// once the site itself is up, and returns the status the site completes
+// with. The site is live on its default domain either way, so nothing here
+// fails the job. An attached domain leaves the site DOMAIN_ACTIVE. One whose
+// DNS does not point at the ingress yet is recorded as pending and the site
+// left DOMAIN_PENDING, for pollPendingDomains to attach it once it does (see
+// domain_wait.go). If the domain was claimed meanwhile or attaching fails,
+// the site stays ACTIVE; the reason is logged and recorded in the site
+// history, and the domain can be set later with POST /api/sites/:site/domain.
+func (w *Worker) attachProvisionDomain(ctx context.Context, job *Job, domain string) SiteStatus {
+	notAttached := func(reason error) SiteStatus {
+		log.Printf("[worker] site=%s custom domain %s not attached: %v", job.Site, domain, reason)
+		if err := w.db.RecordSiteHistory(job.Site, EventDomainAbandoned, domain+": "+reason.Error()); err != nil {
+			log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+		}
+		return SiteActive
+	}
+
+	if err := w.db.EnsureDomainAvailable(domain, job.Site); err != nil {
+		return notAttached(err)
+	}
+	if err := ValidateDomainPointsToIngress(w.cfg.DomainResolver(), domain, w.cfg.IngressIPs); err != nil {
+		if sErr := w.db.SetPendingDomain(job.Site, domain, "", w.cfg.DomainDNSWaitTimeout); sErr != nil {
+			return notAttached(fmt.Errorf("%v; could not record it as pending: %w", err, sErr))
+		}
+		if sErr := w.db.SetPendingDomainError(job.Site, err.Error()); sErr != nil {
+			log.Printf("[worker] site=%s warning: could not record DNS check: %v", job.Site, sErr)
+		}
+		log.Printf("[worker] site=%s custom domain %s pending DNS: %v", job.Site, domain, err)
+		if hErr := w.db.RecordSiteHistory(job.Site, EventDomainPending, domain+": "+err.Error()); hErr != nil {
+			log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, hErr)
+		}
+		w.events.Publish(Event{
+			Type: EventDomainPending, Site: job.Site, JobID: job.ID, RequestID: job.RequestID,
+			Data: map[string]any{"domain": domain},
+		})
+		return SiteDomainPending
+	}
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return notAttached(fmt.Errorf("load site: %w", err))
+	}
+	if err := attachCustomDomain(ctx, w.provisioner.docker, w.cfg, s, true, domain, ""); err != nil {
+		return notAttached(err)
+	}
+	if err := w.db.SetCustomDomain(job.Site, domain, ""); err != nil {
+		log.Printf("[CRITICAL] site=%s domain=%s infra applied but DB commit failed: %v", job.Site, domain, err)
+		return SiteActive
+	}
+
+	log.Printf("[worker] site=%s custom domain %s attached", job.Site, domain)
+	if err := w.db.RecordSiteHistory(job.Site, EventDomainSet, domain); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	w.events.Publish(Event{
+		Type: EventDomainSet, Site: job.Site, JobID: job.ID, RequestID: job.RequestID,
+		Data: map[string]any{"domain": domain},
+	})
+	return SiteDomainActive
+}
+
+// deployStatic rolls out a new release of a live static site. The release
+// that was serving becomes the rollback target and anything older is pruned.
+func (w *Worker) deployStatic(ctx context.Context, site, zipPath string) error {
+	s, err := w.db.GetSite(site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+
+	release := newStaticRelease()
+	if err := w.staticProvisioner.Deploy(ctx, s, zipPath, release); err != nil {
+		return err
+	}
+	// Caddy already serves the new release; a retry after a failed write
+	// here simply deploys the zip again as another release.
+	if err := w.db.SetStaticReleases(site, release, s.StaticRelease); err != nil {
+		return fmt.Errorf("record release: %w", err)
+	}
+	os.Remove(zipPath)
+	log.Printf("[worker] site=%s deployed static release %s (previous %q)", site, release, s.StaticRelease)
+
+	if err := w.staticProvisioner.pruneReleases(site, release, s.StaticRelease); err != nil {
+		log.Printf("[worker] site=%s warning: pruning old releases failed: %v", site, err)
+	}
+	return nil
+}
+
+// changeSubdomainPayload is the CHANGE_SUBDOMAIN job payload.
+type changeSubdomainPayload struct {
+	Subdomain string `json:"subdomain"`
+	WordPress bool   `json:"wordpress"` // resolved by the API (see API.isWordPressSite)
+}
+
+// changeSubdomain moves a site's default domain to <subdomain>.<its base
+// domain>, keeping its slug and so its containers, volume and database.
+// Availability is checked again here since the request was queued. The new
+// name is covered by the base domain's wildcard DNS, like any provision.
+func (w *Worker) changeSubdomain(ctx context.Context, job *Job) error {
+	var req changeSubdomainPayload
+	payload, err := w.db.GetJobPayload(job.ID)
+	if err == nil {
+		err = json.Unmarshal([]byte(payload), &req)
+	}
+	if err != nil || req.Subdomain == "" {
+		return fmt.Errorf("missing subdomain payload for job")
+	}
+
+	s, err := w.db.GetSite(job.Site)
+	if err != nil {
+		return fmt.Errorf("load site: %w", err)
+	}
+	if s.CaddyManual {
+		return errCaddyManuallyEdited
+	}
+	baseDomain := SiteBaseDomain(s, w.cfg.BaseDomain)
+	domain := SiteDomain(req.Subdomain, baseDomain)
+	if domain == s.Domain {
+		return nil
+	}
+	if err := w.db.EnsureSiteDomainAvailable(domain, job.Site); err != nil {
+		return err
+	}
+
+	if err := moveDefaultDomain(ctx, w.provisioner.docker, w.cfg, s, req.WordPress, domain); err != nil {
+		return err
+	}
+	if err := w.db.SetSiteRouting(job.Site, domain, "", baseDomain); err != nil {
+		log.Printf("[CRITICAL] site=%s domain=%s infra applied but DB commit failed: %v", job.Site, domain, err)
+		return fmt.Errorf("record new domain: %w", err)
+	}
+
+	log.Printf("[worker] site=%s default domain changed %s → %s", job.Site, s.Domain, domain)
+	if err := w.db.RecordSiteHistory(job.Site, EventSubdomainChanged, s.Domain+" → "+domain); err != nil {
+		log.Printf("[worker] site=%s warning: could not record history: %v", job.Site, err)
+	}
+	certStatus := PollCaddyCert(ctx, w.provisioner.docker, w.cfg, domain, 30*time.Second)
+	log.Printf("[worker] site=%s cert_status=%s for %s", job.Site, certStatus, domain)
+	return nil
 }
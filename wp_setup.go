@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WordPress locales look like de_DE, pt_BR or de_DE_formal; plugins are
+// given by their wordpress.org slug.
+var (
+	validLocale     = regexp.MustCompile(`^[a-z]{2,3}(_[A-Z]{2})?(_[a-z]+)?$`)
+	validPluginSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// maxProvisionPlugins caps the plugins a provision request may install.
+const maxProvisionPlugins = 20
+
+// wpSetupAdminUser is the administrator account created when the control
+// plane runs the WordPress installer itself.
+const wpSetupAdminUser = "admin"
+
+// defaultWPConfigExtra hardens every WordPress site unless WP_CONFIG_EXTRA
+// or the site's own wp_config_extra replaces it. FORCE_SSL_ADMIN is safe
+// behind the ingress: the nginx sidecar always passes HTTPS=on to PHP, so
+// is_ssl() holds and no redirect loop occurs, and HTTP_HOST (the default
+// domain, or $host with a custom domain) keeps redirects on the right host.
+const defaultWPConfigExtra = `define('DISALLOW_FILE_EDIT', true);
+define('FORCE_SSL_ADMIN', true);
+define('WP_POST_REVISIONS', 10);
+define('WP_MEMORY_LIMIT', '128M');
+define('WP_MAX_MEMORY_LIMIT', '256M');`
+
+// noWPConfigExtra, as WP_CONFIG_EXTRA or a site's wp_config_extra, injects
+// no extra configuration at all.
+const noWPConfigExtra = "none"
+
+// maxWPConfigExtra caps a provision request's wp_config_extra.
+const maxWPConfigExtra = 8 << 10
+
+// siteConfigExtra returns the WORDPRESS_CONFIG_EXTRA for a site's PHP
+// container: override ("" = cfg.WPConfigExtra), followed by the WP_HOME and
+// WP_SITEURL pins for path-routed sites (pathSiteURL != "").
+func siteConfigExtra(cfg Config, override, pathSiteURL string) string {
+	extra := override
+	if extra == "" {
+		extra = cfg.WPConfigExtra
+	}
+	if extra == noWPConfigExtra {
+		extra = ""
+	}
+	if pathSiteURL != "" {
+		extra = strings.TrimSpace(extra + "\n" + wordPressPathConfig(pathSiteURL))
+	}
+	return extra
+}
+
+// validateWordPressSetup checks a provision request's locale and plugins.
+func validateWordPressSetup(locale string, plugins []string) error {
+	if locale != "" && !validLocale.MatchString(locale) {
+		return fmt.Errorf("locale must be a WordPress locale such as de_DE or pt_BR")
+	}
+	if len(plugins) > maxProvisionPlugins {
+		return fmt.Errorf("at most %d plugins may be installed at provision time", maxProvisionPlugins)
+	}
+	for _, slug := range plugins {
+		if !validPluginSlug.MatchString(slug) {
+			return fmt.Errorf("plugin %q is not a wordpress.org plugin slug", slug)
+		}
+	}
+	return nil
+}
+
+// InstallResult reports the WordPress setup step of a provision in the job
+// result. AdminPassword is only set when the control plane ran the installer
+// and created the admin account; the worker moves it to the site record to
+// be revealed once (see admin_credentials.go), so it never reaches the job
+// result.
+type InstallResult struct {
+	Installed     bool           `json:"installed"` // the installer was run by this provision
+	Locale        string         `json:"locale,omitempty"`
+	AdminUser     string         `json:"admin_user,omitempty"`
+	AdminPassword string         `json:"-"`
+	Plugins       []PluginResult `json:"plugins,omitempty"`
+	ObjectCache   *PluginResult  `json:"object_cache,omitempty"` // the redis-cache plugin and its drop-in
+}
+
+// PluginResult is the outcome of installing one plugin.
+type PluginResult struct {
+	Slug   string `json:"slug"`
+	OK     bool   `json:"ok"`
+	Output string `json:"output,omitempty"` // WP-CLI's output when it failed
+}
+
+// setupWordPress installs WordPress in opts.Locale and installs and
+// activates opts.Plugins, all with WP-CLI in the site's PHP container. The
+// installer only runs on a site that is not installed yet (a blank
+// provision); a template or existing volume keeps its install and only gets
+// the language pack and plugins. Install and language failures are
+// returned as errors, failing the provision; each plugin is best effort and
+// only reported in the result.
+func (p *Provisioner) setupWordPress(ctx context.Context, site, domain, siteURL string, opts ProvisionOptions) (*InstallResult, error) {
+	res := &InstallResult{Locale: opts.Locale}
+	wp := func(command string, args ...string) (*ExecResult, error) {
+		r, err := runWPCLI(ctx, p.docker, p.cfg, site, command, args)
+		if err == nil && r.ExitCode != 0 {
+			err = fmt.Errorf("wp %s %s exited %d: %s", command, strings.Join(redactWPCLIArgs(args), " "), r.ExitCode, strings.TrimSpace(r.Stderr))
+		}
+		return r, err
+	}
+
+	if err := p.waitForWordPressFiles(ctx, site); err != nil {
+		return res, err
+	}
+
+	installed, err := runWPCLI(ctx, p.docker, p.cfg, site, "core", []string{"is-installed"})
+	if err != nil {
+		return res, err
+	}
+	if installed.ExitCode != 0 {
+		password, err := randomPassword()
+		if err != nil {
+			return res, err
+		}
+		email := opts.AdminEmail
+		if email == "" {
+			email = wpSetupAdminUser + "@" + domain
+		}
+		args := []string{
+			"--url=" + siteURL, "--title=" + site,
+			"--admin_user=" + wpSetupAdminUser, "--admin_password=" + password, "--admin_email=" + email,
+			"--skip-email",
+		}
+		if opts.Locale != "" {
+			args = append(args, "--locale="+opts.Locale)
+		}
+		if _, err := wp("core", append([]string{"install"}, args...)...); err != nil {
+			return res, fmt.Errorf("core install: %w", err)
+		}
+		res.Installed, res.AdminUser, res.AdminPassword = true, wpSetupAdminUser, password
+		jobLogf(ctx, "provisioner", "site=%s wordpress installed (locale=%s)", site, orDefault(opts.Locale, "en_US"))
+	}
+
+	if opts.Locale != "" && opts.Locale != "en_US" {
+		if _, err := wp("language", "core", "install", opts.Locale, "--activate"); err != nil {
+			return res, fmt.Errorf("language core install: %w", err)
+		}
+	}
+
+	for _, slug := range opts.Plugins {
+		r, err := wp("plugin", "install", slug, "--activate")
+		pr := PluginResult{Slug: slug, OK: err == nil}
+		if err != nil {
+			pr.Output = err.Error()
+			if r != nil {
+				pr.Output = strings.TrimSpace(r.Stdout + "\n" + r.Stderr)
+			}
+			log.Printf("[WARN] site=%s plugin %s install failed (non-fatal): %v", site, slug, err)
+		}
+		res.Plugins = append(res.Plugins, pr)
+	}
+
+	if opts.ObjectCache != "" {
+		// --force: a clone or template may bring a drop-in along already.
+		r, err := wp("plugin", "install", redisCachePlugin, "--activate")
+		if err == nil {
+			r, err = wp("redis", "enable", "--force")
+		}
+		res.ObjectCache = &PluginResult{Slug: redisCachePlugin, OK: err == nil}
+		if err != nil {
+			res.ObjectCache.Output = err.Error()
+			if r != nil {
+				res.ObjectCache.Output = strings.TrimSpace(r.Stdout + "\n" + r.Stderr)
+			}
+			log.Printf("[WARN] site=%s object cache not enabled (non-fatal): %v", site, err)
+		}
+	}
+	return res, nil
+}
+
+// waitForWordPressFiles waits for the wordpress image's entrypoint to copy
+// core into the volume and write wp-config.php, which it does before
+// php-fpm starts and so possibly after the container is reported running.
+func (p *Provisioner) waitForWordPressFiles(ctx context.Context, site string) error {
+	deadline := time.Now().Add(60 * time.Second)
+	for {
+		r, err := runExec(ctx, p.docker, PHPContainerName(site), []string{"test", "-f", "/var/www/html/wp-config.php"})
+		if err == nil && r.ExitCode == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wp-config.php not written within 60s")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// randomPassword returns a 24-character URL-safe random password.
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// wpCLIAllowlist maps top-level WP-CLI commands to their permitted
+// subcommands, written out in full ("event list") where WP-CLI nests them;
+// a group such as "event" is never listed on its own, so every subcommand
+// under it has to be. The bool marks a subcommand as destructive: those
+// require force=true and the admin API key. Anything not listed is rejected.
+var wpCLIAllowlist = map[string]map[string]bool{
+	"plugin": {
+		"list": false, "status": false, "get": false, "is-active": false,
+		"activate": false, "deactivate": false,
+		"install": true, "update": true, "delete": true, "uninstall": true,
+	},
+	"theme": {
+		"list": false, "status": false, "get": false, "is-active": false, "activate": false,
+		"install": true, "update": true, "delete": true,
+	},
+	"cache":     {"flush": false, "type": false},
+	"rewrite":   {"flush": false, "list": false},
+	"transient": {"delete": false},
+	"user":      {"list": false, "get": false, "create": true, "update": true, "delete": true},
+	"option":    {"get": false, "list": false, "update": true, "delete": true},
+	"core":      {"version": false, "check-update": false, "verify-checksums": false, "update": true},
+	"cron":      {"event list": false, "event run": true, "schedule list": false},
+}
+
+// wpCLIForbiddenFlags are global WP-CLI flags that would let a caller run
+// arbitrary PHP or escape the site's install path.
+var wpCLIForbiddenFlags = []string{"--exec", "--require", "--path", "--ssh", "--http", "--url"}
+
+// wpCLISecretFlags carry credentials and are masked in logs.
+var wpCLISecretFlags = []string{"--user_pass", "--admin_password", "--dbpass", "--password"}
+
+// redactWPCLIArgs returns args with the values of credential flags masked,
+// for the audit log. Handles both --flag=value and --flag value forms.
+func redactWPCLIArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i, arg := range out {
+		for _, flag := range wpCLISecretFlags {
+			switch {
+			case strings.HasPrefix(arg, flag+"="):
+				out[i] = flag + "=***"
+			case arg == flag && i+1 < len(out):
+				out[i+1] = "***"
+			}
+		}
+	}
+	return out
+}
+
+// ValidateWPCLICommand checks command and its subcommand path against the
+// allowlist and rejects dangerous flags. Returns whether the command is
+// destructive.
+func ValidateWPCLICommand(command string, args []string) (destructive bool, err error) {
+	subs, ok := wpCLIAllowlist[command]
+	if !ok {
+		return false, fmt.Errorf("wp %s is not allowed", command)
+	}
+	words := wpCLIPositional(args)
+	if len(words) == 0 {
+		return false, fmt.Errorf("wp %s requires a subcommand", command)
+	}
+	destructive, ok = wpCLISubcommand(subs, words)
+	if !ok {
+		return false, fmt.Errorf("wp %s %s is not allowed", command, strings.Join(words, " "))
+	}
+	for _, arg := range args {
+		for _, flag := range wpCLIForbiddenFlags {
+			if arg == flag || strings.HasPrefix(arg, flag+"=") {
+				return false, fmt.Errorf("flag %s is not allowed", flag)
+			}
+		}
+	}
+	return destructive, nil
+}
+
+// wpCLIPositional returns the leading words of args, up to the first flag.
+// The subcommand path is among them; the rest are its arguments.
+func wpCLIPositional(args []string) []string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// wpCLISubcommand looks up the longest subcommand path in subs that words
+// start with, so "event delete" is not mistaken for an allowed "event" and
+// "install akismet" is the "install" subcommand with one argument.
+func wpCLISubcommand(subs map[string]bool, words []string) (destructive, ok bool) {
+	for n := len(words); n > 0; n-- {
+		if destructive, ok := subs[strings.Join(words[:n], " ")]; ok {
+			return destructive, true
+		}
+	}
+	return false, false
+}
+
+// runWPCLI execs `wp <command> <args...>` inside the site's PHP-FPM container
+// and captures its output. The exec runs without a shell, so arguments are
+// passed verbatim and cannot inject additional commands.
+func runWPCLI(ctx context.Context, docker *client.Client, cfg Config, site, command string, args []string) (*ExecResult, error) {
+	cmd := append([]string{cfg.WPCLIBinary, command}, args...)
+	cmd = append(cmd, "--path=/var/www/html", "--allow-root")
+
+	result, err := runExec(ctx, docker, PHPContainerName(site), cmd)
+	if err != nil {
+		return nil, fmt.Errorf("wp-cli %w", err)
+	}
+	return result, nil
+}
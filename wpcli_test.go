@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateWPCLICommand(t *testing.T) {
+	tests := []struct {
+		command     string
+		args        []string
+		destructive bool
+		wantErr     string
+	}{
+		{"plugin", []string{"list", "--format=json"}, false, ""},
+		{"plugin", []string{"install", "akismet", "--activate"}, true, ""},
+		{"option", []string{"get", "home"}, false, ""},
+		{"cache", []string{"flush"}, false, ""},
+		{"cron", []string{"event", "list", "--fields=hook"}, false, ""},
+		{"cron", []string{"event", "run", "--due-now"}, true, ""},
+		{"cron", []string{"schedule", "list"}, false, ""},
+
+		// Nested subcommands are checked in full, not by their group.
+		{"cron", []string{"event", "delete", "wp_version_check"}, false, "wp cron event delete wp_version_check is not allowed"},
+		{"cron", []string{"event", "schedule", "my_hook", "now"}, false, "not allowed"},
+		{"cron", []string{"event"}, false, "wp cron event is not allowed"},
+		{"cron", []string{"event", "--all"}, false, "wp cron event is not allowed"},
+
+		{"eval", []string{"phpinfo();"}, false, "wp eval is not allowed"},
+		{"plugin", nil, false, "requires a subcommand"},
+		{"plugin", []string{"--format=json", "list"}, false, "requires a subcommand"},
+		{"plugin", []string{"list", "--exec=phpinfo();"}, false, "flag --exec is not allowed"},
+		{"plugin", []string{"list", "--path", "/tmp"}, false, "flag --path is not allowed"},
+	}
+	for _, tt := range tests {
+		name := tt.command + " " + strings.Join(tt.args, " ")
+		destructive, err := ValidateWPCLICommand(tt.command, tt.args)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("wp %s: err = %v, want it to contain %q", name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("wp %s: %v", name, err)
+			continue
+		}
+		if destructive != tt.destructive {
+			t.Errorf("wp %s: destructive = %v, want %v", name, destructive, tt.destructive)
+		}
+	}
+}
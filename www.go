@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// www handling for a custom domain (Site.WWWMode), opted into per
+// POST /api/sites/:site/domain request.
+const (
+	WWWRedirect = "redirect" // www.<domain> answers with a permanent redirect to <domain>
+	WWWServe    = "serve"    // www.<domain> serves the site as well
+)
+
+// wwwDomain returns the www variant of a custom domain.
+func wwwDomain(domain string) string {
+	return "www." + domain
+}
+
+// ValidateWWWMode checks a requested www mode for domain. "" disables www
+// handling.
+func ValidateWWWMode(domain, mode string) error {
+	switch mode {
+	case "", WWWRedirect, WWWServe:
+	default:
+		return fmt.Errorf(`www must be "%s" or "%s"`, WWWRedirect, WWWServe)
+	}
+	if mode != "" && strings.HasPrefix(domain, "www.") {
+		return fmt.Errorf("www handling needs the bare domain, not %s", domain)
+	}
+	return nil
+}
+
+// customDomainHosts returns the hostnames that serve a site for its custom
+// domain: the domain itself, plus its www variant in serve mode. "" yields
+// none.
+func customDomainHosts(domain, wwwMode string) []string {
+	if domain == "" {
+		return nil
+	}
+	if wwwMode == WWWServe {
+		return []string{domain, wwwDomain(domain)}
+	}
+	return []string{domain}
+}
+
+// renderWWWRedirect returns the Caddy block redirecting www.<domain> to
+// the bare domain in redirect mode, else "". Caddy obtains a certificate for
+// the www host like any other site block.
+func renderWWWRedirect(domain, wwwMode string) string {
+	if domain == "" || wwwMode != WWWRedirect {
+		return ""
+	}
+	return fmt.Sprintf("\n%s {\n    redir https://%s{uri} permanent\n}\n", wwwDomain(domain), domain)
+}
+
+// checkWWWDNS validates the www variant's DNS before www handling is
+// enabled. A www name that does not resolve at all is skipped (skip is
+// true) so the bare domain can still be set; one that resolves elsewhere is
+// an error, since its certificate could never be issued.
+func checkWWWDNS(r *DomainResolver, domain string, ingressIPs []string) (skip bool, err error) {
+	www := wwwDomain(domain)
+	if _, err := r.LookupHost(www); err != nil {
+		return true, nil
+	}
+	return false, ValidateDomainPointsToIngress(r, www, ingressIPs)
+}